@@ -21,12 +21,13 @@ import (
 
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/agent"
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
 )
 
 var (
 	configFile = flag.String("config", "", "Path to configuration file")
 	listenAddr = flag.String("listen", "0.0.0.0:8090", "Address and port to listen on")
-	serverAddr = flag.String("server", "http://localhost:9090", "Address of the main server")
+	serverAddr = flag.String("server", "", "Address of the main server (empty = discover via mDNS)")
 	debug      = flag.Bool("debug", false, "Enable debug mode")
 	interface_ = flag.String("interface", "", "Network interface to capture packets from")
 	name       = flag.String("name", "", "Agent name (defaults to hostname)")
@@ -114,8 +115,8 @@ func main() {
 		if *listenAddr != "" {
 			cfg.Agent.Listen = *listenAddr
 		}
-		if *serverAddr != "" && *serverAddr != "http://localhost:9090" {
-			// Nur überschreiben, wenn explizit ein anderer Wert als der Standard angegeben wurde
+		if *serverAddr != "" {
+			// Nur überschreiben, wenn explizit ein Server angegeben wurde
 			cfg.Agent.ServerURL = *serverAddr
 		}
 		if *interface_ != "" {
@@ -145,9 +146,11 @@ func main() {
 		log.Fatalf("Failed to initialize agent: %v", err)
 	}
 
-	// Register with the main server
-	if err := captureAgent.Register(); err != nil {
-		log.Printf("Warning: Failed to register with main server: %v", err)
+	// Register with the main server; without a server URL the agent discovers it via mDNS
+	if cfg.Agent.ServerURL != "" {
+		if err := captureAgent.Register(); err != nil {
+			log.Printf("Warning: Failed to register with main server: %v", err)
+		}
 	}
 
 	// Set up the HTTP router
@@ -167,7 +170,7 @@ func main() {
 
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Starting agent server on %s", cfg.Agent.Listen)
+		log.Printf("Starting agent %s (protocol %d) on %s", version.Version, version.ProtocolVersion, cfg.Agent.Listen)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
@@ -184,6 +187,11 @@ func main() {
 		log.Printf("Warning: Failed to unregister from main server: %v", err)
 	}
 
+	// Stop capture and flush ring buffer
+	if err := captureAgent.Close(); err != nil {
+		log.Printf("Warning: Failed to close agent: %v", err)
+	}
+
 	// Shutdown HTTP server
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
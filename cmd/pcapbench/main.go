@@ -0,0 +1,269 @@
+// pcapbench misst den Durchsatz der Paketanalyse (Pakete/s) für verschiedene Anzahlen
+// an Dekodier-Workern. Ohne vorhandene Aufzeichnung erzeugt -generate eine synthetische
+// PCAP-Datei mit vielen parallelen Flows.
+//
+// Beispiel:
+//
+//	go run ./cmd/pcapbench -generate /tmp/bench.pcap -packets 2000000 -workers 1,2,4,8
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+var (
+	configFile   = flag.String("config", "", "Pfad zur Konfigurationsdatei (Standard: Standardkonfiguration)")
+	pcapFile     = flag.String("pcap", "", "Zu analysierende PCAP-Datei")
+	generateFile = flag.String("generate", "", "Synthetische PCAP-Datei an diesem Pfad erzeugen und analysieren")
+	packetCount  = flag.Int("packets", 1000000, "Anzahl der Pakete für -generate")
+	flowCount    = flag.Int("flows", 5000, "Anzahl der Flows für -generate")
+	workerList   = flag.String("workers", "1,2,4,8", "Kommagetrennte Anzahlen an Dekodier-Workern")
+	runs         = flag.Int("runs", 3, "Durchläufe pro Worker-Anzahl (bester Wert zählt)")
+)
+
+func main() {
+	flag.Parse()
+
+	cfg := config.DefaultConfig()
+	if *configFile != "" {
+		var err error
+		if cfg, err = config.LoadConfig(*configFile); err != nil {
+			log.Fatalf("Fehler beim Laden der Konfiguration: %v", err)
+		}
+	}
+
+	path := *pcapFile
+	if *generateFile != "" {
+		log.Printf("Erzeuge %d Pakete in %d Flows: %s", *packetCount, *flowCount, *generateFile)
+		if err := generatePcap(*generateFile, *packetCount, *flowCount); err != nil {
+			log.Fatalf("Fehler beim Erzeugen der PCAP-Datei: %v", err)
+		}
+		path = *generateFile
+	}
+	if path == "" {
+		log.Fatal("Bitte -pcap oder -generate angeben")
+	}
+
+	workerCounts, err := parseWorkerList(*workerList)
+	if err != nil {
+		log.Fatalf("Ungültige Worker-Liste: %v", err)
+	}
+
+	// Die Erfassung schreibt Debug-Ausgaben auf stdout; für die Messung unterdrücken
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		log.Fatalf("Fehler beim Öffnen von %s: %v", os.DevNull, err)
+	}
+	defer devNull.Close()
+
+	fmt.Fprintf(stdout, "%-8s %12s %14s %10s %9s\n", "Worker", "Pakete", "Pakete/s", "MB/s", "Faktor")
+
+	var baseline float64
+	for _, workers := range workerCounts {
+		var best result
+		for i := 0; i < *runs; i++ {
+			os.Stdout = devNull
+			res, err := measure(cfg, path, workers)
+			os.Stdout = stdout
+			if err != nil {
+				log.Fatalf("Messung mit %d Workern fehlgeschlagen: %v", workers, err)
+			}
+			if res.packetsPerSecond() > best.packetsPerSecond() {
+				best = res
+			}
+		}
+
+		if baseline == 0 {
+			baseline = best.packetsPerSecond()
+		}
+		fmt.Fprintf(stdout, "%-8d %12d %14.0f %10.1f %8.2fx\n", workers, best.packets,
+			best.packetsPerSecond(), float64(best.bytes)/best.duration.Seconds()/1e6,
+			best.packetsPerSecond()/baseline)
+	}
+}
+
+// result enthält die Messwerte eines Durchlaufs
+type result struct {
+	packets  int
+	bytes    int64
+	duration time.Duration
+}
+
+// packetsPerSecond gibt den Durchsatz eines Durchlaufs zurück
+func (r result) packetsPerSecond() float64 {
+	if r.duration <= 0 {
+		return 0
+	}
+	return float64(r.packets) / r.duration.Seconds()
+}
+
+// measure analysiert die Datei einmal vollständig mit der angegebenen Anzahl an Workern
+func measure(base *config.Config, path string, workers int) (result, error) {
+	cfg := *base
+	cfg.Capture.DecodeWorkers = workers
+	capturer := packet.NewPcapCapturer(&cfg)
+
+	session, err := capturer.OpenPcapFile(path)
+	if err != nil {
+		return result{}, err
+	}
+	defer session.Stop()
+	// Die Datei ersetzt hier eine Live-Schnittstelle; ohne diese Freigabe würde sie in
+	// einem einzigen Worker analysiert
+	session.AllowParallelFileDecoding()
+
+	start := time.Now()
+	packetChan, errChan := session.Start(context.Background())
+
+	var res result
+	for packetChan != nil || errChan != nil {
+		select {
+		case p, ok := <-packetChan:
+			if !ok {
+				packetChan = nil
+				continue
+			}
+			res.packets++
+			res.bytes += int64(p.Length)
+			packet.ReleasePacketInfo(p)
+		case _, ok := <-errChan:
+			if !ok {
+				errChan = nil
+			}
+		}
+	}
+	res.duration = time.Since(start)
+	return res, nil
+}
+
+// parseWorkerList liest eine Liste wie "1,2,4,8"
+func parseWorkerList(list string) ([]int, error) {
+	var counts []int
+	for _, field := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("'%s' ist keine positive Zahl", field)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// generatePcap schreibt eine PCAP-Datei mit TCP-, UDP- und DNS-Verkehr zwischen lokalen
+// Clients und externen Servern über ein Gateway
+func generatePcap(path string, packets, flows int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := pcapgo.NewWriter(file)
+	if err := writer.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		return err
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	clientMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	gatewayMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+	payload := make([]byte, 512)
+	rng.Read(payload)
+
+	type flow struct {
+		client, server net.IP
+		clientPort     uint16
+		serverPort     uint16
+		udp            bool
+	}
+	flowTable := make([]flow, flows)
+	for i := range flowTable {
+		client := make(net.IP, 4)
+		binary.BigEndian.PutUint32(client, 0xc0a80000|uint32(rng.Intn(65000)+1)) // 192.168.x.x
+		server := make(net.IP, 4)
+		binary.BigEndian.PutUint32(server, 0x5d000000|uint32(rng.Intn(1<<24))) // 93.x.x.x
+		f := flow{client: client, server: server, clientPort: uint16(1024 + rng.Intn(60000)), serverPort: 443}
+		switch i % 10 {
+		case 0:
+			f.udp, f.serverPort = true, 53
+		case 1, 2:
+			f.udp = true
+		}
+		flowTable[i] = f
+	}
+
+	buffer := gopacket.NewSerializeBuffer()
+	options := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	timestamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < packets; i++ {
+		f := flowTable[rng.Intn(len(flowTable))]
+		outbound := rng.Intn(2) == 0
+
+		eth := &layers.Ethernet{SrcMAC: clientMAC, DstMAC: gatewayMAC, EthernetType: layers.EthernetTypeIPv4}
+		ip := &layers.IPv4{Version: 4, TTL: 64, SrcIP: f.client, DstIP: f.server}
+		srcPort, dstPort := f.clientPort, f.serverPort
+		if !outbound {
+			eth.SrcMAC, eth.DstMAC = gatewayMAC, clientMAC
+			ip.SrcIP, ip.DstIP = f.server, f.client
+			srcPort, dstPort = dstPort, srcPort
+		}
+
+		var stack []gopacket.SerializableLayer
+		switch {
+		case f.udp && f.serverPort == 53:
+			ip.Protocol = layers.IPProtocolUDP
+			udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+			udp.SetNetworkLayerForChecksum(ip)
+			dns := &layers.DNS{
+				ID: uint16(i), QR: !outbound, OpCode: layers.DNSOpCodeQuery,
+				Questions: []layers.DNSQuestion{{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+			}
+			if !outbound {
+				dns.Answers = []layers.DNSResourceRecord{{
+					Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 300, IP: f.server,
+				}}
+			}
+			stack = []gopacket.SerializableLayer{eth, ip, udp, dns}
+		case f.udp:
+			ip.Protocol = layers.IPProtocolUDP
+			udp := &layers.UDP{SrcPort: layers.UDPPort(srcPort), DstPort: layers.UDPPort(dstPort)}
+			udp.SetNetworkLayerForChecksum(ip)
+			stack = []gopacket.SerializableLayer{eth, ip, udp, gopacket.Payload(payload[:rng.Intn(len(payload))])}
+		default:
+			ip.Protocol = layers.IPProtocolTCP
+			tcp := &layers.TCP{SrcPort: layers.TCPPort(srcPort), DstPort: layers.TCPPort(dstPort),
+				Seq: uint32(i), ACK: true, Window: 65535}
+			tcp.SetNetworkLayerForChecksum(ip)
+			stack = []gopacket.SerializableLayer{eth, ip, tcp, gopacket.Payload(payload[:rng.Intn(len(payload))])}
+		}
+
+		if err := gopacket.SerializeLayers(buffer, options, stack...); err != nil {
+			return err
+		}
+		data := buffer.Bytes()
+		timestamp = timestamp.Add(10 * time.Microsecond)
+		ci := gopacket.CaptureInfo{Timestamp: timestamp, CaptureLength: len(data), Length: len(data)}
+		if err := writer.WritePacket(ci, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
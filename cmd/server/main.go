@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -17,6 +18,7 @@ import (
 
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/api"
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
 	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
 )
@@ -30,9 +32,9 @@ var (
 	interface_name = flag.String("interface", "", "Netzwerkschnittstelle für Live-Capture")
 )
 
-// Globale Variablen für aktive WebSocket-Verbindungen
+// Globale Variablen für aktive WebSocket-Verbindungen mit dem Anzeigefilter ihrer Subscription
 var (
-	activeWebSockets = make(map[*websocket.Conn]bool)
+	activeWebSockets = make(map[*websocket.Conn]*displayfilter.Filter)
 	wsLock           = make(chan bool, 1) // Ein einfacher Mutex für Zugriff auf activeWebSockets
 )
 
@@ -70,15 +72,53 @@ func main() {
 	// Ausgabeverzeichnisse erstellen
 	createDirs(cfg)
 
+	// Persistierte Agent-Registry laden
+	if err := api.InitAgentRegistry(cfg.Storage.AgentRegistryPath); err != nil {
+		log.Printf("Warnung: Agent-Registry konnte nicht geladen werden: %v", err)
+	}
+
+	// Vom Server verteilte Agent-Konfigurationen laden
+	if err := api.InitAgentConfigStore(cfg.Storage.AgentConfigPath); err != nil {
+		log.Printf("Warnung: Agent-Konfigurationen konnten nicht geladen werden: %v", err)
+	}
+
+	// Bereitgestellte Agent-Releases laden
+	if err := api.InitAgentReleaseStore(cfg.Storage.AgentReleaseDir); err != nil {
+		log.Printf("Warnung: Agent-Releases konnten nicht geladen werden: %v", err)
+	}
+
+	// Schwellwert für Warnungen bei Paketverlusten
+	api.SetDropWarningThreshold(cfg.Capture.DropWarningThreshold)
+
 	// Signalbehandlung für sauberes Herunterfahren
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	setupSignalHandler(cancel)
 
-	// PCAP-Capturer erstellen
+	// PCAP-Capturer erstellen; jede Erfassung öffnet darüber eine eigene Session
 	capturer := packet.NewPcapCapturer(cfg)
-	defer capturer.Close()
+
+	// Ereignisgesteuerte Aufzeichnungen vorbereiten
+	if err := api.InitTriggerRecording(&cfg.Capture); err != nil {
+		log.Printf("Warnung: Ereignisgesteuerte Aufzeichnung nicht verfügbar: %v", err)
+	}
+
+	// Fortlaufende Aufzeichnung in pcap_dir vorbereiten
+	if err := api.InitPcapArchive(&cfg.Capture); err != nil {
+		log.Printf("Warnung: Fortlaufende Aufzeichnung nicht verfügbar: %v", err)
+	}
+
+	// Geplante Capture-Jobs laden und den Scheduler starten
+	if err := api.InitCaptureJobs(cfg.Storage.CaptureJobsPath, capturer); err != nil {
+		log.Printf("Warnung: Capture-Jobs konnten nicht geladen werden: %v", err)
+	}
+	go api.RunCaptureJobScheduler(ctx.Done())
+
+	// Analyse-Jobs laden; unterbrochene Analysen werden erneut eingereiht
+	if err := api.InitAnalysisJobs(cfg.Storage.AnalysisJobsDir, capturer); err != nil {
+		log.Printf("Warnung: Analyse-Jobs konnten nicht geladen werden: %v", err)
+	}
 
 	// API-Router initialisieren
 	router := mux.NewRouter()
@@ -96,6 +136,11 @@ func main() {
 		Handler: router,
 	}
 
+	// Server im LAN ankündigen und nach Agents suchen
+	if cfg.Server.EnableDiscovery {
+		api.StartDiscovery(ctx, cfg.Server.Host, cfg.Server.Port)
+	}
+
 	go func() {
 		log.Printf("Server gestartet auf %s", listenAddr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -107,12 +152,13 @@ func main() {
 	if *pcapFile != "" {
 		log.Printf("Analysiere PCAP-Datei: %s", *pcapFile)
 
-		err := capturer.OpenPcapFile(*pcapFile)
+		session, err := capturer.OpenPcapFile(*pcapFile)
 		if err != nil {
 			log.Fatalf("Fehler beim Öffnen der PCAP-Datei: %v", err)
 		}
+		defer session.Stop()
 
-		packetChan, errChan := capturer.StartCapture(ctx)
+		packetChan, errChan := session.Start(ctx)
 
 		// Pakete verarbeiten
 		go processPackets(packetChan, errChan)
@@ -120,16 +166,25 @@ func main() {
 		// Live-Capture starten
 		log.Printf("Starte Live-Capture auf Schnittstelle: %s", cfg.Capture.Interface)
 
-		err := capturer.OpenLiveCapture(cfg.Capture.Interface)
+		session, err := capturer.OpenLiveCapture(cfg.Capture.Interface, "")
 		if err != nil {
 			log.Fatalf("Fehler beim Öffnen der Netzwerkschnittstelle %s: %v",
 				cfg.Capture.Interface, err)
 		}
+		defer func() {
+			session.Stop()
+			api.DetachArchiveRecorder(session)
+		}()
 
-		packetChan, errChan := capturer.StartCapture(ctx)
+		api.AttachTriggerRecorder(session)
+		api.AttachArchiveRecorder(session)
+		packetChan, errChan := session.Start(ctx)
 
 		// Pakete live verarbeiten und an WebSockets streamen
-		go processLivePackets(packetChan, errChan)
+		go processLivePackets(session, packetChan, errChan)
+
+		// Verlustrate überwachen
+		go api.MonitorCaptureDrops(ctx, session, fmt.Sprintf("Live-Capture %s", cfg.Capture.Interface))
 	}
 
 	// Auf Kontext-Abbruch warten
@@ -149,7 +204,7 @@ func main() {
 // addWebSocket fügt eine neue WebSocket-Verbindung zur aktiven Liste hinzu
 func addWebSocket(conn *websocket.Conn) {
 	wsLock <- true
-	activeWebSockets[conn] = true
+	activeWebSockets[conn] = nil
 	<-wsLock
 }
 
@@ -180,9 +235,15 @@ func broadcastPacketInfo(packet *models.PacketInfo) {
 		IsGatewayTraffic: packet.IsGatewayTraffic,
 		Summary:          createPacketSummary(packet),
 	}
+	if n := len(packet.VLANs); n > 0 {
+		summary.VLANID = packet.VLANs[n-1].ID
+	}
 
-	// An alle aktiven WebSockets senden
-	for conn := range activeWebSockets {
+	// An alle aktiven WebSockets senden, deren Anzeigefilter passt
+	for conn, filter := range activeWebSockets {
+		if !filter.Match(packet) {
+			continue
+		}
 		err := conn.WriteJSON(map[string]interface{}{
 			"type": "packet",
 			"data": summary,
@@ -232,6 +293,19 @@ func createDirs(cfg *config.Config) {
 		cfg.Capture.PCAPDir,
 	}
 
+	if cfg.Storage.AgentRegistryPath != "" {
+		dirs = append(dirs, filepath.Dir(cfg.Storage.AgentRegistryPath))
+	}
+	if cfg.Storage.AgentConfigPath != "" {
+		dirs = append(dirs, filepath.Dir(cfg.Storage.AgentConfigPath))
+	}
+	if cfg.Storage.CaptureJobsPath != "" {
+		dirs = append(dirs, filepath.Dir(cfg.Storage.CaptureJobsPath))
+	}
+	if cfg.Storage.AnalysisJobsDir != "" {
+		dirs = append(dirs, cfg.Storage.AnalysisJobsDir)
+	}
+
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Printf("Warnung: Verzeichnis %s konnte nicht erstellt werden: %v", dir, err)
@@ -268,11 +342,27 @@ func registerAPIHandlers(router *mux.Router, capturer *packet.PcapCapturer, cfg
 	// API-Endpunkte
 	apiRouter.HandleFunc("/health", api.HealthCheckHandler).Methods("GET")
 
-	// PCAP-Upload- und Analyse-Endpunkt
-	apiRouter.HandleFunc("/analyze", func(w http.ResponseWriter, r *http.Request) {
-		api.AnalyzePcapHandler(w, r, capturer)
+	// PCAP-Upload; die Analyse läuft als Analyse-Job im Hintergrund
+	apiRouter.HandleFunc("/analyze", api.AnalyzePcapHandler).Methods("POST")
+
+	// Export als PCAPNG mit Analyseergebnissen als Paketkommentare
+	apiRouter.HandleFunc("/analyze/export", func(w http.ResponseWriter, r *http.Request) {
+		api.ExportAnnotatedPcapHandler(w, r, capturer)
+	}).Methods("POST")
+
+	// Abfrage dekodierter Pakete einer Datei mit Anzeigefilter
+	apiRouter.HandleFunc("/analyze/packets", func(w http.ResponseWriter, r *http.Request) {
+		api.QueryPacketsHandler(w, r, capturer)
 	}).Methods("POST")
 
+	// Analyse-Jobs mit fortsetzbarem Upload, Fortschritt und Ergebnissen
+	apiRouter.HandleFunc("/jobs", api.ListAnalysisJobsHandler).Methods("GET")
+	apiRouter.HandleFunc("/jobs", api.CreateAnalysisJobHandler).Methods("POST")
+	apiRouter.HandleFunc("/jobs/{id}", api.GetAnalysisJobHandler).Methods("GET")
+	apiRouter.HandleFunc("/jobs/{id}", api.DeleteAnalysisJobHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/jobs/{id}/upload", api.UploadAnalysisJobHandler).Methods("PATCH")
+	apiRouter.HandleFunc("/jobs/{id}/cancel", api.CancelAnalysisJobHandler).Methods("POST")
+
 	// Websocket-Endpunkt für Live-Updates
 	apiRouter.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		conn, err := upgrader.Upgrade(w, r, nil)
@@ -296,6 +386,15 @@ func registerAPIHandlers(router *mux.Router, capturer *packet.PcapCapturer, cfg
 	// Verfügbare Netzwerkschnittstellen auflisten
 	apiRouter.HandleFunc("/interfaces", api.GetInterfacesHandler).Methods("GET")
 
+	// BPF-Filter vorab prüfen, z.B. während der Eingabe in der Oberfläche
+	apiRouter.HandleFunc("/filters/validate", func(w http.ResponseWriter, r *http.Request) {
+		api.ValidateFilterHandler(w, r, capturer)
+	}).Methods("POST")
+
+	// Anzeigefilter prüfen und verfügbare Felder auflisten
+	apiRouter.HandleFunc("/display-filters/validate", api.ValidateDisplayFilterHandler).Methods("POST")
+	apiRouter.HandleFunc("/display-filters/fields", api.ListDisplayFilterFieldsHandler).Methods("GET")
+
 	// Live-Capture starten/stoppen
 	apiRouter.HandleFunc("/live/start", func(w http.ResponseWriter, r *http.Request) {
 		api.StartLiveCaptureHandler(w, r, capturer)
@@ -305,20 +404,97 @@ func registerAPIHandlers(router *mux.Router, capturer *packet.PcapCapturer, cfg
 		api.StopLiveCaptureHandler(w, r)
 	}).Methods("POST")
 
+	apiRouter.HandleFunc("/live/status", func(w http.ResponseWriter, r *http.Request) {
+		api.LiveCaptureStatusHandler(w, r)
+	}).Methods("GET")
+
+	// Durch Trigger-Regeln ausgelöste Aufzeichnungen der Live-Capture
+	apiRouter.HandleFunc("/recordings", api.ListRecordingsHandler).Methods("GET")
+	apiRouter.HandleFunc("/recordings/{id}", api.DownloadRecordingHandler).Methods("GET")
+	apiRouter.HandleFunc("/pcap-files", api.ListPcapFilesHandler).Methods("GET")
+	apiRouter.HandleFunc("/pcap-files/{file}", api.DownloadPcapFileHandler).Methods("GET")
+
 	// Remote-Agent-Management-Endpunkte
+	// Geplante und begrenzte Captures auf dem Server oder auf Agents
+	apiRouter.HandleFunc("/capture-jobs", api.ListCaptureJobsHandler).Methods("GET")
+	apiRouter.HandleFunc("/capture-jobs", api.CreateCaptureJobHandler).Methods("POST")
+	apiRouter.HandleFunc("/capture-jobs/{id}", api.GetCaptureJobHandler).Methods("GET")
+	apiRouter.HandleFunc("/capture-jobs/{id}", api.DeleteCaptureJobHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/capture-jobs/{id}/run", api.RunCaptureJobHandler).Methods("POST")
+	apiRouter.HandleFunc("/capture-jobs/{id}/stop", api.StopCaptureJobHandler).Methods("POST")
+
 	apiRouter.HandleFunc("/agents", api.ListAgentsHandler).Methods("GET")
 	apiRouter.HandleFunc("/agents/register", api.RegisterAgentHandler).Methods("POST")
 	apiRouter.HandleFunc("/agents/unregister", api.UnregisterAgentHandler).Methods("POST")
 	apiRouter.HandleFunc("/agents/heartbeat", api.HeartbeatHandler).Methods("POST")
+	apiRouter.HandleFunc("/agents/interfaces", api.UpdateAgentInterfacesHandler).Methods("POST")
 	apiRouter.HandleFunc("/agents/capture/start", api.StartAgentCaptureHandler).Methods("POST")
 	apiRouter.HandleFunc("/agents/capture/stop", api.StopAgentCaptureHandler).Methods("POST")
 	apiRouter.HandleFunc("/agents/set-interface", api.SetInterfaceHandler).Methods("POST")
+	apiRouter.HandleFunc("/agents/discovered", api.ListDiscoveredAgentsHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/pcap", api.AgentPcapHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/recordings", api.ListAgentRecordingsHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/recordings/{id}", api.DownloadAgentRecordingHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/pcap-files", api.ListAgentPcapFilesHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/pcap-files/{file}", api.DownloadAgentPcapFileHandler).Methods("GET")
+
+	// Vom Server verteilte Agent-Konfigurationen (pro Agent oder pro Gruppe)
+	apiRouter.HandleFunc("/agents/configs", api.ListAgentConfigsHandler).Methods("GET")
+
+	// Signierte Agent-Releases für die automatische Aktualisierung
+	apiRouter.HandleFunc("/agents/releases", api.ListAgentReleasesHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/releases", api.UploadAgentReleaseHandler).Methods("POST")
+	apiRouter.HandleFunc("/agents/releases/target", api.SetAgentReleaseTargetHandler).Methods("PUT")
+	apiRouter.HandleFunc("/agents/releases/{version}/{os}/{arch}", api.DownloadAgentReleaseHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/releases/{version}/{os}/{arch}", api.DeleteAgentReleaseHandler).Methods("DELETE")
+
+	apiRouter.HandleFunc("/agents/groups/{group}/config", api.PutGroupConfigHandler).Methods("PUT")
+	apiRouter.HandleFunc("/agents/groups/{group}/config", api.DeleteGroupConfigHandler).Methods("DELETE")
+	apiRouter.HandleFunc("/agents/{name}/config", api.GetAgentConfigHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/{name}/config", api.PutAgentConfigHandler).Methods("PUT")
+	apiRouter.HandleFunc("/agents/{name}/config", api.DeleteAgentConfigHandler).Methods("DELETE")
+
+	// Synchronisierte Capture-Sessions über mehrere Agents
+	apiRouter.HandleFunc("/agents/sessions", api.ListCaptureSessionsHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/sessions", api.CreateCaptureSessionHandler).Methods("POST")
+	apiRouter.HandleFunc("/agents/sessions/{id}", api.GetCaptureSessionHandler).Methods("GET")
+	apiRouter.HandleFunc("/agents/sessions/{id}/stop", api.StopCaptureSessionHandler).Methods("POST")
 
 	// Status-Prüfung für Agents starten
 	go api.CheckAgentsStatus()
 }
 
-// handleWebSocketConnection verwaltet eine WebSocket-Verbindung
+// webSocketMessage ist eine Steuernachricht eines WebSocket-Clients
+type webSocketMessage struct {
+	Type          string `json:"type"` // "subscribe", "unsubscribe"
+	DisplayFilter string `json:"display_filter,omitempty"`
+}
+
+// subscribeWebSocket setzt den Anzeigefilter einer Verbindung und bestätigt ihn. Ungültige
+// Filter werden mit Position abgelehnt; der bisherige Filter bleibt dann bestehen.
+func subscribeWebSocket(conn *websocket.Conn, msg webSocketMessage) error {
+	wsLock <- true
+	defer func() { <-wsLock }()
+
+	filter, err := displayfilter.Compile(msg.DisplayFilter)
+	if err != nil {
+		return conn.WriteJSON(map[string]interface{}{
+			"type":  "error",
+			"error": err.Error(),
+			"data":  err,
+		})
+	}
+	if _, ok := activeWebSockets[conn]; ok {
+		activeWebSockets[conn] = filter
+	}
+	return conn.WriteJSON(map[string]interface{}{
+		"type": "subscribed",
+		"data": map[string]string{"display_filter": filter.String()},
+	})
+}
+
+// handleWebSocketConnection verwaltet eine WebSocket-Verbindung. Mit
+// {"type": "subscribe", "display_filter": "..."} erhält der Client nur passende Pakete.
 func handleWebSocketConnection(conn *websocket.Conn) {
 	defer conn.Close()
 	defer removeWebSocket(conn)
@@ -333,6 +509,19 @@ func handleWebSocketConnection(conn *websocket.Conn) {
 			break
 		}
 
+		// Steuernachrichten auswerten
+		var msg webSocketMessage
+		if json.Unmarshal(message, &msg) == nil && (msg.Type == "subscribe" || msg.Type == "unsubscribe") {
+			if msg.Type == "unsubscribe" {
+				msg.DisplayFilter = ""
+			}
+			if err := subscribeWebSocket(conn, msg); err != nil {
+				log.Printf("Fehler beim Senden der Nachricht: %v", err)
+				break
+			}
+			continue
+		}
+
 		// Einfache Echo-Antwort
 		if err := conn.WriteMessage(messageType, message); err != nil {
 			log.Printf("Fehler beim Senden der Nachricht: %v", err)
@@ -364,6 +553,7 @@ func processPackets(packetChan <-chan *models.PacketInfo, errChan <-chan error)
 			}
 
 			// Hier könnten wir Pakete weiter verarbeiten oder speichern
+			packet.ReleasePacketInfo(p)
 
 		case err, ok := <-errChan:
 			if !ok {
@@ -380,8 +570,8 @@ func processPackets(packetChan <-chan *models.PacketInfo, errChan <-chan error)
 	}
 }
 
-// processLivePackets verarbeitet Pakete in Echtzeit und streamt sie an WebSockets
-func processLivePackets(packetChan <-chan *models.PacketInfo, errChan <-chan error) {
+// processLivePackets verarbeitet Pakete einer Live-Session in Echtzeit und streamt sie an WebSockets
+func processLivePackets(session *packet.CaptureSession, packetChan <-chan *models.PacketInfo, errChan <-chan error) {
 	var packetCount int
 	var gatewayPackets int
 
@@ -400,6 +590,7 @@ func processLivePackets(packetChan <-chan *models.PacketInfo, errChan <-chan err
 			}
 
 			packetCount++
+			api.EvaluateTriggers(session, p)
 			if p.IsGatewayTraffic {
 				gatewayPackets++
 
@@ -409,6 +600,7 @@ func processLivePackets(packetChan <-chan *models.PacketInfo, errChan <-chan err
 				// Paketkennzahlen sammeln für Dashboard-Updates
 				// Hier könnte z.B. eine Aktualisierung von Statistiken erfolgen
 			}
+			packet.ReleasePacketInfo(p)
 
 		case err, ok := <-errChan:
 			if !ok {
@@ -6,6 +6,6 @@ require (
 	github.com/google/gopacket v1.1.19
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
+	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	golang.org/x/sys v0.0.0-20190412213103-97732733099d
 )
-
-require golang.org/x/sys v0.0.0-20190412213103-97732733099d // indirect
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
@@ -16,22 +18,32 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
 	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
 )
 
 // AgentStatus enthält die aktuellen Status-Informationen des Agents
 type AgentStatus struct {
 	Name            string    `json:"name"`
-	Status          string    `json:"status"` // "idle", "capturing", "error"
+	Status          string    `json:"status"` // "idle", "scheduled", "capturing", "error"
 	LastHeartbeat   time.Time `json:"last_heartbeat"`
 	StartTime       time.Time `json:"start_time"`
 	PacketsCaptured int       `json:"packets_captured"`
 	Interface       string    `json:"interface"`
+	SessionID       string    `json:"session_id,omitempty"`
+	CaptureStarted  time.Time `json:"capture_started,omitempty"`
+	ConfigVersion   int       `json:"config_version"`         // angewendete Version der Server-Konfiguration
+	ConfigError     string    `json:"config_error,omitempty"` // Fehler beim Anwenden der letzten Version
+	Version         string    `json:"version"`
+	UpdateError     string    `json:"update_error,omitempty"` // Fehler der letzten automatischen Aktualisierung
 	Error           string    `json:"error,omitempty"`
+
+	// Einzelne Captures; die Felder oben fassen sie für ältere Clients zusammen
+	Captures []CaptureStatus     `json:"captures"`
+	Stats    packet.CaptureStats `json:"stats"` // Summe der Zähler aller Captures
 }
 
 // AgentInfo enthält die Registrierungsinformationen für den Server
@@ -42,7 +54,13 @@ type AgentInfo struct {
 	InterfaceDetails []map[string]interface{} `json:"interface_details"`
 	Version          string                   `json:"version"`
 	OS               string                   `json:"os"`
+	Arch             string                   `json:"arch"`
 	Hostname         string                   `json:"hostname"`
+	Group            string                   `json:"group,omitempty"`
+
+	// Protokollversion und Fähigkeiten für die Kompatibilitätsprüfung des Servers
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
 }
 
 // APIResponse ist eine generische API-Antwortstruktur
@@ -53,22 +71,110 @@ type APIResponse struct {
 	Error   string      `json:"error,omitempty"`
 }
 
+// Fehlermeldung des Servers, wenn ein Heartbeat von einem unbekannten Agent kommt
+const agentNotRegisteredError = "Agent nicht registriert"
+
 // CaptureRequest enthält die Konfiguration für eine Capture-Anfrage
 type CaptureRequest struct {
+	Name      string `json:"name,omitempty"` // Name der Capture, Standard ist die Schnittstelle
 	Interface string `json:"interface"`
 	Filter    string `json:"filter,omitempty"`
+
+	// Optionale Session-ID und gemeinsame Startzeit für synchronisierte Multi-Agent-Captures
+	SessionID string    `json:"session_id,omitempty"`
+	StartAt   time.Time `json:"start_at,omitempty"`
+
+	// Optionale Begrenzung; die Capture endet mit Status "completed", sobald eine Grenze erreicht ist
+	DurationSeconds int   `json:"duration_seconds,omitempty"`
+	MaxPackets      int64 `json:"max_packets,omitempty"`
+	MaxBytes        int64 `json:"max_bytes,omitempty"`
+}
+
+// StopCaptureRequest enthält die optionalen Parameter zum Stoppen einer Capture
+type StopCaptureRequest struct {
+	Name      string `json:"name,omitempty"`
+	Interface string `json:"interface,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+}
+
+// ClockSample enthält die vier Zeitstempel eines Heartbeat-Austauschs für die
+// Schätzung des Uhrenversatzes auf dem Server (NTP-Verfahren)
+type ClockSample struct {
+	AgentSend     time.Time `json:"agent_send"`
+	ServerReceive time.Time `json:"server_receive"`
+	ServerSend    time.Time `json:"server_send"`
+	AgentReceive  time.Time `json:"agent_receive"`
+}
+
+// heartbeatResponse ist die Antwort des Servers auf einen Heartbeat
+type heartbeatResponse struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	Data    struct {
+		AgentSendTime     time.Time `json:"agent_send_time"`
+		ServerReceiveTime time.Time `json:"server_receive_time"`
+		ServerSendTime    time.Time `json:"server_send_time"`
+
+		// Neue Konfiguration, falls sich die angewendete Version unterscheidet
+		Config *config.AgentSettings `json:"config,omitempty"`
+
+		// Angekündigte Agent-Version, falls sie sich von der laufenden unterscheidet
+		Update *config.AgentUpdate `json:"update,omitempty"`
+	} `json:"data"`
+}
+
+// registerResponse ist die Antwort des Servers auf eine Registrierung
+type registerResponse struct {
+	Success bool `json:"success"`
+	Data    struct {
+		Config *config.AgentSettings `json:"config,omitempty"`
+	} `json:"data"`
 }
 
 // CaptureAgent verwaltet die Packet-Capture und API-Kommunikation
 type CaptureAgent struct {
-	config       *config.Config
-	status       AgentStatus
-	statusMutex  sync.RWMutex
-	capturer     *packet.PcapCapturer
-	activeCtx    context.Context
-	cancelFunc   context.CancelFunc
-	clients      map[*websocket.Conn]bool
+	config      *config.Config
+	status      AgentStatus
+	statusMutex sync.RWMutex
+	captures    map[string]*activeCapture // laufende und geplante Captures nach Name, geschützt durch statusMutex
+	// Ereignisse für den nächsten Heartbeat, geschützt durch statusMutex
+	pendingEvents []models.GatewayEvent
+	// PCAP-Ringpuffer je Schnittstelle, nil wenn deaktiviert
+	ring *packet.PcapRingSet
+	// Durch Trigger-Regeln ausgelöste Aufzeichnungen, nil wenn keine Regeln aktiv sind
+	recordings *packet.RecordingStore
+	// Fortlaufende Aufzeichnung in pcap_dir, nil wenn deaktiviert
+	archive      *packet.CaptureArchive
+	clients      map[*wsClient]bool
 	clientsMutex sync.Mutex
+
+	// Zeitmessung des letzten Heartbeats, wird mit dem nächsten Heartbeat gesendet
+	lastClockSample *ClockSample
+
+	// Schützt config.Agent.ServerURL, die mDNS-Suche und Admin-Oberfläche zur Laufzeit ändern;
+	// Zugriff nur über serverURL und setServerURL
+	serverURLMutex sync.RWMutex
+
+	// Stand der vom Server verteilten Konfiguration
+	configMutex         sync.Mutex
+	configVersion       int
+	failedConfigVersion int
+
+	// Stand der automatischen Aktualisierung
+	updateMutex         sync.Mutex
+	updating            bool
+	failedUpdateVersion string
+	pendingUpdate       *updateState // neue Version wartet auf Bestätigung durch Registrierung
+	rollbackTimer       *time.Timer
+
+	// Beendet mDNS-Ankündigung und Serversuche
+	discoveryCancel context.CancelFunc
+
+	// Beendet die Überwachung der Netzwerkschnittstellen
+	interfaceWatchCancel context.CancelFunc
+
+	// Löst einen sofortigen Heartbeat aus, z.B. nach Schnittstellenereignissen
+	heartbeatTrigger chan struct{}
 }
 
 // NewCaptureAgent erstellt eine neue Instanz des CaptureAgent
@@ -81,26 +187,65 @@ func NewCaptureAgent(config *config.Config) *CaptureAgent {
 			StartTime:     time.Now(),
 			LastHeartbeat: time.Now(),
 			Interface:     config.Agent.Interface,
+			Version:       version.Version,
 		},
-		clients: make(map[*websocket.Conn]bool),
+		captures:         make(map[string]*activeCapture),
+		clients:          make(map[*wsClient]bool),
+		heartbeatTrigger: make(chan struct{}, 1),
 	}
 }
 
 // Init initialisiert den CaptureAgent
 func (a *CaptureAgent) Init() error {
-	a.capturer = packet.NewPcapCapturer(a.config)
+	// Ringpuffer für Rohpakete anlegen, falls aktiviert
+	if ringConfig := a.config.Agent.RingBuffer; ringConfig != nil && ringConfig.Enabled {
+		ring, err := packet.NewPcapRingSet(ringConfig, a.config.Capture.SnapLen)
+		if err != nil {
+			return fmt.Errorf("failed to create ring buffer: %w", err)
+		}
+		a.ring = ring
+		log.Printf("PCAP ring buffer enabled")
+	}
+
+	// Ablage für ereignisgesteuerte Aufzeichnungen anlegen, falls Trigger-Regeln aktiv sind
+	if triggers := a.config.Capture.Triggers; triggers != nil && triggers.Enabled && len(triggers.Rules) > 0 {
+		store, err := packet.NewRecordingStore(triggers)
+		if err != nil {
+			return fmt.Errorf("failed to create recording store: %w", err)
+		}
+		a.recordings = store
+		log.Printf("Trigger recording enabled with %d rules", len(triggers.Rules))
+	}
+
+	// Fortlaufende Aufzeichnung in pcap_dir anlegen, falls aktiviert
+	if archiveConfig := a.config.Capture.Archive; archiveConfig != nil && archiveConfig.Enabled {
+		archive, err := packet.NewCaptureArchive(a.config.Capture.PCAPDir, archiveConfig, a.config.Capture.SnapLen)
+		if err != nil {
+			return fmt.Errorf("failed to create capture archive: %w", err)
+		}
+		a.archive = archive
+		log.Printf("Continuous capture recording enabled in %s", a.config.Capture.PCAPDir)
+	}
 
 	// Sicherstellen, dass Interface im Status gesetzt ist
 	a.statusMutex.Lock()
 	a.status.Interface = a.config.Agent.Interface
 	a.statusMutex.Unlock()
 
+	// Ausstehende Aktualisierung bestätigen oder zurückrollen
+	a.checkPendingUpdate()
+
 	// Heartbeat-Routine starten
 	go a.heartbeatRoutine()
 
+	// Schnittstellen überwachen, Captures bei Link-Ausfällen pausieren und fortsetzen
+	watchCtx, cancel := context.WithCancel(context.Background())
+	a.interfaceWatchCancel = cancel
+	go a.watchInterfaces(watchCtx)
+
 	// Automatische Registrierung versuchen, wenn eine Server-URL konfiguriert ist
-	if a.config.Agent.ServerURL != "" {
-		log.Printf("Versuche automatische Registrierung beim Server: %s", a.config.Agent.ServerURL)
+	if a.serverURL() != "" {
+		log.Printf("Versuche automatische Registrierung beim Server: %s", a.serverURL())
 		go func() {
 			// Kurz warten, um sicherzustellen, dass der Server gestartet ist
 			time.Sleep(2 * time.Second)
@@ -125,24 +270,34 @@ func (a *CaptureAgent) Init() error {
 				a.statusMutex.Unlock()
 			}
 		}()
-	} else {
+	} else if a.config.Agent.DisableDiscovery {
 		log.Println("Keine Server-URL konfiguriert. Verwenden Sie die Web-UI zur manuellen Konfiguration und Registrierung.")
 	}
 
+	// Agent im LAN ankündigen und ggf. den Server suchen
+	if !a.config.Agent.DisableDiscovery {
+		a.startDiscovery()
+	}
+
 	return nil
 }
 
-// Register registriert den Agent beim Hauptserver
-func (a *CaptureAgent) Register() error {
-	// Netzwerkschnittstellen abfragen
-	ifaces, err := net.Interfaces()
-	if err != nil {
-		return fmt.Errorf("failed to list interfaces: %v", err)
-	}
+// serverURL gibt die aktuelle Server-URL zurück (leer = kein Server bekannt)
+func (a *CaptureAgent) serverURL() string {
+	a.serverURLMutex.RLock()
+	defer a.serverURLMutex.RUnlock()
+	return a.config.Agent.ServerURL
+}
 
-	var interfaceNames []string
-	var interfaceDetails []map[string]interface{}
+// setServerURL ändert die Server-URL, z.B. nach einem mDNS-Fund oder über die Admin-Oberfläche
+func (a *CaptureAgent) setServerURL(serverURL string) {
+	a.serverURLMutex.Lock()
+	a.config.Agent.ServerURL = serverURL
+	a.serverURLMutex.Unlock()
+}
 
+// Register registriert den Agent beim Hauptserver
+func (a *CaptureAgent) Register() error {
 	// Hostname für die Registrierung abrufen
 	hostname, _ := os.Hostname()
 	if hostname == "" {
@@ -159,7 +314,7 @@ func (a *CaptureAgent) Register() error {
 	actualIP := host
 	if host == "0.0.0.0" || host == "::" || host == "" {
 		// Die Server-URL parsen, um die Netzwerk-Route zu bestimmen
-		serverURL, err := url.Parse(a.config.Agent.ServerURL)
+		serverURL, err := url.Parse(a.serverURL())
 		if err != nil {
 			log.Printf("Warnung: Konnte Server-URL nicht parsen: %v", err)
 		} else {
@@ -176,64 +331,10 @@ func (a *CaptureAgent) Register() error {
 	// Die vollständige URL des Agents erstellen
 	agentURL := fmt.Sprintf("http://%s:%s", actualIP, port)
 
-	// Detaillierte Schnittstelleninformationen sammeln
-	for _, iface := range ifaces {
-		// Lokale Loopback-Schnittstellen und inaktive Schnittstellen überspringen
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
-			continue
-		}
-
-		// IP-Adressen der Schnittstelle abrufen
-		addrs, err := iface.Addrs()
-		if err != nil {
-			log.Printf("Error getting addresses for interface %s: %v", iface.Name, err)
-			continue
-		}
-
-		// IP-Adressen sammeln
-		var ipStrings []string
-		for _, addr := range addrs {
-			ipNet, ok := addr.(*net.IPNet)
-			if ok && !ipNet.IP.IsLoopback() {
-				if ipNet.IP.To4() != nil || ipNet.IP.To16() != nil {
-					ipStrings = append(ipStrings, ipNet.IP.String())
-				}
-			}
-		}
-
-		// Bridge-Status prüfen (Linux-spezifisch)
-		isBridge := false
-		bridgePorts := ""
-
-		// Bridge-Schnittstellen erkennen (Linux-spezifisch)
-		if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/bridge", iface.Name)); err == nil {
-			isBridge = true
-
-			// Bridge-Ports auslesen
-			files, err := os.ReadDir(fmt.Sprintf("/sys/class/net/%s/brif", iface.Name))
-			if err == nil {
-				var ports []string
-				for _, file := range files {
-					ports = append(ports, file.Name())
-				}
-				bridgePorts = strings.Join(ports, ", ")
-			}
-		}
-
-		// Schnittstelle zur Liste hinzufügen
-		interfaceNames = append(interfaceNames, iface.Name)
-
-		// Detaillierte Informationen hinzufügen
-		ifaceDetails := map[string]interface{}{
-			"name":         iface.Name,
-			"mac":          iface.HardwareAddr.String(),
-			"ips":          ipStrings,
-			"is_bridge":    isBridge,
-			"bridge_ports": bridgePorts,
-			"flags":        iface.Flags.String(),
-			"mtu":          iface.MTU,
-		}
-		interfaceDetails = append(interfaceDetails, ifaceDetails)
+	// Aktive Netzwerkschnittstellen mit Details sammeln
+	interfaceNames, interfaceDetails, err := collectInterfaces()
+	if err != nil {
+		return err
 	}
 
 	// AgentInfo erstellen
@@ -242,9 +343,13 @@ func (a *CaptureAgent) Register() error {
 		URL:              agentURL,
 		Interfaces:       interfaceNames,
 		InterfaceDetails: interfaceDetails,
-		Version:          "0.1.0", // TODO: aus Versionsdatei lesen
+		Version:          version.Version,
 		OS:               runtime.GOOS,
+		Arch:             runtime.GOARCH,
 		Hostname:         hostname,
+		Group:            a.config.Agent.Group,
+		ProtocolVersion:  version.ProtocolVersion,
+		Capabilities:     version.AgentCapabilities(),
 	}
 
 	// JSON-Kodierung
@@ -254,12 +359,12 @@ func (a *CaptureAgent) Register() error {
 	}
 
 	// Überprüfe die Server-URL
-	if a.config.Agent.ServerURL == "" {
+	if a.serverURL() == "" {
 		return fmt.Errorf("server URL is not configured")
 	}
 
 	// Registrierungs-URL zusammensetzen
-	registerURL := fmt.Sprintf("%s/api/agents/register", a.config.Agent.ServerURL)
+	registerURL := fmt.Sprintf("%s/api/agents/register", a.serverURL())
 	log.Printf("Sending registration request to: %s", registerURL)
 
 	// HTTP-Request senden
@@ -286,24 +391,122 @@ func (a *CaptureAgent) Register() error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
+		// Fehlermeldung des Servers übernehmen, z.B. bei einer inkompatiblen Protokollversion
+		statusError := fmt.Sprintf("Server antwortete mit Status: %d", resp.StatusCode)
+		var errResp APIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
+			statusError = errResp.Error
+		}
+
 		// Status auf error setzen
 		a.statusMutex.Lock()
 		a.status.Status = "error"
-		a.status.Error = fmt.Sprintf("Server antwortete mit Status: %d", resp.StatusCode)
+		a.status.Error = statusError
 		a.statusMutex.Unlock()
+		if resp.StatusCode == http.StatusUpgradeRequired {
+			return fmt.Errorf("server rejected incompatible agent: %s", statusError)
+		}
 		return fmt.Errorf("server returned non-OK status: %d", resp.StatusCode)
 	}
 
-	// Status auf idle setzen bei erfolgreicher Registrierung
+	// Status auf idle setzen bei erfolgreicher Registrierung, laufende Captures bleiben unberührt
 	a.statusMutex.Lock()
-	a.status.Status = "idle"
+	if a.status.Status == "error" {
+		a.status.Status = "idle"
+	}
 	a.status.Error = ""
 	a.statusMutex.Unlock()
 
-	log.Printf("Agent registered successfully with server %s", a.config.Agent.ServerURL)
+	log.Printf("Agent registered successfully with server %s", a.serverURL())
+
+	// Eine neu installierte Version gilt mit der ersten erfolgreichen Registrierung als bestätigt
+	a.confirmUpdate()
+
+	// Vom Server verteilte Konfiguration anwenden
+	var registerResp registerResponse
+	if err := json.NewDecoder(resp.Body).Decode(&registerResp); err != nil {
+		log.Printf("Warnung: Registrierungsantwort konnte nicht gelesen werden: %v", err)
+	} else if registerResp.Data.Config != nil {
+		a.applyServerSettings(registerResp.Data.Config)
+	}
+
 	return nil
 }
 
+// collectInterfaces liefert die Namen und Details aller aktiven, nicht-lokalen Schnittstellen
+func collectInterfaces() ([]string, []map[string]interface{}, error) {
+	// Netzwerkschnittstellen abfragen
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list interfaces: %v", err)
+	}
+
+	var interfaceNames []string
+	var interfaceDetails []map[string]interface{}
+
+	// Detaillierte Schnittstelleninformationen sammeln
+	for _, iface := range ifaces {
+		// Lokale Loopback-Schnittstellen und inaktive Schnittstellen überspringen
+		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+
+		// IP-Adressen der Schnittstelle abrufen
+		addrs, err := iface.Addrs()
+		if err != nil {
+			log.Printf("Error getting addresses for interface %s: %v", iface.Name, err)
+			continue
+		}
+
+		// IP-Adressen sammeln
+		var ipStrings []string
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if ok && !ipNet.IP.IsLoopback() {
+				if ipNet.IP.To4() != nil || ipNet.IP.To16() != nil {
+					ipStrings = append(ipStrings, ipNet.IP.String())
+				}
+			}
+		}
+
+		// Bridge-Status prüfen (Linux-spezifisch)
+		isBridge := false
+		bridgePorts := ""
+
+		// Bridge-Schnittstellen erkennen (Linux-spezifisch)
+		if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/bridge", iface.Name)); err == nil {
+			isBridge = true
+
+			// Bridge-Ports auslesen
+			files, err := os.ReadDir(fmt.Sprintf("/sys/class/net/%s/brif", iface.Name))
+			if err == nil {
+				var ports []string
+				for _, file := range files {
+					ports = append(ports, file.Name())
+				}
+				bridgePorts = strings.Join(ports, ", ")
+			}
+		}
+
+		// Schnittstelle zur Liste hinzufügen
+		interfaceNames = append(interfaceNames, iface.Name)
+
+		// Detaillierte Informationen hinzufügen
+		ifaceDetails := map[string]interface{}{
+			"name":         iface.Name,
+			"mac":          iface.HardwareAddr.String(),
+			"ips":          ipStrings,
+			"is_bridge":    isBridge,
+			"bridge_ports": bridgePorts,
+			"flags":        iface.Flags.String(),
+			"mtu":          iface.MTU,
+		}
+		interfaceDetails = append(interfaceDetails, ifaceDetails)
+	}
+
+	return interfaceNames, interfaceDetails, nil
+}
+
 // Hilfsfunktionen für die IP-Adressermittlung
 
 // parseListenAddress zerlegt eine Adresse im Format "host:port" oder ":port"
@@ -383,6 +586,11 @@ func (a *CaptureAgent) RegisterRoutes(router *mux.Router) {
 	router.HandleFunc("/capture/start", a.startCaptureHandler).Methods("POST")
 	router.HandleFunc("/capture/stop", a.stopCaptureHandler).Methods("POST")
 	router.HandleFunc("/capture/set-interface", a.setInterfaceHandler).Methods("POST")
+	router.HandleFunc("/pcap", a.pcapHandler).Methods("GET")
+	router.HandleFunc("/recordings", a.listRecordingsHandler).Methods("GET")
+	router.HandleFunc("/recordings/{id}", a.downloadRecordingHandler).Methods("GET")
+	router.HandleFunc("/pcap-files", a.listPcapFilesHandler).Methods("GET")
+	router.HandleFunc("/pcap-files/{file}", a.downloadPcapFileHandler).Methods("GET")
 	router.HandleFunc("/ws", a.websocketHandler)
 
 	// Weitere Routen hier registrieren...
@@ -424,9 +632,7 @@ func (a *CaptureAgent) healthHandler(w http.ResponseWriter, r *http.Request) {
 
 // statusHandler gibt den aktuellen Status des Agents zurück
 func (a *CaptureAgent) statusHandler(w http.ResponseWriter, r *http.Request) {
-	a.statusMutex.RLock()
-	status := a.status
-	a.statusMutex.RUnlock()
+	status := a.currentStatus()
 
 	response := APIResponse{
 		Success: true,
@@ -437,16 +643,9 @@ func (a *CaptureAgent) statusHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// startCaptureHandler startet die Paketerfassung
+// startCaptureHandler startet eine benannte Capture. Mehrere Captures auf
+// unterschiedlichen Schnittstellen (oder mit unterschiedlichen Filtern) können parallel laufen.
 func (a *CaptureAgent) startCaptureHandler(w http.ResponseWriter, r *http.Request) {
-	a.statusMutex.Lock()
-	if a.status.Status == "capturing" {
-		a.statusMutex.Unlock()
-		respondWithError(w, http.StatusConflict, "Capture already in progress")
-		return
-	}
-	a.statusMutex.Unlock()
-
 	// Anfrage parsen
 	var request CaptureRequest
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -464,258 +663,322 @@ func (a *CaptureAgent) startCaptureHandler(w http.ResponseWriter, r *http.Reques
 		}
 	}
 
-	// Capture öffnen
-	if err := a.capturer.OpenLiveCapture(captureInterface); err != nil {
+	// Ohne Namen wird die Capture nach ihrer Schnittstelle benannt
+	name := request.Name
+	if name == "" {
+		name = captureInterface
+	}
+
+	if request.DurationSeconds < 0 || request.MaxPackets < 0 || request.MaxBytes < 0 {
+		respondWithError(w, http.StatusBadRequest, "Capture limits must not be negative")
+		return
+	}
+
+	// Eine abgeschlossene Capture mit gleichem Namen wird ersetzt
+	a.statusMutex.RLock()
+	existing, exists := a.captures[name]
+	exists = exists && existing.status.Status != "completed"
+	a.statusMutex.RUnlock()
+	if exists {
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Capture '%s' already in progress", name))
+		return
+	}
+
+	// Filter vorab für den Linktyp der Schnittstelle prüfen
+	if request.Filter != "" {
+		linkType := packet.InterfaceLinkType(captureInterface)
+		if _, err := packet.CompileFilter(request.Filter, linkType, a.config.Capture.SnapLen); err != nil {
+			respondWithFilterError(w, err)
+			return
+		}
+	}
+
+	// Capture sofort öffnen, damit Fehler noch vor dem gemeinsamen Startzeitpunkt gemeldet werden
+	capture, err := a.openCapture(name, captureInterface, request.Filter, request.SessionID)
+	if err != nil {
+		var filterErr *packet.FilterError
+		if errors.As(err, &filterErr) {
+			respondWithFilterError(w, filterErr)
+			return
+		}
 		respondWithError(w, http.StatusInternalServerError,
 			fmt.Sprintf("Failed to open interface %s: %v", captureInterface, err))
 		return
 	}
 
-	// Context für die Capture erstellen
-	ctx, cancel := context.WithCancel(context.Background())
-	a.activeCtx = ctx
-	a.cancelFunc = cancel
+	startAt := request.StartAt
+	scheduled := !startAt.IsZero() && startAt.After(time.Now())
 
-	// Capture starten
-	packetChan, errChan := a.capturer.StartCapture(ctx)
+	capture.limits = captureLimits{
+		duration: time.Duration(request.DurationSeconds) * time.Second,
+		packets:  request.MaxPackets,
+		bytes:    request.MaxBytes,
+	}
 
-	// Status aktualisieren
 	a.statusMutex.Lock()
-	a.status.Status = "capturing"
-	a.status.Interface = captureInterface
-	a.status.PacketsCaptured = 0
+	if existing, exists := a.captures[name]; exists && existing.status.Status != "completed" {
+		// Parallel mit gleichem Namen gestartet
+		a.statusMutex.Unlock()
+		capture.cancel()
+		capture.session.Stop()
+		respondWithError(w, http.StatusConflict, fmt.Sprintf("Capture '%s' already in progress", name))
+		return
+	}
+	if scheduled {
+		capture.status.Status = "scheduled"
+	}
+	a.captures[name] = capture
 	a.status.Error = ""
+	a.refreshStatusLocked()
 	a.statusMutex.Unlock()
 
-	// Paketverarbeitung in Goroutine starten
-	go a.processPackets(packetChan, errChan)
+	message := fmt.Sprintf("Capture '%s' started on interface %s", name, captureInterface)
+	if scheduled {
+		// Synchronisierter Start: bis zur gemeinsamen Startzeit warten
+		go a.scheduleCapture(capture, startAt)
+		message = fmt.Sprintf("Capture '%s' on interface %s scheduled for %s", name, captureInterface, startAt.Format(time.RFC3339Nano))
+	} else {
+		startAt = a.beginCapture(capture)
+	}
 
 	// Erfolgreiche Antwort senden
 	response := APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Capture started on interface %s", captureInterface),
+		Message: message,
+		Data: map[string]interface{}{
+			"name":       name,
+			"interface":  captureInterface,
+			"session_id": request.SessionID,
+			"start_at":   startAt,
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// stopCaptureHandler stoppt die Paketerfassung
+// stopCaptureHandler stoppt Captures nach Name, Schnittstelle oder Session.
+// Ohne Angaben werden alle Captures gestoppt.
 func (a *CaptureAgent) stopCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	// Der Body ist optional, ältere Server senden keinen
+	var request StopCaptureRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+		respondWithError(w, http.StatusBadRequest, "Invalid request format")
+		return
+	}
+
 	a.statusMutex.Lock()
-	if a.status.Status != "capturing" {
+	if len(a.captures) == 0 {
 		a.statusMutex.Unlock()
 		respondWithError(w, http.StatusBadRequest, "No active capture to stop")
 		return
 	}
-	a.statusMutex.Unlock()
 
-	// Capture stoppen
-	if a.cancelFunc != nil {
-		a.cancelFunc()
+	// Zu stoppende Captures auswählen
+	var selected []*activeCapture
+	for name, capture := range a.captures {
+		if request.Name != "" && request.Name != name {
+			continue
+		}
+		if request.Interface != "" && request.Interface != capture.status.Interface {
+			continue
+		}
+		if request.SessionID != "" && request.SessionID != capture.status.SessionID {
+			continue
+		}
+		selected = append(selected, capture)
 	}
 
-	// Status aktualisieren
-	a.statusMutex.Lock()
-	a.status.Status = "idle"
+	if len(selected) == 0 {
+		a.statusMutex.Unlock()
+		if request.SessionID != "" {
+			respondWithError(w, http.StatusConflict,
+				fmt.Sprintf("Active capture does not belong to session %s", request.SessionID))
+		} else {
+			respondWithError(w, http.StatusBadRequest, "No matching capture to stop")
+		}
+		return
+	}
+
+	// Captures stoppen und ihren letzten Stand zurückgeben
+	stopped := make([]string, 0, len(selected))
+	final := make([]CaptureStatus, 0, len(selected))
+	for _, capture := range selected {
+		a.stopCaptureLocked(capture)
+		stopped = append(stopped, capture.status.Name)
+		final = append(final, capture.status)
+	}
+	remaining := a.status.Status
 	a.statusMutex.Unlock()
 
 	// Erfolgreiche Antwort senden
 	response := APIResponse{
 		Success: true,
-		Message: "Capture stopped",
+		Message: fmt.Sprintf("Capture stopped: %s", strings.Join(stopped, ", ")),
+		Data: map[string]interface{}{
+			"stopped":  stopped,
+			"captures": final,
+			"status":   remaining,
+		},
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// websocketHandler verwaltet WebSocket-Verbindungen für Paket-Streaming
-func (a *CaptureAgent) websocketHandler(w http.ResponseWriter, r *http.Request) {
-	upgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			return true // In Produktion sollte dies eingeschränkt werden
-		},
-	}
-
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Printf("Error upgrading to WebSocket: %v", err)
-		return
-	}
-
-	// Client registrieren
-	a.clientsMutex.Lock()
-	a.clients[conn] = true
-	a.clientsMutex.Unlock()
-
-	// Handler für eingehende Nachrichten
-	go func() {
-		defer func() {
-			conn.Close()
-			a.clientsMutex.Lock()
-			delete(a.clients, conn)
-			a.clientsMutex.Unlock()
-		}()
-
-		for {
-			_, _, err := conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-					log.Printf("WebSocket error: %v", err)
-				}
-				break
-			}
-			// Hier könnten Befehle vom Client verarbeitet werden
-		}
-	}()
-}
-
 // heartbeatRoutine sendet regelmäßig Heartbeats an den Hauptserver
 func (a *CaptureAgent) heartbeatRoutine() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.heartbeatTrigger:
+		}
+
 		a.statusMutex.Lock()
 		a.status.LastHeartbeat = time.Now()
-		currentStatus := a.status.Status
-		packetsCaptured := a.status.PacketsCaptured
 		a.statusMutex.Unlock()
+		a.checkDropRates()
+		a.pruneCompletedCaptures()
+		status := a.currentStatus()
 
 		// Heartbeat an den Hauptserver senden, wenn eine Server-URL konfiguriert ist
-		if a.config.Agent.ServerURL != "" {
-			// Heartbeat-Daten vorbereiten
-			heartbeatData := map[string]interface{}{
-				"name":             a.config.Agent.Name,
-				"status":           currentStatus,
-				"packets_captured": packetsCaptured,
-				"interface":        a.config.Agent.Interface,
-			}
-
-			jsonData, err := json.Marshal(heartbeatData)
-			if err != nil {
-				log.Printf("Fehler beim Erstellen des Heartbeats: %v", err)
-				continue
-			}
-
-			// Heartbeat-URL zusammensetzen
-			heartbeatURL := fmt.Sprintf("%s/api/agents/heartbeat", a.config.Agent.ServerURL)
-
-			// HTTP-Request senden
-			req, err := http.NewRequest("POST", heartbeatURL, bytes.NewBuffer(jsonData))
-			if err != nil {
-				log.Printf("Fehler beim Erstellen des Heartbeat-Requests: %v", err)
-				continue
-			}
-
-			req.Header.Set("Content-Type", "application/json")
-			if a.config.Agent.APIKey != "" {
-				req.Header.Set("X-API-Key", a.config.Agent.APIKey)
-			}
-
-			client := &http.Client{Timeout: 5 * time.Second}
-			resp, err := client.Do(req)
-			if err != nil {
-				log.Printf("Fehler beim Senden des Heartbeats: %v", err)
-				continue
-			}
-
-			// Antwort verwerfen
-			resp.Body.Close()
-
-			if resp.StatusCode != http.StatusOK {
-				log.Printf("Heartbeat wurde vom Server nicht akzeptiert. Status: %d", resp.StatusCode)
-			} else {
-				log.Printf("Heartbeat: Agent %s ist aktiv und mit dem Server verbunden (Pakete: %d)", a.config.Agent.Name, packetsCaptured)
-			}
+		if a.serverURL() != "" {
+			a.sendHeartbeat(status)
 		} else {
 			// Kein Server konfiguriert, lokale Protokollierung
-			log.Printf("Heartbeat: Agent %s is alive (keine Server-Verbindung, Pakete: %d)", a.config.Agent.Name, packetsCaptured)
+			log.Printf("Heartbeat: Agent %s is alive (keine Server-Verbindung, Pakete: %d)", a.config.Agent.Name, status.PacketsCaptured)
 		}
 	}
 }
 
-// processPackets verarbeitet eingehende Pakete
-func (a *CaptureAgent) processPackets(packetChan <-chan *models.PacketInfo, errChan <-chan error) {
-	// Debug-Ausgabe beim Start
-	log.Println("DEBUG: Paketverarbeitung gestartet")
+// triggerHeartbeat fordert einen sofortigen Heartbeat an, ohne auf den nächsten Takt zu warten
+func (a *CaptureAgent) triggerHeartbeat() {
+	select {
+	case a.heartbeatTrigger <- struct{}{}:
+	default:
+	}
+}
 
-	// Zähler für das Debugging
-	debugCounter := 0
-	lastLogTime := time.Now()
+// sendHeartbeat sendet einen einzelnen Heartbeat an den Hauptserver und
+// registriert den Agent erneut, falls der Server ihn nicht (mehr) kennt
+func (a *CaptureAgent) sendHeartbeat(status AgentStatus) {
+	// Heartbeat-Daten vorbereiten
+	heartbeatData := map[string]interface{}{
+		"name":                   a.config.Agent.Name,
+		"status":                 status.Status,
+		"packets_captured":       status.PacketsCaptured,
+		"interface":              status.Interface,
+		"session_id":             status.SessionID,
+		"captures":               status.Captures,
+		"stats":                  status.Stats,
+		"applied_config_version": status.ConfigVersion,
+		"config_error":           status.ConfigError,
+		"update_error":           status.UpdateError,
+	}
+	if status.Status == "capturing" && !status.CaptureStarted.IsZero() {
+		heartbeatData["capture_started"] = status.CaptureStarted
+	}
 
-	for {
-		select {
-		case packet, ok := <-packetChan:
-			if !ok {
-				log.Println("Packet channel closed")
-				return
-			}
+	// Gepufferte Ereignisse mitsenden; sie werden erst nach erfolgreicher Übermittlung verworfen
+	a.statusMutex.Lock()
+	pendingEvents := a.pendingEvents
+	a.statusMutex.Unlock()
+	if len(pendingEvents) > 0 {
+		heartbeatData["events"] = pendingEvents
+	}
 
-			// Debug-Ausgabe alle 10 Pakete oder alle 5 Sekunden
-			debugCounter++
-			if debugCounter%10 == 0 || time.Since(lastLogTime) > 5*time.Second {
-				log.Printf("DEBUG: Paket %d empfangen: %s -> %s (Protokoll: %s, Länge: %d)",
-					debugCounter, packet.SourceIP, packet.DestinationIP, packet.Protocol, packet.Length)
-				lastLogTime = time.Now()
-			}
+	// Zeitmessung des vorherigen Austauschs mitsenden, der Server schätzt daraus den Uhrenversatz
+	if a.lastClockSample != nil {
+		heartbeatData["clock_sample"] = a.lastClockSample
+		a.lastClockSample = nil
+	}
+	sentAt := time.Now()
+	heartbeatData["agent_send_time"] = sentAt
 
-			// Paket zählen
-			a.statusMutex.Lock()
-			a.status.PacketsCaptured++
-			a.statusMutex.Unlock()
+	jsonData, err := json.Marshal(heartbeatData)
+	if err != nil {
+		log.Printf("Fehler beim Erstellen des Heartbeats: %v", err)
+		return
+	}
 
-			// Paket an alle verbundenen Clients senden
-			a.broadcastPacket(packet)
+	// Heartbeat-URL zusammensetzen
+	heartbeatURL := fmt.Sprintf("%s/api/agents/heartbeat", a.serverURL())
 
-		case err, ok := <-errChan:
-			if !ok {
-				continue
-			}
-			log.Printf("Error during packet capture: %v", err)
+	// HTTP-Request senden
+	req, err := http.NewRequest("POST", heartbeatURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("Fehler beim Erstellen des Heartbeat-Requests: %v", err)
+		return
+	}
 
-			a.statusMutex.Lock()
-			a.status.Error = err.Error()
-			a.statusMutex.Unlock()
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.Agent.APIKey != "" {
+		req.Header.Set("X-API-Key", a.config.Agent.APIKey)
+	}
 
-		case <-a.activeCtx.Done():
-			log.Println("Capture context cancelled")
-			return
-		}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Fehler beim Senden des Heartbeats: %v", err)
+		return
 	}
-}
+	defer resp.Body.Close()
+	receivedAt := time.Now()
 
-// broadcastPacket sendet ein Paket an alle verbundenen WebSocket-Clients
-func (a *CaptureAgent) broadcastPacket(packet *models.PacketInfo) {
-	// Vereinfachte Paketdarstellung für die Übertragung
-	packetData := map[string]interface{}{
-		"type": "packet",
-		"data": map[string]interface{}{
-			"timestamp":  packet.Timestamp,
-			"source_ip":  packet.SourceIP.String(),
-			"dest_ip":    packet.DestinationIP.String(),
-			"protocol":   packet.Protocol,
-			"length":     packet.Length,
-			"is_gateway": packet.IsGatewayTraffic,
-			"summary":    fmt.Sprintf("%s: %s -> %s", packet.Protocol, packet.SourceIP, packet.DestinationIP),
-		},
+	var heartbeatResp heartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&heartbeatResp); err != nil {
+		log.Printf("Warnung: Heartbeat-Antwort konnte nicht gelesen werden: %v", err)
 	}
 
-	data, err := json.Marshal(packetData)
-	if err != nil {
-		log.Printf("Error marshaling packet data: %v", err)
+	// Zeitmessung für den nächsten Heartbeat vervollständigen
+	if heartbeatResp.Success && heartbeatResp.Data.AgentSendTime.Equal(sentAt) {
+		a.lastClockSample = &ClockSample{
+			AgentSend:     sentAt,
+			ServerReceive: heartbeatResp.Data.ServerReceiveTime,
+			ServerSend:    heartbeatResp.Data.ServerSendTime,
+			AgentReceive:  receivedAt,
+		}
+	}
+
+	// Der Server kennt den Agent nicht (z.B. nach Verlust der Registry) - neu registrieren
+	if resp.StatusCode == http.StatusNotFound && heartbeatResp.Error == agentNotRegisteredError {
+		log.Printf("Server kennt Agent %s nicht mehr, registriere erneut", a.config.Agent.Name)
+		if err := a.Register(); err != nil {
+			log.Printf("Erneute Registrierung fehlgeschlagen: %v", err)
+		} else {
+			log.Println("Erneute Registrierung beim Server erfolgreich")
+		}
 		return
 	}
 
-	a.clientsMutex.Lock()
-	defer a.clientsMutex.Unlock()
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Heartbeat wurde vom Server nicht akzeptiert. Status: %d", resp.StatusCode)
+	} else {
+		log.Printf("Heartbeat: Agent %s ist aktiv und mit dem Server verbunden (Captures: %d, Pakete: %d)",
+			a.config.Agent.Name, len(status.Captures), status.PacketsCaptured)
 
-	for client := range a.clients {
-		if err := client.WriteMessage(websocket.TextMessage, data); err != nil {
-			log.Printf("Error sending packet to client: %v", err)
-			client.Close()
-			delete(a.clients, client)
+		// Übermittelte Ereignisse entfernen, zwischenzeitlich neu erzeugte behalten
+		if len(pendingEvents) > 0 {
+			a.statusMutex.Lock()
+			sent := len(pendingEvents)
+			if sent > len(a.pendingEvents) {
+				sent = len(a.pendingEvents)
+			}
+			a.pendingEvents = append([]models.GatewayEvent(nil), a.pendingEvents[sent:]...)
+			a.statusMutex.Unlock()
+		}
+
+		// Neue Konfiguration vom Server anwenden
+		if heartbeatResp.Data.Config != nil {
+			a.applyServerSettings(heartbeatResp.Data.Config)
+		}
+
+		// Neue Agent-Version im Hintergrund installieren
+		if heartbeatResp.Data.Update != nil {
+			go a.applyUpdate(heartbeatResp.Data.Update)
 		}
 	}
 }
@@ -732,6 +995,22 @@ func respondWithError(w http.ResponseWriter, statusCode int, message string) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// respondWithFilterError meldet einen ungültigen BPF-Filter mit Position und Token im Feld data
+func respondWithFilterError(w http.ResponseWriter, err error) {
+	response := APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	}
+	var filterErr *packet.FilterError
+	if errors.As(err, &filterErr) {
+		response.Data = filterErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
+
 // setInterfaceHandler setzt die aktive Schnittstelle für die Datenerfassung
 func (a *CaptureAgent) setInterfaceHandler(w http.ResponseWriter, r *http.Request) {
 	// Anfrage-Body parsen
@@ -792,8 +1071,8 @@ func (a *CaptureAgent) setInterfaceHandler(w http.ResponseWriter, r *http.Reques
 		log.Printf("Warnung: Fehler beim Speichern der Konfiguration nach Interface-Änderung: %v", err)
 	}
 
-	// Auch dem Capturer mitteilen, dass sich die Schnittstelle geändert hat
-	a.capturer.UpdateInterface(req.Interface)
+	// Auch die Capture-Konfiguration aktualisieren, neue Captures verwenden die Schnittstelle
+	a.config.Capture.Interface = req.Interface
 
 	// Erfolgreiche Antwort senden
 	response := APIResponse{
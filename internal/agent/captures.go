@@ -0,0 +1,480 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// CaptureStatus beschreibt eine einzelne, benannte Capture des Agents
+type CaptureStatus struct {
+	Name            string    `json:"name"`
+	Interface       string    `json:"interface"`
+	Filter          string    `json:"filter,omitempty"`
+	Status          string    `json:"status"` // "scheduled", "capturing", "paused", "completed"
+	SessionID       string    `json:"session_id,omitempty"`
+	CaptureStarted  time.Time `json:"capture_started,omitempty"`
+	PacketsCaptured int       `json:"packets_captured"`
+	BytesCaptured   int64     `json:"bytes_captured"`
+	Error           string    `json:"error,omitempty"`
+
+	// Ende einer begrenzten Capture und die erreichte Grenze ("duration", "packets", "bytes")
+	CaptureEnded time.Time `json:"capture_ended,omitempty"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+
+	// Empfangs-, Verlust- und Fehlerzähler
+	Stats packet.CaptureStats `json:"stats"`
+}
+
+// Maximale Anzahl von Ereignissen, die bis zum nächsten erfolgreichen Heartbeat gepuffert werden
+const maxPendingEvents = 100
+
+// Abgeschlossene Captures bleiben so lange in der Liste, damit der Server ihr Ergebnis abholen kann
+const completedCaptureRetention = 10 * time.Minute
+
+// captureLimits begrenzt eine Capture nach Dauer, Paketen oder Bytes (0 = unbegrenzt)
+type captureLimits struct {
+	duration time.Duration
+	packets  int64
+	bytes    int64
+}
+
+// activeCapture ist eine laufende oder geplante Capture mit eigenem Capturer. Nach einer
+// Pause wird eine neue Session geöffnet; die Zähler früherer Sessions bleiben erhalten.
+type activeCapture struct {
+	status      CaptureStatus
+	capturer    *packet.PcapCapturer
+	filter      string                 // BPF-Filter der Anfrage, gilt für jede Session der Capture
+	session     *packet.CaptureSession // geschützt durch statusMutex
+	recorder    packet.PacketRecorder  // Ringpuffer und Trigger, nil ohne Aufzeichnung
+	pastStats   packet.CaptureStats    // Zähler beendeter Sessions dieser Capture
+	ctx         context.Context
+	cancel      context.CancelFunc
+	dropMonitor *packet.DropMonitor
+	trigger     *packet.TriggerRecorder // nil ohne Trigger-Regeln
+	archive     *packet.ArchiveRecorder // nil ohne fortlaufende Aufzeichnung
+
+	// Grenzen der Capture und Timer für die zeitliche Begrenzung, geschützt durch statusMutex
+	limits        captureLimits
+	durationTimer *time.Timer
+}
+
+// newCapturer erstellt einen Capturer mit der aktuellen Konfiguration
+func (a *CaptureAgent) newCapturer() *packet.PcapCapturer {
+	// Jede Capture erhält eine eigene Kopie der Konfiguration, damit sich
+	// interfacespezifische Anpassungen nicht gegenseitig beeinflussen
+	a.serverURLMutex.RLock()
+	cfg := *a.config
+	a.serverURLMutex.RUnlock()
+	return packet.NewPcapCapturer(&cfg)
+}
+
+// stats gibt die Zähler aller Sessions der Capture zurück. Der Aufrufer muss statusMutex halten.
+func (c *activeCapture) stats() packet.CaptureStats {
+	return c.pastStats.Add(c.session.Stats())
+}
+
+// closeArchive schließt die aktuelle Datei der fortlaufenden Aufzeichnung
+func (c *activeCapture) closeArchive() {
+	if c.archive == nil {
+		return
+	}
+	if err := c.archive.Close(); err != nil {
+		log.Printf("Warnung: %v", err)
+	}
+}
+
+// openSession öffnet eine neue Session mit dem Filter der Capture auf ihrer Schnittstelle
+func (c *activeCapture) openSession(captureInterface string) (*packet.CaptureSession, error) {
+	session, err := c.capturer.OpenLiveCapture(captureInterface, c.filter)
+	if err != nil {
+		return nil, err
+	}
+	if c.recorder != nil {
+		session.SetPacketRecorder(c.recorder)
+	}
+	return session, nil
+}
+
+// openCapture öffnet eine neue Capture, startet sie aber noch nicht
+func (a *CaptureAgent) openCapture(name, captureInterface, filter, sessionID string) (*activeCapture, error) {
+	capture := &activeCapture{
+		status: CaptureStatus{
+			Name:      name,
+			Interface: captureInterface,
+			Filter:    filter,
+			SessionID: sessionID,
+		},
+		capturer:    a.newCapturer(),
+		filter:      filter,
+		dropMonitor: packet.NewDropMonitor(a.config.Capture.DropWarningThreshold),
+	}
+
+	// Jede Capture erhält einen eigenen Vorlaufpuffer, damit Aufzeichnungen nur
+	// Pakete der auslösenden Schnittstelle enthalten
+	var recorders packet.MultiRecorder
+	if a.recordings != nil {
+		capture.trigger = packet.NewTriggerRecorder(a.config.Capture.Triggers, a.recordings, a.config.Capture.SnapLen)
+		capture.trigger.SetSource(name, captureInterface)
+		recorders = append(recorders, capture.trigger)
+	}
+	// Eigener Ringpuffer je Schnittstelle, damit unterschiedliche Linktypen keine Rotation erzwingen
+	if a.ring != nil {
+		ring, err := a.ring.Ring(captureInterface)
+		if err != nil {
+			log.Printf("Ring buffer for interface %s unavailable: %v", captureInterface, err)
+		} else {
+			recorders = append(recorders, ring)
+		}
+	}
+	// Fortlaufende Aufzeichnung in eigene Dateien je Capture
+	if a.archive != nil {
+		capture.archive = a.archive.NewRecorder(captureInterface)
+		recorders = append(recorders, capture.archive)
+	}
+	switch len(recorders) {
+	case 0:
+	case 1:
+		capture.recorder = recorders[0]
+	default:
+		capture.recorder = recorders
+	}
+
+	session, err := capture.openSession(captureInterface)
+	if err != nil {
+		return nil, err
+	}
+	capture.session = session
+	capture.ctx, capture.cancel = context.WithCancel(context.Background())
+	return capture, nil
+}
+
+// scheduleCapture startet eine bereits geöffnete Capture zum angegebenen Zeitpunkt
+func (a *CaptureAgent) scheduleCapture(capture *activeCapture, startAt time.Time) {
+	timer := time.NewTimer(time.Until(startAt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		a.beginCapture(capture)
+	case <-capture.ctx.Done():
+		// Vor dem Start abgebrochen - geöffnetes Handle wieder freigeben
+		log.Printf("Geplante Capture '%s' vor dem Start abgebrochen", capture.status.Name)
+		capture.session.Stop()
+	}
+}
+
+// beginCapture startet die Paketerfassung auf dem geöffneten Handle und gibt die Startzeit zurück
+func (a *CaptureAgent) beginCapture(capture *activeCapture) time.Time {
+	a.statusMutex.Lock()
+	ctx, session := capture.ctx, capture.session
+	a.statusMutex.Unlock()
+
+	packetChan, errChan := session.Start(ctx)
+	startedAt := time.Now()
+
+	a.statusMutex.Lock()
+	capture.status.Status = "capturing"
+	// Nach einer Pause bleibt der ursprüngliche Startzeitpunkt erhalten
+	if capture.status.CaptureStarted.IsZero() {
+		capture.status.CaptureStarted = startedAt
+	}
+
+	// Zeitliche Begrenzung einmalig setzen; sie läuft ab dem ursprünglichen Start, sodass
+	// Pausen und Neustarts mitzählen
+	if capture.limits.duration > 0 && capture.durationTimer == nil {
+		remaining := time.Until(capture.status.CaptureStarted.Add(capture.limits.duration))
+		capture.durationTimer = time.AfterFunc(remaining, func() {
+			a.statusMutex.Lock()
+			defer a.statusMutex.Unlock()
+			if a.captures[capture.status.Name] == capture {
+				a.completeCaptureLocked(capture, "duration")
+			}
+		})
+	}
+	a.refreshStatusLocked()
+	a.statusMutex.Unlock()
+
+	// Paketverarbeitung in Goroutine starten
+	go a.processPackets(ctx, capture, packetChan, errChan)
+
+	return startedAt
+}
+
+// stopCaptureLocked beendet eine Capture und entfernt sie aus der Liste.
+// Der Aufrufer muss statusMutex halten.
+func (a *CaptureAgent) stopCaptureLocked(capture *activeCapture) {
+	capture.cancel()
+	capture.session.Stop()
+	if capture.durationTimer != nil {
+		capture.durationTimer.Stop()
+	}
+	if capture.trigger != nil {
+		capture.trigger.Stop()
+	}
+	capture.closeArchive()
+	delete(a.captures, capture.status.Name)
+	a.refreshStatusLocked()
+}
+
+// completeCaptureLocked beendet eine begrenzte Capture, sobald eine Grenze erreicht ist. Sie bleibt
+// mit Status "completed" und ihren Zählern in der Liste, bis sie gestoppt, durch eine neue Capture
+// gleichen Namens ersetzt oder nach completedCaptureRetention entfernt wird.
+// Der Aufrufer muss statusMutex halten.
+func (a *CaptureAgent) completeCaptureLocked(capture *activeCapture, reason string) {
+	if capture.status.Status != "capturing" && capture.status.Status != "paused" {
+		return
+	}
+
+	capture.cancel()
+	capture.session.Stop()
+	if capture.durationTimer != nil {
+		capture.durationTimer.Stop()
+	}
+	if capture.trigger != nil {
+		capture.trigger.Stop()
+	}
+	capture.closeArchive()
+	capture.status.Stats = capture.stats()
+	capture.status.Status = "completed"
+	capture.status.StopReason = reason
+	capture.status.CaptureEnded = time.Now()
+	a.refreshStatusLocked()
+
+	log.Printf("Capture '%s' abgeschlossen (%s): %d Pakete, %d Bytes",
+		capture.status.Name, reason, capture.status.PacketsCaptured, capture.status.BytesCaptured)
+	a.addEventLocked(models.GatewayEvent{
+		Timestamp: time.Now(),
+		EventType: "capture_completed",
+		Description: fmt.Sprintf("%s/%s: Capture nach Erreichen der Grenze '%s' beendet (%d Pakete, %d Bytes)",
+			a.config.Agent.Name, capture.status.Name, reason, capture.status.PacketsCaptured, capture.status.BytesCaptured),
+		Severity: "info",
+		Data: map[string]interface{}{
+			"capture":     capture.status.Name,
+			"interface":   capture.status.Interface,
+			"stop_reason": reason,
+			"packets":     capture.status.PacketsCaptured,
+			"bytes":       capture.status.BytesCaptured,
+		},
+	})
+
+	// Ergebnis sofort an den Server melden
+	a.triggerHeartbeat()
+}
+
+// pruneCompletedCaptures entfernt abgeschlossene Captures nach Ablauf der Aufbewahrungszeit
+func (a *CaptureAgent) pruneCompletedCaptures() {
+	a.statusMutex.Lock()
+	defer a.statusMutex.Unlock()
+
+	for name, capture := range a.captures {
+		if capture.status.Status == "completed" && time.Since(capture.status.CaptureEnded) > completedCaptureRetention {
+			delete(a.captures, name)
+		}
+	}
+	a.refreshStatusLocked()
+}
+
+// stopAllCaptures beendet alle laufenden und geplanten Captures
+func (a *CaptureAgent) stopAllCaptures() {
+	a.statusMutex.Lock()
+	defer a.statusMutex.Unlock()
+
+	for _, capture := range a.captures {
+		a.stopCaptureLocked(capture)
+	}
+}
+
+// refreshStatusLocked leitet den Gesamtstatus des Agents aus den einzelnen Captures ab.
+// Der Aufrufer muss statusMutex halten.
+func (a *CaptureAgent) refreshStatusLocked() {
+	captures := make([]CaptureStatus, 0, len(a.captures))
+	var stats packet.CaptureStats
+	packets := 0
+	capturing, scheduled, paused := false, false, false
+	sessionID := ""
+	sameSession := true
+	var started time.Time
+
+	for _, capture := range a.captures {
+		// Abgeschlossene Captures behalten ihre letzten Zähler
+		if capture.status.Status != "completed" {
+			capture.status.Stats = capture.stats()
+		}
+		stats = stats.Add(capture.status.Stats)
+
+		captures = append(captures, capture.status)
+		packets += capture.status.PacketsCaptured
+
+		switch capture.status.Status {
+		case "capturing":
+			capturing = true
+		case "scheduled":
+			scheduled = true
+		case "paused":
+			paused = true
+		}
+
+		if len(captures) == 1 {
+			sessionID = capture.status.SessionID
+		} else if capture.status.SessionID != sessionID {
+			sameSession = false
+		}
+
+		if !capture.status.CaptureStarted.IsZero() &&
+			(started.IsZero() || capture.status.CaptureStarted.Before(started)) {
+			started = capture.status.CaptureStarted
+		}
+	}
+
+	sort.Slice(captures, func(i, j int) bool {
+		return captures[i].Name < captures[j].Name
+	})
+
+	a.status.Captures = captures
+	a.status.Stats = stats
+	a.status.PacketsCaptured = packets
+	a.status.CaptureStarted = started
+	if sameSession {
+		a.status.SessionID = sessionID
+	} else {
+		a.status.SessionID = ""
+	}
+
+	// Gesamtstatus: ein Fehlerzustand (z.B. fehlgeschlagene Registrierung) bleibt erhalten
+	switch {
+	case capturing:
+		a.status.Status = "capturing"
+	case scheduled:
+		a.status.Status = "scheduled"
+	case paused:
+		a.status.Status = "paused"
+	case a.status.Status != "error":
+		a.status.Status = "idle"
+	}
+
+	// Für ältere Clients die Schnittstelle der einzigen Capture anzeigen
+	if len(captures) == 1 {
+		a.status.Interface = captures[0].Interface
+	} else if len(captures) == 0 {
+		a.status.Interface = a.config.Agent.Interface
+	}
+}
+
+// currentStatus aktualisiert die Capture-Übersicht und gibt eine Kopie des Agent-Status zurück
+func (a *CaptureAgent) currentStatus() AgentStatus {
+	a.statusMutex.Lock()
+	defer a.statusMutex.Unlock()
+
+	a.refreshStatusLocked()
+	return a.status
+}
+
+// checkDropRates prüft die Verlustrate jeder Capture seit der letzten Prüfung und
+// puffert beim Überschreiten des Schwellwerts ein Warnereignis für den nächsten Heartbeat
+func (a *CaptureAgent) checkDropRates() {
+	a.statusMutex.Lock()
+	defer a.statusMutex.Unlock()
+
+	for _, capture := range a.captures {
+		stats := capture.stats()
+		interval, rate, crossed := capture.dropMonitor.Check(stats)
+		if !crossed {
+			continue
+		}
+
+		log.Printf("Warnung: Capture '%s' verwirft %.1f%% der Pakete", capture.status.Name, rate*100)
+		a.addEventLocked(models.GatewayEvent{
+			Timestamp: time.Now(),
+			EventType: "capture_drops",
+			Description: fmt.Sprintf("%s/%s: %.1f%% der Pakete verworfen (Kernel: %d, Interface: %d, Verarbeitung: %d)",
+				a.config.Agent.Name, capture.status.Name, rate*100,
+				interval.KernelDropped, interval.InterfaceDropped, interval.PipelineDropped),
+			Severity: "warning",
+			Data: map[string]interface{}{
+				"capture":   capture.status.Name,
+				"interface": capture.status.Interface,
+				"drop_rate": rate,
+				"interval":  interval,
+			},
+		})
+	}
+}
+
+// addEventLocked puffert ein Ereignis für den nächsten Heartbeat.
+// Der Aufrufer muss statusMutex halten.
+func (a *CaptureAgent) addEventLocked(event models.GatewayEvent) {
+	a.pendingEvents = append(a.pendingEvents, event)
+	if len(a.pendingEvents) > maxPendingEvents {
+		a.pendingEvents = a.pendingEvents[len(a.pendingEvents)-maxPendingEvents:]
+	}
+}
+
+// processPackets verarbeitet eingehende Pakete einer Capture
+func (a *CaptureAgent) processPackets(ctx context.Context, capture *activeCapture, packetChan <-chan *models.PacketInfo, errChan <-chan error) {
+	log.Printf("Packet processing for capture '%s' started", capture.status.Name)
+
+	for {
+		select {
+		case pkt, ok := <-packetChan:
+			if !ok {
+				log.Printf("Packet channel of capture '%s' closed", capture.status.Name)
+				return
+			}
+
+			// Paket zählen und Grenzen prüfen
+			a.statusMutex.Lock()
+			if capture.status.Status != "capturing" {
+				// Bereits abgeschlossen oder pausiert, restliche Pakete im Kanal verwerfen
+				a.statusMutex.Unlock()
+				packet.ReleasePacketInfo(pkt)
+				continue
+			}
+			capture.status.PacketsCaptured++
+			capture.status.BytesCaptured += int64(pkt.Length)
+			a.status.PacketsCaptured++
+			limitReached := ""
+			switch {
+			case capture.limits.packets > 0 && int64(capture.status.PacketsCaptured) >= capture.limits.packets:
+				limitReached = "packets"
+			case capture.limits.bytes > 0 && capture.status.BytesCaptured >= capture.limits.bytes:
+				limitReached = "bytes"
+			}
+			if limitReached != "" {
+				a.completeCaptureLocked(capture, limitReached)
+			}
+			a.statusMutex.Unlock()
+
+			// Trigger-Regeln prüfen und ausgelöste Aufzeichnungen melden
+			if capture.trigger != nil && limitReached == "" {
+				if started := capture.trigger.Evaluate(pkt); len(started) > 0 {
+					a.recordTriggerEvents(started)
+				}
+			}
+
+			// Paket an alle verbundenen Clients senden; danach wird es wiederverwendet
+			a.broadcastPacket(capture.status.Name, pkt)
+			packet.ReleasePacketInfo(pkt)
+
+		case err, ok := <-errChan:
+			if !ok {
+				continue
+			}
+			log.Printf("Error during packet capture on '%s': %v", capture.status.Name, err)
+
+			a.statusMutex.Lock()
+			capture.status.Error = err.Error()
+			a.status.Error = err.Error()
+			a.statusMutex.Unlock()
+
+		case <-ctx.Done():
+			log.Printf("Capture context of '%s' cancelled", capture.status.Name)
+			return
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+const (
+	// Größe der Sendewarteschlange pro Client
+	clientSendQueueSize = 256
+	// Aufeinanderfolgende verworfene Nachrichten, nach denen ein langsamer Client getrennt wird
+	maxClientDrops = clientSendQueueSize
+	// Maximale Dauer eines einzelnen Schreibvorgangs
+	clientWriteTimeout = 10 * time.Second
+)
+
+// Subscription beschreibt, welche Pakete ein WebSocket-Client erhalten möchte.
+// Leere Felder schränken nicht ein.
+type Subscription struct {
+	Captures    []string `json:"captures,omitempty"`  // Namen der Captures
+	Protocols   []string `json:"protocols,omitempty"` // z.B. "DNS", "ARP"
+	IPs         []string `json:"ips,omitempty"`       // Quell- oder Ziel-IP, einzelne Adressen oder CIDR
+	GatewayOnly bool     `json:"gateway_only,omitempty"`
+	MaxRate     int      `json:"max_rate,omitempty"` // Pakete pro Sekunde, 0 = unbegrenzt
+
+	// Anzeigefilter auf den dekodierten Feldern, z.B. dns.query contains "corp"
+	DisplayFilter string `json:"display_filter,omitempty"`
+
+	networks      []*net.IPNet
+	displayFilter *displayfilter.Filter
+}
+
+// compile prüft die Subscription und bereitet IP- und Anzeigefilter vor
+func (s *Subscription) compile() error {
+	if s.MaxRate < 0 {
+		return fmt.Errorf("max_rate must not be negative")
+	}
+
+	filter, err := displayfilter.Compile(s.DisplayFilter)
+	if err != nil {
+		return err
+	}
+	s.displayFilter = filter
+
+	s.networks = nil
+	for _, value := range s.IPs {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q", value)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			s.networks = append(s.networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return fmt.Errorf("invalid network %q", value)
+		}
+		s.networks = append(s.networks, network)
+	}
+	return nil
+}
+
+// matches prüft, ob ein Paket einer Capture zur Subscription passt
+func (s *Subscription) matches(captureName string, packet *models.PacketInfo) bool {
+	if s.GatewayOnly && !packet.IsGatewayTraffic {
+		return false
+	}
+
+	if len(s.Captures) > 0 && !containsString(s.Captures, captureName, false) {
+		return false
+	}
+
+	if len(s.Protocols) > 0 && !containsString(s.Protocols, packet.Protocol, true) {
+		return false
+	}
+
+	if !s.displayFilter.Match(packet) {
+		return false
+	}
+
+	if len(s.networks) > 0 {
+		for _, network := range s.networks {
+			if (packet.SourceIP != nil && network.Contains(packet.SourceIP)) ||
+				(packet.DestinationIP != nil && network.Contains(packet.DestinationIP)) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// containsString prüft, ob value in values enthalten ist
+func containsString(values []string, value string, ignoreCase bool) bool {
+	for _, v := range values {
+		if v == value || (ignoreCase && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientMessage ist eine Steuernachricht eines WebSocket-Clients
+type clientMessage struct {
+	Type string `json:"type"` // "subscribe", "unsubscribe"
+	Subscription
+}
+
+// wsClient ist ein verbundener WebSocket-Client mit eigener Sendewarteschlange
+type wsClient struct {
+	conn      *websocket.Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mutex        sync.Mutex
+	subscription Subscription
+	windowStart  time.Time // Beginn des aktuellen Sekundenfensters für max_rate
+	windowCount  int
+	drops        int // aufeinanderfolgend verworfene Nachrichten
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		conn: conn,
+		send: make(chan []byte, clientSendQueueSize),
+		done: make(chan struct{}),
+	}
+}
+
+// close trennt den Client; weitere Aufrufe haben keine Wirkung
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// wants prüft Filter und Ratenbegrenzung für ein Paket
+func (c *wsClient) wants(captureName string, packet *models.PacketInfo) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if !c.subscription.matches(captureName, packet) {
+		return false
+	}
+
+	if c.subscription.MaxRate > 0 {
+		now := time.Now()
+		if now.Sub(c.windowStart) >= time.Second {
+			c.windowStart = now
+			c.windowCount = 0
+		}
+		if c.windowCount >= c.subscription.MaxRate {
+			return false
+		}
+		c.windowCount++
+	}
+
+	return true
+}
+
+// enqueue legt eine Nachricht in die Sendewarteschlange, ohne zu blockieren.
+// Gibt false zurück, wenn der Client zu langsam ist und getrennt werden soll.
+func (c *wsClient) enqueue(data []byte) bool {
+	select {
+	case c.send <- data:
+		c.mutex.Lock()
+		c.drops = 0
+		c.mutex.Unlock()
+		return true
+	default:
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.drops++
+	return c.drops < maxClientDrops
+}
+
+// disconnect sendet eine letzte Nachricht und trennt den Client, sobald die
+// Warteschlange abgearbeitet ist
+func (c *wsClient) disconnect(message []byte) {
+	select {
+	case c.send <- message:
+	default:
+	}
+	select {
+	case c.send <- nil:
+	default:
+		// Warteschlange voll - sofort trennen
+		c.close()
+	}
+}
+
+// writeLoop schreibt die Nachrichten der Warteschlange auf die Verbindung
+func (c *wsClient) writeLoop() {
+	defer c.close()
+
+	for {
+		select {
+		case data := <-c.send:
+			if data == nil {
+				// Von disconnect eingereiht: alle vorherigen Nachrichten sind gesendet
+				return
+			}
+			c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				log.Printf("Error sending to WebSocket client: %v", err)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readLoop verarbeitet Steuernachrichten des Clients, bis die Verbindung endet
+func (c *wsClient) readLoop() {
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				log.Printf("WebSocket error: %v", err)
+			}
+			return
+		}
+
+		var msg clientMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			c.reply("error", nil, "Invalid message format")
+			continue
+		}
+
+		switch msg.Type {
+		case "subscribe":
+			subscription := msg.Subscription
+			if err := subscription.compile(); err != nil {
+				// Bei ungültigen Anzeigefiltern enthält data die Position des Fehlers
+				var data interface{}
+				var filterErr *displayfilter.Error
+				if errors.As(err, &filterErr) {
+					data = filterErr
+				}
+				c.reply("error", data, err.Error())
+				continue
+			}
+			c.mutex.Lock()
+			c.subscription = subscription
+			c.windowStart, c.windowCount = time.Time{}, 0
+			c.mutex.Unlock()
+			c.reply("subscribed", subscription, "")
+
+		case "unsubscribe":
+			// Zurück zum Standard: alle Pakete
+			c.mutex.Lock()
+			c.subscription = Subscription{}
+			c.mutex.Unlock()
+			c.reply("subscribed", Subscription{}, "")
+
+		default:
+			c.reply("error", nil, fmt.Sprintf("Unknown message type '%s'", msg.Type))
+		}
+	}
+}
+
+// reply sendet eine Antwort auf eine Steuernachricht über die Warteschlange
+func (c *wsClient) reply(messageType string, data interface{}, errorMessage string) {
+	response := map[string]interface{}{"type": messageType}
+	if data != nil {
+		response["data"] = data
+	}
+	if errorMessage != "" {
+		response["error"] = errorMessage
+	}
+
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- encoded:
+	default:
+	}
+}
+
+// websocketHandler verwaltet WebSocket-Verbindungen für Paket-Streaming.
+// Clients erhalten zunächst alle Pakete und können ihre Auswahl mit
+// {"type": "subscribe", ...} einschränken.
+func (a *CaptureAgent) websocketHandler(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			return true // In Produktion sollte dies eingeschränkt werden
+		},
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("Error upgrading to WebSocket: %v", err)
+		return
+	}
+
+	// Client registrieren
+	client := newWSClient(conn)
+	a.clientsMutex.Lock()
+	a.clients[client] = true
+	a.clientsMutex.Unlock()
+
+	go client.writeLoop()
+	go func() {
+		defer func() {
+			client.close()
+			a.removeClient(client)
+		}()
+		client.readLoop()
+	}()
+}
+
+// removeClient entfernt einen Client aus der Liste
+func (a *CaptureAgent) removeClient(client *wsClient) {
+	a.clientsMutex.Lock()
+	delete(a.clients, client)
+	a.clientsMutex.Unlock()
+}
+
+// broadcastPacket verteilt ein Paket an alle Clients, deren Subscription passt.
+// Langsame Clients blockieren die Paketverarbeitung nicht: ihre Nachrichten werden
+// verworfen und sie werden bei anhaltendem Rückstau getrennt.
+func (a *CaptureAgent) broadcastPacket(captureName string, packet *models.PacketInfo) {
+	a.clientsMutex.Lock()
+	clients := make([]*wsClient, 0, len(a.clients))
+	for client := range a.clients {
+		clients = append(clients, client)
+	}
+	a.clientsMutex.Unlock()
+
+	var data []byte
+	for _, client := range clients {
+		if !client.wants(captureName, packet) {
+			continue
+		}
+
+		// Erst kodieren, wenn mindestens ein Client das Paket erhält
+		if data == nil {
+			var err error
+			if data, err = encodePacket(captureName, packet); err != nil {
+				log.Printf("Error marshaling packet data: %v", err)
+				return
+			}
+		}
+
+		if !client.enqueue(data) {
+			log.Printf("WebSocket client too slow, disconnecting")
+			client.close()
+			a.removeClient(client)
+		}
+	}
+}
+
+// encodePacket erstellt die vereinfachte Paketdarstellung für die Übertragung
+func encodePacket(captureName string, packet *models.PacketInfo) ([]byte, error) {
+	data := map[string]interface{}{
+		"capture":    captureName,
+		"timestamp":  packet.Timestamp,
+		"source_ip":  packet.SourceIP.String(),
+		"dest_ip":    packet.DestinationIP.String(),
+		"protocol":   packet.Protocol,
+		"length":     packet.Length,
+		"is_gateway": packet.IsGatewayTraffic,
+		"summary":    fmt.Sprintf("%s: %s -> %s", packet.Protocol, packet.SourceIP, packet.DestinationIP),
+	}
+	// Innerstes VLAN-Tag, damit Clients am Trunk-Port Pakete ihrem VLAN zuordnen können
+	if n := len(packet.VLANs); n > 0 {
+		data["vlan_id"] = packet.VLANs[n-1].ID
+	}
+	return json.Marshal(map[string]interface{}{
+		"type": "packet",
+		"data": data,
+	})
+}
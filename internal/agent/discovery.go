@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"context"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/discovery"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+const (
+	// Abstand zwischen zwei mDNS-Suchen nach dem Server
+	serverBrowseInterval = 10 * time.Second
+	// Wartezeit auf Antworten je Suche
+	serverBrowseTimeout = 3 * time.Second
+)
+
+// startDiscovery kündigt den Agent per mDNS an und sucht den Server, falls keine
+// Server-URL konfiguriert ist
+func (a *CaptureAgent) startDiscovery() {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.discoveryCancel = cancel
+
+	host, portValue, err := parseListenAddress(a.config.Agent.Listen)
+	if err != nil {
+		log.Printf("mDNS-Ankündigung deaktiviert, ungültige Listen-Adresse: %v", err)
+	} else if port, err := strconv.Atoi(portValue); err == nil {
+		service := discovery.Service{
+			Instance: a.config.Agent.Name,
+			Type:     discovery.AgentService,
+			Port:     port,
+			TXT: map[string]string{
+				"name":    a.config.Agent.Name,
+				"version": version.Version,
+				"group":   a.config.Agent.Group,
+			},
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			service.IPs = []net.IP{ip}
+		}
+
+		go func() {
+			if err := discovery.NewResponder(service).Run(ctx); err != nil {
+				log.Printf("mDNS-Ankündigung des Agents fehlgeschlagen: %v", err)
+			}
+		}()
+	}
+
+	if a.serverURL() == "" {
+		go a.discoverServer(ctx)
+	}
+}
+
+// discoverServer sucht per mDNS nach dem Server und registriert den Agent beim ersten Fund
+func (a *CaptureAgent) discoverServer(ctx context.Context) {
+	log.Printf("Keine Server-URL konfiguriert, suche Server per mDNS (%s)", discovery.ServerService)
+
+	for {
+		services, err := discovery.Browse(ctx, discovery.ServerService, serverBrowseTimeout)
+		if err != nil {
+			log.Printf("mDNS-Suche nach dem Server fehlgeschlagen: %v", err)
+		}
+
+		for _, service := range services {
+			serverURL := service.URL()
+			if serverURL == "" {
+				continue
+			}
+
+			log.Printf("Server '%s' per mDNS gefunden: %s", service.Instance, serverURL)
+			a.setServerURL(serverURL)
+
+			if err := a.Register(); err != nil {
+				log.Printf("Registrierung beim gefundenen Server fehlgeschlagen: %v", err)
+				a.setServerURL("")
+				continue
+			}
+			log.Println("Automatische Registrierung beim gefundenen Server erfolgreich")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(serverBrowseInterval):
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+const (
+	// Wartezeit nach einer Änderung, damit zusammengehörige netlink-Nachrichten gebündelt werden
+	interfaceChangeDebounce = 500 * time.Millisecond
+	// Abfrageintervall, falls netlink nicht verfügbar ist
+	interfacePollInterval = 5 * time.Second
+)
+
+// interfaceState ist der für die Überwachung relevante Zustand einer Schnittstelle
+type interfaceState struct {
+	up    bool   // administrativ aktiv und mit Link (Carrier)
+	addrs string // sortierte Adressliste zum Erkennen von Adressänderungen
+}
+
+// snapshotInterfaces liest den aktuellen Zustand aller Schnittstellen
+func snapshotInterfaces() (map[string]interfaceState, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]interfaceState, len(ifaces))
+	for _, iface := range ifaces {
+		var addrs []string
+		if ifaceAddrs, err := iface.Addrs(); err == nil {
+			for _, addr := range ifaceAddrs {
+				addrs = append(addrs, addr.String())
+			}
+		}
+		sort.Strings(addrs)
+
+		states[iface.Name] = interfaceState{
+			up:    iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0,
+			addrs: strings.Join(addrs, ","),
+		}
+	}
+	return states, nil
+}
+
+// watchInterfaces überwacht Link- und Adressänderungen (unter Linux per netlink, sonst
+// per Abfrage), pausiert Captures bei einem Link-Ausfall, setzt sie danach fort und
+// meldet die aktualisierte Schnittstellenliste an den Server
+func (a *CaptureAgent) watchInterfaces(ctx context.Context) {
+	states, err := snapshotInterfaces()
+	if err != nil {
+		log.Printf("Schnittstellenüberwachung deaktiviert: %v", err)
+		return
+	}
+
+	changes := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changes <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		err := subscribeInterfaceEvents(ctx, notify)
+		if err == nil {
+			return
+		}
+		log.Printf("Schnittstellenereignisse nicht verfügbar (%v), frage alle %v ab", err, interfacePollInterval)
+
+		ticker := time.NewTicker(interfacePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				notify()
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		}
+
+		// Weitere Nachrichten derselben Änderung abwarten
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interfaceChangeDebounce):
+		}
+		select {
+		case <-changes:
+		default:
+		}
+
+		current, err := snapshotInterfaces()
+		if err != nil {
+			log.Printf("Schnittstellen konnten nicht gelesen werden: %v", err)
+			continue
+		}
+		a.handleInterfaceChanges(states, current)
+		states = current
+	}
+}
+
+// handleInterfaceChanges vergleicht zwei Zustände, erzeugt Ereignisse für jeden Wechsel,
+// pausiert bzw. setzt betroffene Captures fort und meldet Änderungen an den Server
+func (a *CaptureAgent) handleInterfaceChanges(previous, current map[string]interfaceState) {
+	changed := len(previous) != len(current)
+	var down, up []string
+
+	for name, state := range current {
+		before, existed := previous[name]
+		if !existed || before.addrs != state.addrs {
+			changed = true
+		}
+		if state.up && !before.up {
+			up = append(up, name)
+		}
+	}
+	for name, before := range previous {
+		if state, exists := current[name]; before.up && (!exists || !state.up) {
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+	sort.Strings(up)
+
+	a.statusMutex.Lock()
+	for _, name := range down {
+		log.Printf("Schnittstelle %s ist ausgefallen", name)
+		a.addEventLocked(a.interfaceEvent("interface_down", "warning", name,
+			fmt.Sprintf("%s: Schnittstelle %s ist ausgefallen", a.config.Agent.Name, name)))
+		for _, capture := range a.captures {
+			if capture.status.Interface == name {
+				a.pauseCaptureLocked(capture)
+			}
+		}
+	}
+	for _, name := range up {
+		log.Printf("Schnittstelle %s ist wieder verfügbar", name)
+		a.addEventLocked(a.interfaceEvent("interface_up", "info", name,
+			fmt.Sprintf("%s: Schnittstelle %s ist wieder verfügbar", a.config.Agent.Name, name)))
+	}
+
+	// Alle pausierten Captures mit wieder verfügbarer Schnittstelle fortsetzen, auch
+	// solche, deren erster Fortsetzungsversuch gescheitert ist
+	var resumable []*activeCapture
+	for _, capture := range a.captures {
+		if capture.status.Status == "paused" && current[capture.status.Interface].up {
+			resumable = append(resumable, capture)
+		}
+	}
+	a.statusMutex.Unlock()
+
+	for _, capture := range resumable {
+		a.resumeCapture(capture)
+	}
+
+	if changed || len(down) > 0 || len(up) > 0 {
+		a.pushInterfaces()
+		a.triggerHeartbeat()
+	}
+}
+
+// pauseCaptureLocked hält eine laufende Capture an, deren Schnittstelle ausgefallen ist.
+// Die Capture bleibt in der Liste und wird fortgesetzt, sobald die Schnittstelle wieder
+// verfügbar ist. Der Aufrufer muss statusMutex halten.
+func (a *CaptureAgent) pauseCaptureLocked(capture *activeCapture) {
+	if capture.status.Status != "capturing" {
+		return
+	}
+
+	capture.cancel()
+	capture.session.Stop()
+	// Aufzeichnung bis zum Ausfall abschließen; nach der Fortsetzung beginnt eine neue Datei
+	if capture.archive != nil {
+		if err := capture.archive.Rotate(); err != nil {
+			log.Printf("Warnung: %v", err)
+		}
+	}
+	capture.status.Status = "paused"
+	capture.status.Error = fmt.Sprintf("interface %s is down", capture.status.Interface)
+	a.refreshStatusLocked()
+
+	log.Printf("Capture '%s' pausiert, Schnittstelle %s ist ausgefallen", capture.status.Name, capture.status.Interface)
+	event := a.interfaceEvent("capture_paused", "warning", capture.status.Interface,
+		fmt.Sprintf("%s/%s: Capture pausiert, Schnittstelle %s ist ausgefallen",
+			a.config.Agent.Name, capture.status.Name, capture.status.Interface))
+	event.Data.(map[string]interface{})["capture"] = capture.status.Name
+	a.addEventLocked(event)
+}
+
+// resumeCapture öffnet eine neue Session auf der Schnittstelle einer pausierten Capture und setzt sie fort
+func (a *CaptureAgent) resumeCapture(capture *activeCapture) {
+	name, captureInterface := capture.status.Name, capture.status.Interface
+
+	session, err := capture.openSession(captureInterface)
+	if err != nil {
+		log.Printf("Capture '%s' konnte nicht fortgesetzt werden: %v", name, err)
+		a.statusMutex.Lock()
+		capture.status.Error = fmt.Sprintf("failed to reopen interface %s: %v", captureInterface, err)
+		a.statusMutex.Unlock()
+		return
+	}
+
+	a.statusMutex.Lock()
+	// Zwischenzeitlich gestoppt oder bereits fortgesetzt
+	if a.captures[name] != capture || capture.status.Status != "paused" {
+		a.statusMutex.Unlock()
+		session.Stop()
+		return
+	}
+	// Zähler der beendeten Session übernehmen und die neue Session einsetzen
+	capture.pastStats = capture.stats()
+	capture.session = session
+	capture.ctx, capture.cancel = context.WithCancel(context.Background())
+	capture.status.Error = ""
+
+	log.Printf("Capture '%s' auf Schnittstelle %s fortgesetzt", name, captureInterface)
+	event := a.interfaceEvent("capture_resumed", "info", captureInterface,
+		fmt.Sprintf("%s/%s: Capture auf Schnittstelle %s fortgesetzt", a.config.Agent.Name, name, captureInterface))
+	event.Data.(map[string]interface{})["capture"] = name
+	a.addEventLocked(event)
+	a.statusMutex.Unlock()
+
+	a.beginCapture(capture)
+}
+
+// interfaceEvent erstellt ein Ereignis zu einer Schnittstelle
+func (a *CaptureAgent) interfaceEvent(eventType, severity, iface, description string) models.GatewayEvent {
+	return models.GatewayEvent{
+		Timestamp:   time.Now(),
+		EventType:   eventType,
+		Description: description,
+		Severity:    severity,
+		Data: map[string]interface{}{
+			"interface": iface,
+		},
+	}
+}
+
+// pushInterfaces sendet die aktuelle Schnittstellenliste an den Server
+func (a *CaptureAgent) pushInterfaces() {
+	if a.serverURL() == "" {
+		return
+	}
+
+	interfaceNames, interfaceDetails, err := collectInterfaces()
+	if err != nil {
+		log.Printf("Schnittstellen konnten nicht gelesen werden: %v", err)
+		return
+	}
+
+	jsonData, err := json.Marshal(map[string]interface{}{
+		"name":              a.config.Agent.Name,
+		"interfaces":        interfaceNames,
+		"interface_details": interfaceDetails,
+	})
+	if err != nil {
+		log.Printf("Fehler beim Erstellen der Schnittstellenmeldung: %v", err)
+		return
+	}
+
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/api/agents/interfaces", a.serverURL()), bytes.NewBuffer(jsonData))
+	if err != nil {
+		log.Printf("Fehler beim Erstellen der Schnittstellenmeldung: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if a.config.Agent.APIKey != "" {
+		req.Header.Set("X-API-Key", a.config.Agent.APIKey)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Printf("Schnittstellenmeldung an den Server fehlgeschlagen: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("Server lehnte Schnittstellenmeldung ab: Status %d", resp.StatusCode)
+		return
+	}
+	log.Printf("Schnittstellenliste an den Server gemeldet: %s", strings.Join(interfaceNames, ", "))
+}
@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+)
+
+// rtnetlink-Multicast-Gruppen für Link- und Adressänderungen (linux/rtnetlink.h),
+// im syscall-Paket nicht definiert
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4IfAddr = 0x10
+	rtmgrpIPv6IfAddr = 0x100
+)
+
+// subscribeInterfaceEvents abonniert Link- und Adressänderungen über rtnetlink und ruft
+// notify bei jeder relevanten Nachricht auf. Kehrt erst zurück, wenn ctx beendet ist
+// oder der Socket nicht mehr gelesen werden kann.
+func subscribeInterfaceEvents(ctx context.Context, notify func()) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return fmt.Errorf("netlink-Socket konnte nicht geöffnet werden: %w", err)
+	}
+	defer syscall.Close(fd)
+
+	addr := &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: rtmgrpLink | rtmgrpIPv4IfAddr | rtmgrpIPv6IfAddr,
+	}
+	if err := syscall.Bind(fd, addr); err != nil {
+		return fmt.Errorf("netlink-Gruppen konnten nicht abonniert werden: %w", err)
+	}
+
+	// Lesezeitlimit, damit das Beenden des Kontexts regelmäßig geprüft wird
+	timeout := syscall.Timeval{Sec: 1}
+	if err := syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout); err != nil {
+		return fmt.Errorf("netlink-Zeitlimit konnte nicht gesetzt werden: %w", err)
+	}
+
+	buf := make([]byte, 64*1024)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buf, 0)
+		if err != nil {
+			switch err {
+			case syscall.EAGAIN, syscall.EINTR:
+				continue
+			case syscall.ENOBUFS:
+				// Nachrichten gingen verloren - Zustand vollständig neu einlesen
+				notify()
+				continue
+			}
+			return fmt.Errorf("netlink-Nachricht konnte nicht gelesen werden: %w", err)
+		}
+
+		messages, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, message := range messages {
+			switch message.Header.Type {
+			case syscall.RTM_NEWLINK, syscall.RTM_DELLINK, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+				notify()
+			}
+		}
+	}
+	return nil
+}
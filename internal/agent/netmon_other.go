@@ -0,0 +1,14 @@
+//go:build !linux
+
+package agent
+
+import (
+	"context"
+	"errors"
+)
+
+// subscribeInterfaceEvents ist nur unter Linux (rtnetlink) verfügbar; andere Systeme
+// fragen die Schnittstellen regelmäßig ab
+func subscribeInterfaceEvents(ctx context.Context, notify func()) error {
+	return errors.New("netlink wird auf diesem System nicht unterstützt")
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// listPcapFilesHandler listet die fortlaufend aufgezeichneten Dateien, neueste zuerst
+func (a *CaptureAgent) listPcapFilesHandler(w http.ResponseWriter, r *http.Request) {
+	if a.archive == nil {
+		respondWithError(w, http.StatusNotFound, "Continuous recording not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    a.archive.List(),
+	})
+}
+
+// downloadPcapFileHandler liefert eine fortlaufend aufgezeichnete Datei. Laufende Dateien
+// werden bis zum zuletzt geschriebenen Paket geliefert.
+func (a *CaptureAgent) downloadPcapFileHandler(w http.ResponseWriter, r *http.Request) {
+	if a.archive == nil {
+		respondWithError(w, http.StatusNotFound, "Continuous recording not enabled")
+		return
+	}
+
+	name := mux.Vars(r)["file"]
+	reader, file, err := a.archive.Open(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("File '%s' not found", name))
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to open file: %v", err))
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", file.ContentType())
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s-%s\"", a.config.Agent.Name, file.Name))
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Error while sending file %s: %v", name, err)
+	}
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// recordTriggerEvents puffert für jede ausgelöste Aufzeichnung ein Ereignis und meldet es sofort.
+// Der Server ergänzt anhand von "recording_id" den Downloadpfad.
+func (a *CaptureAgent) recordTriggerEvents(started []packet.TriggerRecording) {
+	a.statusMutex.Lock()
+	for _, rec := range started {
+		a.addEventLocked(models.GatewayEvent{
+			Timestamp:   rec.TriggeredAt,
+			EventType:   "capture_trigger",
+			Description: fmt.Sprintf("%s/%s: Regel '%s' ausgelöst: %s", a.config.Agent.Name, rec.Capture, rec.Rule, rec.Reason),
+			Severity:    rec.Severity,
+			Data: map[string]interface{}{
+				"capture":         rec.Capture,
+				"interface":       rec.Interface,
+				"rule":            rec.Rule,
+				"reason":          rec.Reason,
+				"recording_id":    rec.ID,
+				"recording_from":  rec.From,
+				"recording_until": rec.Until,
+			},
+		})
+	}
+	a.statusMutex.Unlock()
+
+	a.triggerHeartbeat()
+}
+
+// listRecordingsHandler listet die ausgelösten Aufzeichnungen, neueste zuerst
+func (a *CaptureAgent) listRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if a.recordings == nil {
+		respondWithError(w, http.StatusNotFound, "Trigger recording not enabled")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    a.recordings.List(),
+	})
+}
+
+// downloadRecordingHandler liefert die PCAP-Datei einer abgeschlossenen Aufzeichnung
+func (a *CaptureAgent) downloadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if a.recordings == nil {
+		respondWithError(w, http.StatusNotFound, "Trigger recording not enabled")
+		return
+	}
+
+	id := mux.Vars(r)["id"]
+	file, rec, err := a.recordings.Open(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Recording '%s' not found", id))
+		return
+	case errors.Is(err, packet.ErrRecordingInProgress):
+		respondWithError(w, http.StatusConflict,
+			fmt.Sprintf("Recording '%s' is still in progress until %s", id, rec.Until.Format("15:04:05")))
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to open recording: %v", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s-%s.pcap\"", a.config.Agent.Name, rec.ID))
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Error while sending recording %s: %v", id, err)
+	}
+}
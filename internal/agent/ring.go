@@ -0,0 +1,79 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/timeparam"
+)
+
+// countingWriter zählt die geschriebenen Bytes, um zu erkennen, ob die Antwort bereits begonnen hat
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// pcapHandler liefert die Pakete eines Zeitraums aus dem Ringpuffer als PCAP-Datei
+func (a *CaptureAgent) pcapHandler(w http.ResponseWriter, r *http.Request) {
+	if a.ring == nil {
+		respondWithError(w, http.StatusNotFound, "Ring buffer not enabled")
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := timeparam.Parse(query.Get("from"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'from' parameter: %v", err))
+		return
+	}
+	to, err := timeparam.Parse(query.Get("to"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Invalid 'to' parameter: %v", err))
+		return
+	}
+	if !to.IsZero() && to.Before(from) {
+		respondWithError(w, http.StatusBadRequest, "'to' must not be before 'from'")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s-%d.pcap\"", a.config.Agent.Name, from.Unix()))
+
+	out := &countingWriter{w: w}
+	count, err := a.ring.Extract(query.Get("interface"), from, to, query.Get("filter"), out)
+	if err != nil {
+		// Fehler nur dann als JSON melden, wenn noch keine PCAP-Daten gesendet wurden
+		if out.n == 0 {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		log.Printf("Error while streaming ring buffer extract: %v", err)
+		return
+	}
+
+	log.Printf("Ring buffer extract: %d packets between %v and %v", count, from, to)
+}
+
+// Close gibt die Ressourcen des Agents frei und schließt den Ringpuffer
+func (a *CaptureAgent) Close() error {
+	if a.discoveryCancel != nil {
+		a.discoveryCancel()
+	}
+	if a.interfaceWatchCancel != nil {
+		a.interfaceWatchCancel()
+	}
+	a.stopAllCaptures()
+	if a.ring != nil {
+		return a.ring.Close()
+	}
+	return nil
+}
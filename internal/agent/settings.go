@@ -0,0 +1,200 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+// localSettings ist ein Abbild der lokal wirksamen Einstellungen für das Zurückrollen
+type localSettings struct {
+	capture config.CaptureConfig
+	gateway config.GatewayConfig
+	iface   string
+	version int
+}
+
+// snapshotSettings sichert die aktuell wirksamen Einstellungen
+func (a *CaptureAgent) snapshotSettings() localSettings {
+	snapshot := localSettings{
+		capture: a.config.Capture,
+		gateway: a.config.Gateway,
+		iface:   a.config.Agent.Interface,
+		version: a.configVersion,
+	}
+	snapshot.gateway.KnownGateways = append([]string(nil), a.config.Gateway.KnownGateways...)
+	return snapshot
+}
+
+// restoreSettings stellt zuvor gesicherte Einstellungen wieder her
+func (a *CaptureAgent) restoreSettings(snapshot localSettings) {
+	a.config.Capture = snapshot.capture
+	a.config.Gateway = snapshot.gateway
+	a.config.Agent.Interface = snapshot.iface
+	a.configVersion = snapshot.version
+}
+
+// applyServerSettings übernimmt ein vom Server verteiltes Konfigurationsdokument.
+// Laufende Captures werden mit den neuen Einstellungen neu gestartet; schlägt das fehl,
+// werden die vorherigen Einstellungen wiederhergestellt.
+func (a *CaptureAgent) applyServerSettings(settings *config.AgentSettings) {
+	if settings == nil {
+		return
+	}
+
+	a.configMutex.Lock()
+	defer a.configMutex.Unlock()
+
+	// Bereits angewendete oder bereits gescheiterte Versionen nicht erneut versuchen
+	if settings.Version == a.configVersion || settings.Version == a.failedConfigVersion {
+		return
+	}
+
+	status := a.currentStatus()
+	running := a.runningCaptureStates()
+
+	// Während eine Capture auf ihren Startzeitpunkt wartet, nichts ändern -
+	// der nächste Heartbeat liefert das Dokument erneut
+	for _, capture := range status.Captures {
+		if capture.Status == "scheduled" {
+			log.Printf("Konfiguration Version %d wird nach dem Start der geplanten Capture angewendet", settings.Version)
+			return
+		}
+	}
+
+	if err := validateServerSettings(settings); err != nil {
+		a.rejectServerSettings(settings.Version, err)
+		return
+	}
+
+	snapshot := a.snapshotSettings()
+
+	// Neue Einstellungen übernehmen; die Schnittstelle gilt nur für künftige Captures
+	a.config.Capture.Filter = settings.Filter
+	a.config.Capture.PromiscMode = settings.PromiscMode
+	a.config.Capture.SnapLen = settings.SnapLen
+	a.config.Capture.BufferSize = settings.BufferSize
+	a.config.Gateway = settings.Gateway
+	a.config.Gateway.KnownGateways = append([]string(nil), settings.Gateway.KnownGateways...)
+	if settings.Interface != "" {
+		a.config.Agent.Interface = settings.Interface
+	}
+
+	if err := a.restartCaptures(running); err != nil {
+		log.Printf("Konfiguration Version %d konnte nicht angewendet werden, stelle vorherige Einstellungen wieder her: %v",
+			settings.Version, err)
+		a.restoreSettings(snapshot)
+		if rollbackErr := a.restartCaptures(running); rollbackErr != nil {
+			log.Printf("Captures mit vorherigen Einstellungen konnten nicht fortgesetzt werden: %v", rollbackErr)
+			a.statusMutex.Lock()
+			a.status.Error = fmt.Sprintf("Rollback fehlgeschlagen: %v", rollbackErr)
+			a.statusMutex.Unlock()
+		}
+		a.rejectServerSettings(settings.Version, err)
+		return
+	}
+
+	a.configVersion = settings.Version
+	a.failedConfigVersion = 0
+
+	a.statusMutex.Lock()
+	a.status.ConfigVersion = settings.Version
+	a.status.ConfigError = ""
+	a.refreshStatusLocked()
+	a.statusMutex.Unlock()
+
+	log.Printf("Konfiguration Version %d vom Server angewendet", settings.Version)
+}
+
+// rejectServerSettings merkt sich eine nicht anwendbare Version und meldet den Fehler im nächsten Heartbeat
+func (a *CaptureAgent) rejectServerSettings(version int, err error) {
+	a.failedConfigVersion = version
+
+	a.statusMutex.Lock()
+	a.status.ConfigError = fmt.Sprintf("Version %d: %v", version, err)
+	a.statusMutex.Unlock()
+
+	log.Printf("Konfiguration Version %d abgelehnt: %v", version, err)
+}
+
+// validateServerSettings prüft ein Konfigurationsdokument vor der Anwendung auf diesem Agent
+func validateServerSettings(settings *config.AgentSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := packet.ValidateBPFFilter(settings.Filter, settings.SnapLen); err != nil {
+		return err
+	}
+	if settings.Interface != "" {
+		if _, err := net.InterfaceByName(settings.Interface); err != nil {
+			return fmt.Errorf("Netzwerkschnittstelle '%s' nicht gefunden", settings.Interface)
+		}
+	}
+	return nil
+}
+
+// captureState ist der Zustand einer laufenden Capture, der einen Neustart überdauert
+type captureState struct {
+	status    CaptureStatus
+	limits    captureLimits
+	pastStats packet.CaptureStats
+}
+
+// runningCaptureStates gibt den Zustand aller laufenden Captures zurück
+func (a *CaptureAgent) runningCaptureStates() []captureState {
+	a.statusMutex.Lock()
+	defer a.statusMutex.Unlock()
+
+	var states []captureState
+	for _, capture := range a.captures {
+		if capture.status.Status != "capturing" {
+			continue
+		}
+		states = append(states, captureState{
+			status:    capture.status,
+			limits:    capture.limits,
+			pastStats: capture.stats(),
+		})
+	}
+	return states
+}
+
+// restartCaptures startet die angegebenen Captures mit der aktuellen Konfiguration neu. Zähler,
+// Grenzen und Startzeit bleiben erhalten; die zeitliche Begrenzung läuft mit der Restdauer weiter.
+// Captures, die nicht mehr laufen (z.B. nach einem fehlgeschlagenen Neustart), werden neu angelegt.
+func (a *CaptureAgent) restartCaptures(states []captureState) error {
+	for _, state := range states {
+		def := state.status
+
+		// Alte Capture beenden
+		a.statusMutex.Lock()
+		if old, exists := a.captures[def.Name]; exists {
+			a.stopCaptureLocked(old)
+		}
+		a.statusMutex.Unlock()
+
+		capture, err := a.openCapture(def.Name, def.Interface, def.Filter, def.SessionID)
+		if err != nil {
+			return fmt.Errorf("Capture '%s': %w", def.Name, err)
+		}
+
+		// Zähler, Grenzen und ursprüngliche Startzeit vor dem Start übernehmen, damit
+		// beginCapture den Timer mit der Restdauer setzt
+		capture.status.PacketsCaptured = def.PacketsCaptured
+		capture.status.BytesCaptured = def.BytesCaptured
+		capture.status.CaptureStarted = def.CaptureStarted
+		capture.limits = state.limits
+		capture.pastStats = state.pastStats
+
+		a.statusMutex.Lock()
+		a.captures[def.Name] = capture
+		a.statusMutex.Unlock()
+
+		a.beginCapture(capture)
+	}
+
+	return nil
+}
@@ -0,0 +1,386 @@
+package agent
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+const (
+	// Standardzeit, innerhalb derer sich eine neue Version registrieren muss
+	defaultRollbackTimeout = 2 * time.Minute
+	// Timeout für den Download einer neuen Version
+	updateDownloadTimeout = 10 * time.Minute
+)
+
+// updateState wird neben der Binärdatei gespeichert und überdauert den Neustart
+// in die neue bzw. zurück in die alte Version
+type updateState struct {
+	PreviousVersion string    `json:"previous_version"`
+	Version         string    `json:"version"`
+	Backup          string    `json:"backup"`
+	Deadline        time.Time `json:"deadline"`
+	Attempts        int       `json:"attempts"`
+
+	// Nach einem Rollback gesetzt, damit die alte Version die neue nicht erneut installiert
+	FailedVersion string `json:"failed_version,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// executablePath gibt den Pfad der laufenden Binärdatei ohne Symlinks zurück
+func executablePath() (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(executable)
+}
+
+func updateStatePath(executable string) string {
+	return executable + ".update.json"
+}
+
+func readUpdateState(path string) (*updateState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state updateState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+func writeUpdateState(path string, state *updateState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// execSelf ersetzt den laufenden Prozess durch die Binärdatei unter executable
+func execSelf(executable string) error {
+	log.Printf("Restarting agent process: %s %v", executable, os.Args[1:])
+	return syscall.Exec(executable, append([]string{executable}, os.Args[1:]...), os.Environ())
+}
+
+// checkPendingUpdate wertet beim Start den Zustand einer vorherigen Aktualisierung aus.
+// Die neue Version muss sich innerhalb des Rollback-Timeouts registrieren; startet sie
+// erneut, ohne dass dies gelungen ist, oder läuft die Frist ab, wird die vorherige
+// Version wiederhergestellt.
+func (a *CaptureAgent) checkPendingUpdate() {
+	executable, err := executablePath()
+	if err != nil {
+		return
+	}
+	statePath := updateStatePath(executable)
+
+	state, err := readUpdateState(statePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warnung: Aktualisierungsstatus konnte nicht gelesen werden: %v", err)
+		}
+		return
+	}
+
+	// Alte Version nach einem Rollback
+	if state.FailedVersion != "" {
+		log.Printf("Aktualisierung auf Version %s wurde zurückgerollt: %s", state.FailedVersion, state.Error)
+		a.updateMutex.Lock()
+		a.failedUpdateVersion = state.FailedVersion
+		a.updateMutex.Unlock()
+		a.setUpdateError(fmt.Sprintf("Version %s: %s", state.FailedVersion, state.Error))
+		os.Remove(statePath)
+		return
+	}
+
+	if state.Version != version.Version {
+		// Der Austausch hat nicht stattgefunden - Status verwerfen
+		log.Printf("Aktualisierungsstatus für Version %s passt nicht zur laufenden Version %s, wird verworfen", state.Version, version.Version)
+		os.Remove(statePath)
+		return
+	}
+
+	state.Attempts++
+	if state.Attempts > 1 || time.Now().After(state.Deadline) {
+		a.rollbackUpdate(executable, state, "neue Version hat sich nicht rechtzeitig registriert")
+		return
+	}
+	if err := writeUpdateState(statePath, state); err != nil {
+		log.Printf("Warnung: Aktualisierungsstatus konnte nicht gespeichert werden: %v", err)
+	}
+
+	log.Printf("Version %s gestartet, warte bis %v auf erfolgreiche Registrierung", version.Version, state.Deadline)
+	a.updateMutex.Lock()
+	a.pendingUpdate = state
+	a.rollbackTimer = time.AfterFunc(time.Until(state.Deadline), func() {
+		a.updateMutex.Lock()
+		pending := a.pendingUpdate
+		a.updateMutex.Unlock()
+		if pending != nil {
+			a.Close()
+			a.rollbackUpdate(executable, pending, "neue Version hat sich nicht rechtzeitig registriert")
+		}
+	})
+	a.updateMutex.Unlock()
+}
+
+// confirmUpdate wird nach einer erfolgreichen Registrierung aufgerufen und schließt eine
+// laufende Aktualisierung ab
+func (a *CaptureAgent) confirmUpdate() {
+	a.updateMutex.Lock()
+	defer a.updateMutex.Unlock()
+
+	if a.pendingUpdate == nil {
+		return
+	}
+	if a.rollbackTimer != nil {
+		a.rollbackTimer.Stop()
+	}
+
+	if executable, err := executablePath(); err == nil {
+		os.Remove(updateStatePath(executable))
+	}
+	os.Remove(a.pendingUpdate.Backup)
+
+	log.Printf("Aktualisierung von Version %s auf %s abgeschlossen", a.pendingUpdate.PreviousVersion, version.Version)
+	a.pendingUpdate = nil
+}
+
+// rollbackUpdate stellt die vorherige Binärdatei wieder her und startet sie
+func (a *CaptureAgent) rollbackUpdate(executable string, state *updateState, reason string) {
+	log.Printf("Rollback auf Version %s: %s", state.PreviousVersion, reason)
+
+	if err := os.Rename(state.Backup, executable); err != nil {
+		log.Printf("Rollback fehlgeschlagen, vorherige Version nicht verfügbar: %v", err)
+		os.Remove(updateStatePath(executable))
+		return
+	}
+
+	failed := &updateState{FailedVersion: state.Version, Error: reason}
+	if err := writeUpdateState(updateStatePath(executable), failed); err != nil {
+		log.Printf("Warnung: Aktualisierungsstatus konnte nicht gespeichert werden: %v", err)
+	}
+
+	if err := execSelf(executable); err != nil {
+		log.Printf("Failed to restart agent: %v", err)
+	}
+}
+
+// setUpdateError setzt den im Heartbeat gemeldeten Aktualisierungsfehler
+func (a *CaptureAgent) setUpdateError(message string) {
+	a.statusMutex.Lock()
+	a.status.UpdateError = message
+	a.statusMutex.Unlock()
+}
+
+// applyUpdate lädt eine vom Server angekündigte Version herunter, prüft Prüfsumme und
+// Signatur, tauscht die Binärdatei aus und startet den Agent neu
+func (a *CaptureAgent) applyUpdate(update *config.AgentUpdate) {
+	updateConfig := a.config.Agent.Update
+	if update == nil || updateConfig == nil || !updateConfig.Enabled || update.Version == version.Version {
+		return
+	}
+
+	a.updateMutex.Lock()
+	if a.updating || a.pendingUpdate != nil || update.Version == a.failedUpdateVersion {
+		a.updateMutex.Unlock()
+		return
+	}
+	a.updating = true
+	a.updateMutex.Unlock()
+
+	defer func() {
+		a.updateMutex.Lock()
+		a.updating = false
+		a.updateMutex.Unlock()
+	}()
+
+	log.Printf("Aktualisierung auf Version %s angekündigt", update.Version)
+
+	if err := a.installUpdate(update, updateConfig); err != nil {
+		log.Printf("Aktualisierung auf Version %s fehlgeschlagen: %v", update.Version, err)
+		a.updateMutex.Lock()
+		a.failedUpdateVersion = update.Version
+		a.updateMutex.Unlock()
+		a.setUpdateError(fmt.Sprintf("Version %s: %v", update.Version, err))
+	}
+}
+
+// installUpdate führt Download, Prüfung, Austausch und Neustart durch.
+// Kehrt nur im Fehlerfall zurück.
+func (a *CaptureAgent) installUpdate(update *config.AgentUpdate, updateConfig *config.UpdateConfig) error {
+	publicKey, err := base64.StdEncoding.DecodeString(updateConfig.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("ungültiger öffentlicher Schlüssel für Aktualisierungen")
+	}
+	signature, err := base64.StdEncoding.DecodeString(update.Signature)
+	if err != nil || len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("ungültige Signatur")
+	}
+	expectedSum, err := hex.DecodeString(update.SHA256)
+	if err != nil || len(expectedSum) != sha256.Size {
+		return fmt.Errorf("ungültige Prüfsumme")
+	}
+
+	// Ältere Versionen werden nicht installiert, auch wenn sie gültig signiert sind
+	if version.Compare(update.Version, version.Version) < 0 {
+		return fmt.Errorf("Version %s ist älter als die laufende Version %s", update.Version, version.Version)
+	}
+
+	// Die Signatur muss zu angekündigter Version, Prüfsumme und der eigenen Plattform passen,
+	// bevor etwas heruntergeladen wird
+	message := version.ReleaseSignatureMessage(update.Version, runtime.GOOS, runtime.GOARCH, update.SHA256)
+	if !ed25519.Verify(ed25519.PublicKey(publicKey), message, signature) {
+		return fmt.Errorf("Signatur ist ungültig")
+	}
+
+	executable, err := executablePath()
+	if err != nil {
+		return fmt.Errorf("Pfad der Binärdatei unbekannt: %w", err)
+	}
+	newPath := executable + ".new"
+	backupPath := executable + ".old"
+
+	if err := a.downloadUpdate(update, newPath, expectedSum); err != nil {
+		os.Remove(newPath)
+		return err
+	}
+
+	// Sicherung der laufenden Version anlegen; ein Hardlink genügt, da die alte
+	// Datei anschließend nur umbenannt und nicht verändert wird
+	os.Remove(backupPath)
+	if err := os.Link(executable, backupPath); err != nil {
+		if err := copyFile(executable, backupPath); err != nil {
+			os.Remove(newPath)
+			return fmt.Errorf("Sicherung der laufenden Version fehlgeschlagen: %w", err)
+		}
+	}
+
+	timeout := defaultRollbackTimeout
+	if updateConfig.RollbackTimeout > 0 {
+		timeout = time.Duration(updateConfig.RollbackTimeout) * time.Second
+	}
+	state := &updateState{
+		PreviousVersion: version.Version,
+		Version:         update.Version,
+		Backup:          backupPath,
+		Deadline:        time.Now().Add(timeout),
+	}
+	if err := writeUpdateState(updateStatePath(executable), state); err != nil {
+		os.Remove(newPath)
+		return fmt.Errorf("Aktualisierungsstatus konnte nicht gespeichert werden: %w", err)
+	}
+
+	// Atomarer Austausch der Binärdatei
+	if err := os.Rename(newPath, executable); err != nil {
+		os.Remove(newPath)
+		os.Remove(updateStatePath(executable))
+		return fmt.Errorf("Austausch der Binärdatei fehlgeschlagen: %w", err)
+	}
+
+	log.Printf("Version %s installiert, starte neu", update.Version)
+	a.Close()
+
+	err = execSelf(executable)
+
+	// Exec fehlgeschlagen - alte Version wiederherstellen; der Prozess läuft ohne Captures weiter
+	a.updateMutex.Lock()
+	a.pendingUpdate = nil
+	a.updateMutex.Unlock()
+	os.Rename(backupPath, executable)
+	os.Remove(updateStatePath(executable))
+	return fmt.Errorf("Neustart fehlgeschlagen: %w", err)
+}
+
+// downloadUpdate lädt die neue Binärdatei nach path und prüft ihre Prüfsumme
+func (a *CaptureAgent) downloadUpdate(update *config.AgentUpdate, path string, expectedSum []byte) error {
+	req, err := http.NewRequest(http.MethodGet, a.serverURL()+update.URL, nil)
+	if err != nil {
+		return fmt.Errorf("Download-Anfrage konnte nicht erstellt werden: %w", err)
+	}
+	if a.config.Agent.APIKey != "" {
+		req.Header.Set("X-API-Key", a.config.Agent.APIKey)
+	}
+
+	client := &http.Client{Timeout: updateDownloadTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Download fehlgeschlagen: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Download fehlgeschlagen: Status %d", resp.StatusCode)
+	}
+
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return fmt.Errorf("Datei konnte nicht angelegt werden: %w", err)
+	}
+
+	hash := sha256.New()
+	body := io.Reader(resp.Body)
+	if update.Size > 0 {
+		body = io.LimitReader(resp.Body, update.Size+1)
+	}
+	_, err = io.Copy(io.MultiWriter(out, hash), body)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Download fehlgeschlagen: %w", err)
+	}
+
+	if sum := hash.Sum(nil); hex.EncodeToString(sum) != hex.EncodeToString(expectedSum) {
+		return fmt.Errorf("Prüfsumme stimmt nicht überein")
+	}
+	return nil
+}
+
+// copyFile kopiert eine Datei samt Berechtigungen
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -10,11 +10,9 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
 )
 
@@ -422,7 +420,7 @@ func (a *CaptureAgent) adminHandler(w http.ResponseWriter, r *http.Request) {
 		Status:          a.status.Status,
 		PacketsCaptured: a.status.PacketsCaptured,
 		Interface:       a.status.Interface,
-		ServerURL:       a.config.Agent.ServerURL,
+		ServerURL:       a.serverURL(),
 		APIKey:          a.config.Agent.APIKey,
 		Connected:       a.status.Status != "error",
 		Interfaces:      interfaces,
@@ -453,7 +451,7 @@ func (a *CaptureAgent) configHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Konfiguration aktualisieren
-	a.config.Agent.ServerURL = req.ServerURL
+	a.setServerURL(req.ServerURL)
 	a.config.Agent.Name = req.Name
 	a.config.Agent.Interface = req.Interface
 	a.config.Agent.APIKey = req.APIKey
@@ -472,7 +470,7 @@ func (a *CaptureAgent) configHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Auch den Status im Capturer aktualisieren, damit die Änderungen beim nächsten Neustart erhalten bleiben
-	a.capturer.UpdateInterface(req.Interface)
+	a.config.Capture.Interface = req.Interface
 
 	// Erfolgreiche Antwort senden
 	respondWithSuccessJSON(w, "Konfiguration erfolgreich gespeichert", nil)
@@ -560,7 +558,10 @@ func (a *CaptureAgent) saveConfig() error {
 		}
 
 		// Konfiguration speichern
-		if err := config.SaveConfig(a.config, configPath); err != nil {
+		a.serverURLMutex.RLock()
+		err := config.SaveConfig(a.config, configPath)
+		a.serverURLMutex.RUnlock()
+		if err != nil {
 			log.Printf("Fehler beim Speichern der Konfiguration in %s: %v", configPath, err)
 			lastErr = err
 			continue
@@ -614,10 +615,8 @@ func (a *CaptureAgent) restartHandler(w http.ResponseWriter, r *http.Request) {
 	log.Println("Agent restart requested")
 
 	// Aktuelle Erfassung beenden, falls eine läuft
-	if a.cancelFunc != nil {
-		log.Println("Stopping current capture before restart")
-		a.cancelFunc()
-	}
+	log.Println("Stopping current captures before restart")
+	a.stopAllCaptures()
 
 	// Sicherstellen, dass die aktuelle Konfiguration gespeichert wird, bevor wir neustarten
 	if err := a.saveConfig(); err != nil {
@@ -635,11 +634,10 @@ func (a *CaptureAgent) restartHandler(w http.ResponseWriter, r *http.Request) {
 	messageJSON, _ := json.Marshal(restartMessage)
 
 	for client := range a.clients {
-		client.WriteMessage(websocket.TextMessage, messageJSON)
-		client.Close()
+		client.disconnect(messageJSON)
 	}
 	// Clients-Map leeren
-	a.clients = make(map[*websocket.Conn]bool)
+	a.clients = make(map[*wsClient]bool)
 	a.clientsMutex.Unlock()
 
 	// Erfolgreiche Antwort senden, bevor der Neustart beginnt
@@ -661,8 +659,7 @@ func (a *CaptureAgent) restartHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Neustart per Exec
-		log.Printf("Restarting agent process: %s %v", executable, os.Args[1:])
-		if err := syscall.Exec(executable, append([]string{executable}, os.Args[1:]...), os.Environ()); err != nil {
+		if err := execSelf(executable); err != nil {
 			log.Printf("Failed to restart agent: %v", err)
 		}
 	}()
@@ -671,7 +668,7 @@ func (a *CaptureAgent) restartHandler(w http.ResponseWriter, r *http.Request) {
 // registerHandler registriert den Agent manuell beim Hauptserver
 func (a *CaptureAgent) registerHandler(w http.ResponseWriter, r *http.Request) {
 	// Sicherstellen, dass die aktuelle Konfiguration verwendet wird
-	serverURL := a.config.Agent.ServerURL
+	serverURL := a.serverURL()
 	log.Printf("Verwende Server-URL für manuelle Registrierung: %s", serverURL)
 
 	if err := a.Register(); err != nil {
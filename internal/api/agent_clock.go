@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// Maximale Anzahl gespeicherter Offset-Messungen pro Agent
+	maxClockOffsetHistory = 64
+
+	// Anzahl der jüngsten Messungen, aus denen der aktuelle Offset geschätzt wird
+	clockFilterWindow = 8
+)
+
+// ClockSample enthält die vier Zeitstempel eines Heartbeat-Austauschs (NTP-Verfahren).
+// AgentSend und AgentReceive stammen von der Uhr des Agents, die übrigen von der Server-Uhr.
+type ClockSample struct {
+	AgentSend     time.Time `json:"agent_send"`     // t0
+	ServerReceive time.Time `json:"server_receive"` // t1
+	ServerSend    time.Time `json:"server_send"`    // t2
+	AgentReceive  time.Time `json:"agent_receive"`  // t3
+}
+
+// ClockOffsetSample ist eine einzelne Offset-Messung zwischen Server und Agent
+type ClockOffsetSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	OffsetMs  float64   `json:"offset_ms"` // Server-Zeit minus Agent-Zeit
+	RTTMs     float64   `json:"rtt_ms"`
+}
+
+// ClockEstimate ist die aktuelle Schätzung des Uhrenversatzes eines Agents
+type ClockEstimate struct {
+	OffsetMs      float64   `json:"offset_ms"`      // Server-Zeit minus Agent-Zeit
+	UncertaintyMs float64   `json:"uncertainty_ms"` // Maximaler Fehler der Schätzung (RTT/2)
+	RTTMs         float64   `json:"rtt_ms"`
+	Samples       int       `json:"samples"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// offset berechnet Versatz und Round-Trip-Time einer Messung
+func (s ClockSample) offset() (offset, rtt time.Duration, err error) {
+	if s.AgentSend.IsZero() || s.ServerReceive.IsZero() || s.ServerSend.IsZero() || s.AgentReceive.IsZero() {
+		return 0, 0, fmt.Errorf("unvollständige Zeitmessung")
+	}
+
+	// θ = ((t1 - t0) + (t2 - t3)) / 2,  δ = (t3 - t0) - (t2 - t1)
+	offset = (s.ServerReceive.Sub(s.AgentSend) + s.ServerSend.Sub(s.AgentReceive)) / 2
+	rtt = s.AgentReceive.Sub(s.AgentSend) - s.ServerSend.Sub(s.ServerReceive)
+	if rtt < 0 {
+		return 0, 0, fmt.Errorf("negative Round-Trip-Time (%v)", rtt)
+	}
+
+	return offset, rtt, nil
+}
+
+// addClockSample übernimmt eine Messung in die Offset-Historie und aktualisiert die Schätzung.
+// Der Aufrufer muss remoteAgentsMutex exklusiv halten.
+func addClockSample(agent *RemoteAgent, sample ClockSample) error {
+	offset, rtt, err := sample.offset()
+	if err != nil {
+		return err
+	}
+
+	agent.ClockOffsetHistory = append(agent.ClockOffsetHistory, ClockOffsetSample{
+		Timestamp: sample.ServerReceive,
+		OffsetMs:  durationToMs(offset),
+		RTTMs:     durationToMs(rtt),
+	})
+	if len(agent.ClockOffsetHistory) > maxClockOffsetHistory {
+		agent.ClockOffsetHistory = agent.ClockOffsetHistory[len(agent.ClockOffsetHistory)-maxClockOffsetHistory:]
+	}
+
+	// Clock-Filter wie bei NTP: aus den jüngsten Messungen die mit der kleinsten
+	// RTT verwenden, da deren Offset am wenigsten durch Netzwerk-Jitter verfälscht ist
+	window := agent.ClockOffsetHistory
+	if len(window) > clockFilterWindow {
+		window = window[len(window)-clockFilterWindow:]
+	}
+	best := window[0]
+	for _, s := range window[1:] {
+		if s.RTTMs < best.RTTMs {
+			best = s
+		}
+	}
+
+	agent.ClockOffset = &ClockEstimate{
+		OffsetMs:      best.OffsetMs,
+		UncertaintyMs: best.RTTMs / 2,
+		RTTMs:         best.RTTMs,
+		Samples:       len(agent.ClockOffsetHistory),
+		UpdatedAt:     sample.ServerReceive,
+	}
+
+	return nil
+}
+
+// agentClockOffset gibt den geschätzten Versatz eines Agents zurück (0, falls unbekannt).
+// Der Aufrufer muss remoteAgentsMutex mindestens lesend halten.
+func agentClockOffset(agent *RemoteAgent) time.Duration {
+	if agent == nil || agent.ClockOffset == nil {
+		return 0
+	}
+	return time.Duration(agent.ClockOffset.OffsetMs * float64(time.Millisecond))
+}
+
+// CorrectAgentTime rechnet einen Zeitstempel von der Uhr eines Agents in Server-Zeit um
+func CorrectAgentTime(agentName string, t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+
+	remoteAgentsMutex.RLock()
+	offset := agentClockOffset(remoteAgents[agentName])
+	remoteAgentsMutex.RUnlock()
+
+	return t.Add(offset)
+}
+
+// toAgentTime rechnet einen Server-Zeitstempel in die Uhrzeit eines Agents um
+func toAgentTime(agentName string, t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+
+	remoteAgentsMutex.RLock()
+	offset := agentClockOffset(remoteAgents[agentName])
+	remoteAgentsMutex.RUnlock()
+
+	return t.Add(-offset)
+}
+
+// durationToMs wandelt eine Dauer in Millisekunden mit Nachkommastellen um
+func durationToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+// Fehlermeldung, wenn ein Agent eine Funktion nicht unterstützt
+const capabilityNotSupportedError = "Agent '%s' unterstützt die Funktion '%s' nicht (Agent-Version %s), bitte den Agent aktualisieren"
+
+// checkAgentCompatibility prüft die Protokollversion eines sich registrierenden Agents.
+// Agents ohne Angabe stammen aus der Zeit vor der Aushandlung und gelten als Version 1.
+func checkAgentCompatibility(reg *AgentRegistration) error {
+	if reg.ProtocolVersion == 0 {
+		reg.ProtocolVersion = 1
+	}
+
+	if reg.ProtocolVersion < version.MinProtocolVersion {
+		return fmt.Errorf("Agent '%s' (Version %s) spricht Protokollversion %d, der Server benötigt mindestens Version %d; bitte den Agent aktualisieren",
+			reg.Name, reg.Version, reg.ProtocolVersion, version.MinProtocolVersion)
+	}
+	if reg.ProtocolVersion > version.ProtocolVersion {
+		return fmt.Errorf("Agent '%s' (Version %s) spricht Protokollversion %d, der Server (Version %s) unterstützt höchstens Version %d; bitte den Server aktualisieren",
+			reg.Name, reg.Version, reg.ProtocolVersion, version.Version, version.ProtocolVersion)
+	}
+	return nil
+}
+
+// updateAgentCompatibility markiert Agents, deren Protokoll oder Version älter als die des Servers ist.
+// Der Aufrufer muss remoteAgentsMutex halten bzw. der einzige Besitzer des Agents sein.
+func updateAgentCompatibility(agent *RemoteAgent) {
+	switch {
+	case agent.ProtocolVersion < version.ProtocolVersion:
+		agent.OutdatedReason = fmt.Sprintf("Protokollversion %d, Server spricht Version %d", agent.ProtocolVersion, version.ProtocolVersion)
+	case version.Compare(agent.Version, version.Version) < 0:
+		agent.OutdatedReason = fmt.Sprintf("Agent-Version %s ist älter als die Server-Version %s", agent.Version, version.Version)
+	default:
+		agent.OutdatedReason = ""
+	}
+	agent.Outdated = agent.OutdatedReason != ""
+}
+
+// agentSupports prüft, ob ein Agent eine Fähigkeit bei der Registrierung angekündigt hat.
+// Der Aufrufer muss remoteAgentsMutex halten.
+func agentSupports(agent *RemoteAgent, capability string) bool {
+	for _, c := range agent.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// respondCapabilityNotSupported meldet, dass ein Agent eine angefragte Funktion nicht unterstützt
+func respondCapabilityNotSupported(w http.ResponseWriter, name, agentVersion, capability string) {
+	respondWithError(w, http.StatusConflict, fmt.Sprintf(capabilityNotSupportedError, name, capability, agentVersion))
+}
@@ -0,0 +1,251 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// agentConfigStore enthält die vom Server verteilten Konfigurationsdokumente.
+// Ein Dokument für einen einzelnen Agent hat Vorrang vor dem Dokument seiner Gruppe.
+type agentConfigStore struct {
+	// Versionen werden über alle Dokumente hinweg fortlaufend vergeben, damit ein
+	// Wechsel zwischen Gruppen- und Agent-Dokument immer als Änderung erkannt wird
+	LastVersion int                              `json:"last_version"`
+	Agents      map[string]*config.AgentSettings `json:"agents"`
+	Groups      map[string]*config.AgentSettings `json:"groups"`
+}
+
+// EffectiveAgentConfig beschreibt die für einen Agent gültige Konfiguration
+type EffectiveAgentConfig struct {
+	Agent                string                `json:"agent"`
+	Group                string                `json:"group,omitempty"`
+	Source               string                `json:"source"` // "agent", "group", "none"
+	Config               *config.AgentSettings `json:"config,omitempty"`
+	AppliedConfigVersion int                   `json:"applied_config_version"`
+	ConfigError          string                `json:"config_error,omitempty"`
+}
+
+var (
+	agentConfigs = agentConfigStore{
+		Agents: make(map[string]*config.AgentSettings),
+		Groups: make(map[string]*config.AgentSettings),
+	}
+	agentConfigsMutex sync.RWMutex
+
+	// Pfad der Konfigurationsdatei (leer = keine Persistenz)
+	agentConfigPath string
+)
+
+// InitAgentConfigStore lädt die gespeicherten Agent-Konfigurationen
+func InitAgentConfigStore(path string) error {
+	agentConfigPath = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Fehler beim Lesen der Agent-Konfigurationen: %w", err)
+	}
+
+	var store agentConfigStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("Fehler beim Parsen der Agent-Konfigurationen: %w", err)
+	}
+	if store.Agents == nil {
+		store.Agents = make(map[string]*config.AgentSettings)
+	}
+	if store.Groups == nil {
+		store.Groups = make(map[string]*config.AgentSettings)
+	}
+
+	agentConfigsMutex.Lock()
+	agentConfigs = store
+	agentConfigsMutex.Unlock()
+
+	log.Printf("Agent-Konfigurationen geladen: %d Agents, %d Gruppen", len(store.Agents), len(store.Groups))
+	return nil
+}
+
+// persistAgentConfigs schreibt die Konfigurationsdokumente in die Datei.
+// Der Aufrufer muss agentConfigsMutex halten.
+func persistAgentConfigs() error {
+	if agentConfigPath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(agentConfigs, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Fehler beim Kodieren der Agent-Konfigurationen: %w", err)
+	}
+	return writeFileAtomic(agentConfigPath, data)
+}
+
+// settingsMap gibt die Dokumente der Gruppen oder der einzelnen Agents zurück.
+// Der Aufrufer muss agentConfigsMutex halten.
+func (s *agentConfigStore) settingsMap(group bool) map[string]*config.AgentSettings {
+	if group {
+		return s.Groups
+	}
+	return s.Agents
+}
+
+// desiredAgentConfig gibt das für einen Agent gültige Konfigurationsdokument und dessen Quelle zurück
+func desiredAgentConfig(name, group string) (*config.AgentSettings, string) {
+	agentConfigsMutex.RLock()
+	defer agentConfigsMutex.RUnlock()
+
+	if settings, ok := agentConfigs.Agents[name]; ok {
+		return settings, "agent"
+	}
+	if group != "" {
+		if settings, ok := agentConfigs.Groups[group]; ok {
+			return settings, "group"
+		}
+	}
+	return nil, "none"
+}
+
+// ListAgentConfigsHandler gibt alle gespeicherten Konfigurationsdokumente zurück
+func ListAgentConfigsHandler(w http.ResponseWriter, r *http.Request) {
+	agentConfigsMutex.RLock()
+	data, err := json.Marshal(agentConfigs)
+	agentConfigsMutex.RUnlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Kodieren der Agent-Konfigurationen")
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    json.RawMessage(data),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetAgentConfigHandler gibt die für einen Agent gültige Konfiguration und deren Anwendungsstatus zurück
+func GetAgentConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	result := EffectiveAgentConfig{Agent: name}
+
+	remoteAgentsMutex.RLock()
+	if agent, exists := remoteAgents[name]; exists {
+		result.Group = agent.Group
+		result.AppliedConfigVersion = agent.AppliedConfigVersion
+		result.ConfigError = agent.ConfigError
+	}
+	remoteAgentsMutex.RUnlock()
+
+	result.Config, result.Source = desiredAgentConfig(name, result.Group)
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// PutAgentConfigHandler speichert ein Konfigurationsdokument für einen einzelnen Agent
+func PutAgentConfigHandler(w http.ResponseWriter, r *http.Request) {
+	putAgentSettings(w, r, false, mux.Vars(r)["name"])
+}
+
+// PutGroupConfigHandler speichert ein Konfigurationsdokument für eine Agent-Gruppe
+func PutGroupConfigHandler(w http.ResponseWriter, r *http.Request) {
+	putAgentSettings(w, r, true, mux.Vars(r)["group"])
+}
+
+// DeleteAgentConfigHandler entfernt das Konfigurationsdokument eines Agents
+func DeleteAgentConfigHandler(w http.ResponseWriter, r *http.Request) {
+	deleteAgentSettings(w, false, mux.Vars(r)["name"])
+}
+
+// DeleteGroupConfigHandler entfernt das Konfigurationsdokument einer Gruppe
+func DeleteGroupConfigHandler(w http.ResponseWriter, r *http.Request) {
+	deleteAgentSettings(w, true, mux.Vars(r)["group"])
+}
+
+// putAgentSettings validiert ein Dokument, vergibt eine neue Version und speichert es
+func putAgentSettings(w http.ResponseWriter, r *http.Request, group bool, key string) {
+	var settings config.AgentSettings
+	if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	if err := settings.Validate(); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültige Konfiguration: %v", err))
+		return
+	}
+
+	agentConfigsMutex.Lock()
+	target := agentConfigs.settingsMap(group)
+	agentConfigs.LastVersion++
+	settings.Version = agentConfigs.LastVersion
+	settings.UpdatedAt = time.Now()
+	target[key] = &settings
+	err := persistAgentConfigs()
+	agentConfigsMutex.Unlock()
+
+	if err != nil {
+		log.Printf("Fehler beim Speichern der Agent-Konfigurationen: %v", err)
+	}
+
+	log.Printf("Konfiguration für '%s' auf Version %d aktualisiert", key, settings.Version)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Konfiguration Version %d gespeichert, sie wird mit dem nächsten Heartbeat verteilt", settings.Version),
+		Data:    settings,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// deleteAgentSettings entfernt ein Konfigurationsdokument
+func deleteAgentSettings(w http.ResponseWriter, group bool, key string) {
+	agentConfigsMutex.Lock()
+	target := agentConfigs.settingsMap(group)
+	_, exists := target[key]
+	var err error
+	if exists {
+		delete(target, key)
+		err = persistAgentConfigs()
+	}
+	agentConfigsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Konfiguration nicht gefunden")
+		return
+	}
+	if err != nil {
+		log.Printf("Fehler beim Speichern der Agent-Konfigurationen: %v", err)
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Konfiguration für '%s' entfernt", key),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
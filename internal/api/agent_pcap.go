@@ -0,0 +1,173 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket/pcapgo"
+	"github.com/gorilla/mux"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/timeparam"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+// Timeout für PCAP-Abrufe von Agents; große Zeiträume können entsprechend lange dauern
+const agentPcapTimeout = 10 * time.Minute
+
+// AgentPcapHandler ruft einen Zeitausschnitt aus dem Ringpuffer eines Agents ab.
+// Die Zeitangaben beziehen sich auf die Server-Uhr und werden mit dem geschätzten
+// Uhrenversatz in die Uhrzeit des Agents umgerechnet. Die Zeitstempel der gelieferten
+// Pakete werden um denselben Versatz in Server-Zeit verschoben, damit sie zu den korrigierten
+// Ereignissen passen; der angewandte Versatz steht im Header X-Clock-Offset-Ms.
+func AgentPcapHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	agentURL, ok := lookupAgentURL(w, name, version.CapabilityPcapRing)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := timeparam.Parse(query.Get("from"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültiger Parameter 'from': %v", err))
+		return
+	}
+	to, err := timeparam.Parse(query.Get("to"))
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültiger Parameter 'to': %v", err))
+		return
+	}
+
+	// Versatz einmal bestimmen, damit Zeitraum und Paketzeitstempel gleich umgerechnet werden
+	remoteAgentsMutex.RLock()
+	offset := agentClockOffset(remoteAgents[name])
+	remoteAgentsMutex.RUnlock()
+
+	// Anfrage an den Agent mit umgerechneten Zeitstempeln zusammensetzen
+	agentQuery := url.Values{}
+	if !from.IsZero() {
+		agentQuery.Set("from", from.Add(-offset).Format(time.RFC3339Nano))
+	}
+	if !to.IsZero() {
+		agentQuery.Set("to", to.Add(-offset).Format(time.RFC3339Nano))
+	}
+	if filter := query.Get("filter"); filter != "" {
+		agentQuery.Set("filter", filter)
+	}
+	if captureInterface := query.Get("interface"); captureInterface != "" {
+		agentQuery.Set("interface", captureInterface)
+	}
+
+	w.Header().Set("X-Clock-Offset-Ms", strconv.FormatFloat(durationToMs(offset), 'f', 3, 64))
+	var rewrite func(dst io.Writer, src io.Reader) error
+	if offset != 0 {
+		rewrite = func(dst io.Writer, src io.Reader) error {
+			return shiftPcapTimestamps(dst, src, offset)
+		}
+	}
+	forwardAgentResponse(w, r, name, agentURL+"/pcap?"+agentQuery.Encode(), rewrite)
+}
+
+// shiftPcapTimestamps kopiert eine PCAP-Datei und verschiebt dabei alle Paketzeitstempel um offset
+func shiftPcapTimestamps(dst io.Writer, src io.Reader, offset time.Duration) error {
+	reader, err := pcapgo.NewReader(src)
+	if err != nil {
+		return fmt.Errorf("Ungültige PCAP-Daten: %w", err)
+	}
+
+	writer := pcapgo.NewWriterNanos(dst)
+	if err := writer.WriteFileHeader(reader.Snaplen(), reader.LinkType()); err != nil {
+		return err
+	}
+
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ci.Timestamp = ci.Timestamp.Add(offset)
+		if err := writer.WritePacket(ci, data); err != nil {
+			return err
+		}
+	}
+}
+
+// lookupAgentURL gibt die URL eines registrierten Agents zurück, der die angegebene Fähigkeit
+// unterstützt. Andernfalls wird eine Fehlerantwort gesendet und false zurückgegeben.
+func lookupAgentURL(w http.ResponseWriter, name, capability string) (string, bool) {
+	remoteAgentsMutex.RLock()
+	agent, exists := remoteAgents[name]
+	var agentURL, agentVersion string
+	var supported bool
+	if exists {
+		agentURL = agent.URL
+		agentVersion = agent.Version
+		supported = agentSupports(agent, capability)
+	}
+	remoteAgentsMutex.RUnlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Agent nicht gefunden")
+		return "", false
+	}
+	if !supported {
+		respondCapabilityNotSupported(w, name, agentVersion, capability)
+		return "", false
+	}
+	return agentURL, true
+}
+
+// forwardAgentGet ruft eine URL des Agents ab und reicht Status, Fehlerantworten (JSON)
+// und Dateiinhalte unverändert an den Client weiter
+func forwardAgentGet(w http.ResponseWriter, r *http.Request, name, target string) {
+	forwardAgentResponse(w, r, name, target, nil)
+}
+
+// forwardAgentResponse arbeitet wie forwardAgentGet, leitet erfolgreiche Antworten aber durch
+// rewrite, sofern angegeben. Die Länge der Antwort wird dann nicht übernommen.
+func forwardAgentResponse(w http.ResponseWriter, r *http.Request, name, target string, rewrite func(dst io.Writer, src io.Reader) error) {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, target, nil)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Erstellen der Anfrage: %v", err))
+		return
+	}
+
+	httpClient := &http.Client{Timeout: agentPcapTimeout}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		respondWithError(w, http.StatusBadGateway, fmt.Sprintf("Fehler bei der Kommunikation mit dem Agent: %v", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		rewrite = nil
+	}
+
+	headers := []string{"Content-Type", "Content-Disposition", "Content-Length"}
+	if rewrite != nil {
+		headers = headers[:2]
+	}
+	for _, header := range headers {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if rewrite != nil {
+		err = rewrite(w, resp.Body)
+	} else {
+		_, err = io.Copy(w, resp.Body)
+	}
+	if err != nil {
+		log.Printf("Fehler beim Weiterleiten der Daten von Agent '%s': %v", name, err)
+	}
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Maximale Anzahl gespeicherter Statuswechsel pro Agent
+const maxStatusHistory = 50
+
+// Formatversion der Registry-Datei
+const agentRegistryFormatVersion = 1
+
+// AgentStatusChange beschreibt einen Statuswechsel eines Remote-Agents
+type AgentStatusChange struct {
+	Timestamp time.Time `json:"timestamp"`
+	Status    string    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// agentRegistryFile ist das Format der persistierten Agent-Registry
+type agentRegistryFile struct {
+	FormatVersion int            `json:"format_version"`
+	SavedAt       time.Time      `json:"saved_at"`
+	Agents        []*RemoteAgent `json:"agents"`
+}
+
+var (
+	// Pfad der Registry-Datei (leer = keine Persistenz)
+	agentRegistryPath string
+
+	// Serialisiert Snapshot und Schreibzugriff auf die Registry-Datei; wird vor
+	// remoteAgentsMutex gesperrt
+	agentRegistryFileMutex sync.Mutex
+)
+
+// InitAgentRegistry lädt die persistierte Agent-Registry und aktiviert die Speicherung.
+// Geladene Agents gelten als offline, bis sie sich per Heartbeat zurückmelden.
+func InitAgentRegistry(path string) error {
+	agentRegistryPath = path
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Printf("Keine Agent-Registry unter %s gefunden, starte mit leerer Registry", path)
+			return nil
+		}
+		return fmt.Errorf("Fehler beim Lesen der Agent-Registry: %w", err)
+	}
+
+	var registry agentRegistryFile
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return fmt.Errorf("Fehler beim Parsen der Agent-Registry: %w", err)
+	}
+
+	remoteAgentsMutex.Lock()
+	for _, agent := range registry.Agents {
+		if agent == nil || agent.Name == "" {
+			continue
+		}
+		if agent.ProtocolVersion == 0 {
+			agent.ProtocolVersion = 1
+		}
+		updateAgentCompatibility(agent)
+		setAgentStatus(agent, "offline", "Server-Neustart")
+		remoteAgents[agent.Name] = agent
+	}
+	count := len(remoteAgents)
+	remoteAgentsMutex.Unlock()
+
+	log.Printf("Agent-Registry geladen: %d Agents aus %s", count, path)
+	return nil
+}
+
+// setAgentStatus setzt den Status eines Agents und protokolliert Statuswechsel.
+// Der Aufrufer muss remoteAgentsMutex halten. Gibt true zurück, wenn sich der Status geändert hat.
+func setAgentStatus(agent *RemoteAgent, status, reason string) bool {
+	if agent.Status == status {
+		return false
+	}
+
+	agent.Status = status
+	agent.StatusHistory = append(agent.StatusHistory, AgentStatusChange{
+		Timestamp: time.Now(),
+		Status:    status,
+		Reason:    reason,
+	})
+
+	// Nur die neuesten Einträge behalten
+	if len(agent.StatusHistory) > maxStatusHistory {
+		agent.StatusHistory = agent.StatusHistory[len(agent.StatusHistory)-maxStatusHistory:]
+	}
+
+	return true
+}
+
+// persistAgentRegistry schreibt die aktuelle Registry atomar in die Registry-Datei
+func persistAgentRegistry() {
+	if agentRegistryPath == "" {
+		return
+	}
+
+	// Snapshot und Schreiben gemeinsam serialisieren, sonst kann ein älterer Snapshot
+	// einen neueren überschreiben
+	agentRegistryFileMutex.Lock()
+	defer agentRegistryFileMutex.Unlock()
+
+	// Snapshot unter Lesesperre erstellen, damit die Datei konsistent bleibt
+	remoteAgentsMutex.RLock()
+	registry := agentRegistryFile{
+		FormatVersion: agentRegistryFormatVersion,
+		SavedAt:       time.Now(),
+		Agents:        make([]*RemoteAgent, 0, len(remoteAgents)),
+	}
+	for _, agent := range remoteAgents {
+		registry.Agents = append(registry.Agents, agent)
+	}
+	data, err := json.MarshalIndent(registry, "", "  ")
+	remoteAgentsMutex.RUnlock()
+
+	if err != nil {
+		log.Printf("Fehler beim Kodieren der Agent-Registry: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(agentRegistryPath, data); err != nil {
+		log.Printf("Fehler beim Speichern der Agent-Registry: %v", err)
+	}
+}
+
+// writeFileAtomic schreibt erst in eine temporäre Datei und benennt sie dann um,
+// damit ein Absturz keine halb geschriebene Datei hinterlässt
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("Fehler beim Erstellen des Verzeichnisses: %w", err)
+	}
+
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
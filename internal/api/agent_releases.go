@@ -0,0 +1,384 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// Maximale Größe einer hochgeladenen Agent-Binärdatei
+const maxReleaseSize = 256 << 20
+
+// Erlaubte Zeichen in Version, Betriebssystem und Architektur (werden Teil des Dateipfads)
+var releaseNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// validReleaseName prüft Version, Betriebssystem oder Architektur; "." und ".." würden als
+// Pfadbestandteil das Release-Verzeichnis verlassen
+func validReleaseName(name string) bool {
+	return releaseNamePattern.MatchString(name) && name != "." && name != ".."
+}
+
+// AgentRelease beschreibt eine hochgeladene Agent-Binärdatei für eine Plattform
+type AgentRelease struct {
+	Version    string    `json:"version"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	SHA256     string    `json:"sha256"`
+	Signature  string    `json:"signature"` // Ed25519-Signatur über version.ReleaseSignatureMessage, Base64
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// agentReleaseStore enthält die bekannten Releases und die Zielversion der Agents
+type agentReleaseStore struct {
+	Target   string          `json:"target,omitempty"` // leer = keine Aktualisierung
+	Releases []*AgentRelease `json:"releases"`
+}
+
+var (
+	agentReleases      agentReleaseStore
+	agentReleasesMutex sync.RWMutex
+
+	// Verzeichnis der Releases (leer = Releases deaktiviert)
+	agentReleaseDir string
+)
+
+// InitAgentReleaseStore lädt die Übersicht der gespeicherten Agent-Releases
+func InitAgentReleaseStore(dir string) error {
+	agentReleaseDir = dir
+	if dir == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(releaseManifestPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Fehler beim Lesen der Agent-Releases: %w", err)
+	}
+
+	var store agentReleaseStore
+	if err := json.Unmarshal(data, &store); err != nil {
+		return fmt.Errorf("Fehler beim Parsen der Agent-Releases: %w", err)
+	}
+
+	agentReleasesMutex.Lock()
+	agentReleases = store
+	agentReleasesMutex.Unlock()
+
+	log.Printf("Agent-Releases geladen: %d Dateien, Zielversion '%s'", len(store.Releases), store.Target)
+	return nil
+}
+
+func releaseManifestPath() string {
+	return filepath.Join(agentReleaseDir, "releases.json")
+}
+
+// releaseBinaryPath gibt den Speicherort der Binärdatei eines Releases zurück. Pfade, die nicht
+// genau zwei Ebenen unterhalb von agentReleaseDir liegen, werden abgelehnt; das schützt auch
+// vor manipulierten Einträgen in releases.json.
+func releaseBinaryPath(version, goos, arch string) (string, error) {
+	path := filepath.Join(agentReleaseDir, version, goos+"-"+arch, "agent")
+	rel, err := filepath.Rel(agentReleaseDir, path)
+	if err != nil || !validReleaseName(version) || !validReleaseName(goos+"-"+arch) ||
+		len(strings.Split(rel, string(filepath.Separator))) != 3 {
+		return "", fmt.Errorf("ungültiger Release-Pfad für %s %s/%s", version, goos, arch)
+	}
+	return path, nil
+}
+
+// persistAgentReleases schreibt die Release-Übersicht.
+// Der Aufrufer muss agentReleasesMutex halten.
+func persistAgentReleases() error {
+	data, err := json.MarshalIndent(agentReleases, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Fehler beim Kodieren der Agent-Releases: %w", err)
+	}
+	return writeFileAtomic(releaseManifestPath(), data)
+}
+
+// findRelease sucht ein Release für eine Plattform.
+// Der Aufrufer muss agentReleasesMutex halten.
+func findRelease(version, goos, arch string) (int, *AgentRelease) {
+	for i, release := range agentReleases.Releases {
+		if release.Version == version && release.OS == goos && release.Arch == arch {
+			return i, release
+		}
+	}
+	return -1, nil
+}
+
+// desiredAgentUpdate gibt die Aktualisierung für einen Agent zurück oder nil,
+// wenn er bereits die Zielversion hat oder für seine Plattform kein Release existiert
+func desiredAgentUpdate(version, goos, arch string) *config.AgentUpdate {
+	agentReleasesMutex.RLock()
+	defer agentReleasesMutex.RUnlock()
+
+	target := agentReleases.Target
+	if target == "" || target == version || goos == "" || arch == "" {
+		return nil
+	}
+
+	_, release := findRelease(target, goos, arch)
+	if release == nil {
+		return nil
+	}
+
+	return &config.AgentUpdate{
+		Version:   release.Version,
+		URL:       fmt.Sprintf("/api/agents/releases/%s/%s/%s", release.Version, release.OS, release.Arch),
+		SHA256:    release.SHA256,
+		Signature: release.Signature,
+		Size:      release.Size,
+	}
+}
+
+// ListAgentReleasesHandler gibt alle Releases und die Zielversion zurück
+func ListAgentReleasesHandler(w http.ResponseWriter, r *http.Request) {
+	agentReleasesMutex.RLock()
+	data, err := json.Marshal(agentReleases)
+	agentReleasesMutex.RUnlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Kodieren der Agent-Releases")
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    json.RawMessage(data),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UploadAgentReleaseHandler nimmt eine signierte Agent-Binärdatei entgegen.
+// Multipart-Felder: version, os, arch, signature und die Datei "binary".
+func UploadAgentReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	if agentReleaseDir == "" {
+		respondWithError(w, http.StatusServiceUnavailable, "Agent-Releases sind nicht konfiguriert")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxReleaseSize+1<<20)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültige Anfrage: %v", err))
+		return
+	}
+
+	release := &AgentRelease{
+		Version:   r.FormValue("version"),
+		OS:        r.FormValue("os"),
+		Arch:      r.FormValue("arch"),
+		Signature: r.FormValue("signature"),
+	}
+	for field, value := range map[string]string{"version": release.Version, "os": release.OS, "arch": release.Arch} {
+		if !validReleaseName(value) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültiger Wert für '%s'", field))
+			return
+		}
+	}
+	if signature, err := base64.StdEncoding.DecodeString(release.Signature); err != nil || len(signature) != ed25519.SignatureSize {
+		respondWithError(w, http.StatusBadRequest, "Signatur muss eine Base64-kodierte Ed25519-Signatur sein")
+		return
+	}
+
+	file, _, err := r.FormFile("binary")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Keine Binärdatei in der Anfrage gefunden")
+		return
+	}
+	defer file.Close()
+
+	// Datei schreiben und dabei die Prüfsumme berechnen
+	path, err := releaseBinaryPath(release.Version, release.OS, release.Arch)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Erstellen des Verzeichnisses: %v", err))
+		return
+	}
+	tempPath := path + ".tmp"
+	out, err := os.Create(tempPath)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Speichern der Binärdatei: %v", err))
+		return
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, hash), io.LimitReader(file, maxReleaseSize+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size > maxReleaseSize {
+		err = fmt.Errorf("Binärdatei größer als %d Bytes", maxReleaseSize)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Fehler beim Speichern der Binärdatei: %v", err))
+		return
+	}
+	release.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	release.Size = size
+	release.UploadedAt = time.Now()
+
+	agentReleasesMutex.Lock()
+	defer agentReleasesMutex.Unlock()
+
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Speichern der Binärdatei: %v", err))
+		return
+	}
+
+	// Ein erneuter Upload ersetzt das Release derselben Plattform
+	if i, _ := findRelease(release.Version, release.OS, release.Arch); i >= 0 {
+		agentReleases.Releases[i] = release
+	} else {
+		agentReleases.Releases = append(agentReleases.Releases, release)
+	}
+	if err := persistAgentReleases(); err != nil {
+		log.Printf("Fehler beim Speichern der Agent-Releases: %v", err)
+	}
+
+	log.Printf("Agent-Release %s für %s/%s hochgeladen (%d Bytes)", release.Version, release.OS, release.Arch, release.Size)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Release %s für %s/%s gespeichert", release.Version, release.OS, release.Arch),
+		Data:    release,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DownloadAgentReleaseHandler liefert die Binärdatei eines Releases aus
+func DownloadAgentReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	agentReleasesMutex.RLock()
+	_, release := findRelease(vars["version"], vars["os"], vars["arch"])
+	agentReleasesMutex.RUnlock()
+
+	if release == nil {
+		respondWithError(w, http.StatusNotFound, "Release nicht gefunden")
+		return
+	}
+
+	path, err := releaseBinaryPath(release.Version, release.OS, release.Arch)
+	if err != nil {
+		respondWithError(w, http.StatusNotFound, "Release nicht gefunden")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Checksum-SHA256", release.SHA256)
+	http.ServeFile(w, r, path)
+}
+
+// DeleteAgentReleaseHandler entfernt ein Release; die Zielversion kann nicht gelöscht werden
+func DeleteAgentReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	agentReleasesMutex.Lock()
+	defer agentReleasesMutex.Unlock()
+
+	i, release := findRelease(vars["version"], vars["os"], vars["arch"])
+	if release == nil {
+		respondWithError(w, http.StatusNotFound, "Release nicht gefunden")
+		return
+	}
+	if release.Version == agentReleases.Target {
+		respondWithError(w, http.StatusConflict, "Release der Zielversion kann nicht gelöscht werden")
+		return
+	}
+
+	agentReleases.Releases = append(agentReleases.Releases[:i], agentReleases.Releases[i+1:]...)
+	if err := persistAgentReleases(); err != nil {
+		log.Printf("Fehler beim Speichern der Agent-Releases: %v", err)
+	}
+	if path, err := releaseBinaryPath(release.Version, release.OS, release.Arch); err == nil {
+		os.RemoveAll(filepath.Dir(path))
+	} else {
+		log.Printf("Release-Dateien nicht gelöscht: %v", err)
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Release %s für %s/%s gelöscht", release.Version, release.OS, release.Arch),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// SetAgentReleaseTargetHandler setzt die Version, auf die alle Agents aktualisiert werden.
+// Eine leere Version beendet die Verteilung.
+func SetAgentReleaseTargetHandler(w http.ResponseWriter, r *http.Request) {
+	if agentReleaseDir == "" {
+		respondWithError(w, http.StatusServiceUnavailable, "Agent-Releases sind nicht konfiguriert")
+		return
+	}
+
+	var req struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	agentReleasesMutex.Lock()
+	defer agentReleasesMutex.Unlock()
+
+	if req.Version != "" {
+		found := false
+		for _, release := range agentReleases.Releases {
+			if release.Version == req.Version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Kein Release für Version '%s' vorhanden", req.Version))
+			return
+		}
+	}
+
+	agentReleases.Target = req.Version
+	if err := persistAgentReleases(); err != nil {
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Speichern: %v", err))
+		return
+	}
+
+	log.Printf("Zielversion der Agents auf '%s' gesetzt", req.Version)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Zielversion auf '%s' gesetzt", req.Version),
+		Data:    map[string]string{"target": req.Version},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,772 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+const (
+	// Anzahl gleichzeitig laufender Dateianalysen; weitere Jobs warten in der Reihenfolge
+	// ihres Uploads
+	maxConcurrentAnalyses = 2
+
+	// Anzahl beendeter Jobs, die samt Ergebnis aufbewahrt werden
+	maxFinishedAnalysisJobs = 100
+
+	// Index aller Jobs im Job-Verzeichnis
+	analysisJobsIndexFile = "jobs.json"
+
+	// Puffergröße beim Schreiben von Uploads
+	analysisUploadBufferSize = 1 << 20
+)
+
+// AnalysisJob ist die Analyse einer hochgeladenen PCAP- oder PCAPNG-Datei im Hintergrund
+type AnalysisJob struct {
+	ID         string    `json:"id"`
+	FileName   string    `json:"file_name"`
+	Size       int64     `json:"size,omitempty"` // angekündigte Dateigröße, 0 = unbekannt
+	Uploaded   int64     `json:"uploaded"`       // bestätigter Upload-Offset
+	Status     string    `json:"status"`         // "uploading", "queued", "running", "completed", "failed", "cancelled"
+	Progress   float64   `json:"progress"`       // Analysefortschritt in Prozent
+	ETASeconds float64   `json:"eta_seconds,omitempty"`
+	Packets    uint64    `json:"packets"`
+	CreatedAt  time.Time `json:"created_at"`
+	StartedAt  time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time `json:"finished_at,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	cancel    context.CancelFunc     // bricht die wartende oder laufende Analyse ab
+	session   *packet.CaptureSession // Session der laufenden Analyse
+	uploading bool                   // ein Block wird gerade geschrieben
+}
+
+// AnalysisJobRequest kündigt einen Upload in Blöcken an
+type AnalysisJobRequest struct {
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+}
+
+// analysisJobDetail ist ein Job mit seinem vollständigen Ergebnis
+type analysisJobDetail struct {
+	*AnalysisJob
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+var (
+	// Verwaltung der Analyse-Jobs
+	analysisJobs      = make(map[string]*AnalysisJob)
+	analysisJobsMutex sync.Mutex
+
+	// Verzeichnis für Uploads, Ergebnisse und Index (leer = Analyse-Jobs deaktiviert)
+	analysisJobsDir string
+
+	// Capturer, mit dem die Dateien analysiert werden
+	analysisJobsCapturer *packet.PcapCapturer
+
+	// Begrenzt die Anzahl gleichzeitig laufender Analysen
+	analysisJobSlots = make(chan struct{}, maxConcurrentAnalyses)
+
+	// Ein Upload wurde abgebrochen, während er geschrieben wurde
+	errAnalysisUploadCancelled = errors.New("Analyse-Job wurde während des Uploads abgebrochen")
+)
+
+// InitAnalysisJobs legt das Job-Verzeichnis fest und lädt gespeicherte Jobs. Uploads in Blöcken
+// können nach einem Neustart ab der gespeicherten Dateigröße fortgesetzt werden; wartende und
+// unterbrochene Analysen werden erneut eingereiht.
+func InitAnalysisJobs(dir string, capturer *packet.PcapCapturer) error {
+	analysisJobsDir = dir
+	analysisJobsCapturer = capturer
+	if dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("Fehler beim Erstellen des Job-Verzeichnisses: %w", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, analysisJobsIndexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Fehler beim Lesen der Analyse-Jobs: %w", err)
+	}
+
+	var jobs []*AnalysisJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("Fehler beim Parsen der Analyse-Jobs: %w", err)
+	}
+
+	now := time.Now()
+	analysisJobsMutex.Lock()
+	defer analysisJobsMutex.Unlock()
+
+	// Der Index ist nach Erstellungszeit sortiert, damit bleibt die Reihenfolge der Warteschlange
+	for _, job := range jobs {
+		if job == nil || job.ID == "" {
+			continue
+		}
+		analysisJobs[job.ID] = job
+
+		switch job.Status {
+		case "uploading":
+			job.Uploaded = 0
+			if info, err := os.Stat(analysisUploadPath(job.ID)); err == nil {
+				job.Uploaded = info.Size()
+			}
+			// Direkte Uploads ohne angekündigte Größe lassen sich nicht fortsetzen
+			if job.Size == 0 {
+				failAnalysisJobLocked(job, "Server-Neustart während des Uploads", now)
+			}
+		case "queued", "running":
+			job.Progress = 0
+			job.ETASeconds = 0
+			job.Packets = 0
+			job.StartedAt = time.Time{}
+			queueAnalysisJobLocked(job)
+		}
+	}
+	persistAnalysisJobs()
+
+	log.Printf("Analyse-Jobs geladen: %d aus %s", len(analysisJobs), dir)
+	return nil
+}
+
+// analysisUploadPath gibt den Pfad der hochgeladenen Datei eines Jobs zurück
+func analysisUploadPath(id string) string {
+	return filepath.Join(analysisJobsDir, id+".upload")
+}
+
+// analysisResultPath gibt den Pfad des gespeicherten Ergebnisses eines Jobs zurück
+func analysisResultPath(id string) string {
+	return filepath.Join(analysisJobsDir, id+".result.json")
+}
+
+// persistAnalysisJobs schreibt den Index aller Jobs ohne Ergebnisse.
+// Der Aufrufer muss analysisJobsMutex halten.
+func persistAnalysisJobs() {
+	if analysisJobsDir == "" {
+		return
+	}
+
+	data, err := json.MarshalIndent(sortedAnalysisJobs(), "", "  ")
+	if err != nil {
+		log.Printf("Fehler beim Kodieren der Analyse-Jobs: %v", err)
+		return
+	}
+	if err := writeFileAtomic(filepath.Join(analysisJobsDir, analysisJobsIndexFile), data); err != nil {
+		log.Printf("Fehler beim Speichern der Analyse-Jobs: %v", err)
+	}
+}
+
+// sortedAnalysisJobs gibt alle Jobs nach Erstellungszeit sortiert zurück.
+// Der Aufrufer muss analysisJobsMutex halten.
+func sortedAnalysisJobs() []*AnalysisJob {
+	jobs := make([]*AnalysisJob, 0, len(analysisJobs))
+	for _, job := range analysisJobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// newAnalysisJob erstellt einen Job im Zustand "uploading"
+func newAnalysisJob(fileName string, size int64) *AnalysisJob {
+	fileName = filepath.Base(fileName)
+	if fileName == "." || fileName == string(filepath.Separator) {
+		fileName = "upload.pcap"
+	}
+	return &AnalysisJob{
+		ID:        newID("analysis"),
+		FileName:  fileName,
+		Size:      size,
+		Status:    "uploading",
+		CreatedAt: time.Now(),
+	}
+}
+
+// finishedAnalysisJob meldet, ob ein Job beendet ist und sich nicht mehr ändert
+func finishedAnalysisJob(job *AnalysisJob) bool {
+	switch job.Status {
+	case "completed", "failed", "cancelled":
+		return true
+	}
+	return false
+}
+
+// failAnalysisJobLocked beendet einen Job mit einem Fehler und entfernt seinen Upload.
+// Der Aufrufer muss analysisJobsMutex halten.
+func failAnalysisJobLocked(job *AnalysisJob, message string, now time.Time) {
+	job.Status = "failed"
+	job.Error = message
+	job.FinishedAt = now
+	os.Remove(analysisUploadPath(job.ID))
+}
+
+// queueAnalysisJobLocked reiht einen vollständig hochgeladenen Job zur Analyse ein.
+// Der Aufrufer muss analysisJobsMutex halten.
+func queueAnalysisJobLocked(job *AnalysisJob) {
+	ctx, cancel := context.WithCancel(context.Background())
+	job.Status = "queued"
+	job.cancel = cancel
+	go runAnalysisJob(ctx, job)
+}
+
+// runAnalysisJob wartet auf einen freien Platz und analysiert die Datei eines Jobs vollständig
+func runAnalysisJob(ctx context.Context, job *AnalysisJob) {
+	select {
+	case analysisJobSlots <- struct{}{}:
+		defer func() { <-analysisJobSlots }()
+	case <-ctx.Done():
+		finishAnalysisJob(ctx, job, nil, nil, nil)
+		return
+	}
+	if ctx.Err() != nil {
+		finishAnalysisJob(ctx, job, nil, nil, nil)
+		return
+	}
+
+	// Eigene Session je Job, unabhängig von einer laufenden Live-Capture
+	session, err := analysisJobsCapturer.OpenPcapFile(analysisUploadPath(job.ID))
+	if err != nil {
+		finishAnalysisJob(ctx, job, nil, nil, fmt.Errorf("Fehler beim Öffnen der Datei: %w", err))
+		return
+	}
+	defer session.Stop()
+
+	analysisJobsMutex.Lock()
+	job.Status = "running"
+	job.StartedAt = time.Now()
+	job.session = session
+	persistAnalysisJobs()
+	analysisJobsMutex.Unlock()
+	log.Printf("Analyse-Job %s gestartet: %s", job.ID, job.FileName)
+
+	// Offline-Erfassungen blockieren bei voller Warteschlange, daher wird jedes Paket der
+	// Datei ausgewertet. Der erste Lesefehler beendet die Analyse mit einem Teilergebnis.
+	collector := packet.NewAnalysisCollector()
+	packetChan, errChan := session.Start(ctx)
+	var readErr error
+	for packetChan != nil || errChan != nil {
+		select {
+		case p, ok := <-packetChan:
+			if !ok {
+				packetChan = nil
+				continue
+			}
+			collector.Add(p)
+			packet.ReleasePacketInfo(p)
+		case err, ok := <-errChan:
+			if !ok {
+				errChan = nil
+				continue
+			}
+			if readErr == nil {
+				readErr = err
+			}
+		}
+	}
+	session.Stop()
+
+	var result *packet.AnalysisResult
+	if ctx.Err() == nil {
+		result = collector.Result(session.Stats())
+	}
+	finishAnalysisJob(ctx, job, session, result, readErr)
+}
+
+// finishAnalysisJob speichert das Ergebnis und setzt den abschließenden Status eines Jobs.
+// Ein Job gilt als abgebrochen, wenn sein Kontext beendet wurde.
+func finishAnalysisJob(ctx context.Context, job *AnalysisJob, session *packet.CaptureSession,
+	result *packet.AnalysisResult, analysisErr error) {
+	var resultErr error
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err == nil {
+			err = writeFileAtomic(analysisResultPath(job.ID), data)
+		}
+		if err != nil {
+			resultErr = fmt.Errorf("Fehler beim Speichern des Ergebnisses: %w", err)
+		}
+	}
+
+	analysisJobsMutex.Lock()
+	defer analysisJobsMutex.Unlock()
+
+	switch {
+	case ctx.Err() != nil:
+		job.Status = "cancelled"
+	case analysisErr != nil:
+		job.Status = "failed"
+		job.Error = analysisErr.Error()
+	case resultErr != nil:
+		job.Status = "failed"
+		job.Error = resultErr.Error()
+	default:
+		job.Status = "completed"
+		job.Progress = 100
+	}
+
+	job.FinishedAt = time.Now()
+	job.ETASeconds = 0
+	job.session = nil
+	if job.cancel != nil {
+		job.cancel()
+		job.cancel = nil
+	}
+	if session != nil {
+		job.Packets = session.Stats().PacketsReceived
+	}
+	os.Remove(analysisUploadPath(job.ID))
+
+	// Während der Analyse gelöscht: das gerade geschriebene Ergebnis verwerfen
+	if analysisJobs[job.ID] != job {
+		os.Remove(analysisResultPath(job.ID))
+		return
+	}
+
+	pruneAnalysisJobsLocked()
+	persistAnalysisJobs()
+	log.Printf("Analyse-Job %s beendet: %s (%d Pakete)", job.ID, job.Status, job.Packets)
+}
+
+// pruneAnalysisJobsLocked entfernt die ältesten beendeten Jobs über maxFinishedAnalysisJobs.
+// Der Aufrufer muss analysisJobsMutex halten.
+func pruneAnalysisJobsLocked() {
+	var finished []*AnalysisJob
+	for _, job := range analysisJobs {
+		if finishedAnalysisJob(job) {
+			finished = append(finished, job)
+		}
+	}
+	if len(finished) <= maxFinishedAnalysisJobs {
+		return
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(finished[j].FinishedAt)
+	})
+	for _, job := range finished[:len(finished)-maxFinishedAnalysisJobs] {
+		removeAnalysisJobLocked(job)
+	}
+}
+
+// removeAnalysisJobLocked bricht einen Job ab und entfernt ihn samt seiner Dateien.
+// Der Aufrufer muss analysisJobsMutex halten.
+func removeAnalysisJobLocked(job *AnalysisJob) {
+	if job.cancel != nil {
+		job.cancel()
+	}
+	if job.Status == "uploading" {
+		// Beendet einen gerade laufenden Block-Upload
+		job.Status = "cancelled"
+	}
+	delete(analysisJobs, job.ID)
+	os.Remove(analysisUploadPath(job.ID))
+	os.Remove(analysisResultPath(job.ID))
+}
+
+// refreshAnalysisJobLocked übernimmt Fortschritt und Restdauer einer laufenden Analyse.
+// Der Aufrufer muss analysisJobsMutex halten.
+func refreshAnalysisJobLocked(job *AnalysisJob) {
+	if job.session == nil {
+		return
+	}
+
+	progress := job.session.Progress()
+	job.Progress = math.Round(progress*1000) / 10
+	job.Packets = job.session.Stats().PacketsReceived
+	job.ETASeconds = 0
+	if progress > 0 && progress < 1 {
+		elapsed := time.Since(job.StartedAt).Seconds()
+		job.ETASeconds = math.Round(elapsed * (1 - progress) / progress)
+	}
+}
+
+// writeAnalysisUpload schreibt Daten in den Upload eines Jobs und zählt den bestätigten
+// Offset nach jedem geschriebenen Block weiter
+func writeAnalysisUpload(job *AnalysisJob, dst io.Writer, src io.Reader) error {
+	buf := make([]byte, analysisUploadBufferSize)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return err
+			}
+
+			analysisJobsMutex.Lock()
+			job.Uploaded += int64(n)
+			cancelled := job.Status != "uploading"
+			analysisJobsMutex.Unlock()
+
+			if cancelled {
+				return errAnalysisUploadCancelled
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+// AnalyzePcapHandler nimmt eine PCAP- oder PCAPNG-Datei als multipart/form-data (Feld "pcap")
+// ohne Größenbegrenzung entgegen und reiht ihre Analyse als Job ein. Die Antwort enthält den
+// Job; Fortschritt und Ergebnis liefert /api/jobs/{id}.
+func AnalyzePcapHandler(w http.ResponseWriter, r *http.Request) {
+	if analysisJobsDir == "" {
+		respondWithError(w, http.StatusServiceUnavailable, "Analyse-Jobs sind nicht konfiguriert")
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Format, multipart/form-data erwartet")
+		return
+	}
+
+	// Die Datei wird direkt aus dem Anfragekörper gestreamt, ohne sie zwischenzuspeichern
+	var part *multipart.Part
+	for {
+		part, err = reader.NextPart()
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Keine PCAP-Datei in der Anfrage gefunden")
+			return
+		}
+		if part.FormName() == "pcap" {
+			break
+		}
+		part.Close()
+	}
+	defer part.Close()
+
+	job := newAnalysisJob(part.FileName(), 0)
+	file, err := os.Create(analysisUploadPath(job.ID))
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Erstellen der Upload-Datei")
+		return
+	}
+
+	analysisJobsMutex.Lock()
+	analysisJobs[job.ID] = job
+	persistAnalysisJobs()
+	analysisJobsMutex.Unlock()
+
+	err = writeAnalysisUpload(job, file, part)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	analysisJobsMutex.Lock()
+	switch {
+	case job.Status != "uploading":
+		err = errAnalysisUploadCancelled
+	case err != nil:
+		failAnalysisJobLocked(job, fmt.Sprintf("Upload abgebrochen: %v", err), time.Now())
+	default:
+		job.Size = job.Uploaded
+		queueAnalysisJobLocked(job)
+	}
+	persistAnalysisJobs()
+	analysisJobsMutex.Unlock()
+
+	if err == errAnalysisUploadCancelled {
+		respondWithError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Fehler beim Speichern der Datei: %v", err))
+		return
+	}
+
+	log.Printf("Analyse-Job %s angelegt: %s (%d Bytes)", job.ID, job.FileName, job.Size)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	respondWithAnalysisJob(w, http.StatusAccepted, job,
+		fmt.Sprintf("PCAP-Datei '%s' hochgeladen, Analyse eingereiht", job.FileName))
+}
+
+// CreateAnalysisJobHandler legt einen Analyse-Job an. Mit multipart/form-data wird die Datei
+// wie bei /api/analyze in einer Anfrage hochgeladen. Ein JSON-Körper mit Dateiname und Größe
+// kündigt dagegen einen fortsetzbaren Upload an, dessen Blöcke per PATCH folgen.
+func CreateAnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") {
+		AnalyzePcapHandler(w, r)
+		return
+	}
+	if analysisJobsDir == "" {
+		respondWithError(w, http.StatusServiceUnavailable, "Analyse-Jobs sind nicht konfiguriert")
+		return
+	}
+
+	var req AnalysisJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+	if req.Size <= 0 {
+		respondWithError(w, http.StatusBadRequest, "Die Dateigröße muss angegeben werden")
+		return
+	}
+
+	job := newAnalysisJob(req.FileName, req.Size)
+	if err := os.WriteFile(analysisUploadPath(job.ID), nil, 0644); err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Erstellen der Upload-Datei")
+		return
+	}
+
+	analysisJobsMutex.Lock()
+	analysisJobs[job.ID] = job
+	persistAnalysisJobs()
+	analysisJobsMutex.Unlock()
+
+	log.Printf("Analyse-Job %s angelegt: %s (%d Bytes erwartet)", job.ID, job.FileName, job.Size)
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	respondWithAnalysisJob(w, http.StatusCreated, job, fmt.Sprintf("Analyse-Job %s angelegt", job.ID))
+}
+
+// UploadAnalysisJobHandler schreibt den Anfragekörper ab dem Offset im Header Upload-Offset in
+// die Datei eines Jobs. Der Offset muss dem bestätigten Stand entsprechen, sonst antwortet der
+// Server mit 409 und dem erwarteten Offset. Nach dem letzten Block startet die Analyse.
+func UploadAnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		respondWithError(w, http.StatusBadRequest, "Header Upload-Offset fehlt oder ist ungültig")
+		return
+	}
+
+	analysisJobsMutex.Lock()
+	job, exists := analysisJobs[id]
+	var status int
+	var message string
+	switch {
+	case !exists:
+		status, message = http.StatusNotFound, "Analyse-Job nicht gefunden"
+	case job.Status != "uploading":
+		status, message = http.StatusConflict, "Analyse-Job erwartet keine weiteren Daten"
+	case job.uploading:
+		status, message = http.StatusConflict, "Für diesen Analyse-Job wird bereits ein Block hochgeladen"
+	case offset != job.Uploaded:
+		status, message = http.StatusConflict, fmt.Sprintf("Falscher Upload-Offset %d, erwartet %d", offset, job.Uploaded)
+		w.Header().Set("Upload-Offset", strconv.FormatInt(job.Uploaded, 10))
+	default:
+		job.uploading = true
+	}
+	analysisJobsMutex.Unlock()
+
+	if status != 0 {
+		respondWithError(w, status, message)
+		return
+	}
+	defer func() {
+		analysisJobsMutex.Lock()
+		job.uploading = false
+		analysisJobsMutex.Unlock()
+	}()
+
+	file, err := os.OpenFile(analysisUploadPath(id), os.O_WRONLY, 0)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Öffnen der Upload-Datei")
+		return
+	}
+
+	// Ab dem bestätigten Offset schreiben; Reste eines abgebrochenen Blocks werden verworfen
+	if err := file.Truncate(offset); err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err == nil {
+		r.Body = http.MaxBytesReader(w, r.Body, job.Size-offset)
+		err = writeAnalysisUpload(job, file, r.Body)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	analysisJobsMutex.Lock()
+	switch {
+	case job.Status != "uploading":
+		err = errAnalysisUploadCancelled
+	case errors.As(err, &maxBytesErr):
+		// Block über die angekündigte Größe hinaus: vollständig verwerfen
+		job.Uploaded = offset
+		os.Truncate(analysisUploadPath(id), offset)
+	case err == nil && job.Uploaded == job.Size:
+		queueAnalysisJobLocked(job)
+	}
+	uploaded := job.Uploaded
+	persistAnalysisJobs()
+	analysisJobsMutex.Unlock()
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(uploaded, 10))
+	switch {
+	case err == errAnalysisUploadCancelled:
+		respondWithError(w, http.StatusConflict, err.Error())
+	case maxBytesErr != nil:
+		respondWithError(w, http.StatusRequestEntityTooLarge, "Der Block überschreitet die angekündigte Dateigröße")
+	case err != nil:
+		// Der bis hierhin geschriebene Teil bleibt bestätigt und kann fortgesetzt werden
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Upload unterbrochen bei Offset %d: %v", uploaded, err))
+	default:
+		respondWithAnalysisJob(w, http.StatusOK, job, "")
+	}
+}
+
+// ListAnalysisJobsHandler gibt alle Analyse-Jobs ohne Ergebnisse zurück
+func ListAnalysisJobsHandler(w http.ResponseWriter, r *http.Request) {
+	analysisJobsMutex.Lock()
+	jobs := sortedAnalysisJobs()
+	for _, job := range jobs {
+		refreshAnalysisJobLocked(job)
+	}
+	data, err := json.Marshal(APIResponse{
+		Success: true,
+		Data:    jobs,
+	})
+	analysisJobsMutex.Unlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler bei der JSON-Kodierung")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// GetAnalysisJobHandler gibt einen Analyse-Job mit Fortschritt und, sobald er beendet ist,
+// mit dem vollständigen Ergebnis aus Statistiken, Gateways und Ereignissen zurück
+func GetAnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	analysisJobsMutex.Lock()
+	job, exists := analysisJobs[id]
+	var snapshot AnalysisJob
+	if exists {
+		refreshAnalysisJobLocked(job)
+		snapshot = *job
+	}
+	analysisJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Analyse-Job nicht gefunden")
+		return
+	}
+
+	detail := analysisJobDetail{AnalysisJob: &snapshot}
+	if finishedAnalysisJob(&snapshot) {
+		// Abgebrochene Jobs haben kein Ergebnis, fehlgeschlagene eventuell ein Teilergebnis
+		if data, err := os.ReadFile(analysisResultPath(id)); err == nil {
+			detail.Result = data
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    detail,
+	})
+}
+
+// CancelAnalysisJobHandler bricht den Upload, das Warten oder die Analyse eines Jobs ab
+func CancelAnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	analysisJobsMutex.Lock()
+	job, exists := analysisJobs[id]
+	finished := exists && finishedAnalysisJob(job)
+	if exists && !finished {
+		if job.cancel != nil {
+			// Die Analyse setzt den Status beim Beenden selbst
+			job.cancel()
+		} else {
+			job.Status = "cancelled"
+			job.FinishedAt = time.Now()
+			os.Remove(analysisUploadPath(id))
+			persistAnalysisJobs()
+		}
+	}
+	analysisJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Analyse-Job nicht gefunden")
+		return
+	}
+	if finished {
+		respondWithError(w, http.StatusConflict, "Analyse-Job ist bereits beendet")
+		return
+	}
+	respondWithAnalysisJob(w, http.StatusOK, job, fmt.Sprintf("Analyse-Job %s wird abgebrochen", id))
+}
+
+// DeleteAnalysisJobHandler bricht einen Job ab und entfernt ihn samt Upload und Ergebnis
+func DeleteAnalysisJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	analysisJobsMutex.Lock()
+	job, exists := analysisJobs[id]
+	if exists {
+		removeAnalysisJobLocked(job)
+		persistAnalysisJobs()
+	}
+	analysisJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Analyse-Job nicht gefunden")
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Analyse-Job %s gelöscht", id),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// respondWithAnalysisJob sendet einen Job ohne Ergebnis als JSON-Antwort
+func respondWithAnalysisJob(w http.ResponseWriter, statusCode int, job *AnalysisJob, message string) {
+	analysisJobsMutex.Lock()
+	refreshAnalysisJobLocked(job)
+	data, err := json.Marshal(APIResponse{
+		Success: true,
+		Message: message,
+		Data:    job,
+	})
+	analysisJobsMutex.Unlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler bei der JSON-Kodierung")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(data)
+}
@@ -0,0 +1,738 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/schedule"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+const (
+	// Ziel für Jobs auf der lokalen Live-Capture des Servers
+	localCaptureTarget = "local"
+
+	// Maximale Anzahl gespeicherter Läufe pro Job
+	maxCaptureJobHistory = 50
+
+	// Prüfintervall des Schedulers und Abfrageintervall laufender Agent-Captures
+	captureJobTick      = time.Second
+	captureJobPollEvery = 2 * time.Second
+
+	// Frist, bis eine gestartete Agent-Capture im Heartbeat erscheinen muss (drei
+	// Heartbeat-Intervalle des Agents); die Dauerbegrenzung des Jobs kommt hinzu
+	captureJobStartTimeout = 90 * time.Second
+)
+
+// CaptureJobRun beschreibt einen einzelnen Lauf eines Capture-Jobs
+type CaptureJobRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at,omitempty"`
+	Status     string    `json:"status"`                // "running", "completed", "stopped", "failed", "skipped"
+	StopReason string    `json:"stop_reason,omitempty"` // "duration", "packets", "bytes", "stopped", "source_closed"
+	Packets    int64     `json:"packets"`
+	Bytes      int64     `json:"bytes"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// CaptureJob ist eine geplante, begrenzte Capture auf dem Server oder einem Agent
+type CaptureJob struct {
+	ID        string        `json:"id"`
+	Name      string        `json:"name,omitempty"`
+	Target    string        `json:"target"` // "local" oder Name eines Agents
+	Interface string        `json:"interface"`
+	Filter    string        `json:"filter,omitempty"`
+	StartAt   time.Time     `json:"start_at,omitempty"` // einmaliger Start, leer = sofort
+	Cron      string        `json:"cron,omitempty"`     // wiederkehrender Start
+	Limits    CaptureLimits `json:"limits"`
+	Enabled   bool          `json:"enabled"`
+	CreatedAt time.Time     `json:"created_at"`
+	NextRun   time.Time     `json:"next_run,omitempty"`
+	Running   bool          `json:"running"`
+
+	History []*CaptureJobRun `json:"history"`
+
+	cron *schedule.Cron
+	stop chan struct{} // beendet den laufenden Lauf vorzeitig
+}
+
+// CaptureJobRequest enthält die Parameter zum Anlegen eines Capture-Jobs
+type CaptureJobRequest struct {
+	Name      string        `json:"name,omitempty"`
+	Target    string        `json:"target,omitempty"`
+	Interface string        `json:"interface"`
+	Filter    string        `json:"filter,omitempty"`
+	StartAt   time.Time     `json:"start_at,omitempty"`
+	Cron      string        `json:"cron,omitempty"`
+	Limits    CaptureLimits `json:"limits"`
+}
+
+var (
+	// Verwaltung der Capture-Jobs
+	captureJobs      = make(map[string]*CaptureJob)
+	captureJobsMutex sync.Mutex
+
+	// Pfad der Job-Datei (leer = keine Persistenz)
+	captureJobsPath string
+
+	// Capturer der lokalen Live-Capture
+	captureJobsCapturer *packet.PcapCapturer
+)
+
+// InitCaptureJobs lädt gespeicherte Capture-Jobs und legt den Capturer für lokale Jobs fest.
+// Beim Herunterfahren unterbrochene Läufe werden als fehlgeschlagen markiert.
+func InitCaptureJobs(path string, capturer *packet.PcapCapturer) error {
+	captureJobsPath = path
+	captureJobsCapturer = capturer
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("Fehler beim Lesen der Capture-Jobs: %w", err)
+	}
+
+	var jobs []*CaptureJob
+	if err := json.Unmarshal(data, &jobs); err != nil {
+		return fmt.Errorf("Fehler beim Parsen der Capture-Jobs: %w", err)
+	}
+
+	now := time.Now()
+	captureJobsMutex.Lock()
+	defer captureJobsMutex.Unlock()
+
+	for _, job := range jobs {
+		if job == nil || job.ID == "" {
+			continue
+		}
+		if job.Cron != "" {
+			cron, err := schedule.ParseCron(job.Cron)
+			if err != nil {
+				log.Printf("Capture-Job %s übersprungen: %v", job.ID, err)
+				continue
+			}
+			job.cron = cron
+			if job.Enabled {
+				job.NextRun = cron.Next(now)
+			}
+		}
+		if job.Running {
+			job.Running = false
+			if run := lastJobRun(job); run != nil && run.Status == "running" {
+				run.Status = "failed"
+				run.Error = "Server-Neustart während des Laufs"
+				run.EndedAt = now
+			}
+		}
+		captureJobs[job.ID] = job
+	}
+
+	log.Printf("Capture-Jobs geladen: %d aus %s", len(captureJobs), path)
+	return nil
+}
+
+// persistCaptureJobs schreibt alle Jobs in die Job-Datei.
+// Der Aufrufer muss captureJobsMutex halten.
+func persistCaptureJobs() {
+	if captureJobsPath == "" {
+		return
+	}
+
+	jobs := sortedCaptureJobs()
+	data, err := json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		log.Printf("Fehler beim Kodieren der Capture-Jobs: %v", err)
+		return
+	}
+	if err := writeFileAtomic(captureJobsPath, data); err != nil {
+		log.Printf("Fehler beim Speichern der Capture-Jobs: %v", err)
+	}
+}
+
+// sortedCaptureJobs gibt alle Jobs nach Erstellungszeit sortiert zurück.
+// Der Aufrufer muss captureJobsMutex halten.
+func sortedCaptureJobs() []*CaptureJob {
+	jobs := make([]*CaptureJob, 0, len(captureJobs))
+	for _, job := range captureJobs {
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// lastJobRun gibt den neuesten Lauf eines Jobs zurück.
+// Der Aufrufer muss captureJobsMutex halten.
+func lastJobRun(job *CaptureJob) *CaptureJobRun {
+	if len(job.History) == 0 {
+		return nil
+	}
+	return job.History[len(job.History)-1]
+}
+
+// addJobRun hängt einen Lauf an die Historie an und kürzt sie.
+// Der Aufrufer muss captureJobsMutex halten.
+func addJobRun(job *CaptureJob, run *CaptureJobRun) {
+	job.History = append(job.History, run)
+	if len(job.History) > maxCaptureJobHistory {
+		job.History = job.History[len(job.History)-maxCaptureJobHistory:]
+	}
+}
+
+// RunCaptureJobScheduler startet fällige Capture-Jobs, bis done geschlossen wird
+func RunCaptureJobScheduler(done <-chan struct{}) {
+	ticker := time.NewTicker(captureJobTick)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			startDueCaptureJobs(now)
+		}
+	}
+}
+
+// startDueCaptureJobs startet alle Jobs, deren nächster Lauf erreicht ist
+func startDueCaptureJobs(now time.Time) {
+	captureJobsMutex.Lock()
+	defer captureJobsMutex.Unlock()
+
+	changed := false
+	for _, job := range captureJobs {
+		if !job.Enabled || job.NextRun.IsZero() || job.NextRun.After(now) {
+			continue
+		}
+		changed = true
+
+		// Nächsten Termin berechnen; einmalige Jobs laufen nur einmal
+		if job.cron != nil {
+			job.NextRun = job.cron.Next(now)
+		} else {
+			job.NextRun = time.Time{}
+			job.Enabled = false
+		}
+
+		// Überlappende Läufe werden nicht gestartet, sondern vermerkt
+		if job.Running {
+			addJobRun(job, &CaptureJobRun{
+				StartedAt: now,
+				EndedAt:   now,
+				Status:    "skipped",
+				Error:     "Vorheriger Lauf ist noch aktiv",
+			})
+			continue
+		}
+		startCaptureJobLocked(job, now)
+	}
+
+	if changed {
+		persistCaptureJobs()
+	}
+}
+
+// startCaptureJobLocked startet einen Lauf im Hintergrund.
+// Der Aufrufer muss captureJobsMutex halten.
+func startCaptureJobLocked(job *CaptureJob, now time.Time) {
+	run := &CaptureJobRun{StartedAt: now, Status: "running"}
+	addJobRun(job, run)
+	job.Running = true
+	job.stop = make(chan struct{})
+
+	log.Printf("Capture-Job %s gestartet (Ziel: %s, Schnittstelle: %s)", job.ID, job.Target, job.Interface)
+	go executeCaptureJob(job, run, job.stop)
+}
+
+// executeCaptureJob führt einen Lauf aus und trägt das Ergebnis in die Historie ein
+func executeCaptureJob(job *CaptureJob, run *CaptureJobRun, stop <-chan struct{}) {
+	captureJobsMutex.Lock()
+	target, iface, filter, limits, id := job.Target, job.Interface, job.Filter, job.Limits, job.ID
+	captureJobsMutex.Unlock()
+
+	var result CaptureJobRun
+	if target == localCaptureTarget {
+		result = runLocalCaptureJob(iface, filter, limits, stop)
+	} else {
+		result = runAgentCaptureJob(id, target, iface, filter, limits, stop)
+	}
+
+	captureJobsMutex.Lock()
+	run.EndedAt = time.Now()
+	run.Status = result.Status
+	run.StopReason = result.StopReason
+	run.Packets = result.Packets
+	run.Bytes = result.Bytes
+	run.Error = result.Error
+	job.Running = false
+	job.stop = nil
+	persistCaptureJobs()
+	captureJobsMutex.Unlock()
+
+	if result.Error != "" {
+		log.Printf("Capture-Job %s beendet (%s): %s", id, result.Status, result.Error)
+	} else {
+		log.Printf("Capture-Job %s beendet (%s, %s): %d Pakete, %d Bytes",
+			id, result.Status, result.StopReason, result.Packets, result.Bytes)
+	}
+}
+
+// runLocalCaptureJob führt einen Lauf auf der lokalen Live-Capture des Servers aus
+func runLocalCaptureJob(iface, filter string, limits CaptureLimits, stop <-chan struct{}) CaptureJobRun {
+	if captureJobsCapturer == nil {
+		return CaptureJobRun{Status: "failed", Error: "Lokale Live-Capture ist nicht verfügbar"}
+	}
+
+	done := make(chan liveCaptureResult, 1)
+	if err := startLiveCapture(captureJobsCapturer, iface, filter, limits, func(result liveCaptureResult) {
+		done <- result
+	}); err != nil {
+		return CaptureJobRun{Status: "failed", Error: err.Error()}
+	}
+
+	var result liveCaptureResult
+	select {
+	case result = <-done:
+	case <-stop:
+		stopLiveCapture()
+		result = <-done
+	}
+
+	status := "completed"
+	if result.StopReason == "stopped" {
+		status = "stopped"
+	}
+	return CaptureJobRun{
+		Status:     status,
+		StopReason: result.StopReason,
+		Packets:    result.Packets,
+		Bytes:      result.Bytes,
+	}
+}
+
+// runAgentCaptureJob startet eine begrenzte Capture auf einem Agent und wartet über die
+// Heartbeats des Agents auf ihr Ende. Agents ohne begrenzte Captures werden nach Ablauf
+// der Dauer vom Server gestoppt.
+func runAgentCaptureJob(id, agentName, iface, filter string, limits CaptureLimits, stop <-chan struct{}) CaptureJobRun {
+	remoteAgentsMutex.RLock()
+	agent, exists := remoteAgents[agentName]
+	var agentURL, status string
+	var bounded, multiInterface bool
+	if exists {
+		agentURL, status = agent.URL, agent.Status
+		bounded = agentSupports(agent, version.CapabilityBoundedCapture)
+		multiInterface = agentSupports(agent, version.CapabilityMultiInterface)
+	}
+	remoteAgentsMutex.RUnlock()
+
+	if !exists {
+		return CaptureJobRun{Status: "failed", Error: fmt.Sprintf("Agent '%s' nicht gefunden", agentName)}
+	}
+	if status == "offline" {
+		return CaptureJobRun{Status: "failed", Error: fmt.Sprintf("Agent '%s' ist offline", agentName)}
+	}
+	if !bounded && (limits.MaxPackets > 0 || limits.MaxBytes > 0) {
+		return CaptureJobRun{Status: "failed", Error: fmt.Sprintf("Agent '%s' unterstützt keine Begrenzung nach Paketen oder Bytes", agentName)}
+	}
+
+	// Eigener Capture-Name, damit der Job parallel zu anderen Captures laufen kann
+	captureName := iface
+	captureReq := map[string]interface{}{
+		"interface": iface,
+		"filter":    filter,
+	}
+	if multiInterface {
+		captureName = "job-" + id
+		captureReq["name"] = captureName
+	}
+	if bounded {
+		captureReq["duration_seconds"] = limits.DurationSeconds
+		captureReq["max_packets"] = limits.MaxPackets
+		captureReq["max_bytes"] = limits.MaxBytes
+	}
+
+	agentResp, err := postToAgent(agentURL, "/capture/start", captureReq)
+	if err != nil {
+		return CaptureJobRun{Status: "failed", Error: err.Error()}
+	}
+	if !agentResp.Success {
+		return CaptureJobRun{Status: "failed", Error: fmt.Sprintf("Agent: %s", agentResp.Error)}
+	}
+	if data, ok := agentResp.Data.(map[string]interface{}); ok {
+		if name, ok := data["name"].(string); ok && name != "" {
+			captureName = name
+		}
+	}
+
+	// Ohne Unterstützung durch den Agent beendet der Server die Capture nach Ablauf der Dauer
+	var deadline <-chan time.Time
+	if !bounded && limits.DurationSeconds > 0 {
+		timer := time.NewTimer(time.Duration(limits.DurationSeconds) * time.Second)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	// Erscheint die Capture nie im Heartbeat (z.B. nach einem Neustart des Agents), schlägt der
+	// Lauf fehl, statt den Job dauerhaft zu blockieren
+	startTimeout := captureJobStartTimeout + time.Duration(limits.DurationSeconds)*time.Second
+	startTimer := time.NewTimer(startTimeout)
+	defer startTimer.Stop()
+	startDeadline := startTimer.C
+
+	ticker := time.NewTicker(captureJobPollEvery)
+	defer ticker.Stop()
+
+	var last AgentCapture
+	seen := false
+	for {
+		select {
+		case <-stop:
+			return stopAgentCaptureJob(agentURL, captureName, last, "stopped", "stopped")
+		case <-deadline:
+			return stopAgentCaptureJob(agentURL, captureName, last, "completed", "duration")
+		case <-startDeadline:
+			result := stopAgentCaptureJob(agentURL, captureName, last, "failed", "")
+			if result.Status == "failed" {
+				result.Error = fmt.Sprintf("Capture '%s' wurde vom Agent nach %v nicht gemeldet", captureName, startTimeout)
+			}
+			return result
+		case <-ticker.C:
+		}
+
+		remoteAgentsMutex.RLock()
+		agent, exists := remoteAgents[agentName]
+		var capture *AgentCapture
+		offline := !exists || agent.Status == "offline"
+		if exists {
+			for i := range agent.Captures {
+				if agent.Captures[i].Name == captureName {
+					c := agent.Captures[i]
+					capture = &c
+					break
+				}
+			}
+		}
+		remoteAgentsMutex.RUnlock()
+
+		if offline {
+			result := jobRunFromAgentCapture(last, "failed", "")
+			result.Error = fmt.Sprintf("Agent '%s' ist nicht mehr erreichbar", agentName)
+			return result
+		}
+		if capture == nil {
+			if seen {
+				result := jobRunFromAgentCapture(last, "failed", "")
+				result.Error = "Capture auf dem Agent nicht mehr vorhanden"
+				return result
+			}
+			continue
+		}
+
+		seen = true
+		startDeadline = nil
+		last = *capture
+		if capture.Status == "completed" {
+			// Abgeschlossene Capture auf dem Agent aufräumen
+			if _, err := postToAgent(agentURL, "/capture/stop", map[string]string{"name": captureName}); err != nil {
+				log.Printf("Abgeschlossene Capture '%s' konnte nicht entfernt werden: %v", captureName, err)
+			}
+			return jobRunFromAgentCapture(last, "completed", capture.StopReason)
+		}
+	}
+}
+
+// stopAgentCaptureJob stoppt die Capture eines Jobs auf dem Agent und übernimmt deren letzte Zähler
+func stopAgentCaptureJob(agentURL, captureName string, last AgentCapture, status, reason string) CaptureJobRun {
+	agentResp, err := postToAgent(agentURL, "/capture/stop", map[string]string{"name": captureName})
+	if err != nil {
+		result := jobRunFromAgentCapture(last, "failed", reason)
+		result.Error = err.Error()
+		return result
+	}
+
+	// Die Antwort enthält den letzten Stand der gestoppten Capture
+	var stopped struct {
+		Captures []AgentCapture `json:"captures"`
+	}
+	if raw, err := json.Marshal(agentResp.Data); err == nil && json.Unmarshal(raw, &stopped) == nil {
+		for _, capture := range stopped.Captures {
+			if capture.Name == captureName {
+				last = capture
+			}
+		}
+	}
+	if last.Status == "completed" && last.StopReason != "" {
+		return jobRunFromAgentCapture(last, "completed", last.StopReason)
+	}
+	return jobRunFromAgentCapture(last, status, reason)
+}
+
+// jobRunFromAgentCapture übernimmt die Zähler einer Agent-Capture in ein Lauf-Ergebnis
+func jobRunFromAgentCapture(capture AgentCapture, status, reason string) CaptureJobRun {
+	return CaptureJobRun{
+		Status:     status,
+		StopReason: reason,
+		Packets:    int64(capture.PacketsCaptured),
+		Bytes:      capture.BytesCaptured,
+	}
+}
+
+// ListCaptureJobsHandler gibt alle Capture-Jobs mit ihrer Historie zurück
+func ListCaptureJobsHandler(w http.ResponseWriter, r *http.Request) {
+	captureJobsMutex.Lock()
+	data, err := json.Marshal(APIResponse{
+		Success: true,
+		Data:    sortedCaptureJobs(),
+	})
+	captureJobsMutex.Unlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler bei der JSON-Kodierung")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// CreateCaptureJobHandler legt einen Capture-Job an. Ohne start_at und cron startet er sofort.
+func CreateCaptureJobHandler(w http.ResponseWriter, r *http.Request) {
+	var req CaptureJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	if req.Target == "" {
+		req.Target = localCaptureTarget
+	}
+	if req.Target == localCaptureTarget && req.Interface == "" {
+		respondWithError(w, http.StatusBadRequest, "Keine Netzwerkschnittstelle angegeben")
+		return
+	}
+	if req.Limits.DurationSeconds < 0 || req.Limits.MaxPackets < 0 || req.Limits.MaxBytes < 0 {
+		respondWithError(w, http.StatusBadRequest, "Grenzen dürfen nicht negativ sein")
+		return
+	}
+	if req.Limits.IsZero() {
+		respondWithError(w, http.StatusBadRequest, "Mindestens eine Grenze (duration_seconds, max_packets oder max_bytes) ist erforderlich")
+		return
+	}
+	if req.Cron != "" && !req.StartAt.IsZero() {
+		respondWithError(w, http.StatusBadRequest, "start_at und cron können nicht gemeinsam angegeben werden")
+		return
+	}
+
+	// Filter schon beim Anlegen prüfen statt erst beim ersten Lauf; für Agents mit Ethernet
+	if req.Filter != "" {
+		linkType := layers.LinkTypeEthernet
+		if req.Target == localCaptureTarget {
+			linkType = packet.InterfaceLinkType(req.Interface)
+		}
+		if _, err := captureJobsCapturer.CompileFilter(req.Filter, linkType); err != nil {
+			respondWithFilterError(w, err)
+			return
+		}
+	}
+
+	// Agent und dessen Fähigkeiten prüfen
+	if req.Target != localCaptureTarget {
+		remoteAgentsMutex.RLock()
+		agent, exists := remoteAgents[req.Target]
+		var bounded bool
+		var agentVersion string
+		if exists {
+			bounded = agentSupports(agent, version.CapabilityBoundedCapture)
+			agentVersion = agent.Version
+		}
+		remoteAgentsMutex.RUnlock()
+
+		if !exists {
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Agent '%s' nicht gefunden", req.Target))
+			return
+		}
+		if !bounded && (req.Limits.MaxPackets > 0 || req.Limits.MaxBytes > 0) {
+			respondCapabilityNotSupported(w, req.Target, agentVersion, version.CapabilityBoundedCapture)
+			return
+		}
+	}
+
+	now := time.Now()
+	job := &CaptureJob{
+		ID:        newID("job"),
+		Name:      req.Name,
+		Target:    req.Target,
+		Interface: req.Interface,
+		Filter:    req.Filter,
+		StartAt:   req.StartAt,
+		Cron:      req.Cron,
+		Limits:    req.Limits,
+		Enabled:   true,
+		CreatedAt: now,
+		History:   []*CaptureJobRun{},
+	}
+
+	switch {
+	case req.Cron != "":
+		cron, err := schedule.ParseCron(req.Cron)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültiger Cron-Ausdruck: %v", err))
+			return
+		}
+		job.cron = cron
+		job.NextRun = cron.Next(now)
+		if job.NextRun.IsZero() {
+			respondWithError(w, http.StatusBadRequest, "Der Cron-Ausdruck trifft nie zu")
+			return
+		}
+	case !req.StartAt.IsZero():
+		job.NextRun = req.StartAt
+	default:
+		job.NextRun = now
+	}
+
+	captureJobsMutex.Lock()
+	captureJobs[job.ID] = job
+	persistCaptureJobs()
+	captureJobsMutex.Unlock()
+
+	// Sofort fällige Jobs nicht erst mit dem nächsten Takt starten
+	startDueCaptureJobs(time.Now())
+
+	log.Printf("Capture-Job %s angelegt (Ziel: %s, nächster Lauf: %v)", job.ID, job.Target, job.NextRun)
+	respondWithCaptureJob(w, job, fmt.Sprintf("Capture-Job %s angelegt", job.ID))
+}
+
+// GetCaptureJobHandler gibt einen Capture-Job mit seiner Historie zurück
+func GetCaptureJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	captureJobsMutex.Lock()
+	job, exists := captureJobs[id]
+	captureJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Job nicht gefunden")
+		return
+	}
+	respondWithCaptureJob(w, job, "")
+}
+
+// RunCaptureJobHandler startet einen Capture-Job sofort, unabhängig von seinem Zeitplan
+func RunCaptureJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	captureJobsMutex.Lock()
+	job, exists := captureJobs[id]
+	alreadyRunning := exists && job.Running
+	if exists && !alreadyRunning {
+		startCaptureJobLocked(job, time.Now())
+		persistCaptureJobs()
+	}
+	captureJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Job nicht gefunden")
+		return
+	}
+	if alreadyRunning {
+		respondWithError(w, http.StatusConflict, "Capture-Job läuft bereits")
+		return
+	}
+	respondWithCaptureJob(w, job, fmt.Sprintf("Capture-Job %s gestartet", id))
+}
+
+// StopCaptureJobHandler beendet den laufenden Lauf eines Jobs. Mit ?disable=true
+// werden zusätzlich alle weiteren geplanten Läufe deaktiviert.
+func StopCaptureJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	disable := r.URL.Query().Get("disable") == "true"
+
+	captureJobsMutex.Lock()
+	job, exists := captureJobs[id]
+	if exists {
+		stopCaptureJobLocked(job)
+		if disable {
+			job.Enabled = false
+			job.NextRun = time.Time{}
+		}
+		persistCaptureJobs()
+	}
+	captureJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Job nicht gefunden")
+		return
+	}
+	respondWithCaptureJob(w, job, fmt.Sprintf("Capture-Job %s gestoppt", id))
+}
+
+// DeleteCaptureJobHandler stoppt einen Job und entfernt ihn samt Historie
+func DeleteCaptureJobHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	captureJobsMutex.Lock()
+	job, exists := captureJobs[id]
+	if exists {
+		stopCaptureJobLocked(job)
+		delete(captureJobs, id)
+		persistCaptureJobs()
+	}
+	captureJobsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Job nicht gefunden")
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Capture-Job %s gelöscht", id),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// stopCaptureJobLocked signalisiert dem laufenden Lauf eines Jobs das Ende.
+// Der Aufrufer muss captureJobsMutex halten.
+func stopCaptureJobLocked(job *CaptureJob) {
+	if job.Running && job.stop != nil {
+		close(job.stop)
+		job.stop = nil
+	}
+}
+
+// respondWithCaptureJob sendet einen Job als JSON-Antwort
+func respondWithCaptureJob(w http.ResponseWriter, job *CaptureJob, message string) {
+	captureJobsMutex.Lock()
+	data, err := json.Marshal(APIResponse{
+		Success: true,
+		Message: message,
+		Data:    job,
+	})
+	captureJobsMutex.Unlock()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler bei der JSON-Kodierung")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,471 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Vorlaufzeit bis zum gemeinsamen Start, wenn keine Startzeit angegeben ist
+const defaultSessionStartDelay = 3 * time.Second
+
+// CaptureSessionAgent beschreibt die Teilnahme eines Agents an einer Capture-Session
+type CaptureSessionAgent struct {
+	Name            string    `json:"name"`
+	Interface       string    `json:"interface"`
+	Filter          string    `json:"filter,omitempty"`
+	Status          string    `json:"status"` // "pending", "scheduled", "capturing", "stopped", "aborted", "failed"
+	Error           string    `json:"error,omitempty"`
+	StartedAt       time.Time `json:"started_at,omitempty"`
+	StoppedAt       time.Time `json:"stopped_at,omitempty"`
+	PacketsCaptured int       `json:"packets_captured"`
+}
+
+// CaptureSession fasst synchronisierte Captures auf mehreren Agents zusammen
+type CaptureSession struct {
+	ID         string                 `json:"id"`
+	Name       string                 `json:"name,omitempty"`
+	Status     string                 `json:"status"` // "scheduled", "running", "partial", "stopped", "stop_failed", "failed"
+	RequireAll bool                   `json:"require_all"`
+	CreatedAt  time.Time              `json:"created_at"`
+	StartAt    time.Time              `json:"start_at"`
+	StoppedAt  time.Time              `json:"stopped_at,omitempty"`
+	Agents     []*CaptureSessionAgent `json:"agents"`
+}
+
+// CaptureSessionRequest enthält die Parameter zum Anlegen einer Capture-Session
+type CaptureSessionRequest struct {
+	Name string `json:"name,omitempty"`
+
+	// Gemeinsame Startzeit; alternativ Verzögerung in Millisekunden ab jetzt
+	StartAt      time.Time `json:"start_at,omitempty"`
+	StartDelayMs int       `json:"start_delay_ms,omitempty"`
+
+	// Bei true wird die Session abgebrochen, sobald ein Agent nicht starten kann
+	RequireAll bool `json:"require_all"`
+
+	Agents []struct {
+		Name      string `json:"name"`
+		Interface string `json:"interface"`
+		Filter    string `json:"filter,omitempty"`
+	} `json:"agents"`
+}
+
+var (
+	// Verwaltung der Capture-Sessions
+	captureSessions      = make(map[string]*CaptureSession)
+	captureSessionsMutex sync.RWMutex
+)
+
+// newID erzeugt eine zufällige ID mit dem angegebenen Präfix
+func newID(prefix string) string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		// Fallback auf die Uhrzeit, falls keine Zufallsdaten verfügbar sind
+		return fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	}
+	return prefix + "-" + hex.EncodeToString(buf)
+}
+
+// CreateCaptureSessionHandler startet eine synchronisierte Capture auf mehreren Agents
+func CreateCaptureSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req CaptureSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	if len(req.Agents) == 0 {
+		respondWithError(w, http.StatusBadRequest, "Mindestens ein Agent ist erforderlich")
+		return
+	}
+
+	// Gemeinsame Startzeit bestimmen
+	startAt := req.StartAt
+	if startAt.IsZero() {
+		delay := defaultSessionStartDelay
+		if req.StartDelayMs > 0 {
+			delay = time.Duration(req.StartDelayMs) * time.Millisecond
+		}
+		startAt = time.Now().Add(delay)
+	} else if !startAt.After(time.Now()) {
+		respondWithError(w, http.StatusBadRequest, "Die Startzeit muss in der Zukunft liegen")
+		return
+	}
+
+	session := &CaptureSession{
+		ID:         newID("sess"),
+		Name:       req.Name,
+		Status:     "scheduled",
+		RequireAll: req.RequireAll,
+		CreatedAt:  time.Now(),
+		StartAt:    startAt,
+	}
+
+	// Teilnehmende Agents prüfen
+	agentURLs := make(map[string]string)
+	remoteAgentsMutex.RLock()
+	for _, a := range req.Agents {
+		if a.Name == "" {
+			remoteAgentsMutex.RUnlock()
+			respondWithError(w, http.StatusBadRequest, "Agent-Name ist erforderlich")
+			return
+		}
+		if _, duplicate := agentURLs[a.Name]; duplicate {
+			remoteAgentsMutex.RUnlock()
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Agent '%s' ist mehrfach angegeben", a.Name))
+			return
+		}
+		agent, exists := remoteAgents[a.Name]
+		if !exists {
+			remoteAgentsMutex.RUnlock()
+			respondWithError(w, http.StatusNotFound, fmt.Sprintf("Agent '%s' nicht gefunden", a.Name))
+			return
+		}
+		agentURLs[a.Name] = agent.URL
+
+		session.Agents = append(session.Agents, &CaptureSessionAgent{
+			Name:      a.Name,
+			Interface: a.Interface,
+			Filter:    a.Filter,
+			Status:    "pending",
+		})
+	}
+	remoteAgentsMutex.RUnlock()
+
+	captureSessionsMutex.Lock()
+	captureSessions[session.ID] = session
+	captureSessionsMutex.Unlock()
+
+	// Alle Agents parallel vorbereiten, damit die Startzeit für alle erreichbar bleibt
+	var wg sync.WaitGroup
+	for _, sa := range session.Agents {
+		wg.Add(1)
+		go func(sa *CaptureSessionAgent) {
+			defer wg.Done()
+			armSessionAgent(session, sa, agentURLs[sa.Name])
+		}(sa)
+	}
+	wg.Wait()
+	persistAgentRegistry()
+
+	// Ergebnis auswerten
+	captureSessionsMutex.Lock()
+	failed := 0
+	for _, sa := range session.Agents {
+		if sa.Status == "failed" {
+			failed++
+		}
+	}
+	abort := failed > 0 && session.RequireAll
+	switch {
+	case failed == len(session.Agents) || abort:
+		session.Status = "failed"
+	case failed > 0:
+		session.Status = "partial"
+	}
+	captureSessionsMutex.Unlock()
+
+	// Bei require_all die bereits vorbereiteten Agents wieder stoppen
+	if abort {
+		log.Printf("Capture-Session %s abgebrochen: %d Agent(s) konnten nicht starten", session.ID, failed)
+		if stopFailed := stopSessionAgents(session, agentURLs, "aborted"); len(stopFailed) > 0 {
+			log.Printf("Capture-Session %s: Agents konnten nicht gestoppt werden: %s",
+				session.ID, strings.Join(stopFailed, ", "))
+			captureSessionsMutex.Lock()
+			session.Status = "stop_failed"
+			captureSessionsMutex.Unlock()
+		}
+	} else {
+		log.Printf("Capture-Session %s geplant für %s (%d Agents, %d fehlgeschlagen)",
+			session.ID, startAt.Format(time.RFC3339), len(session.Agents), failed)
+	}
+
+	captureSessionsMutex.RLock()
+	data, _ := json.Marshal(session)
+	captureSessionsMutex.RUnlock()
+
+	response := APIResponse{
+		Success: !abort && session.Status != "failed",
+		Data:    json.RawMessage(data),
+	}
+	if !response.Success {
+		response.Error = "Capture-Session konnte nicht gestartet werden"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// armSessionAgent sendet die Startanfrage mit gemeinsamer Startzeit an einen Agent
+func armSessionAgent(session *CaptureSession, sa *CaptureSessionAgent, agentURL string) {
+	// Die Startzeit wird in die Uhrzeit des Agents umgerechnet, damit alle Agents
+	// trotz unterschiedlicher Uhren zum selben Server-Zeitpunkt starten
+	captureReq := map[string]interface{}{
+		"interface":  sa.Interface,
+		"filter":     sa.Filter,
+		"session_id": session.ID,
+		"start_at":   toAgentTime(sa.Name, session.StartAt),
+	}
+
+	agentResp, err := postToAgent(agentURL, "/capture/start", captureReq)
+
+	captureSessionsMutex.Lock()
+	defer captureSessionsMutex.Unlock()
+
+	if err != nil {
+		sa.Status = "failed"
+		sa.Error = err.Error()
+		return
+	}
+	if !agentResp.Success {
+		sa.Status = "failed"
+		sa.Error = agentResp.Error
+		return
+	}
+
+	sa.Status = "scheduled"
+	sa.StartedAt = session.StartAt
+
+	// Vom Agent bestätigte Startzeit in Server-Zeit übernehmen
+	if data, ok := agentResp.Data.(map[string]interface{}); ok {
+		if startAt, ok := data["start_at"].(string); ok {
+			if t, err := time.Parse(time.RFC3339Nano, startAt); err == nil {
+				sa.StartedAt = CorrectAgentTime(sa.Name, t)
+			}
+		}
+	}
+
+	// Agent-Status in der Registry vormerken, der nächste Heartbeat bestätigt ihn
+	remoteAgentsMutex.Lock()
+	if agent, exists := remoteAgents[sa.Name]; exists {
+		agent.SessionID = session.ID
+		agent.ActiveInterface = sa.Interface
+		setAgentStatus(agent, "scheduled", fmt.Sprintf("Capture-Session %s", session.ID))
+	}
+	remoteAgentsMutex.Unlock()
+}
+
+// stopSessionAgents stoppt alle noch aktiven Agents einer Session und gibt die Namen der
+// Agents zurück, die nicht gestoppt werden konnten. Diese behalten ihren Status.
+func stopSessionAgents(session *CaptureSession, agentURLs map[string]string, finalStatus string) []string {
+	captureSessionsMutex.RLock()
+	var active []*CaptureSessionAgent
+	for _, sa := range session.Agents {
+		if sa.Status == "scheduled" || sa.Status == "capturing" {
+			active = append(active, sa)
+		}
+	}
+	captureSessionsMutex.RUnlock()
+
+	var (
+		wg          sync.WaitGroup
+		failed      []string
+		failedMutex sync.Mutex
+	)
+	for _, sa := range active {
+		wg.Add(1)
+		go func(sa *CaptureSessionAgent) {
+			defer wg.Done()
+
+			agentResp, err := postToAgent(agentURLs[sa.Name], "/capture/stop", map[string]string{
+				"session_id": session.ID,
+			})
+
+			captureSessionsMutex.Lock()
+			switch {
+			case err != nil:
+				sa.Error = err.Error()
+			case !agentResp.Success:
+				sa.Error = agentResp.Error
+			default:
+				sa.Status = finalStatus
+				sa.Error = "" // Fehler eines früheren Stoppversuchs
+				sa.StoppedAt = time.Now()
+			}
+			captureSessionsMutex.Unlock()
+
+			if err != nil || !agentResp.Success {
+				failedMutex.Lock()
+				failed = append(failed, sa.Name)
+				failedMutex.Unlock()
+				return
+			}
+
+			remoteAgentsMutex.Lock()
+			if agent, exists := remoteAgents[sa.Name]; exists {
+				agent.SessionID = ""
+				setAgentStatus(agent, "online", fmt.Sprintf("Capture-Session %s beendet", session.ID))
+			}
+			remoteAgentsMutex.Unlock()
+		}(sa)
+	}
+	wg.Wait()
+
+	persistAgentRegistry()
+	sort.Strings(failed)
+	return failed
+}
+
+// StopCaptureSessionHandler stoppt alle Captures einer Session
+func StopCaptureSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	captureSessionsMutex.RLock()
+	session, exists := captureSessions[id]
+	captureSessionsMutex.RUnlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Session nicht gefunden")
+		return
+	}
+
+	// Agent-URLs aus der Registry übernehmen
+	agentURLs := make(map[string]string)
+	remoteAgentsMutex.RLock()
+	for _, sa := range session.Agents {
+		if agent, ok := remoteAgents[sa.Name]; ok {
+			agentURLs[sa.Name] = agent.URL
+		}
+	}
+	remoteAgentsMutex.RUnlock()
+
+	stopFailed := stopSessionAgents(session, agentURLs, "stopped")
+
+	// Agents, die sich nicht stoppen ließen, bleiben in der Session sichtbar und werden weiter
+	// über Heartbeats abgeglichen; ein erneuter Stopp versucht nur noch diese
+	captureSessionsMutex.Lock()
+	if len(stopFailed) > 0 {
+		session.Status = "stop_failed"
+	} else {
+		session.Status = "stopped"
+		session.StoppedAt = time.Now()
+	}
+	data, _ := json.Marshal(session)
+	captureSessionsMutex.Unlock()
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Capture-Session %s gestoppt", id),
+		Data:    json.RawMessage(data),
+	}
+	if len(stopFailed) > 0 {
+		response.Success = false
+		response.Message = ""
+		response.Error = fmt.Sprintf("Capture-Session %s: Agents konnten nicht gestoppt werden: %s",
+			id, strings.Join(stopFailed, ", "))
+		log.Print(response.Error)
+	} else {
+		log.Printf("Capture-Session %s gestoppt", id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// refreshSessionStatus gleicht den Session-Status mit Startzeit und Agent-Heartbeats ab.
+// Der Aufrufer muss captureSessionsMutex exklusiv halten.
+func refreshSessionStatus(session *CaptureSession) {
+	if session.Status == "stopped" || session.Status == "failed" {
+		return
+	}
+
+	started := !time.Now().Before(session.StartAt)
+	if started && session.Status == "scheduled" {
+		session.Status = "running"
+	}
+
+	remoteAgentsMutex.RLock()
+	defer remoteAgentsMutex.RUnlock()
+
+	for _, sa := range session.Agents {
+		if sa.Status != "scheduled" && sa.Status != "capturing" {
+			continue
+		}
+		if started {
+			sa.Status = "capturing"
+		}
+
+		agent, exists := remoteAgents[sa.Name]
+		if !exists {
+			continue
+		}
+		if agent.SessionID == session.ID {
+			sa.PacketsCaptured = agent.PacketsCaptured
+		} else if started && agent.LastSeen.After(session.StartAt) {
+			// Der Agent meldet per Heartbeat eine andere (oder keine) Session
+			sa.Status = "stopped"
+			sa.StoppedAt = agent.LastSeen
+		}
+	}
+
+	// Eine Session mit fehlgeschlagenem Stopp ist beendet, sobald kein Agent mehr aufzeichnet
+	if session.Status == "stop_failed" {
+		for _, sa := range session.Agents {
+			if sa.Status == "scheduled" || sa.Status == "capturing" {
+				return
+			}
+		}
+		session.Status = "stopped"
+		session.StoppedAt = time.Now()
+	}
+}
+
+// ListCaptureSessionsHandler gibt alle Capture-Sessions zurück
+func ListCaptureSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	captureSessionsMutex.Lock()
+	sessions := make([]*CaptureSession, 0, len(captureSessions))
+	for _, session := range captureSessions {
+		refreshSessionStatus(session)
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+	})
+	data, _ := json.Marshal(sessions)
+	captureSessionsMutex.Unlock()
+
+	response := APIResponse{
+		Success: true,
+		Data:    json.RawMessage(data),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetCaptureSessionHandler gibt den Status einer Capture-Session zurück
+func GetCaptureSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	captureSessionsMutex.Lock()
+	session, exists := captureSessions[id]
+	var data []byte
+	if exists {
+		refreshSessionStatus(session)
+		data, _ = json.Marshal(session)
+	}
+	captureSessionsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Capture-Session nicht gefunden")
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    json.RawMessage(data),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
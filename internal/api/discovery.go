@@ -0,0 +1,166 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/discovery"
+)
+
+const (
+	// Abstand zwischen zwei mDNS-Suchen nach Agents
+	agentBrowseInterval = 30 * time.Second
+	// Wartezeit auf Antworten je Suche
+	agentBrowseTimeout = 3 * time.Second
+	// Nicht mehr gesehene Agents werden nach dieser Zeit aus der Liste entfernt
+	discoveredAgentExpiry = 5 * time.Minute
+)
+
+// DiscoveredAgent ist ein per mDNS gefundener Agent
+type DiscoveredAgent struct {
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	Host       string    `json:"host"`
+	IPs        []net.IP  `json:"ips"`
+	Version    string    `json:"version,omitempty"`
+	Group      string    `json:"group,omitempty"`
+	FirstSeen  time.Time `json:"first_seen"`
+	LastSeen   time.Time `json:"last_seen"`
+	Registered bool      `json:"registered"`
+}
+
+var (
+	discoveredAgents      = make(map[string]*DiscoveredAgent)
+	discoveredAgentsMutex sync.RWMutex
+)
+
+// StartDiscovery kündigt den Server per mDNS an und sucht regelmäßig nach Agents im LAN
+func StartDiscovery(ctx context.Context, host string, port int) {
+	// Ein nur lokal erreichbarer Server wird nicht angekündigt
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		log.Printf("Server lauscht nur auf %s, mDNS-Ankündigung deaktiviert", host)
+	} else {
+		service := discovery.Service{
+			Instance: "ki-network-analyzer",
+			Type:     discovery.ServerService,
+			Port:     port,
+			TXT:      map[string]string{"path": "/api"},
+		}
+		if hostname, err := os.Hostname(); err == nil {
+			service.Instance = hostname
+		}
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() {
+			service.IPs = []net.IP{ip}
+		}
+
+		go func() {
+			if err := discovery.NewResponder(service).Run(ctx); err != nil {
+				log.Printf("mDNS-Ankündigung des Servers fehlgeschlagen: %v", err)
+			}
+		}()
+	}
+
+	go browseAgents(ctx)
+}
+
+// browseAgents sucht regelmäßig nach Agents und aktualisiert die Liste der gefundenen Agents
+func browseAgents(ctx context.Context) {
+	ticker := time.NewTicker(agentBrowseInterval)
+	defer ticker.Stop()
+
+	for {
+		services, err := discovery.Browse(ctx, discovery.AgentService, agentBrowseTimeout)
+		if err != nil {
+			log.Printf("mDNS-Suche nach Agents fehlgeschlagen: %v", err)
+		} else {
+			updateDiscoveredAgents(services)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// updateDiscoveredAgents übernimmt die Ergebnisse einer Suche und entfernt veraltete Einträge
+func updateDiscoveredAgents(services []discovery.Service) {
+	now := time.Now()
+
+	discoveredAgentsMutex.Lock()
+	defer discoveredAgentsMutex.Unlock()
+
+	for _, service := range services {
+		name := service.TXT["name"]
+		if name == "" {
+			name = service.Instance
+		}
+		agentURL := service.TXT["url"]
+		if agentURL == "" {
+			agentURL = service.URL()
+		}
+
+		agent, exists := discoveredAgents[name]
+		if !exists {
+			agent = &DiscoveredAgent{Name: name, FirstSeen: now}
+			discoveredAgents[name] = agent
+			log.Printf("Agent '%s' per mDNS gefunden: %s", name, agentURL)
+		}
+		agent.URL = agentURL
+		agent.Host = service.Host
+		agent.IPs = service.IPs
+		agent.Version = service.TXT["version"]
+		agent.Group = service.TXT["group"]
+		agent.LastSeen = now
+	}
+
+	for name, agent := range discoveredAgents {
+		if now.Sub(agent.LastSeen) > discoveredAgentExpiry {
+			delete(discoveredAgents, name)
+		}
+	}
+}
+
+// ListDiscoveredAgentsHandler gibt die per mDNS gefundenen Agents zurück.
+// Mit ?unregistered=true nur Agents, die sich noch nicht beim Server registriert haben.
+func ListDiscoveredAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	onlyUnregistered := r.URL.Query().Get("unregistered") == "true"
+
+	discoveredAgentsMutex.RLock()
+	agents := make([]DiscoveredAgent, 0, len(discoveredAgents))
+	for _, agent := range discoveredAgents {
+		agents = append(agents, *agent)
+	}
+	discoveredAgentsMutex.RUnlock()
+
+	remoteAgentsMutex.RLock()
+	result := agents[:0]
+	for _, agent := range agents {
+		_, agent.Registered = remoteAgents[agent.Name]
+		if onlyUnregistered && agent.Registered {
+			continue
+		}
+		result = append(result, agent)
+	}
+	remoteAgentsMutex.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Maximale Anzahl gespeicherter Ereignisse
+const maxEvents = 1000
+
+// Intervall, in dem die Verlustrate der lokalen Live-Capture geprüft wird
+const dropCheckInterval = 5 * time.Second
+
+var (
+	// Ereignisspeicher, älteste zuerst
+	events      []models.GatewayEvent
+	eventsMutex sync.RWMutex
+
+	// Verlustrate, ab der eine Warnung erzeugt wird (0 = deaktiviert)
+	dropWarningThreshold float64
+)
+
+// SetDropWarningThreshold setzt die Verlustrate (0..1), ab der Warnungen erzeugt werden
+func SetDropWarningThreshold(threshold float64) {
+	dropWarningThreshold = threshold
+}
+
+// RecordEvent speichert ein Ereignis im Ereignisspeicher
+func RecordEvent(event models.GatewayEvent) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	eventsMutex.Lock()
+	events = append(events, event)
+	if len(events) > maxEvents {
+		events = events[len(events)-maxEvents:]
+	}
+	eventsMutex.Unlock()
+
+	if event.Severity == "warning" || event.Severity == "error" {
+		log.Printf("Ereignis [%s] %s: %s", event.Severity, event.EventType, event.Description)
+	}
+}
+
+// dropWarningEvent erstellt ein Warnereignis für eine überschrittene Verlustrate
+func dropWarningEvent(source string, interval packet.CaptureStats, rate float64) models.GatewayEvent {
+	return models.GatewayEvent{
+		Timestamp: time.Now(),
+		EventType: "capture_drops",
+		Description: fmt.Sprintf("%s: %.1f%% der Pakete verworfen (Kernel: %d, Interface: %d, Verarbeitung: %d)",
+			source, rate*100, interval.KernelDropped, interval.InterfaceDropped, interval.PipelineDropped),
+		Severity: "warning",
+		Data: map[string]interface{}{
+			"source":    source,
+			"drop_rate": rate,
+			"interval":  interval,
+		},
+	}
+}
+
+// MonitorCaptureDrops prüft regelmäßig die Verlustrate einer Session und erzeugt
+// bei Überschreitung des Schwellwerts ein Warnereignis
+func MonitorCaptureDrops(ctx context.Context, session *packet.CaptureSession, source string) {
+	monitor := packet.NewDropMonitor(dropWarningThreshold)
+	ticker := time.NewTicker(dropCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if interval, rate, crossed := monitor.Check(session.Stats()); crossed {
+				RecordEvent(dropWarningEvent(source, interval, rate))
+			}
+		}
+	}
+}
+
+// GetGatewayEventsHandler gibt die gespeicherten Ereignisse zurück, neueste zuerst.
+// Optional: ?type=<event_type>, ?severity=<severity>, ?limit=<n>
+func GetGatewayEventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	eventType := query.Get("type")
+	severity := query.Get("severity")
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			respondWithError(w, http.StatusBadRequest, "Ungültiger Parameter 'limit'")
+			return
+		}
+		limit = min(parsed, maxEvents)
+	}
+
+	eventsMutex.RLock()
+	result := make([]models.GatewayEvent, 0, min(limit, len(events)))
+	for i := len(events) - 1; i >= 0 && len(result) < limit; i-- {
+		if eventType != "" && events[i].EventType != eventType {
+			continue
+		}
+		if severity != "" && events[i].Severity != severity {
+			continue
+		}
+		result = append(result, events[i])
+	}
+	eventsMutex.RUnlock()
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
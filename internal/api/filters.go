@@ -0,0 +1,173 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+// FilterValidationRequest enthält einen zu prüfenden BPF-Filter. Der Linktyp ergibt sich aus
+// der Schnittstelle des Servers oder wird direkt angegeben; Standard ist Ethernet.
+type FilterValidationRequest struct {
+	Filter    string `json:"filter"`
+	Interface string `json:"interface,omitempty"`
+	LinkType  string `json:"link_type,omitempty"` // Name wie "ethernet" oder DLT-Nummer
+}
+
+// FilterValidationResult ist das Ergebnis der Filterprüfung
+type FilterValidationResult struct {
+	Valid        bool                `json:"valid"`
+	Filter       string              `json:"filter"`
+	LinkType     string              `json:"link_type"`
+	Instructions int                 `json:"instructions"` // Länge des BPF-Programms, 0 = kein Filter
+	Error        *packet.FilterError `json:"error,omitempty"`
+}
+
+// Linktypen, die bei der Filterprüfung per Namen angegeben werden können
+var filterLinkTypes = map[string]layers.LinkType{
+	"ethernet":         layers.LinkTypeEthernet,
+	"raw":              layers.LinkTypeRaw,
+	"linux_sll":        layers.LinkTypeLinuxSLL,
+	"null":             layers.LinkTypeNull,
+	"loop":             layers.LinkTypeLoop,
+	"ppp":              layers.LinkTypePPP,
+	"ieee802_11":       layers.LinkTypeIEEE802_11,
+	"ieee802_11_radio": layers.LinkTypeIEEE80211Radio,
+}
+
+// parseFilterLinkType übersetzt einen Linktyp aus Name oder DLT-Nummer
+func parseFilterLinkType(name string) (layers.LinkType, error) {
+	if linkType, ok := filterLinkTypes[strings.ToLower(name)]; ok {
+		return linkType, nil
+	}
+	if number, err := strconv.ParseUint(name, 10, 16); err == nil {
+		return layers.LinkType(number), nil
+	}
+	return 0, fmt.Errorf("Unbekannter Linktyp '%s'", name)
+}
+
+// ValidateFilterHandler übersetzt einen BPF-Filter probeweise, ohne eine Capture zu öffnen.
+// Ungültige Filter sind keine Fehler der Anfrage: die Antwort enthält valid=false und die
+// Stelle des fehlerhaften Tokens, damit die Oberfläche sie schon während der Eingabe markiert.
+func ValidateFilterHandler(w http.ResponseWriter, r *http.Request, capturer *packet.PcapCapturer) {
+	var req FilterValidationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	linkType := layers.LinkTypeEthernet
+	switch {
+	case req.LinkType != "":
+		var err error
+		if linkType, err = parseFilterLinkType(req.LinkType); err != nil {
+			respondWithError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case req.Interface != "":
+		linkType = packet.InterfaceLinkType(req.Interface)
+	}
+
+	result := FilterValidationResult{
+		Valid:    true,
+		Filter:   req.Filter,
+		LinkType: linkType.String(),
+	}
+	if strings.TrimSpace(req.Filter) != "" {
+		instructions, err := capturer.CompileFilter(req.Filter, linkType)
+		if err != nil {
+			result.Valid = false
+			if !errors.As(err, &result.Error) {
+				result.Error = &packet.FilterError{Filter: req.Filter, LinkType: result.LinkType, Message: err.Error(), Offset: -1}
+			}
+		}
+		result.Instructions = len(instructions)
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// DisplayFilterValidationRequest enthält einen zu prüfenden Anzeigefilter
+type DisplayFilterValidationRequest struct {
+	Filter string `json:"filter"`
+}
+
+// DisplayFilterValidationResult ist das Ergebnis der Prüfung eines Anzeigefilters
+type DisplayFilterValidationResult struct {
+	Valid  bool                 `json:"valid"`
+	Filter string               `json:"filter"`
+	Error  *displayfilter.Error `json:"error,omitempty"`
+}
+
+// ValidateDisplayFilterHandler prüft einen Anzeigefilter. Wie bei BPF-Filtern sind ungültige
+// Filter keine Fehler der Anfrage; die Antwort enthält die Position des Fehlers.
+func ValidateDisplayFilterHandler(w http.ResponseWriter, r *http.Request) {
+	var req DisplayFilterValidationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	result := DisplayFilterValidationResult{
+		Valid:  true,
+		Filter: req.Filter,
+	}
+	if _, err := displayfilter.Compile(req.Filter); err != nil {
+		result.Valid = false
+		errors.As(err, &result.Error)
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ListDisplayFilterFieldsHandler gibt die Felder der Anzeigefilter mit ihren Typen zurück
+func ListDisplayFilterFieldsHandler(w http.ResponseWriter, r *http.Request) {
+	response := APIResponse{
+		Success: true,
+		Data:    displayfilter.Fields(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// respondWithFilterError meldet einen ungültigen Filter mit 400. Bei einem *packet.FilterError
+// oder *displayfilter.Error enthält das Feld data die Position und das fehlerhafte Token.
+func respondWithFilterError(w http.ResponseWriter, err error) {
+	response := APIResponse{
+		Success: false,
+		Error:   err.Error(),
+	}
+	var filterErr *packet.FilterError
+	var displayFilterErr *displayfilter.Error
+	switch {
+	case errors.As(err, &filterErr):
+		response.Data = filterErr
+	case errors.As(err, &displayFilterErr):
+		response.Data = displayFilterErr
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(response)
+}
@@ -3,20 +3,17 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"net/http"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
-	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
 	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
 )
 
@@ -52,15 +49,21 @@ type InterfaceInfo struct {
 type LiveCaptureRequest struct {
 	Interface string `json:"interface"`
 	Filter    string `json:"filter,omitempty"`
+
+	// Optionale Begrenzung nach Dauer, Paketen oder Bytes
+	Limits CaptureLimits `json:"limits"`
 }
 
 var (
 	startTime = time.Now()
 
-	// Für die Verwaltung der aktiven Live-Capture
-	activeCaptureContext context.Context
+	// Für die Verwaltung der aktiven Live-Capture. activeCaptureSession bleibt nach dem
+	// Ende erhalten, damit die Zähler der letzten Capture abrufbar sind.
+	activeCaptureSession *packet.CaptureSession
 	activeCaptureCancel  context.CancelFunc
-	activeCaptureStatus  string = "idle" // "idle", "running", "error"
+	activeCaptureStatus  string = "idle" // "idle", "starting", "running", "error"
+	activeCaptureIface   string
+	activeCaptureStarted time.Time
 	captureStatusMutex   sync.Mutex
 )
 
@@ -82,117 +85,6 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(health)
 }
 
-// AnalyzePcapHandler verarbeitet den Upload und die Analyse einer PCAP-Datei
-func AnalyzePcapHandler(w http.ResponseWriter, r *http.Request, capturer *packet.PcapCapturer) {
-	// Maximale Dateigröße festlegen (100 MB)
-	maxFileSize := int64(100 * 1024 * 1024)
-	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
-
-	// Multipart-Anfrage analysieren
-	err := r.ParseMultipartForm(maxFileSize)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Datei zu groß oder ungültiges Format")
-		return
-	}
-
-	// Datei aus der Anfrage extrahieren
-	file, fileHeader, err := r.FormFile("pcap")
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Keine PCAP-Datei in der Anfrage gefunden")
-		return
-	}
-	defer file.Close()
-
-	// Temporäre Datei erstellen
-	tempDir := os.TempDir()
-	tempFileName := filepath.Join(tempDir, "upload-"+time.Now().Format("20060102-150405")+".pcap")
-	tempFile, err := os.Create(tempFileName)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Fehler beim Erstellen der temporären Datei")
-		return
-	}
-	defer tempFile.Close()
-	defer os.Remove(tempFileName)
-
-	// Datei speichern
-	_, err = io.Copy(tempFile, file)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Fehler beim Speichern der Datei")
-		return
-	}
-	tempFile.Close() // Schließen, um die Datei für das Lesen zu öffnen
-
-	// PCAP-Datei öffnen
-	err = capturer.OpenPcapFile(tempFileName)
-	if err != nil {
-		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Fehler beim Öffnen der PCAP-Datei: %v", err))
-		return
-	}
-
-	// Kontext erstellen
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Paketerfassung starten
-	packetChan, errChan := capturer.StartCapture(ctx)
-
-	// Pakete sammeln
-	var packets []*models.PacketInfo
-	var gatewayPackets []*models.PacketInfo
-	var packetCount, gatewayCount int
-
-	// Maximale Analyse-Zeit festlegen (30 Sekunden)
-	timeout := time.After(30 * time.Second)
-
-packetLoop:
-	for {
-		select {
-		case p, ok := <-packetChan:
-			if !ok {
-				break packetLoop
-			}
-			packetCount++
-
-			// Gateway-Pakete separat sammeln
-			if p.IsGatewayTraffic {
-				gatewayCount++
-				gatewayPackets = append(gatewayPackets, p)
-			}
-
-			// Begrenzte Anzahl von Paketen für die Antwort
-			if len(packets) < 1000 {
-				packets = append(packets, p)
-			}
-
-		case err, ok := <-errChan:
-			if !ok {
-				continue
-			}
-			log.Printf("Fehler bei der Paketverarbeitung: %v", err)
-
-		case <-timeout:
-			log.Println("Timeout bei der Paketanalyse")
-			break packetLoop
-		}
-	}
-
-	// Antwort erstellen
-	response := APIResponse{
-		Success: true,
-		Message: fmt.Sprintf("PCAP-Datei '%s' erfolgreich analysiert", fileHeader.Filename),
-		Data: map[string]interface{}{
-			"total_packets":      packetCount,
-			"gateway_packets":    gatewayCount,
-			"gateway_percentage": float64(gatewayCount) / float64(packetCount) * 100,
-			"sample_packets":     packets[:min(len(packets), 100)], // Kleine Stichprobe zurückgeben
-		},
-	}
-
-	// Als JSON antworten
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
 // WebSocketHandler verwaltet Websocket-Verbindungen für Live-Updates
 func WebSocketHandler(w http.ResponseWriter, r *http.Request, upgrader websocket.Upgrader) {
 	// Upgrade der HTTP-Verbindung zu einer Websocket-Verbindung
@@ -276,17 +168,30 @@ func GetInterfacesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// CaptureLimits begrenzt eine Capture; sie endet, sobald eine der gesetzten Grenzen erreicht ist
+type CaptureLimits struct {
+	DurationSeconds int   `json:"duration_seconds,omitempty"`
+	MaxPackets      int64 `json:"max_packets,omitempty"`
+	MaxBytes        int64 `json:"max_bytes,omitempty"`
+}
+
+// IsZero gibt an, ob keine Grenze gesetzt ist
+func (l CaptureLimits) IsZero() bool {
+	return l.DurationSeconds <= 0 && l.MaxPackets <= 0 && l.MaxBytes <= 0
+}
+
+// liveCaptureResult beschreibt das Ergebnis einer beendeten lokalen Live-Capture
+type liveCaptureResult struct {
+	Packets    int64
+	Bytes      int64
+	StopReason string // "duration", "packets", "bytes", "stopped", "source_closed"
+}
+
+// errLiveCaptureRunning wird zurückgegeben, wenn bereits eine lokale Live-Capture läuft
+var errLiveCaptureRunning = fmt.Errorf("Eine Live-Capture läuft bereits")
+
 // StartLiveCaptureHandler startet die Live-Capture auf einer Netzwerkschnittstelle
 func StartLiveCaptureHandler(w http.ResponseWriter, r *http.Request, capturer *packet.PcapCapturer) {
-	// Prüfen, ob bereits eine Capture läuft
-	captureStatusMutex.Lock()
-	if activeCaptureStatus == "running" {
-		captureStatusMutex.Unlock()
-		respondWithError(w, http.StatusConflict, "Eine Live-Capture läuft bereits")
-		return
-	}
-	captureStatusMutex.Unlock()
-
 	// Request-Body einlesen
 	var request LiveCaptureRequest
 	err := json.NewDecoder(r.Body).Decode(&request)
@@ -302,101 +207,231 @@ func StartLiveCaptureHandler(w http.ResponseWriter, r *http.Request, capturer *p
 	}
 
 	// Capture starten
-	err = capturer.OpenLiveCapture(request.Interface)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError,
-			fmt.Sprintf("Fehler beim Öffnen der Netzwerkschnittstelle: %v", err))
+	if err := startLiveCapture(capturer, request.Interface, request.Filter, request.Limits, nil); err != nil {
+		var filterErr *packet.FilterError
+		switch {
+		case err == errLiveCaptureRunning:
+			respondWithError(w, http.StatusConflict, err.Error())
+		case errors.As(err, &filterErr):
+			respondWithFilterError(w, filterErr)
+		default:
+			respondWithError(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
+	// Erfolgreiche Antwort senden
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Live-Capture auf Schnittstelle %s gestartet", request.Interface),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// startLiveCapture startet die lokale Live-Capture des Servers, optional begrenzt.
+// done wird nach dem Ende der Capture mit den erfassten Zählern aufgerufen.
+func startLiveCapture(capturer *packet.PcapCapturer, iface, filter string, limits CaptureLimits, done func(liveCaptureResult)) error {
+	// Prüfen, ob bereits eine Capture läuft, und den Platz reservieren
+	captureStatusMutex.Lock()
+	if activeCaptureStatus == "running" || activeCaptureStatus == "starting" {
+		captureStatusMutex.Unlock()
+		return errLiveCaptureRunning
+	}
+	activeCaptureStatus = "starting"
+	captureStatusMutex.Unlock()
+
+	fail := func(err error) error {
+		captureStatusMutex.Lock()
+		activeCaptureStatus = "error"
+		captureStatusMutex.Unlock()
+		return err
+	}
+
+	// Filter vorab für den Linktyp der Schnittstelle prüfen, bevor sie geöffnet wird
+	if filter != "" {
+		if _, err := capturer.CompileFilter(filter, packet.InterfaceLinkType(iface)); err != nil {
+			return fail(err)
+		}
+	}
+
+	// Schnittstelle in einer neuen Session mit dem Filter der Anfrage öffnen
+	session, err := capturer.OpenLiveCapture(iface, filter)
+	if err != nil {
+		var filterErr *packet.FilterError
+		if errors.As(err, &filterErr) {
+			return fail(filterErr)
+		}
+		return fail(fmt.Errorf("Fehler beim Öffnen der Netzwerkschnittstelle: %v", err))
+	}
+
+	// Vorlaufpuffer für ereignisgesteuerte Aufzeichnungen und fortlaufende Aufzeichnung anhängen
+	AttachTriggerRecorder(session)
+	AttachArchiveRecorder(session)
+
 	// Neuen Kontext für die Capture erstellen
-	activeCaptureContext, activeCaptureCancel = context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
 
 	// Capture starten
-	packetChan, errChan := capturer.StartCapture(activeCaptureContext)
+	packetChan, errChan := session.Start(ctx)
 
 	// Status aktualisieren
 	captureStatusMutex.Lock()
+	activeCaptureSession, activeCaptureCancel = session, cancel
 	activeCaptureStatus = "running"
+	activeCaptureIface = iface
+	activeCaptureStarted = time.Now()
 	captureStatusMutex.Unlock()
 
+	// Verlustrate überwachen
+	go MonitorCaptureDrops(ctx, session, fmt.Sprintf("Live-Capture %s", iface))
+
 	// Verarbeitung in Goroutine starten
 	go func() {
-		var packetCount int
+		var result liveCaptureResult
 		var gatewayCount int
 
+		defer func() {
+			cancel()
+			session.Stop()
+			DetachTriggerRecorder(session)
+			DetachArchiveRecorder(session)
+			captureStatusMutex.Lock()
+			activeCaptureStatus = "idle"
+			captureStatusMutex.Unlock()
+			if done != nil {
+				done(result)
+			}
+		}()
+
 		ticker := time.NewTicker(5 * time.Second)
 		defer ticker.Stop()
 
+		// Zeitliche Begrenzung
+		var deadline <-chan time.Time
+		if limits.DurationSeconds > 0 {
+			timer := time.NewTimer(time.Duration(limits.DurationSeconds) * time.Second)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+
 		for {
 			select {
 			case p, ok := <-packetChan:
 				if !ok {
 					log.Println("Paketkanal geschlossen")
-					captureStatusMutex.Lock()
-					activeCaptureStatus = "idle"
-					captureStatusMutex.Unlock()
+					if result.StopReason == "" {
+						result.StopReason = "source_closed"
+					}
 					return
 				}
 
-				packetCount++
+				result.Packets++
+				result.Bytes += int64(p.Length)
 				if p.IsGatewayTraffic {
 					gatewayCount++
 				}
+				EvaluateTriggers(session, p)
+				packet.ReleasePacketInfo(p)
 
 				// Hier könnte die Verarbeitung erfolgen und Daten an WebSockets gesendet werden
 				// Diese Logik ist bereits in processLivePackets im Hauptprogramm implementiert
 
+				// Mengenbegrenzung prüfen
+				if limits.MaxPackets > 0 && result.Packets >= limits.MaxPackets {
+					log.Printf("Live-Capture nach %d Paketen beendet", result.Packets)
+					result.StopReason = "packets"
+					return
+				}
+				if limits.MaxBytes > 0 && result.Bytes >= limits.MaxBytes {
+					log.Printf("Live-Capture nach %d Bytes beendet", result.Bytes)
+					result.StopReason = "bytes"
+					return
+				}
+
 			case err, ok := <-errChan:
 				if !ok {
 					continue
 				}
 				log.Printf("Fehler bei der Live-Capture: %v", err)
 
+			case <-deadline:
+				log.Printf("Live-Capture nach %d Sekunden beendet", limits.DurationSeconds)
+				result.StopReason = "duration"
+				return
+
 			case <-ticker.C:
 				log.Printf("Live-Capture Status: %d Pakete erfasst, davon %d Gateway-Pakete",
-					packetCount, gatewayCount)
+					result.Packets, gatewayCount)
 
-			case <-activeCaptureContext.Done():
+			case <-ctx.Done():
 				log.Println("Live-Capture wurde gestoppt")
-				captureStatusMutex.Lock()
-				activeCaptureStatus = "idle"
-				captureStatusMutex.Unlock()
+				result.StopReason = "stopped"
 				return
 			}
 		}
 	}()
 
+	return nil
+}
+
+// stopLiveCapture stoppt die lokale Live-Capture. Gibt false zurück, wenn keine läuft.
+func stopLiveCapture() bool {
+	captureStatusMutex.Lock()
+	defer captureStatusMutex.Unlock()
+
+	if activeCaptureStatus != "running" {
+		return false
+	}
+	if activeCaptureCancel != nil {
+		activeCaptureCancel()
+	}
+	return true
+}
+
+// StopLiveCaptureHandler stoppt die aktive Live-Capture
+func StopLiveCaptureHandler(w http.ResponseWriter, r *http.Request) {
+	// Capture stoppen, falls eine läuft
+	if !stopLiveCapture() {
+		respondWithError(w, http.StatusBadRequest, "Keine aktive Live-Capture vorhanden")
+		return
+	}
+
 	// Erfolgreiche Antwort senden
 	response := APIResponse{
 		Success: true,
-		Message: fmt.Sprintf("Live-Capture auf Schnittstelle %s gestartet", request.Interface),
+		Message: "Live-Capture wurde gestoppt",
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// StopLiveCaptureHandler stoppt die aktive Live-Capture
-func StopLiveCaptureHandler(w http.ResponseWriter, r *http.Request) {
-	// Prüfen, ob eine Capture läuft
+// LiveCaptureStatusHandler gibt den Status und die Zähler der lokalen Live-Capture zurück
+func LiveCaptureStatusHandler(w http.ResponseWriter, r *http.Request) {
 	captureStatusMutex.Lock()
-	if activeCaptureStatus != "running" {
-		captureStatusMutex.Unlock()
-		respondWithError(w, http.StatusBadRequest, "Keine aktive Live-Capture vorhanden")
-		return
+	status := map[string]interface{}{
+		"status":    activeCaptureStatus,
+		"interface": activeCaptureIface,
 	}
+	if !activeCaptureStarted.IsZero() {
+		status["started_at"] = activeCaptureStarted
+	}
+	session := activeCaptureSession
 	captureStatusMutex.Unlock()
 
-	// Capture stoppen
-	if activeCaptureCancel != nil {
-		activeCaptureCancel()
+	var stats packet.CaptureStats
+	if session != nil {
+		stats = session.Stats()
 	}
+	status["stats"] = stats
+	status["drop_rate"] = stats.DropRate()
+	status["drop_warning_threshold"] = dropWarningThreshold
 
-	// Erfolgreiche Antwort senden
 	response := APIResponse{
 		Success: true,
-		Message: "Live-Capture wurde gestoppt",
+		Data:    status,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -450,45 +485,6 @@ func GetGatewayTrafficHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-// GetGatewayEventsHandler gibt Gateway-relevante Ereignisse zurück
-func GetGatewayEventsHandler(w http.ResponseWriter, r *http.Request) {
-	// Mock-Daten für den MVP
-	events := []models.GatewayEvent{
-		{
-			Timestamp:   time.Now().Add(-5 * time.Minute),
-			EventType:   "dhcp",
-			Description: "DHCP-Lease-Erneuerung",
-			Severity:    "info",
-			GatewayIP:   "192.168.1.1",
-			ClientIP:    "192.168.1.100",
-		},
-		{
-			Timestamp:   time.Now().Add(-10 * time.Minute),
-			EventType:   "dns",
-			Description: "DNS-Auflösung für example.com",
-			Severity:    "info",
-			GatewayIP:   "192.168.1.1",
-			ClientIP:    "192.168.1.100",
-		},
-		{
-			Timestamp:   time.Now().Add(-15 * time.Minute),
-			EventType:   "arp",
-			Description: "ARP-Anfrage für Gateway-MAC",
-			Severity:    "info",
-			GatewayIP:   "192.168.1.1",
-			ClientIP:    "192.168.1.100",
-		},
-	}
-
-	response := APIResponse{
-		Success: true,
-		Data:    events,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-}
-
 // respondWithError sendet eine Fehlerantwort an den Client
 func respondWithError(w http.ResponseWriter, statusCode int, message string) {
 	response := APIResponse{
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+// QueryPacketsHandler analysiert eine hochgeladene PCAP- oder PCAPNG-Datei und gibt die
+// dekodierten Pakete zurück, die zum Anzeigefilter im Formularfeld display_filter passen.
+// offset und limit blättern durch die passenden Pakete; matched zählt alle Treffer.
+func QueryPacketsHandler(w http.ResponseWriter, r *http.Request, capturer *packet.PcapCapturer) {
+	// Maximale Dateigröße wie beim Export (100 MB)
+	maxFileSize := int64(100 * 1024 * 1024)
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
+
+	if err := r.ParseMultipartForm(maxFileSize); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Datei zu groß oder ungültiges Format")
+		return
+	}
+
+	file, _, err := r.FormFile("pcap")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Keine PCAP-Datei in der Anfrage gefunden")
+		return
+	}
+	defer file.Close()
+
+	query := packet.PacketQuery{Limit: 1000}
+	if query.Filter, err = displayfilter.Compile(r.FormValue("display_filter")); err != nil {
+		respondWithFilterError(w, err)
+		return
+	}
+	for name, target := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		value := r.FormValue(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 || (name == "limit" && number == 0) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Ungültiger Wert für %s: '%s'", name, value))
+			return
+		}
+		*target = number
+	}
+	if query.Limit > packet.MaxQueryLimit {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("limit darf höchstens %d sein", packet.MaxQueryLimit))
+		return
+	}
+
+	result, err := capturer.QueryPackets(r.Context(), file, query)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Fehler bei der Abfrage: %v", err))
+		return
+	}
+
+	response := APIResponse{
+		Success: true,
+		Data:    result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+var (
+	// Fortlaufende Aufzeichnung der Live-Captures des Servers (nil = deaktiviert)
+	pcapArchive *packet.CaptureArchive
+	// Recorder je laufender Live-Session
+	archiveRecorders      = make(map[*packet.CaptureSession]*packet.ArchiveRecorder)
+	archiveRecordersMutex sync.Mutex
+)
+
+// InitPcapArchive legt die fortlaufende Aufzeichnung in pcap_dir an, falls sie aktiviert ist
+func InitPcapArchive(cfg *config.CaptureConfig) error {
+	if cfg.Archive == nil || !cfg.Archive.Enabled {
+		return nil
+	}
+
+	archive, err := packet.NewCaptureArchive(cfg.PCAPDir, cfg.Archive, cfg.SnapLen)
+	if err != nil {
+		return err
+	}
+	pcapArchive = archive
+
+	log.Printf("Fortlaufende Aufzeichnung aktiv: %s", cfg.PCAPDir)
+	return nil
+}
+
+// AttachArchiveRecorder zeichnet alle Pakete einer Live-Session des Servers fortlaufend auf.
+// Muss vor dem Start der Session aufgerufen werden.
+func AttachArchiveRecorder(session *packet.CaptureSession) {
+	if pcapArchive == nil {
+		return
+	}
+
+	archiveRecordersMutex.Lock()
+	defer archiveRecordersMutex.Unlock()
+
+	if _, exists := archiveRecorders[session]; exists {
+		return
+	}
+	recorder := pcapArchive.NewRecorder(session.Source())
+	session.AddPacketRecorder(recorder)
+	archiveRecorders[session] = recorder
+}
+
+// DetachArchiveRecorder schließt die aktuelle Datei einer beendeten Live-Session
+func DetachArchiveRecorder(session *packet.CaptureSession) {
+	archiveRecordersMutex.Lock()
+	recorder, exists := archiveRecorders[session]
+	delete(archiveRecorders, session)
+	archiveRecordersMutex.Unlock()
+
+	if !exists {
+		return
+	}
+	if err := recorder.Close(); err != nil {
+		log.Printf("Warnung: %v", err)
+	}
+}
+
+// ListPcapFilesHandler listet die fortlaufend aufgezeichneten Dateien des Servers
+func ListPcapFilesHandler(w http.ResponseWriter, r *http.Request) {
+	files := []packet.ArchiveFile{}
+	if pcapArchive != nil {
+		files = pcapArchive.List()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    files,
+	})
+}
+
+// DownloadPcapFileHandler liefert eine fortlaufend aufgezeichnete Datei des Servers
+func DownloadPcapFileHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["file"]
+	if pcapArchive == nil {
+		respondWithError(w, http.StatusNotFound, "Fortlaufende Aufzeichnung ist nicht aktiviert")
+		return
+	}
+
+	reader, file, err := pcapArchive.Open(name)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Datei '%s' nicht gefunden", name))
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Öffnen der Datei: %v", err))
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Type", file.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", file.Name))
+	w.Header().Set("Content-Length", strconv.FormatInt(file.Size, 10))
+
+	if _, err := io.Copy(w, reader); err != nil {
+		log.Printf("Fehler beim Senden der Datei %s: %v", name, err)
+	}
+}
+
+// ListAgentPcapFilesHandler listet die fortlaufend aufgezeichneten Dateien eines Agents
+func ListAgentPcapFilesHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	agentURL, ok := lookupAgentURL(w, name, version.CapabilityPcapArchive)
+	if !ok {
+		return
+	}
+	forwardAgentGet(w, r, name, agentURL+"/pcap-files")
+}
+
+// DownloadAgentPcapFileHandler lädt eine fortlaufend aufgezeichnete Datei über den Server vom Agent
+func DownloadAgentPcapFileHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	agentURL, ok := lookupAgentURL(w, name, version.CapabilityPcapArchive)
+	if !ok {
+		return
+	}
+	forwardAgentGet(w, r, name, agentURL+"/pcap-files/"+url.PathEscape(vars["file"]))
+}
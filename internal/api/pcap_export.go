@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+)
+
+// ExportAnnotatedPcapHandler analysiert eine hochgeladene PCAP- oder PCAPNG-Datei und liefert
+// sie als PCAPNG mit den Analyseergebnissen als Paketkommentare zurück. Das optionale
+// Formularfeld display_filter beschränkt den Export auf passende Pakete. Die Zusammenfassung
+// steht im Header X-Analysis-Summary.
+func ExportAnnotatedPcapHandler(w http.ResponseWriter, r *http.Request, capturer *packet.PcapCapturer) {
+	// Maximale Dateigröße wie beim Analyse-Upload (100 MB)
+	maxFileSize := int64(100 * 1024 * 1024)
+	r.Body = http.MaxBytesReader(w, r.Body, maxFileSize)
+
+	if err := r.ParseMultipartForm(maxFileSize); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Datei zu groß oder ungültiges Format")
+		return
+	}
+
+	file, fileHeader, err := r.FormFile("pcap")
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, "Keine PCAP-Datei in der Anfrage gefunden")
+		return
+	}
+	defer file.Close()
+
+	filter, err := displayfilter.Compile(r.FormValue("display_filter"))
+	if err != nil {
+		respondWithFilterError(w, err)
+		return
+	}
+
+	// Ergebnis zunächst in eine temporäre Datei schreiben, damit Lesefehler in der Mitte der
+	// Datei als Fehler gemeldet werden statt als abgeschnittener Download
+	output, err := os.CreateTemp("", "export-*.pcapng")
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Erstellen der temporären Datei")
+		return
+	}
+	defer os.Remove(output.Name())
+	defer output.Close()
+
+	summary, err := capturer.ExportAnnotatedPcapng(r.Context(), file, output, filter)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, fmt.Sprintf("Fehler beim Export der Datei: %v", err))
+		return
+	}
+
+	size, err := output.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = output.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Fehler beim Lesen der exportierten Datei")
+		return
+	}
+
+	summaryJSON, _ := json.Marshal(summary)
+	name := strings.TrimSuffix(filepath.Base(fileHeader.Filename), filepath.Ext(fileHeader.Filename)) + "-annotated.pcapng"
+
+	w.Header().Set("Content-Type", "application/x-pcapng")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.Header().Set("X-Analysis-Summary", string(summaryJSON))
+
+	if _, err := io.Copy(w, output); err != nil {
+		log.Printf("Fehler beim Senden der exportierten Datei: %v", err)
+	}
+}
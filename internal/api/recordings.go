@@ -0,0 +1,175 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+var (
+	// Aufzeichnungen der Live-Captures des Servers (nil = keine Trigger-Regeln)
+	recordingStore *packet.RecordingStore
+	// Vorlaufpuffer und Regelzustand der Live-Capture des Servers
+	liveTriggerRecorder *packet.TriggerRecorder
+	// Session, an der der Vorlaufpuffer hängt (nil = keine)
+	liveTriggerSession *packet.CaptureSession
+	liveTriggerMutex   sync.Mutex
+)
+
+// InitTriggerRecording legt die Ablage für ausgelöste Aufzeichnungen an, falls Trigger-Regeln aktiv sind
+func InitTriggerRecording(cfg *config.CaptureConfig) error {
+	if cfg.Triggers == nil || !cfg.Triggers.Enabled || len(cfg.Triggers.Rules) == 0 {
+		return nil
+	}
+
+	store, err := packet.NewRecordingStore(cfg.Triggers)
+	if err != nil {
+		return err
+	}
+	recordingStore = store
+	liveTriggerRecorder = packet.NewTriggerRecorder(cfg.Triggers, store, cfg.SnapLen)
+
+	log.Printf("Ereignisgesteuerte Aufzeichnung aktiv: %d Regeln", len(cfg.Triggers.Rules))
+	return nil
+}
+
+// AttachTriggerRecorder verbindet den Vorlaufpuffer mit einer Live-Session des Servers.
+// Muss vor dem Start der Session aufgerufen werden. Der Puffer hängt immer nur an einer
+// Session, damit Aufzeichnungen nur Pakete einer Schnittstelle enthalten.
+func AttachTriggerRecorder(session *packet.CaptureSession) {
+	liveTriggerMutex.Lock()
+	defer liveTriggerMutex.Unlock()
+
+	if liveTriggerRecorder == nil || liveTriggerSession != nil {
+		return
+	}
+	liveTriggerRecorder.SetSource("live", session.Source())
+	session.AddPacketRecorder(liveTriggerRecorder)
+	liveTriggerSession = session
+}
+
+// DetachTriggerRecorder gibt den Vorlaufpuffer nach dem Ende einer Live-Session frei und
+// schließt laufende Aufzeichnungen ab
+func DetachTriggerRecorder(session *packet.CaptureSession) {
+	liveTriggerMutex.Lock()
+	defer liveTriggerMutex.Unlock()
+
+	if liveTriggerSession != session {
+		return
+	}
+	liveTriggerSession = nil
+	liveTriggerRecorder.Stop()
+}
+
+// EvaluateTriggers prüft ein Paket einer Live-Session gegen die Trigger-Regeln und
+// erzeugt für jede ausgelöste Aufzeichnung ein Ereignis mit Downloadpfad.
+// Pakete von Sessions ohne Vorlaufpuffer werden ignoriert.
+func EvaluateTriggers(session *packet.CaptureSession, info *models.PacketInfo) {
+	liveTriggerMutex.Lock()
+	attached := liveTriggerSession == session
+	liveTriggerMutex.Unlock()
+	if !attached {
+		return
+	}
+
+	for _, rec := range liveTriggerRecorder.Evaluate(info) {
+		RecordEvent(models.GatewayEvent{
+			Timestamp:   rec.TriggeredAt,
+			EventType:   "capture_trigger",
+			Description: fmt.Sprintf("Live-Capture %s: Regel '%s' ausgelöst: %s", rec.Interface, rec.Rule, rec.Reason),
+			Severity:    rec.Severity,
+			Data: map[string]interface{}{
+				"interface":       rec.Interface,
+				"rule":            rec.Rule,
+				"reason":          rec.Reason,
+				"recording_id":    rec.ID,
+				"recording_url":   "/api/recordings/" + rec.ID,
+				"recording_from":  rec.From,
+				"recording_until": rec.Until,
+			},
+		})
+	}
+}
+
+// ListRecordingsHandler listet die Aufzeichnungen der Live-Captures des Servers
+func ListRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	recordings := []packet.TriggerRecording{}
+	if recordingStore != nil {
+		recordings = recordingStore.List()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{
+		Success: true,
+		Data:    recordings,
+	})
+}
+
+// DownloadRecordingHandler liefert die PCAP-Datei einer abgeschlossenen Aufzeichnung des Servers
+func DownloadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if recordingStore == nil {
+		respondWithError(w, http.StatusNotFound, "Ereignisgesteuerte Aufzeichnung ist nicht aktiviert")
+		return
+	}
+
+	file, rec, err := recordingStore.Open(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		respondWithError(w, http.StatusNotFound, fmt.Sprintf("Aufzeichnung '%s' nicht gefunden", id))
+		return
+	case errors.Is(err, packet.ErrRecordingInProgress):
+		respondWithError(w, http.StatusConflict,
+			fmt.Sprintf("Aufzeichnung '%s' läuft noch bis %s", id, rec.Until.Format("15:04:05")))
+		return
+	case err != nil:
+		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler beim Öffnen der Aufzeichnung: %v", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pcap\"", rec.ID))
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+
+	if _, err := io.Copy(w, file); err != nil {
+		log.Printf("Fehler beim Senden der Aufzeichnung %s: %v", id, err)
+	}
+}
+
+// ListAgentRecordingsHandler listet die ausgelösten Aufzeichnungen eines Agents
+func ListAgentRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	agentURL, ok := lookupAgentURL(w, name, version.CapabilityTriggerRecording)
+	if !ok {
+		return
+	}
+	forwardAgentGet(w, r, name, agentURL+"/recordings")
+}
+
+// DownloadAgentRecordingHandler lädt die PCAP-Datei einer Aufzeichnung über den Server vom Agent
+func DownloadAgentRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	agentURL, ok := lookupAgentURL(w, name, version.CapabilityTriggerRecording)
+	if !ok {
+		return
+	}
+	forwardAgentGet(w, r, name, agentURL+"/recordings/"+url.PathEscape(vars["id"]))
+}
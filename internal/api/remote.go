@@ -6,23 +6,76 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"sync"
 	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/packet"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
 )
 
 // RemoteAgent enthält Informationen zu einem Remote-Capture-Agent
 type RemoteAgent struct {
 	Name             string                   `json:"name"`
 	URL              string                   `json:"url"`
-	Status           string                   `json:"status"` // "online", "offline", "capturing"
+	Status           string                   `json:"status"` // "online", "offline", "scheduled", "capturing"
 	LastSeen         time.Time                `json:"last_seen"`
 	Interfaces       []string                 `json:"interfaces"`
 	InterfaceDetails []map[string]interface{} `json:"interface_details"`
 	ActiveInterface  string                   `json:"active_interface"`
 	PacketsCaptured  int                      `json:"packets_captured"`
+	SessionID        string                   `json:"session_id,omitempty"`
+	CaptureStarted   time.Time                `json:"capture_started,omitempty"` // in Server-Zeit
 	Version          string                   `json:"version"`
 	OS               string                   `json:"os"`
+	Arch             string                   `json:"arch,omitempty"`
 	Hostname         string                   `json:"hostname"`
+	RegisteredAt     time.Time                `json:"registered_at"`
+	StatusHistory    []AgentStatusChange      `json:"status_history,omitempty"`
+
+	// Gruppe und Stand der vom Server verteilten Konfiguration
+	Group                string `json:"group,omitempty"`
+	AppliedConfigVersion int    `json:"applied_config_version"`
+	ConfigError          string `json:"config_error,omitempty"`
+
+	// Fehler der letzten automatischen Aktualisierung (z.B. nach einem Rollback)
+	UpdateError string `json:"update_error,omitempty"`
+
+	// Ausgehandelte Protokollversion, angekündigte Fähigkeiten und Hinweis auf veraltete Agents
+	ProtocolVersion int      `json:"protocol_version"`
+	Capabilities    []string `json:"capabilities"`
+	Outdated        bool     `json:"outdated"`
+	OutdatedReason  string   `json:"outdated_reason,omitempty"`
+
+	// Einzelne Captures des Agents (mehrere Schnittstellen gleichzeitig möglich)
+	Captures []AgentCapture `json:"captures,omitempty"`
+
+	// Summe der Empfangs- und Verlustzähler aller Captures
+	Stats packet.CaptureStats `json:"stats"`
+
+	// Uhrenversatz des Agents relativ zum Server
+	ClockOffset        *ClockEstimate      `json:"clock_offset,omitempty"`
+	ClockOffsetHistory []ClockOffsetSample `json:"clock_offset_history,omitempty"`
+}
+
+// AgentCapture beschreibt eine einzelne, benannte Capture auf einem Remote-Agent
+type AgentCapture struct {
+	Name            string    `json:"name"`
+	Interface       string    `json:"interface"`
+	Filter          string    `json:"filter,omitempty"`
+	Status          string    `json:"status"` // "scheduled", "capturing", "paused", "completed"
+	SessionID       string    `json:"session_id,omitempty"`
+	CaptureStarted  time.Time `json:"capture_started,omitempty"` // in Server-Zeit
+	PacketsCaptured int       `json:"packets_captured"`
+	BytesCaptured   int64     `json:"bytes_captured"`
+	Error           string    `json:"error,omitempty"`
+
+	// Ende einer begrenzten Capture (in Server-Zeit) und die erreichte Grenze
+	CaptureEnded time.Time `json:"capture_ended,omitempty"`
+	StopReason   string    `json:"stop_reason,omitempty"`
+
+	Stats packet.CaptureStats `json:"stats"`
 }
 
 // AgentRegistration enthält die Informationen für die Agentenregistrierung
@@ -33,7 +86,11 @@ type AgentRegistration struct {
 	InterfaceDetails []map[string]interface{} `json:"interface_details"`
 	Version          string                   `json:"version"`
 	OS               string                   `json:"os"`
+	Arch             string                   `json:"arch,omitempty"`
 	Hostname         string                   `json:"hostname"`
+	Group            string                   `json:"group,omitempty"`
+	ProtocolVersion  int                      `json:"protocol_version"`
+	Capabilities     []string                 `json:"capabilities"`
 }
 
 var (
@@ -61,30 +118,60 @@ func RegisterAgentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Neuen Agent erstellen oder bestehenden aktualisieren
-	agent := &RemoteAgent{
-		Name:             reg.Name,
-		URL:              reg.URL,
-		Status:           "online",
-		LastSeen:         time.Now(),
-		Interfaces:       reg.Interfaces,
-		InterfaceDetails: reg.InterfaceDetails,
-		Version:          reg.Version,
-		OS:               reg.OS,
-		Hostname:         reg.Hostname,
+	// Inkompatible Agents mit einer verständlichen Meldung abweisen
+	if err := checkAgentCompatibility(&reg); err != nil {
+		log.Printf("Registrierung abgelehnt: %v", err)
+		respondWithError(w, http.StatusUpgradeRequired, err.Error())
+		return
 	}
 
-	// In der Map speichern
+	// Bestehenden Agent aktualisieren, damit Uhrenversatz-Historie, Captures und Zähler
+	// eine erneute Registrierung (z.B. nach einem Verbindungsabbruch) überstehen
 	remoteAgentsMutex.Lock()
-	remoteAgents[reg.Name] = agent
+	agent, exists := remoteAgents[reg.Name]
+	if !exists {
+		agent = &RemoteAgent{Name: reg.Name}
+		remoteAgents[reg.Name] = agent
+	}
+	agent.URL = reg.URL
+	agent.LastSeen = time.Now()
+	agent.Interfaces = reg.Interfaces
+	agent.InterfaceDetails = reg.InterfaceDetails
+	agent.Version = reg.Version
+	agent.OS = reg.OS
+	agent.Arch = reg.Arch
+	agent.Hostname = reg.Hostname
+	agent.RegisteredAt = time.Now()
+	agent.Group = reg.Group
+	agent.ProtocolVersion = reg.ProtocolVersion
+	agent.Capabilities = reg.Capabilities
+	updateAgentCompatibility(agent)
+	setAgentStatus(agent, "online", "Registrierung")
+	outdated, outdatedReason := agent.Outdated, agent.OutdatedReason
+	supportsRemoteConfig := agentSupports(agent, version.CapabilityRemoteConfig)
 	remoteAgentsMutex.Unlock()
 
-	log.Printf("Agent '%s' registriert: %s", reg.Name, reg.URL)
+	persistAgentRegistry()
 
-	// Erfolgreiche Antwort senden
+	log.Printf("Agent '%s' registriert: %s (Version %s, Protokoll %d)", reg.Name, reg.URL, reg.Version, reg.ProtocolVersion)
+	if outdated {
+		log.Printf("Agent '%s' ist veraltet: %s", reg.Name, outdatedReason)
+	}
+
+	// Erfolgreiche Antwort mit der Protokollversion des Servers und, falls unterstützt,
+	// der aktuellen Konfiguration des Agents senden
+	data := map[string]interface{}{
+		"protocol_version": version.ProtocolVersion,
+		"server_version":   version.Version,
+	}
+	if supportsRemoteConfig {
+		settings, _ := desiredAgentConfig(reg.Name, reg.Group)
+		data["config"] = settings
+	}
 	response := APIResponse{
 		Success: true,
 		Message: fmt.Sprintf("Agent '%s' erfolgreich registriert", reg.Name),
+		Data:    data,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -117,6 +204,8 @@ func UnregisterAgentHandler(w http.ResponseWriter, r *http.Request) {
 	delete(remoteAgents, req.Name)
 	remoteAgentsMutex.Unlock()
 
+	persistAgentRegistry()
+
 	log.Printf("Agent '%s' abgemeldet", req.Name)
 
 	// Erfolgreiche Antwort senden
@@ -135,12 +224,33 @@ func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
 	// apiKey := r.Header.Get("X-API-Key")
 	// TODO: Implementiere richtige Validierung des API-Keys
 
+	// Empfangszeitpunkt für die Uhrenversatz-Messung (t1)
+	receivedAt := time.Now()
+
 	// Request-Body parsen
 	var req struct {
-		Name            string `json:"name"`
-		Status          string `json:"status"`
-		PacketsCaptured int    `json:"packets_captured"`
-		Interface       string `json:"interface"`
+		Name            string         `json:"name"`
+		Status          string         `json:"status"`
+		PacketsCaptured int            `json:"packets_captured"`
+		Interface       string         `json:"interface"`
+		SessionID       string         `json:"session_id"`
+		CaptureStarted  time.Time      `json:"capture_started"`
+		Captures        []AgentCapture `json:"captures"`
+
+		// Zähler und seit dem letzten Heartbeat aufgetretene Ereignisse (z.B. Paketverluste)
+		Stats  packet.CaptureStats   `json:"stats"`
+		Events []models.GatewayEvent `json:"events,omitempty"`
+
+		// Sendezeitpunkt dieses Heartbeats und vollständige Messung des vorherigen Austauschs
+		AgentSendTime time.Time    `json:"agent_send_time"`
+		ClockSample   *ClockSample `json:"clock_sample,omitempty"`
+
+		// Vom Agent angewendete Konfigurationsversion und ggf. Fehler der letzten Anwendung
+		AppliedConfigVersion int    `json:"applied_config_version"`
+		ConfigError          string `json:"config_error,omitempty"`
+
+		// Fehler der letzten automatischen Aktualisierung
+		UpdateError string `json:"update_error,omitempty"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
@@ -154,12 +264,16 @@ func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Agent in der Map aktualisieren
+	statusChanged := false
+	group := ""
+	var agentVersion, goos, arch string
+	var supportsConfig, supportsUpdate bool
 	remoteAgentsMutex.Lock()
 	agent, exists := remoteAgents[req.Name]
 	if exists {
 		agent.LastSeen = time.Now()
 		if req.Status != "" {
-			agent.Status = req.Status
+			statusChanged = setAgentStatus(agent, req.Status, "Heartbeat")
 		}
 
 		// Interface und Paketzähler aktualisieren, wenn sie im Heartbeat enthalten sind
@@ -167,8 +281,60 @@ func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
 			agent.ActiveInterface = req.Interface
 		}
 
-		// Paketzähler aktualisieren
+		// Paketzähler und Session-Zugehörigkeit aktualisieren
 		agent.PacketsCaptured = req.PacketsCaptured
+		agent.SessionID = req.SessionID
+
+		// Stand der Konfiguration übernehmen
+		if agent.AppliedConfigVersion != req.AppliedConfigVersion || agent.ConfigError != req.ConfigError {
+			agent.AppliedConfigVersion = req.AppliedConfigVersion
+			agent.ConfigError = req.ConfigError
+			statusChanged = true
+		}
+		group = agent.Group
+		agentVersion, goos, arch = agent.Version, agent.OS, agent.Arch
+		supportsConfig = agentSupports(agent, version.CapabilityRemoteConfig)
+		supportsUpdate = agentSupports(agent, version.CapabilitySelfUpdate)
+
+		if agent.UpdateError != req.UpdateError {
+			agent.UpdateError = req.UpdateError
+			statusChanged = true
+		}
+
+		// Uhrenversatz aus dem vorherigen Heartbeat-Austausch schätzen
+		if req.ClockSample != nil {
+			if err := addClockSample(agent, *req.ClockSample); err != nil {
+				log.Printf("Ungültige Zeitmessung von Agent %s verworfen: %v", req.Name, err)
+			}
+		}
+
+		// Zeitstempel des Agents in Server-Zeit umrechnen
+		offset := agentClockOffset(agent)
+		agent.CaptureStarted = time.Time{}
+		if !req.CaptureStarted.IsZero() {
+			agent.CaptureStarted = req.CaptureStarted.Add(offset)
+		}
+		for i := range req.Captures {
+			if !req.Captures[i].CaptureStarted.IsZero() {
+				req.Captures[i].CaptureStarted = req.Captures[i].CaptureStarted.Add(offset)
+			}
+			if !req.Captures[i].CaptureEnded.IsZero() {
+				req.Captures[i].CaptureEnded = req.Captures[i].CaptureEnded.Add(offset)
+			}
+		}
+		agent.Captures = req.Captures
+		agent.Stats = req.Stats
+
+		for i := range req.Events {
+			req.Events[i].Timestamp = req.Events[i].Timestamp.Add(offset)
+			if data, ok := req.Events[i].Data.(map[string]interface{}); ok {
+				data["agent"] = req.Name
+				// Ausgelöste Aufzeichnungen über den Server herunterladbar machen
+				if id, ok := data["recording_id"].(string); ok && id != "" {
+					data["recording_url"] = fmt.Sprintf("/api/agents/%s/recordings/%s", url.PathEscape(req.Name), url.PathEscape(id))
+				}
+			}
+		}
 
 		// Füge Logausgabe für Debug-Zwecke hinzu
 		log.Printf("Heartbeat von Agent %s erhalten: Status=%s, Pakete=%d, Interface=%s",
@@ -181,21 +347,58 @@ func HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Erfolgreiche Antwort senden
+	// Ereignisse des Agents außerhalb des Locks übernehmen
+	for _, event := range req.Events {
+		RecordEvent(event)
+	}
+
+	// Statuswechsel sofort speichern, reine Lebenszeichen übernimmt CheckAgentsStatus
+	if statusChanged {
+		persistAgentRegistry()
+	}
+
+	// Erfolgreiche Antwort mit den Server-Zeitstempeln (t1, t2) für die Offset-Messung senden
+	data := map[string]interface{}{
+		"agent_send_time":     req.AgentSendTime,
+		"server_receive_time": receivedAt,
+	}
+
+	// Neue Konfiguration nur mitsenden, wenn der Agent eine andere Version angewendet hat
+	if supportsConfig {
+		if settings, _ := desiredAgentConfig(req.Name, group); settings != nil && settings.Version != req.AppliedConfigVersion {
+			data["config"] = settings
+		}
+	}
+
+	// Neue Agent-Version ankündigen, falls eine Zielversion gesetzt ist
+	if supportsUpdate {
+		if update := desiredAgentUpdate(agentVersion, goos, arch); update != nil {
+			data["update"] = update
+		}
+	}
+
+	data["server_send_time"] = time.Now()
 	response := APIResponse{
 		Success: true,
+		Data:    data,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
 
-// ListAgentsHandler gibt eine Liste aller registrierten Agents zurück
+// ListAgentsHandler gibt eine Liste aller registrierten Agents zurück.
+// Mit ?outdated=true nur Agents, deren Protokoll oder Version älter als die des Servers ist.
 func ListAgentsHandler(w http.ResponseWriter, r *http.Request) {
+	onlyOutdated := r.URL.Query().Get("outdated") == "true"
+
 	// Alle Agents aus der Map abrufen
 	remoteAgentsMutex.RLock()
 	agents := make([]*RemoteAgent, 0, len(remoteAgents))
 	for _, agent := range remoteAgents {
+		if onlyOutdated && !agent.Outdated {
+			continue
+		}
 		agents = append(agents, agent)
 	}
 	remoteAgentsMutex.RUnlock()
@@ -217,6 +420,7 @@ func StartAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 		Name      string `json:"name"`
 		Interface string `json:"interface"`
 		Filter    string `json:"filter,omitempty"`
+		Capture   string `json:"capture,omitempty"` // Name der Capture auf dem Agent, Standard ist die Schnittstelle
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
@@ -232,6 +436,13 @@ func StartAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 	// Agent in der Map finden
 	remoteAgentsMutex.RLock()
 	agent, exists := remoteAgents[req.Name]
+	var multiInterface, busy bool
+	var agentVersion string
+	if exists {
+		multiInterface = agentSupports(agent, version.CapabilityMultiInterface)
+		busy = len(agent.Captures) > 0 || agent.Status == "capturing"
+		agentVersion = agent.Version
+	}
 	remoteAgentsMutex.RUnlock()
 
 	if !exists {
@@ -239,11 +450,20 @@ func StartAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Agents ohne Mehrfach-Capture erlauben nur eine unbenannte Capture gleichzeitig
+	if !multiInterface && (busy || req.Capture != "") {
+		respondCapabilityNotSupported(w, req.Name, agentVersion, version.CapabilityMultiInterface)
+		return
+	}
+
 	// Capture-Anfrage an den Agent senden
 	captureReq := map[string]string{
 		"interface": req.Interface,
 		"filter":    req.Filter,
 	}
+	if multiInterface {
+		captureReq["name"] = req.Capture
+	}
 	jsonData, err := json.Marshal(captureReq)
 	if err != nil {
 		respondWithError(w, http.StatusInternalServerError, "Fehler bei der JSON-Kodierung")
@@ -272,8 +492,9 @@ func StartAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 	// Status des Agents aktualisieren
 	if agentResp.Success {
 		remoteAgentsMutex.Lock()
-		agent.Status = "capturing"
+		setAgentStatus(agent, "capturing", "Capture gestartet")
 		remoteAgentsMutex.Unlock()
+		persistAgentRegistry()
 	}
 
 	// Antwort des Agents weiterleiten
@@ -285,7 +506,9 @@ func StartAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 func StopAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 	// Request-Body parsen
 	var req struct {
-		Name string `json:"name"`
+		Name      string `json:"name"`
+		Interface string `json:"interface,omitempty"` // nur Captures auf dieser Schnittstelle stoppen
+		Capture   string `json:"capture,omitempty"`   // nur die Capture mit diesem Namen stoppen
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
@@ -308,30 +531,31 @@ func StopAgentCaptureHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// URL zusammensetzen
-	url := fmt.Sprintf("%s/capture/stop", agent.URL)
-
-	// HTTP-Request senden
-	httpClient := &http.Client{Timeout: 10 * time.Second}
-	resp, err := httpClient.Post(url, "application/json", http.NoBody)
+	// Stop-Anfrage an den Agent senden; ohne Angaben stoppt der Agent alle Captures
+	agentResp, err := postToAgent(agent.URL, "/capture/stop", map[string]string{
+		"name":      req.Capture,
+		"interface": req.Interface,
+	})
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Fehler bei der Kommunikation mit dem Agent: %v", err))
-		return
-	}
-	defer resp.Body.Close()
-
-	// Antwort des Agents parsen
-	var agentResp APIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&agentResp); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Fehler beim Parsen der Agent-Antwort")
+		respondWithError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	// Status des Agents aktualisieren
+	// Status des Agents aktualisieren; laufen noch weitere Captures, bleibt er "capturing"
 	if agentResp.Success {
+		remaining := ""
+		if data, ok := agentResp.Data.(map[string]interface{}); ok {
+			remaining, _ = data["status"].(string)
+		}
+
 		remoteAgentsMutex.Lock()
-		agent.Status = "online"
+		if remaining == "capturing" || remaining == "scheduled" {
+			setAgentStatus(agent, remaining, "Capture gestoppt")
+		} else {
+			setAgentStatus(agent, "online", "Capture gestoppt")
+		}
 		remoteAgentsMutex.Unlock()
+		persistAgentRegistry()
 	}
 
 	// Antwort des Agents weiterleiten
@@ -350,14 +574,65 @@ func CheckAgentsStatus() {
 			// Wenn ein Agent seit mehr als 2 Minuten keinen Heartbeat gesendet hat,
 			// markieren wir ihn als offline
 			if time.Since(agent.LastSeen) > 2*time.Minute {
-				agent.Status = "offline"
-				log.Printf("Agent '%s' ist offline (kein Heartbeat seit %v)", name, time.Since(agent.LastSeen))
+				if setAgentStatus(agent, "offline", "Heartbeat-Timeout") {
+					log.Printf("Agent '%s' ist offline (kein Heartbeat seit %v)", name, time.Since(agent.LastSeen))
+				}
 			}
 		}
 		remoteAgentsMutex.Unlock()
+
+		// Letzte Lebenszeichen und Paketzähler regelmäßig sichern
+		persistAgentRegistry()
 	}
 }
 
+// UpdateAgentInterfacesHandler übernimmt die vom Agent gemeldete Schnittstellenliste,
+// z.B. nach einem Link-Ausfall oder einer Adressänderung
+func UpdateAgentInterfacesHandler(w http.ResponseWriter, r *http.Request) {
+	// Request-Body parsen
+	var req struct {
+		Name             string                   `json:"name"`
+		Interfaces       []string                 `json:"interfaces"`
+		InterfaceDetails []map[string]interface{} `json:"interface_details"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondWithError(w, http.StatusBadRequest, "Ungültiges Anfrageformat")
+		return
+	}
+
+	// Agent-Namen validieren
+	if req.Name == "" {
+		respondWithError(w, http.StatusBadRequest, "Agent-Name ist erforderlich")
+		return
+	}
+
+	remoteAgentsMutex.Lock()
+	agent, exists := remoteAgents[req.Name]
+	if exists {
+		agent.Interfaces = req.Interfaces
+		agent.InterfaceDetails = req.InterfaceDetails
+		agent.LastSeen = time.Now()
+	}
+	remoteAgentsMutex.Unlock()
+
+	if !exists {
+		respondWithError(w, http.StatusNotFound, "Agent nicht registriert")
+		return
+	}
+
+	persistAgentRegistry()
+
+	log.Printf("Schnittstellen von Agent '%s' aktualisiert: %v", req.Name, req.Interfaces)
+
+	response := APIResponse{
+		Success: true,
+		Message: fmt.Sprintf("Schnittstellen von Agent '%s' aktualisiert", req.Name),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
 // SetInterfaceHandler verarbeitet Anfragen zum Setzen der aktiven Schnittstellte auf einem Agent
 func SetInterfaceHandler(w http.ResponseWriter, r *http.Request) {
 	// Request-Body parsen
@@ -434,9 +709,32 @@ func SetInterfaceHandler(w http.ResponseWriter, r *http.Request) {
 		remoteAgentsMutex.Lock()
 		agent.ActiveInterface = req.Interface
 		remoteAgentsMutex.Unlock()
+		persistAgentRegistry()
 	}
 
 	// Antwort des Agents weiterleiten
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(agentResp)
 }
+
+// postToAgent sendet eine JSON-Anfrage an einen Agent und gibt dessen Antwort zurück
+func postToAgent(agentURL, path string, payload interface{}) (*APIResponse, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("Fehler bei der JSON-Kodierung: %w", err)
+	}
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Post(agentURL+path, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("Fehler bei der Kommunikation mit dem Agent: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var agentResp APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&agentResp); err != nil {
+		return nil, fmt.Errorf("Fehler beim Parsen der Agent-Antwort: %w", err)
+	}
+
+	return &agentResp, nil
+}
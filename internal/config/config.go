@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Config repräsentiert die Hauptkonfiguration der Anwendung
@@ -26,6 +28,9 @@ type ServerConfig struct {
 	EnableWebSocket bool   `json:"enable_websocket"`
 	EnableCORS      bool   `json:"enable_cors"`
 	StaticDir       string `json:"static_dir"`
+
+	// Server per mDNS ankündigen und Agents im LAN suchen
+	EnableDiscovery bool `json:"enable_discovery"`
 }
 
 // CaptureConfig enthält die Konfiguration für die Paketerfassung
@@ -37,6 +42,263 @@ type CaptureConfig struct {
 	Filter      string `json:"filter"`
 	BufferSize  int    `json:"buffer_size"`
 	EnableLive  bool   `json:"enable_live"`
+
+	// Verlustrate (0..1), ab der eine Warnung erzeugt wird; 0 deaktiviert die Warnung
+	DropWarningThreshold float64 `json:"drop_warning_threshold"`
+
+	// Verhalten bei voller Verarbeitungswarteschlange während einer Live-Capture:
+	// "drop_newest" (Standard), "drop_oldest", "sample" oder "block".
+	// PCAP-Dateien werden immer vollständig ("block") gelesen.
+	BackpressurePolicy string `json:"backpressure_policy"`
+	// Größe der Warteschlange zwischen Erfassung und Verarbeitung (Pakete)
+	ChannelSize int `json:"channel_size"`
+	// Bei "sample": ab halb voller Warteschlange nur jedes n-te Paket weiterleiten
+	SampleRate int `json:"sample_rate"`
+	// Anzahl paralleler Dekodier-Worker pro Capture (0 oder 1 = Dekodierung in der Erfassung).
+	// Pakete derselben Verbindung landen immer beim selben Worker und bleiben in Reihenfolge.
+	DecodeWorkers int `json:"decode_workers"`
+
+	// Backend für Live-Captures: "pcap" (Standard, libpcap) oder "afpacket" (nur Linux,
+	// TPACKET_V3-Ringpuffer, optional verteilt auf mehrere Sockets)
+	Backend string `json:"backend"`
+	// Einstellungen für das AF_PACKET-Backend; nil = Standardwerte
+	AFPacket *AFPacketConfig `json:"afpacket,omitempty"`
+
+	// Regeln, die bei bestimmten Paketen eine PCAP-Aufzeichnung auf dem erfassenden Knoten starten
+	Triggers *TriggerConfig `json:"triggers,omitempty"`
+
+	// Fortlaufende Aufzeichnung aller Live-Captures in pcap_dir
+	Archive *ArchiveConfig `json:"archive,omitempty"`
+}
+
+// Obergrenze für decode_workers
+const maxDecodeWorkers = 64
+
+// Backends für Live-Captures
+const (
+	CaptureBackendPcap     = "pcap"
+	CaptureBackendAFPacket = "afpacket"
+)
+
+// AFPacketConfig enthält die Ringpuffer- und Fanout-Einstellungen des AF_PACKET-Backends.
+// Jeder Socket erhält einen eigenen Ring aus NumBlocks Blöcken zu BlockSize Bytes.
+type AFPacketConfig struct {
+	// Anzahl der Sockets in der Fanout-Gruppe (0 oder 1 = ein Socket ohne Fanout)
+	FanoutSockets int `json:"fanout_sockets"`
+	// Verteilung auf die Sockets: "hash" (Standard, nach Verbindung), "lb" (reihum) oder "cpu"
+	FanoutMode string `json:"fanout_mode"`
+	// Blockgröße in Bytes, Vielfaches der Seitengröße (Standard 1 MiB)
+	BlockSize int `json:"block_size"`
+	// Anzahl der Blöcke pro Ring (Standard 64)
+	NumBlocks int `json:"num_blocks"`
+	// Zeit, nach der ein nicht voller Block an die Anwendung übergeben wird (Standard 64 ms)
+	BlockTimeoutMs int `json:"block_timeout_ms"`
+}
+
+// Fanout-Modi des AF_PACKET-Backends
+const (
+	FanoutModeHash        = "hash"
+	FanoutModeLoadBalance = "lb"
+	FanoutModeCPU         = "cpu"
+)
+
+// Obergrenze für fanout_sockets
+const maxFanoutSockets = 64
+
+// Validate prüft die AF_PACKET-Einstellungen
+func (a *AFPacketConfig) Validate() error {
+	if a.FanoutSockets < 0 || a.FanoutSockets > maxFanoutSockets {
+		return fmt.Errorf("fanout_sockets muss zwischen 0 und %d liegen (ist %d)", maxFanoutSockets, a.FanoutSockets)
+	}
+	switch a.FanoutMode {
+	case "", FanoutModeHash, FanoutModeLoadBalance, FanoutModeCPU:
+	default:
+		return fmt.Errorf("unbekannter fanout_mode '%s'", a.FanoutMode)
+	}
+	if a.BlockSize < 0 || a.BlockSize%4096 != 0 {
+		return fmt.Errorf("block_size muss ein Vielfaches von 4096 sein (ist %d)", a.BlockSize)
+	}
+	if a.NumBlocks < 0 {
+		return fmt.Errorf("num_blocks darf nicht negativ sein (ist %d)", a.NumBlocks)
+	}
+	if a.BlockTimeoutMs < 0 {
+		return fmt.Errorf("block_timeout_ms darf nicht negativ sein (ist %d)", a.BlockTimeoutMs)
+	}
+	return nil
+}
+
+// Backpressure-Richtlinien für die Paketwarteschlange
+const (
+	BackpressureBlock      = "block"       // warten, bis die Verarbeitung aufholt
+	BackpressureDropNewest = "drop_newest" // neues Paket verwerfen
+	BackpressureDropOldest = "drop_oldest" // ältestes wartendes Paket verwerfen
+	BackpressureSample     = "sample"      // unter Last nur jedes n-te Paket weiterleiten
+)
+
+// Validate prüft die Capture-Einstellungen zur Backpressure
+func (c *CaptureConfig) Validate() error {
+	switch c.BackpressurePolicy {
+	case "", BackpressureBlock, BackpressureDropNewest, BackpressureDropOldest, BackpressureSample:
+	default:
+		return fmt.Errorf("unbekannte backpressure_policy '%s'", c.BackpressurePolicy)
+	}
+	if c.ChannelSize < 0 {
+		return fmt.Errorf("channel_size darf nicht negativ sein (ist %d)", c.ChannelSize)
+	}
+	if c.SampleRate < 0 {
+		return fmt.Errorf("sample_rate darf nicht negativ sein (ist %d)", c.SampleRate)
+	}
+	if c.DecodeWorkers < 0 || c.DecodeWorkers > maxDecodeWorkers {
+		return fmt.Errorf("decode_workers muss zwischen 0 und %d liegen (ist %d)", maxDecodeWorkers, c.DecodeWorkers)
+	}
+	switch c.Backend {
+	case "", CaptureBackendPcap, CaptureBackendAFPacket:
+	default:
+		return fmt.Errorf("unbekanntes backend '%s'", c.Backend)
+	}
+	if c.AFPacket != nil {
+		if err := c.AFPacket.Validate(); err != nil {
+			return fmt.Errorf("afpacket: %w", err)
+		}
+	}
+	if c.Triggers != nil {
+		if err := c.Triggers.Validate(); err != nil {
+			return fmt.Errorf("triggers: %w", err)
+		}
+	}
+	if c.Archive != nil {
+		if err := c.Archive.Validate(); err != nil {
+			return fmt.Errorf("archive: %w", err)
+		}
+	}
+	return nil
+}
+
+// ArchiveConfig enthält die Einstellungen der fortlaufenden Aufzeichnung von Live-Captures.
+// Jede Capture schreibt in eigene, rotierende Dateien; die Aufbewahrung gilt für alle Dateien.
+type ArchiveConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Format       string `json:"format"`         // "pcapng" (Standard) oder "pcap"
+	MaxFileSize  int64  `json:"max_file_size"`  // Rotation nach dieser Dateigröße in Bytes
+	MaxFileAge   int    `json:"max_file_age"`   // Rotation nach dieser Dauer in Sekunden
+	MaxFiles     int    `json:"max_files"`      // Höchstzahl an Dateien, 0 = unbegrenzt
+	MaxTotalSize int64  `json:"max_total_size"` // Disk-Quota für alle Dateien in Bytes
+}
+
+// Dateiformate der fortlaufenden Aufzeichnung
+const (
+	ArchiveFormatPcapng = "pcapng"
+	ArchiveFormatPcap   = "pcap"
+)
+
+// Validate prüft die Einstellungen der fortlaufenden Aufzeichnung
+func (a *ArchiveConfig) Validate() error {
+	switch a.Format {
+	case "", ArchiveFormatPcapng, ArchiveFormatPcap:
+	default:
+		return fmt.Errorf("unbekanntes format '%s'", a.Format)
+	}
+	if a.MaxFileSize < 0 {
+		return fmt.Errorf("max_file_size darf nicht negativ sein (ist %d)", a.MaxFileSize)
+	}
+	if a.MaxFileAge < 0 {
+		return fmt.Errorf("max_file_age darf nicht negativ sein (ist %d)", a.MaxFileAge)
+	}
+	if a.MaxFiles < 0 {
+		return fmt.Errorf("max_files darf nicht negativ sein (ist %d)", a.MaxFiles)
+	}
+	if a.MaxTotalSize < 0 {
+		return fmt.Errorf("max_total_size darf nicht negativ sein (ist %d)", a.MaxTotalSize)
+	}
+	return nil
+}
+
+// TriggerConfig enthält die Regeln für ereignisgesteuerte Aufzeichnungen. Jede Capture hält die
+// Pakete der letzten Sekunden im Speicher; trifft eine Regel zu, werden sie zusammen mit den
+// folgenden Paketen in eine PCAP-Datei geschrieben, die dem ausgelösten Ereignis beiliegt.
+type TriggerConfig struct {
+	Enabled        bool          `json:"enabled"`
+	Dir            string        `json:"dir"`              // Verzeichnis für die Aufzeichnungen
+	MaxBufferBytes int64         `json:"max_buffer_bytes"` // Speichergrenze des Vorlaufpuffers pro Capture
+	MaxTotalSize   int64         `json:"max_total_size"`   // Disk-Quota für alle Aufzeichnungen in Bytes
+	Rules          []TriggerRule `json:"rules"`
+}
+
+// Arten von Trigger-Regeln
+const (
+	TriggerTypeMatch            = "match"              // jedes Paket, das den Bedingungen entspricht
+	TriggerTypeNewDHCPServer    = "new_dhcp_server"    // DHCP-Angebot eines bisher unbekannten Servers
+	TriggerTypeGatewayMACChange = "gateway_mac_change" // ARP-Antwort eines Gateways mit neuer MAC-Adresse
+)
+
+// TriggerRule beschreibt eine Bedingung, die eine Aufzeichnung auslöst. Die optionalen
+// Paketbedingungen gelten für alle Arten und müssen gemeinsam zutreffen.
+type TriggerRule struct {
+	Name string `json:"name"`
+	Type string `json:"type"` // "match", "new_dhcp_server" oder "gateway_mac_change"
+
+	Protocol        string `json:"protocol,omitempty"`          // z.B. "DHCP", "ARP", "TCP"
+	IP              string `json:"ip,omitempty"`                // Quell- oder Zieladresse, bei "gateway_mac_change" das Gateway
+	Port            uint16 `json:"port,omitempty"`              // Quell- oder Zielport
+	DHCPMessageType string `json:"dhcp_message_type,omitempty"` // z.B. "OFFER"
+	DNSName         string `json:"dns_name,omitempty"`          // Teil eines angefragten DNS-Namens
+
+	// Erlaubte DHCP-Server für "new_dhcp_server"; ohne Angabe gilt der erste gesehene Server als bekannt
+	KnownDHCPServers []string `json:"known_dhcp_servers,omitempty"`
+
+	PreSeconds      int    `json:"pre_seconds"`      // Vorlauf aus dem Speicherpuffer
+	PostSeconds     int    `json:"post_seconds"`     // Aufzeichnungsdauer nach dem Auslösen
+	CooldownSeconds int    `json:"cooldown_seconds"` // Mindestabstand zwischen zwei Auslösungen der Regel
+	Severity        string `json:"severity,omitempty"`
+}
+
+// Obergrenze für Vor- und Nachlauf einer Aufzeichnung
+const maxTriggerSeconds = 600
+
+// Validate prüft die Trigger-Regeln auf plausible Werte
+func (c *TriggerConfig) Validate() error {
+	if c.MaxBufferBytes < 0 || c.MaxTotalSize < 0 {
+		return fmt.Errorf("max_buffer_bytes und max_total_size dürfen nicht negativ sein")
+	}
+
+	names := make(map[string]bool, len(c.Rules))
+	for i, rule := range c.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("Regel %d hat keinen Namen", i+1)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("Regelname '%s' ist doppelt vergeben", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case TriggerTypeMatch, TriggerTypeNewDHCPServer, TriggerTypeGatewayMACChange:
+		default:
+			return fmt.Errorf("Regel '%s': unbekannter Typ '%s'", rule.Name, rule.Type)
+		}
+		if rule.IP != "" && net.ParseIP(rule.IP) == nil {
+			return fmt.Errorf("Regel '%s': ungültige IP-Adresse %q", rule.Name, rule.IP)
+		}
+		for _, server := range rule.KnownDHCPServers {
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("Regel '%s': ungültige Adresse in known_dhcp_servers: %q", rule.Name, server)
+			}
+		}
+		if rule.PreSeconds < 0 || rule.PreSeconds > maxTriggerSeconds ||
+			rule.PostSeconds < 0 || rule.PostSeconds > maxTriggerSeconds {
+			return fmt.Errorf("Regel '%s': pre_seconds und post_seconds müssen zwischen 0 und %d liegen",
+				rule.Name, maxTriggerSeconds)
+		}
+		if rule.CooldownSeconds < 0 {
+			return fmt.Errorf("Regel '%s': cooldown_seconds darf nicht negativ sein", rule.Name)
+		}
+		switch rule.Severity {
+		case "", "info", "warning", "error":
+		default:
+			return fmt.Errorf("Regel '%s': unbekannte severity '%s'", rule.Name, rule.Severity)
+		}
+	}
+	return nil
 }
 
 // StorageConfig enthält die Konfiguration für die Datenspeicherung
@@ -45,6 +307,21 @@ type StorageConfig struct {
 	Path       string `json:"path"` // Pfad zur SQLite-Datei
 	AutoVacuum bool   `json:"auto_vacuum"`
 	MaxPackets int    `json:"max_packets"` // Max. Anzahl zu speichernder Pakete
+
+	// Pfad zur JSON-Datei, in der die Registry der Remote-Agents gespeichert wird
+	AgentRegistryPath string `json:"agent_registry_path"`
+
+	// Pfad zur JSON-Datei mit den vom Server verteilten Agent-Konfigurationen
+	AgentConfigPath string `json:"agent_config_path"`
+
+	// Verzeichnis für die vom Server bereitgestellten Agent-Releases
+	AgentReleaseDir string `json:"agent_release_dir"`
+
+	// Pfad zur JSON-Datei mit den geplanten Capture-Jobs und ihrer Historie
+	CaptureJobsPath string `json:"capture_jobs_path"`
+
+	// Verzeichnis für hochgeladene Dateien, Ergebnisse und Index der Analyse-Jobs
+	AnalysisJobsDir string `json:"analysis_jobs_dir"`
 }
 
 // AIConfig enthält die Konfiguration für KI-Integration
@@ -86,9 +363,12 @@ type AgentConfig struct {
 	// Auf welcher Adresse/Port der Agent lauscht
 	Listen string `json:"listen"`
 
-	// URL des Hauptservers für die Registrierung
+	// URL des Hauptservers für die Registrierung; leer = Server per mDNS suchen
 	ServerURL string `json:"server_url"`
 
+	// Keine mDNS-Ankündigung des Agents und keine Suche nach dem Server
+	DisableDiscovery bool `json:"disable_discovery,omitempty"`
+
 	// Zu verwendende Netzwerkschnittstelle für Packet-Capture
 	Interface string `json:"interface"`
 
@@ -97,6 +377,73 @@ type AgentConfig struct {
 
 	// API-Schlüssel für die Authentifizierung mit dem Hauptserver
 	APIKey string `json:"api_key,omitempty"`
+
+	// Gruppe des Agents, für die der Server eine gemeinsame Konfiguration verteilen kann
+	Group string `json:"group,omitempty"`
+
+	// Ringpuffer für Rohpakete, aus dem nachträglich PCAP-Ausschnitte abgerufen werden können
+	RingBuffer *RingBufferConfig `json:"ring_buffer,omitempty"`
+
+	// Automatische Aktualisierung über signierte Releases des Servers
+	Update *UpdateConfig `json:"update,omitempty"`
+}
+
+// UpdateConfig enthält die Einstellungen für die automatische Aktualisierung des Agents
+type UpdateConfig struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"` // Ed25519-Schlüssel der Release-Signatur, Base64
+	// Sekunden, innerhalb derer sich eine neue Version beim Server registrieren muss,
+	// sonst wird die vorherige Version wiederhergestellt
+	RollbackTimeout int `json:"rollback_timeout"`
+}
+
+// AgentUpdate beschreibt eine vom Server angekündigte Agent-Version
+type AgentUpdate struct {
+	Version   string `json:"version"`
+	URL       string `json:"url"`       // Downloadpfad relativ zur Server-URL
+	SHA256    string `json:"sha256"`    // Prüfsumme der Binärdatei, hexadezimal
+	Signature string `json:"signature"` // Ed25519-Signatur über version.ReleaseSignatureMessage, Base64
+	Size      int64  `json:"size"`
+}
+
+// RingBufferConfig enthält die Konfiguration des rotierenden PCAP-Ringpuffers. Jede Schnittstelle
+// erhält einen eigenen Ring in einem Unterverzeichnis von Dir.
+type RingBufferConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Dir          string `json:"dir"`            // Verzeichnis für die PCAP-Dateien
+	MaxFileSize  int64  `json:"max_file_size"`  // Rotation nach dieser Dateigröße in Bytes
+	MaxFileAge   int    `json:"max_file_age"`   // Rotation nach dieser Dauer in Sekunden
+	MaxTotalSize int64  `json:"max_total_size"` // Disk-Quota je Schnittstelle in Bytes
+}
+
+// AgentSettings ist ein versioniertes Konfigurationsdokument, das der Server an Agents verteilt.
+// Es ersetzt die Capture- und Gateway-Einstellungen aus der lokalen Konfigurationsdatei des Agents.
+type AgentSettings struct {
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	Interface   string        `json:"interface,omitempty"` // leer = lokale Einstellung beibehalten
+	Filter      string        `json:"filter"`
+	PromiscMode bool          `json:"promisc_mode"`
+	SnapLen     int           `json:"snap_len"`
+	BufferSize  int           `json:"buffer_size"`
+	Gateway     GatewayConfig `json:"gateway"`
+}
+
+// Validate prüft ein Konfigurationsdokument auf plausible Werte
+func (s *AgentSettings) Validate() error {
+	if s.SnapLen < 64 || s.SnapLen > 262144 {
+		return fmt.Errorf("snap_len muss zwischen 64 und 262144 liegen (ist %d)", s.SnapLen)
+	}
+	if s.BufferSize < 0 {
+		return fmt.Errorf("buffer_size darf nicht negativ sein (ist %d)", s.BufferSize)
+	}
+	for _, gw := range s.Gateway.KnownGateways {
+		if net.ParseIP(gw) == nil {
+			return fmt.Errorf("ungültige Gateway-Adresse in known_gateways: %q", gw)
+		}
+	}
+	return nil
 }
 
 // LoadConfig lädt die Konfiguration aus einer Datei
@@ -116,6 +463,10 @@ func LoadConfig(configPath string) (*Config, error) {
 		}
 	}
 
+	if err := config.Capture.Validate(); err != nil {
+		return nil, fmt.Errorf("Ungültige Capture-Konfiguration: %w", err)
+	}
+
 	return config, nil
 }
 
@@ -131,6 +482,7 @@ func DefaultConfig() *Config {
 			EnableWebSocket: true,
 			EnableCORS:      true,
 			StaticDir:       filepath.Join(baseDir, "web"),
+			EnableDiscovery: true,
 		},
 		Capture: CaptureConfig{
 			PCAPDir:     filepath.Join(baseDir, "pcaps"),
@@ -140,12 +492,23 @@ func DefaultConfig() *Config {
 			Filter:      "",
 			BufferSize:  2 * 1024 * 1024, // 2MB
 			EnableLive:  false,
+
+			DropWarningThreshold: 0.01, // 1%
+			BackpressurePolicy:   BackpressureDropNewest,
+			ChannelSize:          1000,
+			SampleRate:           10,
 		},
 		Storage: StorageConfig{
 			Type:       "sqlite",
 			Path:       filepath.Join(baseDir, "data", "packets.db"),
 			AutoVacuum: true,
 			MaxPackets: 1000000,
+
+			AgentRegistryPath: filepath.Join(baseDir, "data", "agents.json"),
+			AgentConfigPath:   filepath.Join(baseDir, "data", "agent_configs.json"),
+			AgentReleaseDir:   filepath.Join(baseDir, "data", "releases"),
+			CaptureJobsPath:   filepath.Join(baseDir, "data", "capture_jobs.json"),
+			AnalysisJobsDir:   filepath.Join(baseDir, "data", "analysis-jobs"),
 		},
 		AI: AIConfig{
 			Enabled:     false,
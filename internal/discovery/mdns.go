@@ -0,0 +1,381 @@
+// Package discovery implementiert eine schlanke mDNS/DNS-SD-Ankündigung und -Suche
+// (RFC 6762/6763), mit der sich Server und Agents im selben LAN ohne Konfiguration finden.
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Dienst-Typen, unter denen Server und Agents angekündigt werden
+const (
+	ServerService = "_kna-server._tcp"
+	AgentService  = "_kna-agent._tcp"
+)
+
+const (
+	mdnsDomain = "local"
+	mdnsPort   = 5353
+	// TTL der angekündigten Einträge in Sekunden
+	recordTTL = 120
+	// Cache-Flush-Bit für eindeutige Einträge (SRV, TXT, A)
+	cacheFlush = 0x8000
+)
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+
+// Service beschreibt einen angekündigten Dienst
+type Service struct {
+	Instance string            `json:"instance"` // z.B. Name des Agents
+	Type     string            `json:"type"`     // z.B. "_kna-agent._tcp"
+	Host     string            `json:"host"`
+	Port     int               `json:"port"`
+	IPs      []net.IP          `json:"ips"`
+	TXT      map[string]string `json:"txt,omitempty"`
+}
+
+// URL gibt die HTTP-Adresse des Dienstes über die erste IPv4-Adresse zurück
+func (s Service) URL() string {
+	for _, ip := range s.IPs {
+		if ip.To4() != nil {
+			return fmt.Sprintf("http://%s", net.JoinHostPort(ip.String(), strconv.Itoa(s.Port)))
+		}
+	}
+	if len(s.IPs) > 0 {
+		return fmt.Sprintf("http://%s", net.JoinHostPort(s.IPs[0].String(), strconv.Itoa(s.Port)))
+	}
+	return ""
+}
+
+// serviceName gibt den vollständigen Namen des Dienst-Typs zurück, z.B. "_kna-agent._tcp.local"
+func serviceName(serviceType string) string {
+	return serviceType + "." + mdnsDomain
+}
+
+// sanitizeLabel ersetzt Zeichen, die in einem einzelnen DNS-Label nicht vorkommen dürfen
+func sanitizeLabel(label string) string {
+	label = strings.Map(func(r rune) rune {
+		if r == '.' || r == ' ' {
+			return '-'
+		}
+		return r
+	}, label)
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// Responder beantwortet mDNS-Anfragen für einen Dienst und kündigt ihn beim Start an
+type Responder struct {
+	service  Service
+	instance string // vollständiger Name der Instanz
+	host     string // vollständiger Hostname
+}
+
+// NewResponder erstellt einen Responder. Ohne IP-Adressen werden die Adressen
+// der lokalen Schnittstellen angekündigt.
+func NewResponder(service Service) *Responder {
+	if service.Host == "" {
+		service.Host = service.Instance
+	}
+	if len(service.IPs) == 0 {
+		service.IPs = localIPv4Addresses()
+	}
+
+	return &Responder{
+		service:  service,
+		instance: sanitizeLabel(service.Instance) + "." + serviceName(service.Type),
+		host:     sanitizeLabel(service.Host) + "." + mdnsDomain,
+	}
+}
+
+// Run lauscht auf mDNS-Anfragen, bis der Kontext beendet wird
+func (r *Responder) Run(ctx context.Context) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return fmt.Errorf("mDNS-Socket konnte nicht geöffnet werden: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// Dienst beim Start zweimal unaufgefordert ankündigen (RFC 6762, Abschnitt 8.3)
+	for i := 0; i < 2; i++ {
+		if data, err := r.response(0); err == nil {
+			conn.WriteToUDP(data, mdnsGroup)
+		}
+		if i == 0 {
+			time.Sleep(time.Second)
+		}
+	}
+
+	buffer := make([]byte, 9000)
+	for {
+		n, src, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("Fehler beim Lesen vom mDNS-Socket: %w", err)
+		}
+
+		var query layers.DNS
+		if err := query.DecodeFromBytes(buffer[:n], gopacket.NilDecodeFeedback); err != nil || query.QR {
+			continue
+		}
+		if !r.matches(&query) {
+			continue
+		}
+
+		// Anfragen von einem anderen Port als 5353 sind einfache Unicast-Anfragen
+		// (RFC 6762, Abschnitt 6.7) und werden direkt an den Absender beantwortet
+		legacy := src.Port != mdnsPort
+		id := uint16(0)
+		if legacy {
+			id = query.ID
+		}
+		data, err := r.response(id)
+		if err != nil {
+			log.Printf("mDNS-Antwort konnte nicht erstellt werden: %v", err)
+			continue
+		}
+		if legacy {
+			conn.WriteToUDP(data, src)
+		} else {
+			conn.WriteToUDP(data, mdnsGroup)
+		}
+	}
+}
+
+// matches prüft, ob eine Anfrage nach dem Dienst-Typ oder der Instanz fragt
+func (r *Responder) matches(query *layers.DNS) bool {
+	for _, question := range query.Questions {
+		name := strings.ToLower(string(question.Name))
+		switch question.Type {
+		case layers.DNSTypePTR, layers.DNSType(255): // PTR oder ANY
+			if name == strings.ToLower(serviceName(r.service.Type)) || name == strings.ToLower(r.instance) {
+				return true
+			}
+		case layers.DNSTypeSRV, layers.DNSTypeTXT:
+			if name == strings.ToLower(r.instance) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// response erstellt die Antwort mit PTR-, SRV-, TXT- und A-Einträgen
+func (r *Responder) response(id uint16) ([]byte, error) {
+	var txts [][]byte
+	for key, value := range r.service.TXT {
+		entry := key + "=" + value
+		if len(entry) > 255 {
+			continue
+		}
+		txts = append(txts, []byte(entry))
+	}
+	if len(txts) == 0 {
+		txts = [][]byte{{}}
+	}
+
+	msg := &layers.DNS{
+		ID:     id,
+		QR:     true,
+		AA:     true,
+		OpCode: layers.DNSOpCodeQuery,
+		Answers: []layers.DNSResourceRecord{{
+			Name:  []byte(serviceName(r.service.Type)),
+			Type:  layers.DNSTypePTR,
+			Class: layers.DNSClassIN,
+			TTL:   recordTTL,
+			PTR:   []byte(r.instance),
+		}},
+		Additionals: []layers.DNSResourceRecord{
+			{
+				Name:  []byte(r.instance),
+				Type:  layers.DNSTypeSRV,
+				Class: layers.DNSClassIN | cacheFlush,
+				TTL:   recordTTL,
+				SRV:   layers.DNSSRV{Port: uint16(r.service.Port), Name: []byte(r.host)},
+			},
+			{
+				Name:  []byte(r.instance),
+				Type:  layers.DNSTypeTXT,
+				Class: layers.DNSClassIN | cacheFlush,
+				TTL:   recordTTL,
+				TXTs:  txts,
+			},
+		},
+	}
+	for _, ip := range r.service.IPs {
+		if ip4 := ip.To4(); ip4 != nil {
+			msg.Additionals = append(msg.Additionals, layers.DNSResourceRecord{
+				Name:  []byte(r.host),
+				Type:  layers.DNSTypeA,
+				Class: layers.DNSClassIN | cacheFlush,
+				TTL:   recordTTL,
+				IP:    ip4,
+			})
+		}
+	}
+	msg.ANCount = uint16(len(msg.Answers))
+	msg.ARCount = uint16(len(msg.Additionals))
+
+	buffer := gopacket.NewSerializeBuffer()
+	if err := msg.SerializeTo(buffer, gopacket.SerializeOptions{}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// Browse sucht für die Dauer von timeout nach Instanzen eines Dienst-Typs
+func Browse(ctx context.Context, serviceType string, timeout time.Duration) ([]Service, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero, Port: 0})
+	if err != nil {
+		return nil, fmt.Errorf("UDP-Socket konnte nicht geöffnet werden: %w", err)
+	}
+	defer conn.Close()
+
+	query := &layers.DNS{
+		ID:      uint16(time.Now().UnixNano()),
+		OpCode:  layers.DNSOpCodeQuery,
+		QDCount: 1,
+		Questions: []layers.DNSQuestion{{
+			Name:  []byte(serviceName(serviceType)),
+			Type:  layers.DNSTypePTR,
+			Class: layers.DNSClassIN,
+		}},
+	}
+	buffer := gopacket.NewSerializeBuffer()
+	if err := query.SerializeTo(buffer, gopacket.SerializeOptions{}); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteToUDP(buffer.Bytes(), mdnsGroup); err != nil {
+		return nil, fmt.Errorf("mDNS-Anfrage konnte nicht gesendet werden: %w", err)
+	}
+
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	services := make(map[string]*Service)
+	go func() {
+		<-ctx.Done()
+		conn.SetReadDeadline(time.Now())
+	}()
+
+	data := make([]byte, 9000)
+	for {
+		n, _, err := conn.ReadFromUDP(data)
+		if err != nil {
+			break // Timeout oder Abbruch
+		}
+
+		var response layers.DNS
+		if err := response.DecodeFromBytes(data[:n], gopacket.NilDecodeFeedback); err != nil || !response.QR {
+			continue
+		}
+
+		collectServices(&response, serviceType, services)
+	}
+
+	result := make([]Service, 0, len(services))
+	for _, service := range services {
+		if service.Port != 0 && len(service.IPs) > 0 {
+			result = append(result, *service)
+		}
+	}
+	return result, nil
+}
+
+// collectServices übernimmt die Einträge einer Antwort in die gefundenen Dienste
+func collectServices(response *layers.DNS, serviceType string, services map[string]*Service) {
+	records := append(append([]layers.DNSResourceRecord{}, response.Answers...), response.Additionals...)
+	suffix := "." + strings.ToLower(serviceName(serviceType))
+
+	hostIPs := make(map[string][]net.IP)
+	for _, record := range records {
+		if record.Type == layers.DNSTypeA || record.Type == layers.DNSTypeAAAA {
+			name := strings.ToLower(string(record.Name))
+			hostIPs[name] = append(hostIPs[name], record.IP)
+		}
+	}
+
+	for _, record := range records {
+		name := strings.ToLower(string(record.Name))
+		switch record.Type {
+		case layers.DNSTypePTR:
+			instance := strings.ToLower(string(record.PTR))
+			if name == strings.ToLower(serviceName(serviceType)) && strings.HasSuffix(instance, suffix) {
+				serviceFor(services, instance, string(record.PTR), serviceType)
+			}
+		case layers.DNSTypeSRV:
+			if strings.HasSuffix(name, suffix) {
+				service := serviceFor(services, name, string(record.Name), serviceType)
+				service.Port = int(record.SRV.Port)
+				service.Host = string(record.SRV.Name)
+				service.IPs = hostIPs[strings.ToLower(service.Host)]
+			}
+		case layers.DNSTypeTXT:
+			if strings.HasSuffix(name, suffix) {
+				service := serviceFor(services, name, string(record.Name), serviceType)
+				for _, txt := range record.TXTs {
+					if key, value, ok := strings.Cut(string(txt), "="); ok {
+						service.TXT[key] = value
+					}
+				}
+			}
+		}
+	}
+}
+
+// serviceFor gibt den Eintrag einer Instanz zurück und legt ihn bei Bedarf an
+func serviceFor(services map[string]*Service, key, fullName, serviceType string) *Service {
+	if service, ok := services[key]; ok {
+		return service
+	}
+	service := &Service{
+		Instance: strings.TrimSuffix(fullName, "."+serviceName(serviceType)),
+		Type:     serviceType,
+		TXT:      make(map[string]string),
+	}
+	services[key] = service
+	return service
+}
+
+// localIPv4Addresses gibt die IPv4-Adressen aller aktiven, nicht lokalen Schnittstellen zurück
+func localIPv4Addresses() []net.IP {
+	var ips []net.IP
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+				ips = append(ips, ipNet.IP.To4())
+			}
+		}
+	}
+	return ips
+}
@@ -0,0 +1,216 @@
+// Package displayfilter implementiert Anzeigefilter auf analysierten Paketen. Anders als
+// BPF-Filter, die nur Rohdaten der Header prüfen, vergleichen Anzeigefilter die dekodierten
+// Felder von models.PacketInfo, z.B. dns.query contains "corp" oder
+// is_gateway && arp.gratuitous.
+package displayfilter
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Error beschreibt einen ungültigen Anzeigefilter. Offset und Token zeigen auf die Stelle,
+// an der der Ausdruck ungültig wird.
+type Error struct {
+	Filter  string `json:"filter"`
+	Message string `json:"message"`
+	Offset  int    `json:"offset"`          // Byte-Position des fehlerhaften Tokens
+	Token   string `json:"token,omitempty"` // leer, wenn der Ausdruck unvollständig endet
+}
+
+func (e *Error) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("Ungültiger Anzeigefilter: %s (Ausdruck endet unvollständig)", e.Message)
+	}
+	return fmt.Sprintf("Ungültiger Anzeigefilter: %s (bei Position %d: '%s')", e.Message, e.Offset, e.Token)
+}
+
+// newError erstellt einen Fehler an einer Stelle des Filters
+func newError(filter string, offset int, tokenText, format string, args ...interface{}) *Error {
+	return &Error{
+		Filter:  filter,
+		Message: fmt.Sprintf(format, args...),
+		Offset:  offset,
+		Token:   tokenText,
+	}
+}
+
+// Filter ist ein übersetzter Anzeigefilter. Ein Filter ist unveränderlich und kann von
+// mehreren Goroutinen gleichzeitig verwendet werden; ein nil-Filter trifft auf jedes Paket zu.
+type Filter struct {
+	text string
+	root node
+}
+
+// Compile übersetzt einen Anzeigefilter und prüft dabei Feldnamen, Operatoren und die Typen
+// der Vergleichswerte. Fehler werden als *Error mit der Position zurückgegeben. Ein leerer
+// Filter ergibt nil.
+func Compile(filter string) (*Filter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+
+	tokens, err := lex(filter)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{filter: filter, tokens: tokens}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{text: filter, root: root}, nil
+}
+
+// Match prüft, ob ein Paket dem Filter entspricht
+func (f *Filter) Match(info *models.PacketInfo) bool {
+	if f == nil {
+		return true
+	}
+	return f.root.match(info)
+}
+
+// String gibt den Filter im Wortlaut zurück
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.text
+}
+
+// node ist ein Knoten des übersetzten Ausdrucks
+type node interface {
+	match(info *models.PacketInfo) bool
+}
+
+type orNode struct{ left, right node }
+
+func (n *orNode) match(info *models.PacketInfo) bool {
+	return n.left.match(info) || n.right.match(info)
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) match(info *models.PacketInfo) bool {
+	return n.left.match(info) && n.right.match(info)
+}
+
+type notNode struct{ operand node }
+
+func (n *notNode) match(info *models.PacketInfo) bool { return !n.operand.match(info) }
+
+// existsNode prüft ein Feld ohne Vergleich: boolesche Felder müssen wahr sein, alle
+// anderen im Paket vorhanden
+type existsNode struct{ field *field }
+
+func (n *existsNode) match(info *models.PacketInfo) bool {
+	found := false
+	n.field.values(info, func(v value) bool {
+		found = n.field.typ != typeBool || v.b
+		return !found
+	})
+	return found
+}
+
+// operator ist ein Vergleichsoperator
+type operator int
+
+const (
+	opEqual operator = iota
+	opNotEqual
+	opLess
+	opLessEqual
+	opGreater
+	opGreaterEqual
+	opContains
+	opMatches
+	opIn
+)
+
+// literal ist ein geprüfter Vergleichswert; belegt ist nur das Element zum Typ des Felds
+type literal struct {
+	b       bool
+	num     uint64
+	str     string
+	network *net.IPNet
+}
+
+// compareNode vergleicht die Werte eines Felds mit Literalen. Bei mehreren Werten genügt
+// ein passender Wert; != trifft zu, wenn das Feld vorhanden ist und kein Wert gleich ist.
+type compareNode struct {
+	field    *field
+	op       operator
+	literals []literal
+	re       *regexp.Regexp
+}
+
+func (n *compareNode) match(info *models.PacketInfo) bool {
+	present, found := false, false
+	n.field.values(info, func(v value) bool {
+		present = true
+		found = n.test(v)
+		return !found
+	})
+	if n.op == opNotEqual {
+		return present && !found
+	}
+	return found
+}
+
+// test prüft einen einzelnen Wert; für != prüft er auf Gleichheit
+func (n *compareNode) test(v value) bool {
+	switch n.op {
+	case opEqual, opNotEqual, opIn:
+		for i := range n.literals {
+			if n.equal(v, &n.literals[i]) {
+				return true
+			}
+		}
+		return false
+	case opLess:
+		return v.num < n.literals[0].num
+	case opLessEqual:
+		return v.num <= n.literals[0].num
+	case opGreater:
+		return v.num > n.literals[0].num
+	case opGreaterEqual:
+		return v.num >= n.literals[0].num
+	case opContains:
+		return containsFold(v.str, n.literals[0].str)
+	case opMatches:
+		return n.re.MatchString(v.str)
+	}
+	return false
+}
+
+// equal vergleicht einen Wert mit einem Literal nach dem Typ des Felds. Texte und
+// MAC-Adressen werden ohne Beachtung der Groß- und Kleinschreibung verglichen, Adressen
+// mit Netzen auf Enthaltensein.
+func (n *compareNode) equal(v value, l *literal) bool {
+	switch n.field.typ {
+	case typeBool:
+		return v.b == l.b
+	case typeInt:
+		return v.num == l.num
+	case typeString, typeMAC:
+		return strings.EqualFold(v.str, l.str)
+	case typeIP:
+		return l.network.Contains(v.ip)
+	}
+	return false
+}
+
+// containsFold prüft ohne Beachtung der Groß- und Kleinschreibung, ob substr in s enthalten
+// ist, ohne dafür Kopien anzulegen
+func containsFold(s, substr string) bool {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,311 @@
+package displayfilter
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Testpakete: eine TCP-Verbindung über das Gateway, eine DNS-Anfrage über IPv6,
+// ein NTP-Paket und ein ARP-Paket ohne IP-Adressen
+var (
+	tcpPacket = &models.PacketInfo{
+		SourceIP:         net.ParseIP("10.1.2.3").To4(),
+		DestinationIP:    net.ParseIP("192.168.1.1").To4(),
+		SourcePort:       51000,
+		DestinationPort:  443,
+		Protocol:         "TCP",
+		Length:           60,
+		TTL:              64,
+		IsGatewayTraffic: true,
+		GatewayIP:        net.ParseIP("192.168.1.1").To4(),
+	}
+	dnsPacket = &models.PacketInfo{
+		SourceIP:        net.ParseIP("2001:db8::1"),
+		DestinationIP:   net.ParseIP("2001:db8::53"),
+		SourcePort:      5353,
+		DestinationPort: 53,
+		Protocol:        "DNS",
+		Length:          90,
+		DNSInfo: &models.DNSInfo{
+			Queries: []models.DNSQuery{{Name: "Mail.Corp.example", Type: "A", Class: "IN"}},
+			IsQuery: true,
+		},
+	}
+	ntpPacket = &models.PacketInfo{
+		SourceIP:        net.ParseIP("10.9.9.9").To4(),
+		DestinationIP:   net.ParseIP("10.9.9.1").To4(),
+		SourcePort:      123,
+		DestinationPort: 123,
+		Protocol:        "UDP",
+		Length:          76,
+	}
+	arpPacket = &models.PacketInfo{
+		Protocol: "ARP",
+		Length:   42,
+	}
+)
+
+func TestCompileMatch(t *testing.T) {
+	testCases := []struct {
+		name   string
+		filter string
+		want   map[*models.PacketInfo]bool
+	}{
+		{
+			name:   "field without comparison",
+			filter: "tcp",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "and binds tighter than or",
+			filter: "tcp || udp && port == 53",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "parentheses override precedence",
+			filter: "(tcp || udp) && port == 53",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "not binds tighter than and",
+			filter: "!tcp && ip",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: true, arpPacket: false},
+		},
+		{
+			name:   "keywords are case-insensitive",
+			filter: "NOT (TCP Or udp)",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: false, ntpPacket: false, arpPacket: true},
+		},
+		{
+			name:   "single address",
+			filter: "ip.src == 10.1.2.3",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "ipv4 network",
+			filter: "ip.addr eq 10.0.0.0/8",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: true, arpPacket: false},
+		},
+		{
+			name:   "ipv6 network",
+			filter: "ip.dst == 2001:db8::/32",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "not equal requires the field",
+			filter: "ip.src != 10.0.0.0/8",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "set of ports",
+			filter: "port in {80 443}",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "set with commas and trailing comma",
+			filter: "port in {53, 123,}",
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: true, arpPacket: false},
+		},
+		{
+			name:   "set of mixed quoted and unquoted strings",
+			filter: `protocol in {tcp, "arp"}`,
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: true},
+		},
+		{
+			name:   "set of networks",
+			filter: "ip.addr in {192.168.0.0/16, 2001:db8::/32}",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "contains ignores case",
+			filter: `dns.query contains "corp"`,
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "regular expression with kept escape",
+			filter: `dns.query ~ "(?i)^mail\.corp\.example$"`,
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "digit class does not match letters",
+			filter: `dns.query matches "\d"`,
+			want:   map[*models.PacketInfo]bool{tcpPacket: false, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "numeric comparison",
+			filter: "port.dst > 100 and length le 60",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "hexadecimal number",
+			filter: "port == 0x1bb",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "boolean literal",
+			filter: "dns.is_query == true || is_gateway == 1",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: true, ntpPacket: false, arpPacket: false},
+		},
+		{
+			name:   "gateway address",
+			filter: "is_gateway && gateway == 192.168.1.1",
+			want:   map[*models.PacketInfo]bool{tcpPacket: true, dnsPacket: false, ntpPacket: false, arpPacket: false},
+		},
+	}
+
+	names := map[*models.PacketInfo]string{tcpPacket: "tcp", dnsPacket: "dns", ntpPacket: "ntp", arpPacket: "arp"}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := Compile(tc.filter)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tc.filter, err)
+			}
+			for info, want := range tc.want {
+				if got := filter.Match(info); got != want {
+					t.Errorf("Match(%s) = %v, want %v", names[info], got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileEmpty(t *testing.T) {
+	filter, err := Compile("  \t ")
+	if err != nil || filter != nil {
+		t.Fatalf("Compile(blank) = %v, %v; want nil, nil", filter, err)
+	}
+	if !filter.Match(tcpPacket) {
+		t.Error("nil filter must match every packet")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		filter  string
+		offset  int
+		token   string
+		message string
+	}{
+		{name: "single equals", filter: "ip.src = 1.2.3.4", offset: 7, token: "=", message: "'=='"},
+		{name: "single ampersand", filter: "tcp & udp", offset: 4, token: "&", message: "'&&'"},
+		{name: "unknown character", filter: "tcp && $", offset: 7, token: "$", message: "Unerwartetes Zeichen"},
+		{name: "unknown field with suggestion", filter: `dns.qry == "x"`, offset: 0, token: "dns.qry", message: "'dns.query'"},
+		{name: "missing value", filter: "ip.src == ", offset: 10, token: "", message: "Wert für 'ip.src'"},
+		{name: "missing operator between terms", filter: "tcp udp", offset: 4, token: "udp", message: "'&&' oder '||'"},
+		{name: "missing closing parenthesis", filter: "(tcp || udp", offset: 11, token: "", message: "')' zu '(' bei Position 0"},
+		{name: "unbalanced closing parenthesis", filter: "tcp)", offset: 3, token: ")", message: "ohne öffnende Klammer"},
+		{name: "dangling operator", filter: "tcp &&", offset: 6, token: "", message: "Feldname"},
+		{name: "unterminated string", filter: `dns.query == "abc`, offset: 13, token: `"abc`, message: "nicht abgeschlossen"},
+		{name: "escaped quote does not terminate", filter: `dns.query == "abc\"`, offset: 13, token: `"abc\"`, message: "nicht abgeschlossen"},
+		{name: "string for number", filter: `port > "abc"`, offset: 7, token: `"abc"`, message: "Zahl"},
+		{name: "ordering on string field", filter: "protocol < 5", offset: 9, token: "<", message: "nur für Zahlen"},
+		{name: "contains on address field", filter: "ip.src contains 10", offset: 7, token: "contains", message: "nur für Texte"},
+		{name: "invalid network", filter: "ip.src == 10.0.0.0/33", offset: 10, token: "10.0.0.0/33", message: "IP-Adresse oder ein Netz"},
+		{name: "invalid mac", filter: "arp.sender_mac == 00:11", offset: 18, token: "00:11", message: "MAC-Adresse"},
+		{name: "invalid boolean", filter: "tcp == yes", offset: 7, token: "yes", message: "true oder false"},
+		{name: "invalid regular expression", filter: `dns.query matches "("`, offset: 18, token: `"("`, message: "regulärer Ausdruck"},
+		{name: "set without braces", filter: "port in 80", offset: 8, token: "80", message: "'{' nach 'in'"},
+		{name: "empty set", filter: "port in {}", offset: 9, token: "}", message: "leer"},
+		{name: "unterminated set", filter: "port in {80, 443", offset: 16, token: "", message: "'}' erwartet"},
+		{name: "leading comma in set", filter: "port in {, 80}", offset: 9, token: ",", message: "Wert für 'port'"},
+		{name: "invalid value in set", filter: "ip.addr in {10.0.0.1, nohost}", offset: 22, token: "nohost", message: "IP-Adresse"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filter, err := Compile(tc.filter)
+			if err == nil {
+				t.Fatalf("Compile(%q) = %v, want error", tc.filter, filter)
+			}
+			var filterErr *Error
+			if !errors.As(err, &filterErr) {
+				t.Fatalf("error %v is %T, want *Error", err, err)
+			}
+			if filterErr.Offset != tc.offset || filterErr.Token != tc.token {
+				t.Errorf("error at %d %q, want %d %q (%v)", filterErr.Offset, filterErr.Token, tc.offset, tc.token, err)
+			}
+			if filterErr.Filter != tc.filter {
+				t.Errorf("error filter = %q, want %q", filterErr.Filter, tc.filter)
+			}
+			if !strings.Contains(filterErr.Message, tc.message) {
+				t.Errorf("message %q does not contain %q", filterErr.Message, tc.message)
+			}
+		})
+	}
+}
+
+func TestLexStringEscapes(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "plain", input: `"abc"`, want: "abc"},
+		{name: "escaped quote", input: `"a\"b"`, want: `a"b`},
+		{name: "escaped backslash", input: `"a\\b"`, want: `a\b`},
+		{name: "newline and tab", input: `"a\nb\tc"`, want: "a\nb\tc"},
+		{name: "regular expression escapes are kept", input: `"\d+\.\w"`, want: `\d+\.\w`},
+		{name: "escaped backslash before quote", input: `"a\\"`, want: `a\`},
+		{name: "multibyte characters", input: `"grüße"`, want: "grüße"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, err := lex(tc.input)
+			if err != nil {
+				t.Fatalf("lex(%s): %v", tc.input, err)
+			}
+			if len(tokens) != 2 || tokens[0].kind != tokenString || tokens[1].kind != tokenEOF {
+				t.Fatalf("lex(%s) = %+v, want one string token", tc.input, tokens)
+			}
+			if tokens[0].text != tc.want {
+				t.Errorf("text = %q, want %q", tokens[0].text, tc.want)
+			}
+			if tokens[0].raw != tc.input {
+				t.Errorf("raw = %q, want %q", tokens[0].raw, tc.input)
+			}
+		})
+	}
+}
+
+func TestLexTokens(t *testing.T) {
+	tokens, err := lex(`ip.addr in {fe80::1/64,"x"}&&!(port>=10)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		kind   tokenKind
+		text   string
+		offset int
+	}{
+		{tokenWord, "ip.addr", 0},
+		{tokenWord, "in", 8},
+		{tokenSymbol, "{", 11},
+		{tokenWord, "fe80::1/64", 12},
+		{tokenSymbol, ",", 22},
+		{tokenString, "x", 23},
+		{tokenSymbol, "}", 26},
+		{tokenSymbol, "&&", 27},
+		{tokenSymbol, "!", 29},
+		{tokenSymbol, "(", 30},
+		{tokenWord, "port", 31},
+		{tokenSymbol, ">=", 35},
+		{tokenWord, "10", 37},
+		{tokenSymbol, ")", 39},
+		{tokenEOF, "", 40},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %d tokens, want %d: %+v", len(tokens), len(want), tokens)
+	}
+	for i, w := range want {
+		if got := tokens[i]; got.kind != w.kind || got.text != w.text || got.offset != w.offset {
+			t.Errorf("token %d = %+v, want %+v", i, got, w)
+		}
+	}
+}
@@ -0,0 +1,462 @@
+package displayfilter
+
+import (
+	"net"
+	"sort"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// fieldType ist der Typ eines Felds und seiner Vergleichswerte
+type fieldType int
+
+const (
+	typeBool fieldType = iota
+	typeInt
+	typeString
+	typeIP
+	typeMAC
+)
+
+func (t fieldType) String() string {
+	switch t {
+	case typeBool:
+		return "bool"
+	case typeInt:
+		return "int"
+	case typeString:
+		return "string"
+	case typeIP:
+		return "ip"
+	case typeMAC:
+		return "mac"
+	}
+	return "unknown"
+}
+
+// value ist ein Wert eines Felds; belegt ist nur das Element zum Typ des Felds
+type value struct {
+	b   bool
+	num uint64
+	str string
+	ip  net.IP
+}
+
+// field beschreibt ein filterbares Feld von models.PacketInfo. values ruft yield für jeden
+// Wert des Felds auf, bis yield false zurückgibt; fehlt das Feld im Paket, gibt es keinen
+// Wert. Felder wie dns.query haben je Paket mehrere Werte.
+type field struct {
+	name        string
+	typ         fieldType
+	description string
+	values      func(info *models.PacketInfo, yield func(value) bool)
+}
+
+// FieldInfo beschreibt ein Feld für die Feldliste der API
+type FieldInfo struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+}
+
+// Hilfsfunktionen für die Wertlieferung der Felder
+func yieldBool(yield func(value) bool, b bool) { yield(value{b: b}) }
+
+func yieldIP(yield func(value) bool, ip net.IP) bool {
+	if len(ip) == 0 {
+		return true
+	}
+	return yield(value{ip: ip})
+}
+
+func yieldString(yield func(value) bool, s string) bool {
+	if s == "" {
+		return true
+	}
+	return yield(value{str: s})
+}
+
+// hasPorts meldet, ob das Paket TCP- oder UDP-Ports enthält
+func hasPorts(info *models.PacketInfo) bool {
+	return info.SourcePort != 0 || info.DestinationPort != 0
+}
+
+// Alle Felder der Filtersprache. Protokollnamen ohne Vergleich prüfen, ob das Paket
+// das Protokoll enthält.
+var fields = []*field{
+	{name: "protocol", typ: typeString, description: "Protokoll des Pakets, z.B. TCP, DNS, ARP",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldString(yield, info.Protocol) }},
+	{name: "length", typ: typeInt, description: "Länge des Pakets in Bytes",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yield(value{num: uint64(info.Length)}) }},
+
+	{name: "ip", typ: typeBool, description: "IPv4- oder IPv6-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, len(info.SourceIP) > 0) }},
+	{name: "ip.src", typ: typeIP, description: "Quelladresse",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldIP(yield, info.SourceIP) }},
+	{name: "ip.dst", typ: typeIP, description: "Zieladresse",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldIP(yield, info.DestinationIP) }},
+	{name: "ip.addr", typ: typeIP, description: "Quell- oder Zieladresse",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if yieldIP(yield, info.SourceIP) {
+				yieldIP(yield, info.DestinationIP)
+			}
+		}},
+	{name: "ip.ttl", typ: typeInt, description: "TTL bzw. Hop-Limit",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if len(info.SourceIP) > 0 {
+				yield(value{num: uint64(info.TTL)})
+			}
+		}},
+
+	{name: "tcp", typ: typeBool, description: "TCP-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.Protocol == "TCP") }},
+	{name: "udp", typ: typeBool, description: "UDP-Paket, auch DNS und DHCP",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			yieldBool(yield, info.Protocol == "UDP" || info.Protocol == "DNS" || info.Protocol == "DHCP")
+		}},
+	{name: "icmp", typ: typeBool, description: "ICMP-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.Protocol == "ICMP") }},
+	{name: "port.src", typ: typeInt, description: "TCP- oder UDP-Quellport",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if hasPorts(info) {
+				yield(value{num: uint64(info.SourcePort)})
+			}
+		}},
+	{name: "port.dst", typ: typeInt, description: "TCP- oder UDP-Zielport",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if hasPorts(info) {
+				yield(value{num: uint64(info.DestinationPort)})
+			}
+		}},
+	{name: "port", typ: typeInt, description: "TCP- oder UDP-Quell- oder Zielport",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if hasPorts(info) && yield(value{num: uint64(info.SourcePort)}) {
+				yield(value{num: uint64(info.DestinationPort)})
+			}
+		}},
+
+	{name: "is_gateway", typ: typeBool, description: "Paket von oder zu einem Gateway",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.IsGatewayTraffic) }},
+	{name: "gateway", typ: typeIP, description: "Beteiligtes Gateway",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldIP(yield, info.GatewayIP) }},
+
+	{name: "dns", typ: typeBool, description: "DNS-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.DNSInfo != nil) }},
+	{name: "dns.is_query", typ: typeBool, description: "DNS-Anfrage",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				yieldBool(yield, info.DNSInfo.IsQuery)
+			}
+		}},
+	{name: "dns.is_answer", typ: typeBool, description: "DNS-Antwort",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				yieldBool(yield, info.DNSInfo.IsAnswer)
+			}
+		}},
+	{name: "dns.query", typ: typeString, description: "Angefragter Name",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, query := range info.DNSInfo.Queries {
+					if !yieldString(yield, query.Name) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dns.query.type", typ: typeString, description: "Typ der Anfrage, z.B. A, AAAA, PTR",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, query := range info.DNSInfo.Queries {
+					if !yieldString(yield, query.Type) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dns.answer.name", typ: typeString, description: "Name in einer Antwort",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, answer := range info.DNSInfo.Answers {
+					if !yieldString(yield, answer.Name) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dns.answer.type", typ: typeString, description: "Typ einer Antwort",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, answer := range info.DNSInfo.Answers {
+					if !yieldString(yield, answer.Type) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dns.answer.data", typ: typeString, description: "Daten einer Antwort, z.B. Adresse oder Zielname",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, answer := range info.DNSInfo.Answers {
+					if !yieldString(yield, answer.Data) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dns.answer.ttl", typ: typeInt, description: "TTL einer Antwort in Sekunden",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DNSInfo != nil {
+				for _, answer := range info.DNSInfo.Answers {
+					if !yield(value{num: uint64(answer.TTL)}) {
+						return
+					}
+				}
+			}
+		}},
+
+	{name: "dhcp", typ: typeBool, description: "DHCP-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.DHCPInfo != nil) }},
+	{name: "dhcp.type", typ: typeString, description: "Nachrichtentyp, z.B. DISCOVER, OFFER, REQUEST, ACK",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldString(yield, info.DHCPInfo.MessageType)
+			}
+		}},
+	{name: "dhcp.client_ip", typ: typeIP, description: "Bisherige Adresse des Clients (ciaddr)",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldIP(yield, info.DHCPInfo.ClientIP)
+			}
+		}},
+	{name: "dhcp.your_ip", typ: typeIP, description: "Zugewiesene Adresse (yiaddr)",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldIP(yield, info.DHCPInfo.YourIP)
+			}
+		}},
+	{name: "dhcp.server_ip", typ: typeIP, description: "Adresse des DHCP-Servers",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldIP(yield, info.DHCPInfo.ServerIP)
+			}
+		}},
+	{name: "dhcp.gateway", typ: typeIP, description: "Gemeldetes Standard-Gateway (Router-Option)",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldIP(yield, info.DHCPInfo.GatewayIP)
+			}
+		}},
+	{name: "dhcp.dns_server", typ: typeIP, description: "Gemeldeter DNS-Server",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				for _, server := range info.DHCPInfo.DNSServers {
+					if !yieldIP(yield, server) {
+						return
+					}
+				}
+			}
+		}},
+	{name: "dhcp.client_mac", typ: typeMAC, description: "MAC-Adresse des Clients",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldString(yield, info.DHCPInfo.ClientMAC)
+			}
+		}},
+	{name: "dhcp.hostname", typ: typeString, description: "Hostname des DHCP-Servers",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil {
+				yieldString(yield, info.DHCPInfo.ServerHostname)
+			}
+		}},
+	{name: "dhcp.lease_time", typ: typeInt, description: "Lease-Dauer in Sekunden",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.DHCPInfo != nil && info.DHCPInfo.LeaseTime != 0 {
+				yield(value{num: uint64(info.DHCPInfo.LeaseTime)})
+			}
+		}},
+
+	{name: "arp", typ: typeBool, description: "ARP-Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.ARPInfo != nil) }},
+	{name: "arp.op", typ: typeString, description: "REQUEST oder REPLY",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldString(yield, info.ARPInfo.Operation)
+			}
+		}},
+	{name: "arp.sender_mac", typ: typeMAC, description: "MAC-Adresse des Absenders",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldString(yield, info.ARPInfo.SenderMAC)
+			}
+		}},
+	{name: "arp.sender_ip", typ: typeIP, description: "IP-Adresse des Absenders",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldIP(yield, info.ARPInfo.SenderIP)
+			}
+		}},
+	{name: "arp.target_mac", typ: typeMAC, description: "MAC-Adresse des Ziels",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldString(yield, info.ARPInfo.TargetMAC)
+			}
+		}},
+	{name: "arp.target_ip", typ: typeIP, description: "IP-Adresse des Ziels",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldIP(yield, info.ARPInfo.TargetIP)
+			}
+		}},
+	{name: "arp.gratuitous", typ: typeBool, description: "Gratuitous ARP (Absender kündigt eigene Adresse an)",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.ARPInfo != nil {
+				yieldBool(yield, info.ARPInfo.IsGratuitous)
+			}
+		}},
+
+	{name: "vlan", typ: typeBool, description: "Paket mit 802.1Q-Tag",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, len(info.VLANs) > 0) }},
+	{name: "vlan.id", typ: typeInt, description: "VLAN-ID, bei QinQ jedes Tags",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tag := range info.VLANs {
+				if !yield(value{num: uint64(tag.ID)}) {
+					return
+				}
+			}
+		}},
+	{name: "vlan.pcp", typ: typeInt, description: "Priorität (PCP) eines VLAN-Tags",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tag := range info.VLANs {
+				if !yield(value{num: uint64(tag.Priority)}) {
+					return
+				}
+			}
+		}},
+
+	{name: "mpls", typ: typeBool, description: "Paket mit MPLS-Labels",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, len(info.MPLSLabels) > 0) }},
+	{name: "mpls.label", typ: typeInt, description: "MPLS-Label des Label-Stapels",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, label := range info.MPLSLabels {
+				if !yield(value{num: uint64(label.Label)}) {
+					return
+				}
+			}
+		}},
+
+	{name: "tunnel", typ: typeBool, description: "Getunneltes Paket; die übrigen Felder beschreiben das innere Paket",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, len(info.Tunnels) > 0) }},
+	{name: "tunnel.type", typ: typeString, description: "Tunnelart: GRE, VXLAN, Geneve oder IP-in-IP",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tunnel := range info.Tunnels {
+				if !yieldString(yield, tunnel.Type) {
+					return
+				}
+			}
+		}},
+	{name: "tunnel.id", typ: typeInt, description: "VNI bei VXLAN und Geneve, Schlüssel bei GRE",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tunnel := range info.Tunnels {
+				if tunnel.ID != 0 && !yield(value{num: uint64(tunnel.ID)}) {
+					return
+				}
+			}
+		}},
+	{name: "tunnel.src", typ: typeIP, description: "Äußere Quelladresse eines Tunnels",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tunnel := range info.Tunnels {
+				if !yieldIP(yield, tunnel.OuterSourceIP) {
+					return
+				}
+			}
+		}},
+	{name: "tunnel.dst", typ: typeIP, description: "Äußere Zieladresse eines Tunnels",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			for _, tunnel := range info.Tunnels {
+				if !yieldIP(yield, tunnel.OuterDestinationIP) {
+					return
+				}
+			}
+		}},
+
+	{name: "nat", typ: typeBool, description: "Paket mit erkannter NAT-Übersetzung",
+		values: func(info *models.PacketInfo, yield func(value) bool) { yieldBool(yield, info.NATInfo != nil) }},
+	{name: "nat.type", typ: typeString, description: "Art der Übersetzung, z.B. SNAT, DNAT, PAT",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.NATInfo != nil {
+				yieldString(yield, info.NATInfo.TranslationType)
+			}
+		}},
+	{name: "nat.src", typ: typeIP, description: "Ursprüngliche Quelladresse",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.NATInfo != nil {
+				yieldIP(yield, info.NATInfo.OriginalSourceIP)
+			}
+		}},
+	{name: "nat.dst", typ: typeIP, description: "Ursprüngliche Zieladresse",
+		values: func(info *models.PacketInfo, yield func(value) bool) {
+			if info.NATInfo != nil {
+				yieldIP(yield, info.NATInfo.OriginalDestinationIP)
+			}
+		}},
+}
+
+// fieldsByName ordnet die Felder ihrem Namen zu
+var fieldsByName = func() map[string]*field {
+	byName := make(map[string]*field, len(fields))
+	for _, f := range fields {
+		byName[f.name] = f
+	}
+	return byName
+}()
+
+// Fields gibt alle Felder der Filtersprache alphabetisch sortiert zurück
+func Fields() []FieldInfo {
+	infos := make([]FieldInfo, 0, len(fields))
+	for _, f := range fields {
+		infos = append(infos, FieldInfo{Name: f.name, Type: f.typ.String(), Description: f.description})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// suggestField sucht zu einem unbekannten Namen das ähnlichste Feld, z.B. "dns.qry" zu
+// "dns.query". Gibt "" zurück, wenn kein Feld nahe genug liegt.
+func suggestField(name string) string {
+	best, bestDistance := "", 3
+	for _, f := range fields {
+		if distance := editDistance(name, f.name); distance < bestDistance {
+			best, bestDistance = f.name, distance
+		}
+	}
+	return best
+}
+
+// editDistance berechnet die Levenshtein-Distanz zweier Zeichenketten
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
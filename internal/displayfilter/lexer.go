@@ -0,0 +1,111 @@
+package displayfilter
+
+import (
+	"strings"
+)
+
+// tokenKind unterscheidet die Tokens eines Filters
+type tokenKind int
+
+const (
+	tokenEOF    tokenKind = iota
+	tokenWord             // Feldname, Schlüsselwort oder Wert ohne Anführungszeichen
+	tokenString           // Wert in Anführungszeichen, text enthält den entschlüsselten Inhalt
+	tokenSymbol           // Operator oder Klammer
+)
+
+// token ist ein Token mit seiner Byte-Position im Filter
+type token struct {
+	kind   tokenKind
+	text   string
+	raw    string // Text im Filter, bei Zeichenketten mit Anführungszeichen
+	offset int
+}
+
+// Symbole der Sprache, längere vor kürzeren
+var symbols = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "~", "(", ")", "{", "}", ","}
+
+// isWordByte meldet, ob ein Zeichen zu einem Wort gehört. Punkte, Doppelpunkte, Schrägstriche
+// und Bindestriche erlauben Feldnamen, IPv6-Adressen, Netze und MAC-Adressen ohne Anführungszeichen.
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == ':' || c == '/' || c == '-' || c >= 0x80
+}
+
+// lex zerlegt einen Filter in Tokens. Das letzte Token ist immer tokenEOF.
+func lex(filter string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '"':
+			text, end, ok := lexString(filter, i)
+			if !ok {
+				return nil, newError(filter, i, filter[i:], "Zeichenkette ist nicht abgeschlossen")
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, raw: filter[i:end], offset: i})
+			i = end
+
+		case isWordByte(c):
+			start := i
+			for i < len(filter) && isWordByte(filter[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: filter[start:i], raw: filter[start:i], offset: start})
+
+		default:
+			symbol := ""
+			for _, s := range symbols {
+				if strings.HasPrefix(filter[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			switch {
+			case symbol != "":
+			case c == '=':
+				return nil, newError(filter, i, "=", "Unerwartetes '=' – meinten Sie '=='?")
+			case c == '&' || c == '|':
+				return nil, newError(filter, i, string(c), "Unerwartetes '%c' – meinten Sie '%c%c'?", c, c, c)
+			default:
+				return nil, newError(filter, i, string(c), "Unerwartetes Zeichen '%c'", c)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: symbol, raw: symbol, offset: i})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, offset: len(filter)}), nil
+}
+
+// lexString liest eine Zeichenkette ab dem öffnenden Anführungszeichen. \" und \\ stehen für
+// das Zeichen selbst, \n und \t für Zeilenumbruch und Tabulator; andere Escapes bleiben
+// unverändert, damit reguläre Ausdrücke wie "\d+" ohne doppelte Backslashes auskommen.
+func lexString(filter string, start int) (text string, end int, ok bool) {
+	var b strings.Builder
+	for i := start + 1; i < len(filter); i++ {
+		c := filter[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, true
+		case c == '\\' && i+1 < len(filter):
+			i++
+			switch filter[i] {
+			case '"', '\\':
+				b.WriteByte(filter[i])
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(filter[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,284 @@
+package displayfilter
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Operatoren mit ihren Schreibweisen; Wörter werden ohne Beachtung der Groß- und
+// Kleinschreibung erkannt
+var operators = map[string]operator{
+	"==": opEqual, "eq": opEqual,
+	"!=": opNotEqual, "ne": opNotEqual,
+	"<": opLess, "lt": opLess,
+	"<=": opLessEqual, "le": opLessEqual,
+	">": opGreater, "gt": opGreater,
+	">=": opGreaterEqual, "ge": opGreaterEqual,
+	"contains": opContains,
+	"matches":  opMatches, "~": opMatches,
+	"in": opIn,
+}
+
+// Schlüsselwörter der logischen Verknüpfungen
+var logicalKeywords = map[string]bool{"and": true, "or": true, "not": true}
+
+// parser übersetzt die Tokens eines Filters mit rekursivem Abstieg. Bindungsstärke von
+// stark nach schwach: Vergleich, not, and, or.
+//
+//	expr       = and { ("||" | "or") and }
+//	and        = unary { ("&&" | "and") unary }
+//	unary      = ("!" | "not") unary | "(" expr ")" | comparison
+//	comparison = field [ op value | "in" "{" value { [","] value } "}" ]
+type parser struct {
+	filter string
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token { return p.tokens[p.pos] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is prüft, ob ein Token ein bestimmtes Symbol oder Schlüsselwort ist
+func (t token) is(texts ...string) bool {
+	if t.kind != tokenSymbol && t.kind != tokenWord {
+		return false
+	}
+	for _, text := range texts {
+		if strings.EqualFold(t.text, text) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorAt erstellt einen Fehler an der Stelle eines Tokens
+func (p *parser) errorAt(t token, format string, args ...interface{}) *Error {
+	return newError(p.filter, t.offset, t.raw, format, args...)
+}
+
+// describe beschreibt ein Token für Fehlermeldungen
+func describe(t token) string {
+	if t.kind == tokenEOF {
+		return "Ende des Ausdrucks"
+	}
+	return "'" + t.raw + "'"
+}
+
+// parse übersetzt den vollständigen Filter
+func (p *parser) parse() (node, error) {
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		if t.is(")") {
+			return nil, p.errorAt(t, "')' ohne öffnende Klammer")
+		}
+		return nil, p.errorAt(t, "Unerwartetes %s – '&&' oder '||' erwartet", describe(t))
+	}
+	return root, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("||", "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is("&&", "and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	switch {
+	case t.is("!", "not"):
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+
+	case t.is("("):
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.peek(); !closing.is(")") {
+			return nil, p.errorAt(closing, "')' zu '(' bei Position %d erwartet", t.offset)
+		}
+		p.next()
+		return inner, nil
+
+	case t.kind == tokenWord && !logicalKeywords[strings.ToLower(t.text)]:
+		return p.parseComparison()
+	}
+
+	if t.kind == tokenEOF {
+		return nil, p.errorAt(t, "Feldname, '!' oder '(' erwartet")
+	}
+	return nil, p.errorAt(t, "Unerwartetes %s – Feldname erwartet", describe(t))
+}
+
+func (p *parser) parseComparison() (node, error) {
+	name := p.next()
+	f, ok := fieldsByName[strings.ToLower(name.text)]
+	if !ok {
+		if suggestion := suggestField(strings.ToLower(name.text)); suggestion != "" {
+			return nil, p.errorAt(name, "Unbekanntes Feld '%s' – meinten Sie '%s'?", name.text, suggestion)
+		}
+		return nil, p.errorAt(name, "Unbekanntes Feld '%s'", name.text)
+	}
+
+	opToken := p.peek()
+	op, ok := operators[strings.ToLower(opToken.text)]
+	if !ok || opToken.kind == tokenString || opToken.kind == tokenEOF {
+		// Feld ohne Vergleich
+		return &existsNode{field: f}, nil
+	}
+	p.next()
+
+	switch op {
+	case opLess, opLessEqual, opGreater, opGreaterEqual:
+		if f.typ != typeInt {
+			return nil, p.errorAt(opToken, "Operator '%s' ist nur für Zahlen möglich, '%s' ist vom Typ %s",
+				opToken.text, f.name, f.typ)
+		}
+	case opContains, opMatches:
+		if f.typ != typeString {
+			return nil, p.errorAt(opToken, "Operator '%s' ist nur für Texte möglich, '%s' ist vom Typ %s",
+				opToken.text, f.name, f.typ)
+		}
+	}
+
+	n := &compareNode{field: f, op: op}
+	if op == opIn {
+		return p.parseSet(n)
+	}
+
+	valueToken := p.next()
+	l, err := p.parseLiteral(f, valueToken)
+	if err != nil {
+		return nil, err
+	}
+	if op == opMatches {
+		if n.re, err = regexp.Compile(l.str); err != nil {
+			return nil, p.errorAt(valueToken, "Ungültiger regulärer Ausdruck: %v", err)
+		}
+	}
+	n.literals = []literal{l}
+	return n, nil
+}
+
+// parseSet liest die Werte von "in { ... }"
+func (p *parser) parseSet(n *compareNode) (node, error) {
+	if open := p.next(); !open.is("{") {
+		return nil, p.errorAt(open, "'{' nach 'in' erwartet, nicht %s", describe(open))
+	}
+	for {
+		t := p.next()
+		if t.is("}") {
+			if len(n.literals) == 0 {
+				return nil, p.errorAt(t, "Die Menge nach 'in' ist leer")
+			}
+			return n, nil
+		}
+		if t.is(",") && len(n.literals) > 0 {
+			continue
+		}
+		if t.kind == tokenEOF {
+			return nil, p.errorAt(t, "'}' erwartet")
+		}
+		l, err := p.parseLiteral(n.field, t)
+		if err != nil {
+			return nil, err
+		}
+		n.literals = append(n.literals, l)
+	}
+}
+
+// parseLiteral prüft einen Vergleichswert gegen den Typ des Felds
+func (p *parser) parseLiteral(f *field, t token) (literal, error) {
+	if t.kind != tokenWord && t.kind != tokenString {
+		return literal{}, p.errorAt(t, "Wert für '%s' erwartet, nicht %s", f.name, describe(t))
+	}
+
+	text := t.text
+	switch f.typ {
+	case typeBool:
+		switch strings.ToLower(text) {
+		case "true", "1":
+			return literal{b: true}, nil
+		case "false", "0":
+			return literal{b: false}, nil
+		}
+		return literal{}, p.errorAt(t, "'%s' erwartet true oder false, nicht '%s'", f.name, text)
+
+	case typeInt:
+		num, err := strconv.ParseUint(text, 0, 64)
+		if err != nil {
+			return literal{}, p.errorAt(t, "'%s' erwartet eine Zahl, nicht '%s'", f.name, text)
+		}
+		return literal{num: num}, nil
+
+	case typeIP:
+		if strings.Contains(text, "/") {
+			_, network, err := net.ParseCIDR(text)
+			if err != nil {
+				return literal{}, p.errorAt(t, "'%s' erwartet eine IP-Adresse oder ein Netz, nicht '%s'", f.name, text)
+			}
+			return literal{network: network}, nil
+		}
+		ip := net.ParseIP(text)
+		if ip == nil {
+			return literal{}, p.errorAt(t, "'%s' erwartet eine IP-Adresse oder ein Netz, nicht '%s'", f.name, text)
+		}
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return literal{network: &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}}, nil
+
+	case typeMAC:
+		mac, err := net.ParseMAC(text)
+		if err != nil {
+			return literal{}, p.errorAt(t, "'%s' erwartet eine MAC-Adresse, nicht '%s'", f.name, text)
+		}
+		return literal{str: mac.String()}, nil
+	}
+
+	return literal{str: text}, nil
+}
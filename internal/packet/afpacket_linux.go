@@ -0,0 +1,297 @@
+//go:build linux
+
+package packet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// Standardwerte für den TPACKET_V3-Ring
+const (
+	afpacketFrameSize           = 4096
+	defaultAFPacketBlockSize    = 1 << 20 // 1 MiB
+	defaultAFPacketNumBlocks    = 64
+	defaultAFPacketBlockTimeout = 64 * time.Millisecond
+
+	// Leser kehren spätestens nach dieser Zeit aus poll() zurück und prüfen, ob das Handle
+	// geschlossen wird; begrenzt damit auch die Wartezeit in Close
+	afpacketPollTimeout = 100 * time.Millisecond
+)
+
+// afpacketFanoutSeq unterscheidet die Fanout-Gruppen mehrerer Sessions desselben Prozesses
+var afpacketFanoutSeq uint32
+
+// afpacketHandle erfasst über einen oder mehrere AF_PACKET-Sockets mit TPACKET_V3-Ring.
+// Bei mehreren Sockets verteilt der Kernel die Pakete per PACKET_FANOUT; jeder Socket wird
+// von einem eigenen Leser gelesen. Das Backend kommt ohne cgo und libpcap aus.
+type afpacketHandle struct {
+	iface    string
+	sockets  []*tpacketSocket
+	linkType layers.LinkType
+	snapLen  int
+
+	// Socket, der nur den Promisc-Modus der Schnittstelle hält; -1 ohne Promisc
+	promiscFD int
+	// rx_dropped der Schnittstelle beim Öffnen, Basis für InterfaceDropped
+	ifDroppedBase uint64
+
+	mutex    sync.Mutex // schützt closed gegen gleichzeitiges Öffnen von Lesern
+	closed   bool
+	stopping uint32         // wird vor dem Schließen gesetzt; Leser liefern danach io.EOF
+	active   sync.WaitGroup // Leser, die noch Daten aus den Ringen referenzieren
+}
+
+// openAFPacket öffnet die Sockets für eine Schnittstelle und setzt den konfigurierten Filter
+func openAFPacket(iface string, cfg *config.CaptureConfig) (*afpacketHandle, error) {
+	opts := config.AFPacketConfig{}
+	if cfg.AFPacket != nil {
+		opts = *cfg.AFPacket
+	}
+	if opts.FanoutSockets < 1 {
+		opts.FanoutSockets = 1
+	}
+	if opts.BlockSize == 0 {
+		opts.BlockSize = defaultAFPacketBlockSize
+	}
+	if opts.NumBlocks == 0 {
+		opts.NumBlocks = defaultAFPacketNumBlocks
+	}
+	blockTimeout := defaultAFPacketBlockTimeout
+	if opts.BlockTimeoutMs > 0 {
+		blockTimeout = time.Duration(opts.BlockTimeoutMs) * time.Millisecond
+	}
+
+	h := &afpacketHandle{
+		iface:     iface,
+		linkType:  interfaceLinkType(iface),
+		snapLen:   cfg.SnapLen,
+		promiscFD: -1,
+	}
+	h.ifDroppedBase, _ = interfaceRxDropped(iface)
+
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Öffnen des AF_PACKET-Sockets auf %s: %w", iface, err)
+	}
+	socketOpts := tpacketOptions{
+		ifindex:      ifi.Index,
+		frameSize:    afpacketFrameSize,
+		blockSize:    opts.BlockSize,
+		numBlocks:    opts.NumBlocks,
+		blockTimeout: blockTimeout,
+		pollTimeout:  afpacketPollTimeout,
+		// VLAN-Tags wie bei libpcap im Paket belassen
+		addVLANHeader: true,
+	}
+
+	for i := 0; i < opts.FanoutSockets; i++ {
+		socket, err := newTPacketSocket(socketOpts)
+		if err != nil {
+			h.Close()
+			return nil, fmt.Errorf("Fehler beim Öffnen des AF_PACKET-Sockets auf %s: %w", iface, err)
+		}
+		h.sockets = append(h.sockets, socket)
+	}
+
+	if len(h.sockets) > 1 {
+		fanoutType, err := afpacketFanoutType(opts.FanoutMode)
+		if err != nil {
+			h.Close()
+			return nil, err
+		}
+		id := uint16(os.Getpid()) + uint16(atomic.AddUint32(&afpacketFanoutSeq, 1))
+		for _, socket := range h.sockets {
+			if err := socket.setFanout(fanoutType, id); err != nil {
+				h.Close()
+				return nil, fmt.Errorf("Fehler beim Einrichten von PACKET_FANOUT: %w", err)
+			}
+		}
+	}
+
+	if cfg.PromiscMode {
+		if err := h.enablePromisc(); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("Fehler beim Setzen des Promisc-Modus: %w", err)
+		}
+	}
+
+	if cfg.Filter != "" {
+		if err := h.SetBPFFilter(cfg.Filter); err != nil {
+			h.Close()
+			return nil, fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
+		}
+	}
+
+	return h, nil
+}
+
+// afpacketFanoutType übersetzt fanout_mode. Hash-Fanout setzt Fragmente vor der Verteilung
+// zusammen, damit alle Pakete einer Verbindung beim selben Socket ankommen.
+func afpacketFanoutType(mode string) (uint16, error) {
+	switch mode {
+	case "", config.FanoutModeHash:
+		return unix.PACKET_FANOUT_HASH | unix.PACKET_FANOUT_FLAG_DEFRAG, nil
+	case config.FanoutModeLoadBalance:
+		return unix.PACKET_FANOUT_LB, nil
+	case config.FanoutModeCPU:
+		return unix.PACKET_FANOUT_CPU, nil
+	}
+	return 0, fmt.Errorf("unbekannter fanout_mode '%s'", mode)
+}
+
+// enablePromisc aktiviert den Promisc-Modus über eine Mitgliedschaft auf einem eigenen
+// Socket. Der Kernel nimmt ihn zurück, sobald der Socket geschlossen wird.
+func (h *afpacketHandle) enablePromisc() error {
+	ifi, err := net.InterfaceByName(h.iface)
+	if err != nil {
+		return err
+	}
+
+	// Protokoll 0: der Socket empfängt selbst keine Pakete
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, 0)
+	if err != nil {
+		return err
+	}
+	mreq := unix.PacketMreq{Ifindex: int32(ifi.Index), Type: unix.PACKET_MR_PROMISC}
+	if err := unix.SetsockoptPacketMreq(fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		unix.Close(fd)
+		return err
+	}
+	h.promiscFD = fd
+	return nil
+}
+
+// LinkType gibt den Linktyp der Schnittstelle zurück
+func (h *afpacketHandle) LinkType() layers.LinkType {
+	return h.linkType
+}
+
+// SetBPFFilter übersetzt den Filter und setzt ihn auf allen Sockets. Ohne libpcap werden
+// nur vorkompilierte Programme angenommen (siehe CompileFilter).
+func (h *afpacketHandle) SetBPFFilter(filter string) error {
+	program, err := CompileFilter(filter, h.linkType, h.snapLen)
+	if err != nil {
+		return err
+	}
+
+	for _, socket := range h.sockets {
+		if err := socket.setBPF(program); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *afpacketHandle) readers() []packetReader {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.closed {
+		return nil
+	}
+
+	readers := make([]packetReader, len(h.sockets))
+	for i, socket := range h.sockets {
+		h.active.Add(1)
+		readers[i] = &afpacketReader{handle: h, socket: socket}
+	}
+	return readers
+}
+
+// kernelStats summiert die Verluste aller Sockets. InterfaceDropped ist wie bei libpcap
+// der Anstieg von rx_dropped der Schnittstelle seit dem Öffnen.
+func (h *afpacketHandle) kernelStats() (CaptureStats, error) {
+	var stats CaptureStats
+	for _, socket := range h.sockets {
+		dropped, err := socket.dropped()
+		if err != nil {
+			return CaptureStats{}, err
+		}
+		stats.KernelDropped += dropped
+	}
+
+	if dropped, err := interfaceRxDropped(h.iface); err == nil && dropped >= h.ifDroppedBase {
+		stats.InterfaceDropped = dropped - h.ifDroppedBase
+	}
+	return stats, nil
+}
+
+// Close beendet alle Leser und schließt danach die Sockets. Das Schließen gibt den Ring
+// frei, ohne auf laufende Lesezugriffe zu warten; daher wird zuerst gewartet, bis kein
+// Leser mehr Daten aus dem Ring referenziert.
+func (h *afpacketHandle) Close() {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return
+	}
+	h.closed = true
+	h.mutex.Unlock()
+
+	atomic.StoreUint32(&h.stopping, 1)
+	h.active.Wait()
+
+	for _, socket := range h.sockets {
+		socket.close()
+	}
+	if h.promiscFD >= 0 {
+		unix.Close(h.promiscFD)
+		h.promiscFD = -1
+	}
+}
+
+// afpacketReader liest die Pakete eines Sockets
+type afpacketReader struct {
+	handle   *afpacketHandle
+	socket   *tpacketSocket
+	released sync.Once
+}
+
+// ZeroCopyReadPacketData liefert das nächste Paket aus dem Ring. Poll-Timeouts werden
+// intern wiederholt; wird das Handle geschlossen, folgt io.EOF.
+func (r *afpacketReader) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for atomic.LoadUint32(&r.handle.stopping) == 0 {
+		data, ci, err := r.socket.ZeroCopyReadPacketData()
+		if err != errTPacketTimeout {
+			return data, ci, err
+		}
+	}
+	return nil, gopacket.CaptureInfo{}, io.EOF
+}
+
+func (r *afpacketReader) release() {
+	r.released.Do(r.handle.active.Done)
+}
+
+// interfaceLinkType bestimmt den Linktyp aus dem ARPHRD-Typ der Schnittstelle. Schnittstellen
+// ohne Link-Layer-Header (z.B. tun) liefern reine IP-Pakete, alle anderen Ethernet-Rahmen.
+func interfaceLinkType(iface string) layers.LinkType {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/class/net/%s/type", iface))
+	if err == nil && strings.TrimSpace(string(data)) == strconv.Itoa(unix.ARPHRD_NONE) {
+		return layers.LinkTypeRaw
+	}
+	return layers.LinkTypeEthernet
+}
+
+// interfaceRxDropped liest den Zähler der von der Schnittstelle verworfenen Pakete
+func interfaceRxDropped(iface string) (uint64, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/sys/class/net/%s/statistics/rx_dropped", iface))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,32 @@
+//go:build !linux
+
+package packet
+
+import (
+	"errors"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// afpacketHandle ist nur unter Linux verfügbar
+type afpacketHandle struct {
+	sockets []struct{}
+}
+
+// openAFPacket meldet auf anderen Systemen, dass das Backend nicht unterstützt wird
+func openAFPacket(iface string, cfg *config.CaptureConfig) (*afpacketHandle, error) {
+	return nil, errors.New("Das AF_PACKET-Backend wird nur unter Linux unterstützt")
+}
+
+func (h *afpacketHandle) LinkType() layers.LinkType          { return layers.LinkTypeEthernet }
+func (h *afpacketHandle) SetBPFFilter(filter string) error   { return nil }
+func (h *afpacketHandle) readers() []packetReader            { return nil }
+func (h *afpacketHandle) kernelStats() (CaptureStats, error) { return CaptureStats{}, nil }
+func (h *afpacketHandle) Close()                             {}
+
+// interfaceLinkType nimmt ohne sysfs Ethernet an
+func interfaceLinkType(iface string) layers.LinkType {
+	return layers.LinkTypeEthernet
+}
@@ -0,0 +1,207 @@
+package packet
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Höchstzahl der Ereignisse im Ergebnis einer Dateianalyse; weitere werden nur gezählt
+const maxAnalysisEvents = 10000
+
+// Rollen eines Gateways im Analyseergebnis
+const (
+	GatewayRoleDefault    = "default_gateway" // per DHCP als Router gemeldet
+	GatewayRoleDHCPServer = "dhcp_server"
+	GatewayRoleDNSServer  = "dns_server"
+)
+
+// AnalysisStatistics enthält die Zähler einer vollständig ausgewerteten Datei
+type AnalysisStatistics struct {
+	TotalPackets      uint64            `json:"total_packets"`
+	TotalBytes        uint64            `json:"total_bytes"`
+	GatewayPackets    uint64            `json:"gateway_packets"`
+	GatewayPercentage float64           `json:"gateway_percentage"`
+	Protocols         map[string]uint64 `json:"protocols"`
+	FirstPacket       time.Time         `json:"first_packet,omitempty"`
+	LastPacket        time.Time         `json:"last_packet,omitempty"`
+	DurationSeconds   float64           `json:"duration_seconds"`
+	Capture           CaptureStats      `json:"capture"` // Zähler der Session, u.a. Dekodierfehler
+}
+
+// AnalysisGateway ist ein in der Datei beobachtetes Gateway. Gleiche Adressen in
+// verschiedenen VLANs ergeben getrennte Einträge.
+type AnalysisGateway struct {
+	IP      string   `json:"ip"`
+	VLANs   []uint16 `json:"vlans,omitempty"` // VLAN-IDs, äußeres Tag zuerst
+	MAC     string   `json:"mac,omitempty"`
+	Packets uint64   `json:"packets"`
+	Roles   []string `json:"roles,omitempty"`
+
+	scope vlanScope
+}
+
+// AnalysisResult ist das vollständige Ergebnis einer Dateianalyse
+type AnalysisResult struct {
+	Statistics    AnalysisStatistics    `json:"statistics"`
+	Gateways      []AnalysisGateway     `json:"gateways"`
+	Events        []models.GatewayEvent `json:"events"`
+	EventsDropped int                   `json:"events_dropped,omitempty"` // über maxAnalysisEvents hinaus
+	Findings      map[string]int        `json:"findings"`                 // Anzahl je Befundart
+}
+
+// AnalysisCollector sammelt aus den analysierten Paketen einer Datei Statistiken, Gateways
+// und Ereignisse. Pakete werden nicht aufbewahrt und können nach Add freigegeben werden.
+// Ein Collector ist nicht threadsicher.
+type AnalysisCollector struct {
+	stats     AnalysisStatistics
+	gateways  map[scopedIPKey]*AnalysisGateway
+	roles     map[scopedIPKey]map[string]bool
+	annotator *packetAnnotator
+
+	events        []models.GatewayEvent
+	eventsDropped int
+}
+
+// NewAnalysisCollector erstellt einen leeren Collector
+func NewAnalysisCollector() *AnalysisCollector {
+	return &AnalysisCollector{
+		stats:     AnalysisStatistics{Protocols: make(map[string]uint64)},
+		gateways:  make(map[scopedIPKey]*AnalysisGateway),
+		roles:     make(map[scopedIPKey]map[string]bool),
+		annotator: newPacketAnnotator(),
+	}
+}
+
+// Add wertet ein analysiertes Paket aus
+func (c *AnalysisCollector) Add(info *models.PacketInfo) {
+	stats := &c.stats
+	stats.TotalPackets++
+	stats.TotalBytes += uint64(info.Length)
+	stats.Protocols[info.Protocol]++
+	if !info.Timestamp.IsZero() {
+		if stats.FirstPacket.IsZero() || info.Timestamp.Before(stats.FirstPacket) {
+			stats.FirstPacket = info.Timestamp
+		}
+		if info.Timestamp.After(stats.LastPacket) {
+			stats.LastPacket = info.Timestamp
+		}
+	}
+
+	if info.IsGatewayTraffic {
+		stats.GatewayPackets++
+	}
+	if info.GatewayIP != nil {
+		gateway := c.gateway(info.VLANs, info.GatewayIP)
+		gateway.Packets++
+
+		// MAC-Adresse aus ARP-Paketen des Gateways übernehmen
+		if arp := info.ARPInfo; arp != nil && info.GatewayIP.Equal(arp.SenderIP) {
+			gateway.MAC = arp.SenderMAC
+		}
+	}
+
+	// Rollen aus DHCP- und DNS-Antworten
+	if dhcp := info.DHCPInfo; dhcp != nil && (dhcp.MessageType == "OFFER" || dhcp.MessageType == "ACK") {
+		if len(info.SourceIP) > 0 {
+			c.addRole(info.VLANs, info.SourceIP, GatewayRoleDHCPServer)
+		}
+		if dhcp.GatewayIP != nil && !dhcp.GatewayIP.IsUnspecified() {
+			c.gateway(info.VLANs, dhcp.GatewayIP)
+			c.addRole(info.VLANs, dhcp.GatewayIP, GatewayRoleDefault)
+		}
+		for _, dnsServer := range dhcp.DNSServers {
+			c.addRole(info.VLANs, dnsServer, GatewayRoleDNSServer)
+		}
+	}
+	if dns := info.DNSInfo; dns != nil && dns.IsAnswer && len(info.SourceIP) > 0 {
+		c.addRole(info.VLANs, info.SourceIP, GatewayRoleDNSServer)
+	}
+
+	for _, finding := range c.annotator.annotate(info, false) {
+		if len(c.events) >= maxAnalysisEvents {
+			c.eventsDropped++
+			continue
+		}
+		event := models.GatewayEvent{
+			Timestamp:   info.Timestamp,
+			EventType:   finding.Type,
+			Description: finding.Description,
+			Severity:    "warning",
+		}
+		if info.GatewayIP != nil {
+			event.GatewayIP = info.GatewayIP.String()
+		}
+		c.events = append(c.events, event)
+	}
+}
+
+// gateway gibt den Eintrag eines Gateways im VLAN des Pakets zurück und legt ihn bei Bedarf an
+func (c *AnalysisCollector) gateway(tags []models.VLANTag, ip net.IP) *AnalysisGateway {
+	key := scopedIPKey{scope: scopeOf(tags), ip: makeIPKey(ip)}
+	gateway, ok := c.gateways[key]
+	if !ok {
+		gateway = &AnalysisGateway{IP: ip.String(), VLANs: vlanIDs(tags), scope: key.scope}
+		c.gateways[key] = gateway
+	}
+	return gateway
+}
+
+// addRole merkt sich eine Rolle einer Adresse im VLAN des Pakets; sie erscheint nur bei
+// Gateways im Ergebnis
+func (c *AnalysisCollector) addRole(tags []models.VLANTag, ip net.IP, role string) {
+	key := scopedIPKey{scope: scopeOf(tags), ip: makeIPKey(ip)}
+	if c.roles[key] == nil {
+		c.roles[key] = make(map[string]bool)
+	}
+	c.roles[key][role] = true
+}
+
+// Result gibt das Ergebnis mit den Zählern der Session zurück. Gateways sind nach Anzahl der
+// Pakete sortiert, Ereignisse in der Reihenfolge der Verarbeitung.
+func (c *AnalysisCollector) Result(captureStats CaptureStats) *AnalysisResult {
+	stats := c.stats
+	stats.Capture = captureStats
+	if stats.TotalPackets > 0 {
+		stats.GatewayPercentage = float64(stats.GatewayPackets) / float64(stats.TotalPackets) * 100
+	}
+	if !stats.FirstPacket.IsZero() {
+		stats.DurationSeconds = stats.LastPacket.Sub(stats.FirstPacket).Seconds()
+	}
+
+	gateways := make([]AnalysisGateway, 0, len(c.gateways))
+	for key, gateway := range c.gateways {
+		entry := *gateway
+		entry.Roles = nil
+		for _, role := range []string{GatewayRoleDefault, GatewayRoleDHCPServer, GatewayRoleDNSServer} {
+			if c.roles[key][role] {
+				entry.Roles = append(entry.Roles, role)
+			}
+		}
+		gateways = append(gateways, entry)
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].Packets != gateways[j].Packets {
+			return gateways[i].Packets > gateways[j].Packets
+		}
+		if gateways[i].IP != gateways[j].IP {
+			return gateways[i].IP < gateways[j].IP
+		}
+		return gateways[i].scope < gateways[j].scope
+	})
+
+	findings := make(map[string]int, len(c.annotator.findings))
+	for finding, count := range c.annotator.findings {
+		findings[finding] = count
+	}
+
+	return &AnalysisResult{
+		Statistics:    stats,
+		Gateways:      gateways,
+		Events:        append([]models.GatewayEvent{}, c.events...),
+		EventsDropped: c.eventsDropped,
+		Findings:      findings,
+	}
+}
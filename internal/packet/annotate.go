@@ -0,0 +1,139 @@
+package packet
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/pcapng"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Befundarten der Annotation; sie bilden die Schlüssel der Zusammenfassung
+const (
+	FindingARPSpoofing   = "arp_spoofing"
+	FindingRogueDHCP     = "rogue_dhcp"
+	FindingGatewayChange = "dhcp_gateway_change"
+	FindingDecodeError   = "decode_error"
+)
+
+// packetFinding ist ein Befund zu einem einzelnen Paket
+type packetFinding struct {
+	Type        string
+	Description string
+}
+
+// packetAnnotator wertet eine Paketfolge in Dateireihenfolge aus und beschreibt Auffälligkeiten
+// zum jeweiligen Paket. ARP- und DHCP-Zustand wird je VLAN-Bereich geführt, damit Geräte
+// verschiedener VLANs nicht als Spoofing oder Rogue-DHCP gelten. Zusätzlich sammelt er
+// Namensauflösungen aus DNS-Antworten. Ein Annotator ist nicht threadsicher.
+type packetAnnotator struct {
+	arpTable     map[scopedIPKey]string // IP zu zuletzt gesehener MAC
+	dhcpServers  map[vlanScope]net.IP   // erster antwortender DHCP-Server
+	dhcpGateways map[vlanScope]net.IP   // zuletzt per DHCP gemeldetes Gateway
+
+	names     map[ipKey]*pcapng.NameRecord
+	nameOrder []ipKey
+
+	findings map[string]int
+}
+
+// newPacketAnnotator erstellt einen Annotator ohne Vorwissen
+func newPacketAnnotator() *packetAnnotator {
+	return &packetAnnotator{
+		arpTable:     make(map[scopedIPKey]string),
+		dhcpServers:  make(map[vlanScope]net.IP),
+		dhcpGateways: make(map[vlanScope]net.IP),
+		names:        make(map[ipKey]*pcapng.NameRecord),
+		findings:     make(map[string]int),
+	}
+}
+
+// annotate gibt die Befunde zu einem analysierten Paket zurück
+func (a *packetAnnotator) annotate(info *models.PacketInfo, decodeFailed bool) []packetFinding {
+	var findings []packetFinding
+	add := func(finding, format string, args ...interface{}) {
+		a.findings[finding]++
+		findings = append(findings, packetFinding{Type: finding, Description: fmt.Sprintf(format, args...)})
+	}
+
+	if decodeFailed {
+		add(FindingDecodeError, "Paket konnte nicht vollständig dekodiert werden")
+	}
+	vlan := scopeOf(info.VLANs)
+
+	// ARP: Wechsel der MAC-Adresse zu einer bekannten IP (ARP-Spoofing)
+	if arp := info.ARPInfo; arp != nil && len(arp.SenderIP) > 0 && !arp.SenderIP.IsUnspecified() {
+		key := scopedIPKey{scope: vlan, ip: makeIPKey(arp.SenderIP)}
+		if previous, known := a.arpTable[key]; known && previous != arp.SenderMAC {
+			target := ""
+			if info.IsGatewayTraffic && arp.SenderIP.Equal(info.GatewayIP) {
+				target = " (Gateway)"
+			}
+			add(FindingARPSpoofing, "ARP-Spoofing vermutet: %s%s%s wird jetzt von %s beansprucht, zuvor von %s",
+				arp.SenderIP, target, inVLAN(info.VLANs), arp.SenderMAC, previous)
+		}
+		a.arpTable[key] = arp.SenderMAC
+	}
+
+	// DHCP: Antworten eines weiteren Servers und wechselnde Gateways
+	if dhcp := info.DHCPInfo; dhcp != nil && (dhcp.MessageType == "OFFER" || dhcp.MessageType == "ACK") {
+		server, first := info.SourceIP, a.dhcpServers[vlan]
+		switch {
+		case len(server) == 0:
+		case first == nil:
+			a.dhcpServers[vlan] = copyIP(server)
+		case !server.Equal(first):
+			add(FindingRogueDHCP, "Weiterer DHCP-Server %s%s (zuerst gesehen: %s) – möglicher Rogue-DHCP-Server",
+				server, inVLAN(info.VLANs), first)
+		}
+
+		if dhcp.GatewayIP != nil && !dhcp.GatewayIP.IsUnspecified() {
+			if previous := a.dhcpGateways[vlan]; previous != nil && !dhcp.GatewayIP.Equal(previous) {
+				add(FindingGatewayChange, "DHCP meldet Gateway %s%s, zuvor %s",
+					dhcp.GatewayIP, inVLAN(info.VLANs), previous)
+			}
+			a.dhcpGateways[vlan] = copyIP(dhcp.GatewayIP)
+		}
+	}
+
+	// DNS: A- und AAAA-Antworten als Namensauflösung übernehmen
+	if dns := info.DNSInfo; dns != nil && dns.IsAnswer {
+		for _, answer := range dns.Answers {
+			if answer.Type == "A" || answer.Type == "AAAA" {
+				a.addName(net.ParseIP(answer.Data), answer.Name)
+			}
+		}
+	}
+
+	return findings
+}
+
+// addName ergänzt einen Namen zu einer Adresse
+func (a *packetAnnotator) addName(ip net.IP, name string) {
+	if ip == nil || name == "" {
+		return
+	}
+
+	key := makeIPKey(ip)
+	record, ok := a.names[key]
+	if !ok {
+		record = &pcapng.NameRecord{IP: ip}
+		a.names[key] = record
+		a.nameOrder = append(a.nameOrder, key)
+	}
+	for _, existing := range record.Names {
+		if existing == name {
+			return
+		}
+	}
+	record.Names = append(record.Names, name)
+}
+
+// nameRecords gibt die gesammelten Namensauflösungen in der Reihenfolge ihres Auftretens zurück
+func (a *packetAnnotator) nameRecords() []pcapng.NameRecord {
+	records := make([]pcapng.NameRecord, 0, len(a.nameOrder))
+	for _, key := range a.nameOrder {
+		records = append(records, *a.names[key])
+	}
+	return records
+}
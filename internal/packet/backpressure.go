@@ -0,0 +1,109 @@
+package packet
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Standardwerte, falls die Konfiguration keine Angaben enthält
+const (
+	defaultChannelSize = 1000
+	defaultSampleRate  = 10
+)
+
+// packetQueue leitet analysierte Pakete gemäß der Backpressure-Richtlinie an die Verarbeitung weiter
+type packetQueue struct {
+	sampled    uint64 // unter Last gesehene Pakete für "sample" (atomar, vorn für 64-Bit-Ausrichtung)
+	ch         chan *models.PacketInfo
+	policy     string
+	sampleRate uint64
+	counters   *captureCounters
+}
+
+// newPacketQueue erstellt die Warteschlange einer Erfassung. Offline-Erfassungen
+// blockieren immer, damit die Analyse einer Datei vollständig und reproduzierbar ist.
+func newPacketQueue(cfg *config.CaptureConfig, live bool, counters *captureCounters) *packetQueue {
+	size := cfg.ChannelSize
+	if size <= 0 {
+		size = defaultChannelSize
+	}
+	sampleRate := cfg.SampleRate
+	if sampleRate <= 0 {
+		sampleRate = defaultSampleRate
+	}
+
+	policy := cfg.BackpressurePolicy
+	switch {
+	case !live:
+		policy = config.BackpressureBlock
+	case policy == "":
+		policy = config.BackpressureDropNewest
+	}
+
+	return &packetQueue{
+		ch:         make(chan *models.PacketInfo, size),
+		policy:     policy,
+		sampleRate: uint64(sampleRate),
+		counters:   counters,
+	}
+}
+
+// push übergibt ein Paket an die Warteschlange. Gibt false zurück, wenn der Kontext
+// beendet wurde, während auf freien Platz gewartet wurde.
+func (q *packetQueue) push(ctx context.Context, info *models.PacketInfo) bool {
+	switch q.policy {
+	case config.BackpressureBlock:
+		select {
+		case q.ch <- info:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+
+	case config.BackpressureDropOldest:
+		for {
+			select {
+			case q.ch <- info:
+				return true
+			default:
+			}
+			// Ältestes Paket entfernen und erneut versuchen; hat die Verarbeitung
+			// inzwischen selbst gelesen, ist ohnehin wieder Platz
+			select {
+			case dropped := <-q.ch:
+				atomic.AddUint64(&q.counters.pipelineDropped, 1)
+				ReleasePacketInfo(dropped)
+			default:
+			}
+		}
+
+	case config.BackpressureSample:
+		// Erst ab halb voller Warteschlange ausdünnen
+		if len(q.ch) >= cap(q.ch)/2 {
+			if atomic.AddUint64(&q.sampled, 1)%q.sampleRate != 0 {
+				atomic.AddUint64(&q.counters.pipelineSampled, 1)
+				ReleasePacketInfo(info)
+				return true
+			}
+		}
+		q.tryPush(info)
+		return true
+
+	default: // config.BackpressureDropNewest
+		q.tryPush(info)
+		return true
+	}
+}
+
+// tryPush sendet ohne zu warten und zählt das Paket bei voller Warteschlange als verworfen
+func (q *packetQueue) tryPush(info *models.PacketInfo) {
+	select {
+	case q.ch <- info:
+	default:
+		atomic.AddUint64(&q.counters.pipelineDropped, 1)
+		ReleasePacketInfo(info)
+	}
+}
@@ -1,61 +1,71 @@
 package packet
 
 import (
-	"context"
 	"fmt"
 	"net"
 	"os"
-	"time"
+	"sync"
 
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
-	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
 
 	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
 	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
 )
 
-// Capturer ist die Schnittstelle für Paketerfassung
+// Capturer öffnet unabhängige Capture-Sessions. Jede Session besitzt ein eigenes Handle,
+// eigene Kanäle und einen eigenen Lebenszyklus, sodass mehrere Erfassungen gleichzeitig
+// laufen und nacheinander gestartet werden können.
 type Capturer interface {
-	OpenPcapFile(path string) error
-	OpenLiveCapture(interfaceName string) error
-	StartCapture(ctx context.Context) (<-chan *models.PacketInfo, <-chan error)
-	Close() error
+	OpenPcapFile(path string) (*CaptureSession, error)
+	OpenLiveCapture(interfaceName, filter string) (*CaptureSession, error)
 }
 
-// PcapCapturer ist die Implementierung der Capturer-Schnittstelle
+// PcapCapturer erzeugt Capture-Sessions mit libpcap und analysiert deren Pakete. Die
+// Gateway-Erkennung wird von allen Sessions und Dekodier-Workern gemeinsam genutzt.
 type PcapCapturer struct {
 	config      *config.CaptureConfig
 	gwConfig    *config.GatewayConfig
-	handle      *pcap.Handle
-	packetChan  chan *models.PacketInfo
-	errorChan   chan error
 	gatewayInfo *GatewayDetector
 }
 
-// GatewayDetector enthält Informationen über das erkannte Gateway
+// Höchstzahl der VLAN-Bereiche mit eigener Gateway-Erkennung. Pakete weiterer Bereiche werden
+// nur gegen die konfigurierten Gateways geprüft, damit gefälschte Tags den Speicher nicht füllen.
+const maxGatewayScopes = 4096
+
+// GatewayDetector enthält Informationen über die erkannten Gateways. Jedes VLAN bzw. jeder
+// QinQ-Stapel bildet einen eigenen Bereich, da gleiche Adressen in verschiedenen VLANs
+// verschiedene Geräte sein können. Die Analyse läuft parallel in mehreren Workern; Zugriffe
+// auf die Tabellen sind daher über mutex geschützt.
 type GatewayDetector struct {
-	knownGateways map[string]bool // IP-Adressen als Strings
+	mutex      sync.RWMutex
+	configured map[ipKey]bool // konfigurierte Gateways, gelten in allen VLANs
+	localNets  []*net.IPNet   // Netze der eigenen Schnittstellen, gelten nur ohne VLAN-Tag
+	scopes     map[vlanScope]*gatewayScope
+}
+
+// gatewayScope enthält die Gateway-Erkennung eines VLAN-Bereichs
+type gatewayScope struct {
+	knownGateways map[ipKey]bool
 	gatewayIP     net.IP
 	gatewayMAC    net.HardwareAddr
-	localNets     []*net.IPNet
-	dhcpServers   map[string]bool   // DHCP-Server IPs
-	dnsServers    map[string]bool   // DNS-Server IPs
+	dhcpServers   map[ipKey]bool    // DHCP-Server IPs
+	dnsServers    map[ipKey]bool    // DNS-Server IPs
 	arpTable      map[string]string // IP zu MAC
 }
 
 // NewPcapCapturer erstellt einen neuen PcapCapturer
 func NewPcapCapturer(cfg *config.Config) *PcapCapturer {
 	gwDetector := &GatewayDetector{
-		knownGateways: make(map[string]bool),
-		dhcpServers:   make(map[string]bool),
-		dnsServers:    make(map[string]bool),
-		arpTable:      make(map[string]string),
+		configured: make(map[ipKey]bool),
+		scopes:     make(map[vlanScope]*gatewayScope),
 	}
 
 	// Bekannte Gateways hinzufügen
 	for _, gw := range cfg.Gateway.KnownGateways {
-		gwDetector.knownGateways[gw] = true
+		if ip := net.ParseIP(gw); ip != nil {
+			gwDetector.configured[makeIPKey(ip)] = true
+		}
 	}
 
 	// Lokale Netzwerke erkennen
@@ -72,353 +82,146 @@ func NewPcapCapturer(cfg *config.Config) *PcapCapturer {
 	// Default-Gateway ermitteln
 	defaultGW, _ := getDefaultGateway()
 	if defaultGW != nil {
-		gwDetector.gatewayIP = defaultGW
+		gwDetector.scopeLocked(0, true).gatewayIP = defaultGW
 	}
 
 	return &PcapCapturer{
 		config:      &cfg.Capture,
 		gwConfig:    &cfg.Gateway,
-		packetChan:  make(chan *models.PacketInfo, 1000),
-		errorChan:   make(chan error, 10),
 		gatewayInfo: gwDetector,
 	}
 }
 
-// OpenPcapFile öffnet eine PCAP-Datei zum Lesen
-func (c *PcapCapturer) OpenPcapFile(path string) error {
-	var err error
-	c.handle, err = pcap.OpenOffline(path)
+// OpenPcapFile öffnet eine PCAP- oder PCAPNG-Datei als neue Session. PCAPNG-Dateien werden
+// mit eigenem Reader gelesen, damit auch Schnittstellen mit verschiedenen Linktypen
+// ausgewertet werden.
+func (c *PcapCapturer) OpenPcapFile(path string) (*CaptureSession, error) {
+	session, err := c.openPcapFile(path)
 	if err != nil {
-		return fmt.Errorf("Fehler beim Öffnen der PCAP-Datei: %w", err)
+		return nil, err
 	}
-
-	if c.config.Filter != "" {
-		if err := c.handle.SetBPFFilter(c.config.Filter); err != nil {
-			return fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
-		}
+	if info, err := os.Stat(path); err == nil {
+		session.fileSize = info.Size()
 	}
-
-	return nil
+	return session, nil
 }
 
-// OpenLiveCapture öffnet eine Live-Netzwerkschnittstelle für die Erfassung
-func (c *PcapCapturer) OpenLiveCapture(interfaceName string) error {
-	var err error
-
-	// Prüfen, ob es sich um eine Bridge-Schnittstelle handelt
-	isBridge := false
-	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/bridge", interfaceName)); err == nil {
-		isBridge = true
-		fmt.Printf("Bridge-Interface erkannt: %s\n", interfaceName)
-
-		// Für Bridge-Interfaces: Promisc-Modus erzwingen und BufferSize erhöhen
-		c.config.PromiscMode = true
-
-		// Buffer-Größe für Bridge-Interfaces erhöhen
-		if c.config.BufferSize < 8*1024*1024 {
-			c.config.BufferSize = 8 * 1024 * 1024 // 8MB für Bridge-Interfaces
-			fmt.Printf("Buffer-Größe für Bridge-Interface auf %d Bytes erhöht\n", c.config.BufferSize)
-		}
-	}
-
-	// Konfigurieren der pcap-Bibliothek für Live-Capture
-	inactive, err := pcap.NewInactiveHandle(interfaceName)
+// openPcapFile wählt anhand der Dateikennung den Reader und setzt den konfigurierten Filter
+func (c *PcapCapturer) openPcapFile(path string) (*CaptureSession, error) {
+	isPcapng, err := isPcapngFile(path)
 	if err != nil {
-		return fmt.Errorf("Fehler beim Erstellen des inaktiven Handles: %w", err)
-	}
-	defer inactive.CleanUp()
-
-	// SnapLen setzen (maximale Paketgröße)
-	if err := inactive.SetSnapLen(c.config.SnapLen); err != nil {
-		return fmt.Errorf("Fehler beim Setzen von SnapLen: %w", err)
+		return nil, fmt.Errorf("Fehler beim Öffnen der PCAP-Datei: %w", err)
 	}
-
-	// Promisc-Modus setzen
-	if err := inactive.SetPromisc(c.config.PromiscMode); err != nil {
-		return fmt.Errorf("Fehler beim Setzen des Promisc-Modus: %w", err)
-	}
-
-	// Timeout setzen (BlockForever = -1)
-	if err := inactive.SetTimeout(pcap.BlockForever); err != nil {
-		return fmt.Errorf("Fehler beim Setzen des Timeouts: %w", err)
-	}
-
-	// Buffer-Größe setzen
-	if err := inactive.SetBufferSize(c.config.BufferSize); err != nil {
-		return fmt.Errorf("Fehler beim Setzen der Buffer-Größe: %w", err)
-	}
-
-	// Für Bridge-Interfaces: Immediate-Modus aktivieren (falls verfügbar)
-	if isBridge {
-		// Immediate-Modus ist plattformspezifisch, daher mit Fehlerbehandlung
-		if err := inactive.SetImmediateMode(true); err != nil {
-			// Fehler nur loggen, nicht abbrechen
-			fmt.Printf("Warnung: Immediate-Modus konnte nicht aktiviert werden: %v\n", err)
+	if isPcapng {
+		handle, err := openPcapngFile(path, c.config.SnapLen)
+		if err != nil {
+			return nil, fmt.Errorf("Fehler beim Öffnen der PCAPNG-Datei: %w", err)
 		}
+		if c.config.Filter != "" {
+			if err := handle.SetBPFFilter(c.config.Filter); err != nil {
+				handle.Close()
+				return nil, fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
+			}
+		}
+		return newCaptureSession(c, handle, path, false), nil
 	}
 
-	// Handle aktivieren
-	c.handle, err = inactive.Activate()
+	handle, err := openLibpcapFile(path)
 	if err != nil {
-		return fmt.Errorf("Fehler beim Aktivieren des Handles: %w", err)
+		return nil, fmt.Errorf("Fehler beim Öffnen der PCAP-Datei: %w", err)
 	}
 
-	// BPF-Filter setzen, falls konfiguriert
 	if c.config.Filter != "" {
-		if err := c.handle.SetBPFFilter(c.config.Filter); err != nil {
-			return fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
+		if err := handle.SetBPFFilter(c.config.Filter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
 		}
 	}
 
-	fmt.Printf("Live-Capture auf Interface %s gestartet (Promisc: %v, SnapLen: %d, BufferSize: %d)\n",
-		interfaceName, c.config.PromiscMode, c.config.SnapLen, c.config.BufferSize)
-
-	return nil
+	return newCaptureSession(c, handle, path, false), nil
 }
 
-// StartCapture startet die Erfassung von Paketen
-func (c *PcapCapturer) StartCapture(ctx context.Context) (<-chan *models.PacketInfo, <-chan error) {
-	packetSource := gopacket.NewPacketSource(c.handle, c.handle.LinkType())
-	packetSource.DecodeOptions.Lazy = true
-	packetSource.DecodeOptions.NoCopy = true
-
-	// Debug-Ausgabe
-	fmt.Printf("DEBUG: Starte Paketerfassung mit Linktyp: %v\n", c.handle.LinkType())
-
-	// Konfigurierter Filter aus der Konfiguration ausgeben
-	if c.config.Filter != "" {
-		fmt.Printf("DEBUG: Konfigurierter BPF-Filter: %s\n", c.config.Filter)
-	} else {
-		fmt.Printf("DEBUG: Kein BPF-Filter konfiguriert\n")
+// OpenLiveCapture öffnet eine Live-Netzwerkschnittstelle als neue Session. Das Backend
+// (libpcap oder AF_PACKET) wird über capture.backend gewählt. Ein angegebener Filter gilt nur
+// für diese Session und ersetzt capture.filter; er wird für den Linktyp der Schnittstelle
+// übersetzt, Fehler werden als *FilterError gemeldet.
+func (c *PcapCapturer) OpenLiveCapture(interfaceName, filter string) (*CaptureSession, error) {
+	// Anpassungen für Bridges und der Filter gelten nur für diese Session
+	cfg := *c.config
+	if filter != "" {
+		cfg.Filter = filter
 	}
 
-	// Debug-Zähler
-	var packetCount uint64 = 0
-	lastLogTime := time.Now()
-
-	go func() {
-		defer close(c.packetChan)
-		defer close(c.errorChan)
-
-		fmt.Println("DEBUG: Paketerfassungs-Goroutine gestartet")
-
-		for {
-			select {
-			case <-ctx.Done():
-				fmt.Println("DEBUG: Paketerfassung durch Kontext beendet")
-				return
-			case packet, ok := <-packetSource.Packets():
-				if !ok {
-					fmt.Println("DEBUG: Paketquelle geschlossen")
-					return
-				}
-
-				// Paketzähler erhöhen
-				packetCount++
-
-				// Debug-Log alle 10 Pakete oder alle 5 Sekunden
-				if packetCount%10 == 0 || time.Since(lastLogTime) > 5*time.Second {
-					fmt.Printf("DEBUG: %d Pakete erfasst, letztes Paket: %d Bytes\n",
-						packetCount, packet.Metadata().Length)
-					lastLogTime = time.Now()
-				}
+	// Prüfen, ob es sich um eine Bridge-Schnittstelle handelt
+	isBridge := false
+	if _, err := os.Stat(fmt.Sprintf("/sys/class/net/%s/bridge", interfaceName)); err == nil {
+		isBridge = true
+		fmt.Printf("Bridge-Interface erkannt: %s\n", interfaceName)
 
-				packetInfo, err := c.analyzePacket(packet)
-				if err != nil {
-					select {
-					case c.errorChan <- err:
-					default:
-						// Errorkanal voll - ignorieren
-					}
-					continue
-				}
+		// Für Bridge-Interfaces: Promisc-Modus erzwingen und BufferSize erhöhen
+		cfg.PromiscMode = true
 
-				if packetInfo != nil {
-					select {
-					case c.packetChan <- packetInfo:
-						// Debug-Info alle 50 Pakete
-						if packetCount%50 == 0 {
-							fmt.Printf("DEBUG: Paket an Kanal gesendet: %s -> %s (%s)\n",
-								packetInfo.SourceIP, packetInfo.DestinationIP, packetInfo.Protocol)
-						}
-					default:
-						// Kanal voll - ignorieren
-						fmt.Println("DEBUG: Paketkanal ist voll - Paket verworfen")
-					}
-				} else {
-					if packetCount%100 == 0 {
-						fmt.Println("DEBUG: Paket wurde analysiert, aber nicht weitergeleitet (nil)")
-					}
-				}
-			}
+		// Buffer-Größe für Bridge-Interfaces erhöhen
+		if cfg.BufferSize < 8*1024*1024 {
+			cfg.BufferSize = 8 * 1024 * 1024 // 8MB für Bridge-Interfaces
+			fmt.Printf("Buffer-Größe für Bridge-Interface auf %d Bytes erhöht\n", cfg.BufferSize)
 		}
-	}()
-
-	return c.packetChan, c.errorChan
-}
-
-// Close schließt den Capturer
-func (c *PcapCapturer) Close() error {
-	if c.handle != nil {
-		c.handle.Close()
 	}
-	return nil
-}
 
-// analyzePacket analysiert ein einzelnes Paket mit Gateway-Fokus
-func (c *PcapCapturer) analyzePacket(packet gopacket.Packet) (*models.PacketInfo, error) {
-	info := &models.PacketInfo{
-		Timestamp: packet.Metadata().Timestamp,
-		Length:    uint32(packet.Metadata().Length),
-		Protocol:  "Unknown",
-	}
-
-	// Link Layer (z.B. Ethernet)
-	ethLayer := packet.Layer(layers.LayerTypeEthernet)
-	if ethLayer != nil {
-		eth, _ := ethLayer.(*layers.Ethernet)
-
-		// ARP-Analyse
-		if eth.EthernetType == layers.EthernetTypeARP {
-			arpLayer := packet.Layer(layers.LayerTypeARP)
-			if arpLayer != nil {
-				arp, _ := arpLayer.(*layers.ARP)
-				return c.analyzeARPPacket(packet, arp, info)
-			}
+	if cfg.Backend == config.CaptureBackendAFPacket {
+		handle, err := openAFPacket(interfaceName, &cfg)
+		if err != nil {
+			return nil, err
 		}
+		fmt.Printf("Live-Capture (AF_PACKET) auf Interface %s gestartet (Promisc: %v, Sockets: %d)\n",
+			interfaceName, cfg.PromiscMode, len(handle.sockets))
+		return newCaptureSession(c, handle, interfaceName, true), nil
 	}
 
-	// Netzwerk Layer (IPv4/IPv6)
-	var srcIP, dstIP net.IP
-	var ipLayer gopacket.Layer
-
-	// IPv4
-	ipLayer = packet.Layer(layers.LayerTypeIPv4)
-	if ipLayer != nil {
-		ip, _ := ipLayer.(*layers.IPv4)
-		srcIP = ip.SrcIP
-		dstIP = ip.DstIP
-		info.SourceIP = srcIP
-		info.DestinationIP = dstIP
-		info.TTL = ip.TTL
-
-		// ICMP-Analyse
-		if ip.Protocol == layers.IPProtocolICMPv4 {
-			icmpLayer := packet.Layer(layers.LayerTypeICMPv4)
-			if icmpLayer != nil {
-				// Wir extrahieren ICMP-Typ und -Code nicht, aber könnten das später hinzufügen
-				// icmp, _ := icmpLayer.(*layers.ICMPv4)
-				info.Protocol = "ICMP"
-
-				// Prüfen, ob Gateway involviert ist
-				isGatewayTraffic := c.isGatewayTraffic(srcIP, dstIP)
-				info.IsGatewayTraffic = isGatewayTraffic
-
-				if isGatewayTraffic {
-					// Identifizieren, welche IP das Gateway ist
-					if c.isGatewayIP(srcIP) {
-						info.GatewayIP = srcIP
-					} else if c.isGatewayIP(dstIP) {
-						info.GatewayIP = dstIP
-					}
-				}
-
-				return info, nil
-			}
-		}
-	} else {
-		// IPv6
-		ipLayer = packet.Layer(layers.LayerTypeIPv6)
-		if ipLayer != nil {
-			ip, _ := ipLayer.(*layers.IPv6)
-			srcIP = ip.SrcIP
-			dstIP = ip.DstIP
-			info.SourceIP = srcIP
-			info.DestinationIP = dstIP
-			info.TTL = ip.HopLimit
-		} else {
-			// Weder IPv4 noch IPv6 - vermutlich ARP oder anderes Link-Layer-Protokoll
-			return info, nil
-		}
+	handle, err := openLibpcapLive(interfaceName, &cfg, isBridge)
+	if err != nil {
+		return nil, err
 	}
 
-	// Transport Layer (TCP/UDP)
-	var srcPort, dstPort uint16
-
-	tcpLayer := packet.Layer(layers.LayerTypeTCP)
-	if tcpLayer != nil {
-		tcp, _ := tcpLayer.(*layers.TCP)
-		srcPort = uint16(tcp.SrcPort)
-		dstPort = uint16(tcp.DstPort)
-		info.SourcePort = srcPort
-		info.DestinationPort = dstPort
-		info.Protocol = "TCP"
-	} else {
-		udpLayer := packet.Layer(layers.LayerTypeUDP)
-		if udpLayer != nil {
-			udp, _ := udpLayer.(*layers.UDP)
-			srcPort = uint16(udp.SrcPort)
-			dstPort = uint16(udp.DstPort)
-			info.SourcePort = srcPort
-			info.DestinationPort = dstPort
-			info.Protocol = "UDP"
-
-			// DNS-Analyse (Port 53)
-			if udp.SrcPort == 53 || udp.DstPort == 53 {
-				dnsLayer := packet.Layer(layers.LayerTypeDNS)
-				if dnsLayer != nil {
-					dns, _ := dnsLayer.(*layers.DNS)
-					return c.analyzeDNSPacket(packet, dns, info)
-				}
-			}
-
-			// DHCP-Analyse (Port 67/68)
-			if (udp.SrcPort == 67 && udp.DstPort == 68) || (udp.SrcPort == 68 && udp.DstPort == 67) {
-				dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
-				if dhcpLayer != nil {
-					dhcp, _ := dhcpLayer.(*layers.DHCPv4)
-					return c.analyzeDHCPPacket(packet, dhcp, info)
-				}
-			}
+	// BPF-Filter für den tatsächlichen Linktyp des Handles setzen
+	if cfg.Filter != "" {
+		if err := handle.SetBPFFilter(cfg.Filter); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("Fehler beim Setzen des BPF-Filters: %w", err)
 		}
 	}
 
-	// Gateway-Traffic erkennen
-	isGatewayTraffic := c.isGatewayTraffic(srcIP, dstIP)
-	info.IsGatewayTraffic = isGatewayTraffic
+	fmt.Printf("Live-Capture auf Interface %s gestartet (Promisc: %v, SnapLen: %d, BufferSize: %d)\n",
+		interfaceName, cfg.PromiscMode, cfg.SnapLen, cfg.BufferSize)
 
-	if isGatewayTraffic {
-		// Identifizieren, welche IP das Gateway ist
-		if c.isGatewayIP(srcIP) {
-			info.GatewayIP = srcIP
-		} else if c.isGatewayIP(dstIP) {
-			info.GatewayIP = dstIP
-		}
-	}
+	return newCaptureSession(c, handle, interfaceName, true), nil
+}
 
-	return info, nil
+// CompileFilter übersetzt einen Filter mit der SnapLen der Konfiguration für einen Linktyp,
+// z.B. um ihn vor dem Öffnen einer Schnittstelle zu prüfen
+func (c *PcapCapturer) CompileFilter(filter string, linkType layers.LinkType) ([]bpf.RawInstruction, error) {
+	return CompileFilter(filter, linkType, c.config.SnapLen)
 }
 
-// analyzeARPPacket analysiert ein ARP-Paket mit Fokus auf Gateway-Erkennung
-func (c *PcapCapturer) analyzeARPPacket(packet gopacket.Packet, arp *layers.ARP, info *models.PacketInfo) (*models.PacketInfo, error) {
+// analyzeARPPacket analysiert ein ARP-Paket mit Fokus auf Gateway-Erkennung. Adressen werden
+// kopiert, da der Paketpuffer nach der Analyse wiederverwendet wird.
+func (c *PcapCapturer) analyzeARPPacket(arp *layers.ARP, info *models.PacketInfo) *models.PacketInfo {
 	info.Protocol = "ARP"
 
 	// ARP-spezifische Informationen
-	senderIP := net.IP(arp.SourceProtAddress)
-	targetIP := net.IP(arp.DstProtAddress)
 	senderMAC := net.HardwareAddr(arp.SourceHwAddress)
 	targetMAC := net.HardwareAddr(arp.DstHwAddress)
 
-	info.SourceIP = senderIP
-	info.DestinationIP = targetIP
+	info.SourceIP = append(info.SourceIP, arp.SourceProtAddress...)
+	info.DestinationIP = append(info.DestinationIP, arp.DstProtAddress...)
+	senderIP, targetIP := info.SourceIP, info.DestinationIP
 
 	// ARP-Info erstellen
-	arpInfo := &models.ARPInfo{
-		SenderIP:  senderIP,
-		TargetIP:  targetIP,
-		SenderMAC: senderMAC.String(),
-		TargetMAC: targetMAC.String(),
-	}
+	arpInfo := acquireARPInfo()
+	arpInfo.SenderIP = append(arpInfo.SenderIP, senderIP...)
+	arpInfo.TargetIP = append(arpInfo.TargetIP, targetIP...)
+	arpInfo.SenderMAC = senderMAC.String()
+	arpInfo.TargetMAC = targetMAC.String()
 
 	// Operation bestimmen
 	if arp.Operation == layers.ARPRequest {
@@ -426,14 +229,16 @@ func (c *PcapCapturer) analyzeARPPacket(packet gopacket.Packet, arp *layers.ARP,
 	} else if arp.Operation == layers.ARPReply {
 		arpInfo.Operation = "REPLY"
 
-		// ARP-Tabelle aktualisieren
-		c.gatewayInfo.arpTable[senderIP.String()] = senderMAC.String()
-
-		// Prüfen, ob dieses Gerät ein Gateway ist
-		if c.isGatewayIP(senderIP) {
-			c.gatewayInfo.gatewayIP = senderIP
-			c.gatewayInfo.gatewayMAC = senderMAC
+		// ARP-Tabelle des VLANs aktualisieren und prüfen, ob dieses Gerät ein Gateway ist
+		c.gatewayInfo.mutex.Lock()
+		if scope := c.gatewayInfo.scopeLocked(scopeOf(info.VLANs), true); scope != nil {
+			scope.arpTable[senderIP.String()] = arpInfo.SenderMAC
+			if c.gatewayInfo.isGatewayIPLocked(scope, senderIP) {
+				scope.gatewayIP = append(net.IP(nil), senderIP...)
+				scope.gatewayMAC = append(net.HardwareAddr(nil), senderMAC...)
+			}
 		}
+		c.gatewayInfo.mutex.Unlock()
 	}
 
 	// Gratuitous ARP erkennen (gleiche Quell- und Ziel-IP)
@@ -442,34 +247,39 @@ func (c *PcapCapturer) analyzeARPPacket(packet gopacket.Packet, arp *layers.ARP,
 	}
 
 	// Prüfen, ob Gateway involviert ist
-	info.IsGatewayTraffic = c.isGatewayIP(senderIP) || c.isGatewayIP(targetIP)
-	if info.IsGatewayTraffic {
-		if c.isGatewayIP(senderIP) {
-			info.GatewayIP = senderIP
-		} else if c.isGatewayIP(targetIP) {
-			info.GatewayIP = targetIP
-		}
-	}
+	info.GatewayIP = c.gatewayEndpoint(scopeOf(info.VLANs), senderIP, targetIP)
+	info.IsGatewayTraffic = info.GatewayIP != nil
 
 	info.ARPInfo = arpInfo
-	return info, nil
+	return info
 }
 
 // analyzeDNSPacket analysiert ein DNS-Paket mit Fokus auf Gateway-Erkennung
-func (c *PcapCapturer) analyzeDNSPacket(packet gopacket.Packet, dns *layers.DNS, info *models.PacketInfo) (*models.PacketInfo, error) {
+func (c *PcapCapturer) analyzeDNSPacket(dns *layers.DNS, info *models.PacketInfo) *models.PacketInfo {
 	info.Protocol = "DNS"
+	vlan := scopeOf(info.VLANs)
 
 	// DNS-Server-IP merken
 	if dns.QR {
-		// Es ist eine Antwort, Quell-IP ist ein DNS-Server
-		c.gatewayInfo.dnsServers[info.SourceIP.String()] = true
+		// Es ist eine Antwort, Quell-IP ist ein DNS-Server; nur neue Server erfordern eine Schreibsperre
+		key := makeIPKey(info.SourceIP)
+		c.gatewayInfo.mutex.RLock()
+		scope := c.gatewayInfo.scopeLocked(vlan, false)
+		known := scope != nil && scope.dnsServers[key]
+		c.gatewayInfo.mutex.RUnlock()
+		if !known {
+			c.gatewayInfo.mutex.Lock()
+			if scope := c.gatewayInfo.scopeLocked(vlan, true); scope != nil {
+				scope.dnsServers[key] = true
+			}
+			c.gatewayInfo.mutex.Unlock()
+		}
 	}
 
 	// DNS-Info erstellen
-	dnsInfo := &models.DNSInfo{
-		IsQuery:  !dns.QR,
-		IsAnswer: dns.QR,
-	}
+	dnsInfo := acquireDNSInfo()
+	dnsInfo.IsQuery = !dns.QR
+	dnsInfo.IsAnswer = dns.QR
 
 	// Abfragen extrahieren
 	for _, question := range dns.Questions {
@@ -516,33 +326,30 @@ func (c *PcapCapturer) analyzeDNSPacket(packet gopacket.Packet, dns *layers.DNS,
 	}
 
 	// Prüfen, ob Gateway involviert ist
-	isGatewayTraffic := c.isGatewayIP(info.SourceIP) || c.isGatewayIP(info.DestinationIP)
-	info.IsGatewayTraffic = isGatewayTraffic
-
-	if isGatewayTraffic {
-		if c.isGatewayIP(info.SourceIP) {
-			info.GatewayIP = info.SourceIP
-		} else if c.isGatewayIP(info.DestinationIP) {
-			info.GatewayIP = info.DestinationIP
-		}
-	}
+	info.GatewayIP = c.gatewayEndpoint(vlan, info.SourceIP, info.DestinationIP)
+	info.IsGatewayTraffic = info.GatewayIP != nil
 
 	info.DNSInfo = dnsInfo
-	return info, nil
+	return info
 }
 
-// analyzeDHCPPacket analysiert ein DHCP-Paket mit Fokus auf Gateway-Erkennung
-func (c *PcapCapturer) analyzeDHCPPacket(packet gopacket.Packet, dhcp *layers.DHCPv4, info *models.PacketInfo) (*models.PacketInfo, error) {
+// analyzeDHCPPacket analysiert ein DHCP-Paket mit Fokus auf Gateway-Erkennung. DHCP-Pakete sind
+// selten; ihre Informationen werden daher nicht wiederverwendet, sondern neu angelegt.
+func (c *PcapCapturer) analyzeDHCPPacket(dhcp *layers.DHCPv4, info *models.PacketInfo) *models.PacketInfo {
 	info.Protocol = "DHCP"
+	vlan := scopeOf(info.VLANs)
 
 	// DHCP-Info erstellen
 	dhcpInfo := &models.DHCPInfo{
-		ClientIP:  dhcp.ClientIP,
-		YourIP:    dhcp.YourClientIP,
-		ServerIP:  dhcp.NextServerIP,
+		ClientIP:  copyIP(dhcp.ClientIP),
+		YourIP:    copyIP(dhcp.YourClientIP),
+		ServerIP:  copyIP(dhcp.NextServerIP),
 		ClientMAC: dhcp.ClientHWAddr.String(),
 	}
 
+	// Vom DHCP-Server gemeldete Adressen; sie werden nach dem Auswerten gemeinsam übernommen
+	var serverIDs []net.IP
+
 	// DHCP-Optionen auswerten
 	for _, option := range dhcp.Options {
 		switch option.Type {
@@ -563,27 +370,19 @@ func (c *PcapCapturer) analyzeDHCPPacket(packet gopacket.Packet, dhcp *layers.DH
 		case layers.DHCPOptRouter:
 			// Gateway-Information
 			if len(option.Data) >= 4 {
-				dhcpInfo.GatewayIP = net.IP(option.Data[:4])
-
-				// Gateway-Detektion aktualisieren
-				if c.gwConfig.DetectGateways {
-					c.gatewayInfo.knownGateways[dhcpInfo.GatewayIP.String()] = true
-					c.gatewayInfo.gatewayIP = dhcpInfo.GatewayIP
-				}
+				dhcpInfo.GatewayIP = copyIP(option.Data[:4])
 			}
 		case layers.DHCPOptServerID:
 			// DHCP-Server-IP
 			if len(option.Data) >= 4 {
-				serverIP := net.IP(option.Data[:4])
-				c.gatewayInfo.dhcpServers[serverIP.String()] = true
+				serverIDs = append(serverIDs, net.IP(option.Data[:4]))
 			}
 		case layers.DHCPOptDNS:
 			// DNS-Server
 			for i := 0; i < len(option.Data); i += 4 {
 				if i+4 <= len(option.Data) {
-					dnsServer := net.IP(option.Data[i : i+4])
+					dnsServer := copyIP(option.Data[i : i+4])
 					dhcpInfo.DNSServers = append(dhcpInfo.DNSServers, dnsServer)
-					c.gatewayInfo.dnsServers[dnsServer.String()] = true
 				}
 			}
 		case layers.DHCPOptLeaseTime:
@@ -600,10 +399,26 @@ func (c *PcapCapturer) analyzeDHCPPacket(packet gopacket.Packet, dhcp *layers.DH
 		}
 	}
 
-	// DHCP-Server als Gateway-Kandidat hinzufügen
-	if c.gwConfig.DetectGateways && dhcpInfo.ServerIP != nil && !dhcpInfo.ServerIP.IsUnspecified() {
-		c.gatewayInfo.knownGateways[dhcpInfo.ServerIP.String()] = true
+	// Gateway-Erkennung des VLANs in einem Schritt aktualisieren, damit parallele Worker keinen
+	// halb übernommenen Stand sehen
+	c.gatewayInfo.mutex.Lock()
+	if scope := c.gatewayInfo.scopeLocked(vlan, true); scope != nil {
+		if c.gwConfig.DetectGateways && dhcpInfo.GatewayIP != nil {
+			scope.knownGateways[makeIPKey(dhcpInfo.GatewayIP)] = true
+			scope.gatewayIP = dhcpInfo.GatewayIP
+		}
+		for _, serverIP := range serverIDs {
+			scope.dhcpServers[makeIPKey(serverIP)] = true
+		}
+		for _, dnsServer := range dhcpInfo.DNSServers {
+			scope.dnsServers[makeIPKey(dnsServer)] = true
+		}
+		// DHCP-Server als Gateway-Kandidat hinzufügen
+		if c.gwConfig.DetectGateways && dhcpInfo.ServerIP != nil && !dhcpInfo.ServerIP.IsUnspecified() {
+			scope.knownGateways[makeIPKey(dhcpInfo.ServerIP)] = true
+		}
 	}
+	c.gatewayInfo.mutex.Unlock()
 
 	// Prüfen, ob Gateway involviert ist
 	info.IsGatewayTraffic = true // DHCP ist fast immer Gateway-relevant
@@ -611,47 +426,119 @@ func (c *PcapCapturer) analyzeDHCPPacket(packet gopacket.Packet, dhcp *layers.DH
 	// Wenn wir Gateway kennen, setzen wir es
 	if dhcpInfo.GatewayIP != nil && !dhcpInfo.GatewayIP.IsUnspecified() {
 		info.GatewayIP = dhcpInfo.GatewayIP
-	} else if c.isGatewayIP(info.SourceIP) {
-		info.GatewayIP = info.SourceIP
-	} else if c.isGatewayIP(info.DestinationIP) {
-		info.GatewayIP = info.DestinationIP
+	} else {
+		info.GatewayIP = c.gatewayEndpoint(vlan, info.SourceIP, info.DestinationIP)
 	}
 
 	info.DHCPInfo = dhcpInfo
-	return info, nil
+	return info
+}
+
+// copyIP kopiert eine Adresse aus dem Paketpuffer; leere Adressen ergeben nil
+func copyIP(ip []byte) net.IP {
+	if len(ip) == 0 {
+		return nil
+	}
+	return append(net.IP(nil), ip...)
 }
 
-// isGatewayIP prüft, ob eine IP-Adresse ein Gateway ist
-func (c *PcapCapturer) isGatewayIP(ip net.IP) bool {
+// ipKey ist eine IP-Adresse als Map-Schlüssel. IPv4-Adressen werden in IPv6-Darstellung
+// abgelegt, sodass 4- und 16-Byte-Formen übereinstimmen; die Suche erfolgt ohne Allokation.
+type ipKey [16]byte
+
+// makeIPKey erstellt den Map-Schlüssel einer Adresse
+func makeIPKey(ip net.IP) ipKey {
+	var key ipKey
+	if len(ip) == net.IPv4len {
+		key[10], key[11] = 0xff, 0xff
+		copy(key[12:], ip)
+	} else {
+		copy(key[:], ip)
+	}
+	return key
+}
+
+// scopeLocked gibt die Erkennung eines VLAN-Bereichs zurück. Mit create wird sie bei Bedarf
+// angelegt, solange maxGatewayScopes nicht erreicht ist; dafür muss der Aufrufer die
+// Schreibsperre halten, sonst genügt die Lesesperre. Das Ergebnis kann nil sein.
+func (d *GatewayDetector) scopeLocked(vlan vlanScope, create bool) *gatewayScope {
+	scope := d.scopes[vlan]
+	if scope == nil && create && len(d.scopes) < maxGatewayScopes {
+		scope = &gatewayScope{
+			knownGateways: make(map[ipKey]bool),
+			dhcpServers:   make(map[ipKey]bool),
+			dnsServers:    make(map[ipKey]bool),
+			arpTable:      make(map[string]string),
+		}
+		d.scopes[vlan] = scope
+	}
+	return scope
+}
+
+// isGatewayIPLocked prüft, ob eine IP-Adresse in einem VLAN-Bereich ein Gateway ist; scope
+// darf nil sein. Der Aufrufer muss mutex halten.
+func (d *GatewayDetector) isGatewayIPLocked(scope *gatewayScope, ip net.IP) bool {
 	if ip == nil {
 		return false
 	}
 
+	// Konfigurierte Gateways prüfen
+	key := makeIPKey(ip)
+	if d.configured[key] {
+		return true
+	}
+	if scope == nil {
+		return false
+	}
+
 	// Bekannte Gateways prüfen
-	if c.gatewayInfo.knownGateways[ip.String()] {
+	if scope.knownGateways[key] {
 		return true
 	}
 
 	// Erkanntes Gateway prüfen
-	if c.gatewayInfo.gatewayIP != nil && ip.Equal(c.gatewayInfo.gatewayIP) {
+	if scope.gatewayIP != nil && ip.Equal(scope.gatewayIP) {
 		return true
 	}
 
 	// DHCP-Server sind oft Gateways
-	if c.gatewayInfo.dhcpServers[ip.String()] {
+	if scope.dhcpServers[key] {
 		return true
 	}
 
 	return false
 }
 
-// isGatewayTraffic prüft, ob ein Paket mit Gateway-Traffic zu tun hat
-func (c *PcapCapturer) isGatewayTraffic(srcIP, dstIP net.IP) bool {
-	if c.isGatewayIP(srcIP) || c.isGatewayIP(dstIP) {
-		return true
+// gatewayEndpoint gibt die Adresse zurück, die im VLAN-Bereich des Pakets ein Gateway ist
+// (zuerst src), oder nil
+func (c *PcapCapturer) gatewayEndpoint(vlan vlanScope, srcIP, dstIP net.IP) net.IP {
+	c.gatewayInfo.mutex.RLock()
+	defer c.gatewayInfo.mutex.RUnlock()
+
+	scope := c.gatewayInfo.scopeLocked(vlan, false)
+	if c.gatewayInfo.isGatewayIPLocked(scope, srcIP) {
+		return srcIP
+	}
+	if c.gatewayInfo.isGatewayIPLocked(scope, dstIP) {
+		return dstIP
+	}
+	return nil
+}
+
+// classifyGatewayTraffic prüft, ob ein Paket mit Gateway-Traffic zu tun hat, und gibt
+// gegebenenfalls die beteiligte Gateway-Adresse zurück
+func (c *PcapCapturer) classifyGatewayTraffic(vlan vlanScope, srcIP, dstIP net.IP) (bool, net.IP) {
+	if gatewayIP := c.gatewayEndpoint(vlan, srcIP, dstIP); gatewayIP != nil {
+		return true, gatewayIP
+	}
+
+	// Die Netze der eigenen Schnittstellen beschreiben nur Pakete ohne VLAN-Tag
+	if vlan != 0 {
+		return false, nil
 	}
 
-	// Prüfen, ob eine der IPs extern ist (also nicht im lokalen Netz)
+	// Prüfen, ob eine der IPs extern ist (also nicht im lokalen Netz).
+	// localNets wird nur beim Erstellen gesetzt und ist daher ohne Sperre lesbar.
 	srcIsLocal := false
 	dstIsLocal := false
 
@@ -666,7 +553,7 @@ func (c *PcapCapturer) isGatewayTraffic(srcIP, dstIP net.IP) bool {
 
 	// Wenn eine IP lokal und die andere nicht lokal ist,
 	// dann ist es wahrscheinlich Gateway-Traffic
-	return srcIsLocal != dstIsLocal
+	return srcIsLocal != dstIsLocal, nil
 }
 
 // getDefaultGateway versucht, das Standard-Gateway zu ermitteln
@@ -0,0 +1,616 @@
+package packet
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+// Standardwerte für die fortlaufende Aufzeichnung
+const (
+	defaultArchiveFileSize  = 64 * 1024 * 1024       // 64 MB pro Datei
+	defaultArchiveFileAge   = 15 * time.Minute       // spätestens alle 15 Minuten rotieren
+	defaultArchiveTotalSize = 2 * 1024 * 1024 * 1024 // 2 GB Disk-Quota
+	archiveFlushInterval    = time.Second
+
+	archiveFilePrefix = "live-"
+	archiveMetaSuffix = ".json"
+)
+
+// ArchiveFile beschreibt eine Datei der fortlaufenden Aufzeichnung. Die Indexdaten werden
+// beim Rotieren neben der Datei als JSON abgelegt; für laufende Dateien sind sie höchstens
+// archiveFlushInterval alt.
+type ArchiveFile struct {
+	Name      string    `json:"name"`
+	Interface string    `json:"interface"`
+	Format    string    `json:"format"`
+	LinkType  string    `json:"link_type"`
+	Start     time.Time `json:"start"` // Zeitstempel des ersten Pakets
+	End       time.Time `json:"end"`   // Zeitstempel des letzten Pakets
+	Packets   int64     `json:"packets"`
+	Size      int64     `json:"size"`
+	Active    bool      `json:"active"` // Datei wird noch geschrieben
+}
+
+// ContentType gibt den MIME-Typ der Datei für Downloads zurück
+func (f ArchiveFile) ContentType() string {
+	if f.Format == config.ArchiveFormatPcapng {
+		return "application/x-pcapng"
+	}
+	return "application/vnd.tcpdump.pcap"
+}
+
+// CaptureArchive verwaltet die fortlaufend aufgezeichneten Dateien in pcap_dir. Jede
+// Live-Capture schreibt über einen eigenen ArchiveRecorder; Rotation erfolgt je Recorder,
+// die Aufbewahrung nach Anzahl und Gesamtgröße gilt für alle Dateien gemeinsam.
+type CaptureArchive struct {
+	dir          string
+	format       string
+	maxFileSize  int64
+	maxFileAge   time.Duration
+	maxFiles     int
+	maxTotalSize int64
+	snapLen      uint32
+
+	mutex sync.Mutex
+	files []*ArchiveFile // älteste zuerst, laufende Dateien eingeschlossen
+}
+
+// NewCaptureArchive legt das Verzeichnis an und übernimmt Dateien einer früheren Laufzeit.
+// Dateien ohne Indexdaten (z.B. nach einem Absturz) werden dabei neu eingelesen.
+func NewCaptureArchive(dir string, cfg *config.ArchiveConfig, snapLen int) (*CaptureArchive, error) {
+	archive := &CaptureArchive{
+		dir:          dir,
+		format:       cfg.Format,
+		maxFileSize:  cfg.MaxFileSize,
+		maxFileAge:   time.Duration(cfg.MaxFileAge) * time.Second,
+		maxFiles:     cfg.MaxFiles,
+		maxTotalSize: cfg.MaxTotalSize,
+		snapLen:      uint32(snapLen),
+	}
+
+	// Standardwerte setzen
+	if archive.dir == "" {
+		archive.dir = filepath.Join(os.TempDir(), "ki-network-analyzer", "pcaps")
+	}
+	if archive.format == "" {
+		archive.format = config.ArchiveFormatPcapng
+	}
+	if archive.maxFileSize <= 0 {
+		archive.maxFileSize = defaultArchiveFileSize
+	}
+	if archive.maxFileAge <= 0 {
+		archive.maxFileAge = defaultArchiveFileAge
+	}
+	if archive.maxTotalSize <= 0 {
+		archive.maxTotalSize = defaultArchiveTotalSize
+	}
+	if archive.snapLen == 0 {
+		archive.snapLen = 65535
+	}
+
+	if err := os.MkdirAll(archive.dir, 0755); err != nil {
+		return nil, fmt.Errorf("Fehler beim Erstellen des Aufzeichnungsverzeichnisses: %w", err)
+	}
+	if err := archive.load(); err != nil {
+		return nil, err
+	}
+
+	archive.mutex.Lock()
+	archive.enforceRetentionLocked()
+	archive.mutex.Unlock()
+
+	return archive, nil
+}
+
+// load liest die Indexdaten vorhandener Dateien
+func (a *CaptureArchive) load() error {
+	entries, err := os.ReadDir(a.dir)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Lesen des Aufzeichnungsverzeichnisses: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		format := archiveFileFormat(name)
+		if entry.IsDir() || !strings.HasPrefix(name, archiveFilePrefix) || format == "" {
+			continue
+		}
+
+		file := a.loadMeta(name)
+		if file == nil {
+			// Beim Beenden nicht abgeschlossene Datei: Index aus den Paketen erzeugen
+			if file, err = indexArchiveFile(filepath.Join(a.dir, name), format); err != nil {
+				log.Printf("Warnung: Aufzeichnung %s konnte nicht eingelesen werden: %v", name, err)
+				continue
+			}
+			a.saveMetaLocked(file)
+		}
+
+		if fileInfo, err := entry.Info(); err == nil {
+			file.Size = fileInfo.Size()
+		}
+		file.Active = false
+		a.files = append(a.files, file)
+	}
+
+	sort.Slice(a.files, func(i, j int) bool {
+		return a.files[i].Start.Before(a.files[j].Start)
+	})
+	return nil
+}
+
+// loadMeta liest die Indexdaten einer Datei; nil, wenn sie fehlen oder ungültig sind
+func (a *CaptureArchive) loadMeta(name string) *ArchiveFile {
+	data, err := ioutil.ReadFile(a.metaPath(name))
+	if err != nil {
+		return nil
+	}
+	var file ArchiveFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Name != name {
+		log.Printf("Warnung: Indexdaten der Aufzeichnung %s sind ungültig", name)
+		return nil
+	}
+	return &file
+}
+
+// indexArchiveFile liest eine Datei vollständig und ermittelt Zeitraum und Paketanzahl.
+// Die Schnittstelle wird aus dem Dateinamen übernommen.
+func indexArchiveFile(path, format string) (*ArchiveFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	name := filepath.Base(path)
+	file := &ArchiveFile{Name: name, Format: format}
+	if iface, start, ok := parseArchiveFileName(name); ok {
+		file.Interface = iface
+		file.Start, file.End = start, start
+	}
+
+	var source gopacket.PacketDataSource
+	if format == config.ArchiveFormatPcapng {
+		reader, err := pcapgo.NewNgReader(bufio.NewReader(f), pcapgo.DefaultNgReaderOptions)
+		if err != nil {
+			return nil, err
+		}
+		file.LinkType = reader.LinkType().String()
+		source = reader
+	} else {
+		reader, err := pcapgo.NewReader(bufio.NewReader(f))
+		if err != nil {
+			return nil, err
+		}
+		file.LinkType = reader.LinkType().String()
+		source = reader
+	}
+
+	// Ein abgeschnittenes letztes Paket beendet das Einlesen ohne Fehler
+	for {
+		_, ci, err := source.ReadPacketData()
+		if err != nil {
+			break
+		}
+		if file.Packets == 0 {
+			file.Start = ci.Timestamp
+		}
+		file.End = ci.Timestamp
+		file.Packets++
+	}
+	return file, nil
+}
+
+// archiveFileFormat bestimmt das Format anhand der Dateiendung; leer für andere Dateien
+func archiveFileFormat(name string) string {
+	switch filepath.Ext(name) {
+	case "." + config.ArchiveFormatPcapng:
+		return config.ArchiveFormatPcapng
+	case "." + config.ArchiveFormatPcap:
+		return config.ArchiveFormatPcap
+	}
+	return ""
+}
+
+// archiveFileName bildet den Dateinamen aus Schnittstelle, Startzeit und Format.
+// Zeichen außerhalb von [A-Za-z0-9._] im Schnittstellennamen werden ersetzt.
+func archiveFileName(iface string, start time.Time, format string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '_'
+	}, iface)
+	return fmt.Sprintf("%s%s-%d.%s", archiveFilePrefix, safe, start.UnixNano(), format)
+}
+
+// parseArchiveFileName liest Schnittstelle und Startzeit aus einem Dateinamen
+func parseArchiveFileName(name string) (string, time.Time, bool) {
+	base := strings.TrimPrefix(strings.TrimSuffix(name, filepath.Ext(name)), archiveFilePrefix)
+	sep := strings.LastIndex(base, "-")
+	if sep < 0 {
+		return "", time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(base[sep+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return base[:sep], time.Unix(0, nanos), true
+}
+
+// path gibt den Pfad einer Datei zurück
+func (a *CaptureArchive) path(name string) string {
+	return filepath.Join(a.dir, name)
+}
+
+// metaPath gibt den Pfad der Indexdaten einer Datei zurück
+func (a *CaptureArchive) metaPath(name string) string {
+	return filepath.Join(a.dir, name+archiveMetaSuffix)
+}
+
+// saveMetaLocked schreibt die Indexdaten einer Datei. Der Aufrufer muss mutex halten.
+func (a *CaptureArchive) saveMetaLocked(file *ArchiveFile) {
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(a.metaPath(file.Name), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warnung: Indexdaten der Aufzeichnung %s konnten nicht gespeichert werden: %v", file.Name, err)
+	}
+}
+
+// NewRecorder erstellt einen Recorder für eine Live-Capture auf der angegebenen Schnittstelle.
+// Die erste Datei wird mit dem ersten Paket angelegt.
+func (a *CaptureArchive) NewRecorder(iface string) *ArchiveRecorder {
+	return &ArchiveRecorder{archive: a, iface: iface}
+}
+
+// create legt eine neue Datei an und nimmt sie als laufend in den Index auf
+func (a *CaptureArchive) create(file *ArchiveFile) (*os.File, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	// Eindeutiger Name, auch wenn zwei Captures im selben Moment rotieren
+	start := file.Start
+	for {
+		file.Name = archiveFileName(file.Interface, start, file.Format)
+		if a.findLocked(file.Name) == nil {
+			break
+		}
+		start = start.Add(time.Nanosecond)
+	}
+
+	f, err := os.Create(a.path(file.Name))
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Anlegen der Aufzeichnungsdatei: %w", err)
+	}
+
+	// Der Index hält eine eigene Kopie, der Recorder aktualisiert sie über update
+	file.Active = true
+	stored := *file
+	a.files = append(a.files, &stored)
+	a.enforceRetentionLocked()
+	return f, nil
+}
+
+// update übernimmt den aktuellen Stand einer laufenden Datei in den Index
+func (a *CaptureArchive) update(file *ArchiveFile) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if stored := a.findLocked(file.Name); stored != nil {
+		*stored = *file
+	}
+}
+
+// finish speichert die Indexdaten einer abgeschlossenen Datei und erzwingt die Aufbewahrung
+func (a *CaptureArchive) finish(file *ArchiveFile) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	file.Active = false
+	if stored := a.findLocked(file.Name); stored != nil {
+		*stored = *file
+	}
+	a.saveMetaLocked(file)
+	a.enforceRetentionLocked()
+}
+
+// enforceRetentionLocked löscht die ältesten abgeschlossenen Dateien, bis Anzahl und
+// Gesamtgröße eingehalten werden. Laufende Dateien zählen mit, werden aber nie gelöscht.
+// Der Aufrufer muss mutex halten.
+func (a *CaptureArchive) enforceRetentionLocked() {
+	var total int64
+	for _, file := range a.files {
+		total += file.Size
+	}
+	count := len(a.files)
+
+	kept := a.files[:0]
+	for _, file := range a.files {
+		exceeded := total > a.maxTotalSize || (a.maxFiles > 0 && count > a.maxFiles)
+		if exceeded && !file.Active {
+			for _, path := range []string{a.path(file.Name), a.metaPath(file.Name)} {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warnung: Aufzeichnung %s konnte nicht gelöscht werden: %v", path, err)
+				}
+			}
+			total -= file.Size
+			count--
+			continue
+		}
+		kept = append(kept, file)
+	}
+	a.files = kept
+}
+
+// findLocked sucht eine Datei nach Namen. Der Aufrufer muss mutex halten.
+func (a *CaptureArchive) findLocked(name string) *ArchiveFile {
+	for _, file := range a.files {
+		if file.Name == name {
+			return file
+		}
+	}
+	return nil
+}
+
+// List gibt alle Dateien zurück, neueste zuerst
+func (a *CaptureArchive) List() []ArchiveFile {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	list := make([]ArchiveFile, 0, len(a.files))
+	for i := len(a.files) - 1; i >= 0; i-- {
+		list = append(list, *a.files[i])
+	}
+	return list
+}
+
+// Open öffnet eine Datei zum Herunterladen. Laufende Dateien werden bis zum zuletzt auf die
+// Platte geschriebenen Paket geliefert und bilden damit eine gültige, abgeschlossene Datei.
+func (a *CaptureArchive) Open(name string) (io.ReadCloser, ArchiveFile, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	file := a.findLocked(name)
+	if file == nil {
+		return nil, ArchiveFile{}, os.ErrNotExist
+	}
+
+	f, err := os.Open(a.path(name))
+	if err != nil {
+		return nil, *file, err
+	}
+	if !file.Active {
+		return f, *file, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, file.Size), f}, *file, nil
+}
+
+// ArchiveRecorder schreibt die Rohpakete einer Live-Capture in rotierende Dateien des Archivs
+type ArchiveRecorder struct {
+	archive *CaptureArchive
+	iface   string
+
+	mutex     sync.Mutex
+	current   *ArchiveFile
+	linkType  layers.LinkType
+	file      *os.File
+	counter   *countingFileWriter
+	writer    archiveWriter
+	lastFlush time.Time
+	closed    bool
+}
+
+// archiveWriter schreibt Pakete im Format der Datei; flush schreibt gepufferte Daten
+type archiveWriter interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte) error
+	flush() error
+}
+
+// pcapArchiveWriter schreibt klassische PCAP-Dateien mit Nanosekunden-Zeitstempeln
+type pcapArchiveWriter struct {
+	*pcapgo.Writer
+	buffered *bufio.Writer
+}
+
+func (w pcapArchiveWriter) flush() error {
+	return w.buffered.Flush()
+}
+
+// pcapngArchiveWriter schreibt PCAPNG-Dateien mit einer Schnittstelle
+type pcapngArchiveWriter struct {
+	*pcapgo.NgWriter
+}
+
+func (w pcapngArchiveWriter) flush() error {
+	return w.Flush()
+}
+
+// countingFileWriter zählt die in die Datei geschriebenen Bytes
+type countingFileWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingFileWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WritePacket schreibt ein Rohpaket in die aktuelle Datei und rotiert bei Bedarf
+func (r *ArchiveRecorder) WritePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	// Rotation bei Größen- oder Zeitüberschreitung sowie bei geändertem Linktyp
+	if r.current != nil {
+		if r.counter.n >= r.archive.maxFileSize ||
+			ci.Timestamp.Sub(r.current.Start) >= r.archive.maxFileAge ||
+			r.linkType != linkType {
+			if err := r.closeFileLocked(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.current == nil {
+		if err := r.openFileLocked(ci.Timestamp, linkType); err != nil {
+			return err
+		}
+	}
+
+	// Beide Formate erwarten eine Schnittstelle je Datei und keine eingefügten VLAN-Tags
+	// über die Originallänge hinaus
+	ci.InterfaceIndex = 0
+	if ci.Length < ci.CaptureLength {
+		ci.Length = ci.CaptureLength
+	}
+	if err := r.writer.WritePacket(ci, data); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben der Aufzeichnung: %w", err)
+	}
+	r.current.End = ci.Timestamp
+	r.current.Packets++
+
+	// Regelmäßig auf die Platte schreiben, damit Downloads aktuelle Daten sehen
+	if time.Since(r.lastFlush) >= archiveFlushInterval {
+		r.flushLocked()
+	}
+	return nil
+}
+
+// openFileLocked legt eine neue Datei an. Der Aufrufer muss mutex halten.
+func (r *ArchiveRecorder) openFileLocked(start time.Time, linkType layers.LinkType) error {
+	archive := r.archive
+	current := &ArchiveFile{
+		Interface: r.iface,
+		Format:    archive.format,
+		LinkType:  linkType.String(),
+		Start:     start,
+		End:       start,
+	}
+
+	file, err := archive.create(current)
+	if err != nil {
+		return err
+	}
+	counter := &countingFileWriter{w: file}
+
+	var writer archiveWriter
+	if archive.format == config.ArchiveFormatPcapng {
+		intf := pcapgo.NgInterface{
+			Name:                r.iface,
+			OS:                  runtime.GOOS,
+			LinkType:            linkType,
+			SnapLength:          archive.snapLen,
+			TimestampResolution: 9,
+		}
+		options := pcapgo.NgWriterOptions{SectionInfo: pcapgo.NgSectionInfo{
+			Hardware:    runtime.GOARCH,
+			OS:          runtime.GOOS,
+			Application: "ki-network-analyzer " + version.Version,
+		}}
+		ngWriter, ngErr := pcapgo.NewNgWriterInterface(counter, intf, options)
+		writer, err = pcapngArchiveWriter{ngWriter}, ngErr
+	} else {
+		buffered := bufio.NewWriterSize(counter, 64*1024)
+		pcapWriter := pcapgo.NewWriterNanos(buffered)
+		writer, err = pcapArchiveWriter{pcapWriter, buffered}, pcapWriter.WriteFileHeader(archive.snapLen, linkType)
+	}
+	if err != nil {
+		file.Close()
+		current.Active = false
+		archive.finish(current)
+		return fmt.Errorf("Fehler beim Schreiben des Dateikopfs: %w", err)
+	}
+
+	r.current = current
+	r.linkType = linkType
+	r.file = file
+	r.counter = counter
+	r.writer = writer
+
+	// Dateikopf sofort schreiben, damit auch eine neue Datei vollständig lesbar ist
+	r.flushLocked()
+	return nil
+}
+
+// flushLocked schreibt gepufferte Pakete und übernimmt den Stand in den Index.
+// Der Aufrufer muss mutex halten.
+func (r *ArchiveRecorder) flushLocked() {
+	if err := r.writer.flush(); err != nil {
+		log.Printf("Warnung: Aufzeichnung %s konnte nicht geschrieben werden: %v", r.current.Name, err)
+	}
+	r.current.Size = r.counter.n
+	r.archive.update(r.current)
+	r.lastFlush = time.Now()
+}
+
+// closeFileLocked schließt die aktuelle Datei und legt ihre Indexdaten ab.
+// Der Aufrufer muss mutex halten.
+func (r *ArchiveRecorder) closeFileLocked() error {
+	if r.current == nil {
+		return nil
+	}
+
+	flushErr := r.writer.flush()
+	closeErr := r.file.Close()
+
+	r.current.Size = r.counter.n
+	r.archive.finish(r.current)
+	r.current = nil
+	r.file = nil
+	r.counter = nil
+	r.writer = nil
+
+	if err := errors.Join(flushErr, closeErr); err != nil {
+		return fmt.Errorf("Fehler beim Schließen der Aufzeichnung: %w", err)
+	}
+	return nil
+}
+
+// Rotate schließt die aktuelle Datei; das nächste Paket beginnt eine neue
+func (r *ArchiveRecorder) Rotate() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.closeFileLocked()
+}
+
+// Close schließt die aktuelle Datei. Danach übergebene Pakete werden verworfen.
+func (r *ArchiveRecorder) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.closed = true
+	return r.closeFileLocked()
+}
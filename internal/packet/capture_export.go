@@ -0,0 +1,231 @@
+package packet
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/pcapng"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/version"
+)
+
+// Größte Paketlänge, die beim Lesen von PCAP-Dateien toleriert wird (wie tcpdump)
+const exportMaxSnapLen = 262144
+
+// ExportSummary fasst einen annotierten PCAPNG-Export zusammen
+type ExportSummary struct {
+	Packets          int            `json:"packets"`
+	FilteredPackets  int            `json:"filtered_packets,omitempty"` // vom Anzeigefilter ausgeschlossen
+	AnnotatedPackets int            `json:"annotated_packets"`
+	Interfaces       int            `json:"interfaces"`
+	NameRecords      int            `json:"name_records"`
+	Findings         map[string]int `json:"findings"` // Anzahl je Befundart
+}
+
+// exportSource liefert die Pakete einer Eingabedatei im Modell von PCAPNG. *pcapng.Reader
+// erfüllt die Schnittstelle direkt; PCAP-Dateien werden als eine Sektion mit einer
+// Schnittstelle dargestellt.
+type exportSource interface {
+	Next() (*pcapng.Packet, error)
+	Section() pcapng.Section
+	SectionNumber() int
+	NumInterfaces() int
+	Interface(index int) (pcapng.Interface, bool)
+	NameRecords() []pcapng.NameRecord
+}
+
+// openExportSource erkennt das Format der Eingabe anhand der Dateikennung
+func openExportSource(r io.Reader) (exportSource, error) {
+	buffered := bufio.NewReaderSize(r, 64*1024)
+	magic, err := buffered.Peek(len(pcapng.Magic))
+	if err != nil {
+		return nil, fmt.Errorf("Datei ist zu kurz: %w", err)
+	}
+	if bytes.Equal(magic, pcapng.Magic) {
+		reader, err := pcapng.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	}
+
+	reader, err := pcapgo.NewReader(buffered)
+	if err != nil {
+		return nil, err
+	}
+	return newPcapExportSource(reader), nil
+}
+
+// pcapExportSource stellt eine PCAP-Datei als PCAPNG-Sektion mit einer Schnittstelle dar
+type pcapExportSource struct {
+	reader *pcapgo.Reader
+	intf   pcapng.Interface
+	packet pcapng.Packet
+}
+
+func newPcapExportSource(reader *pcapgo.Reader) *pcapExportSource {
+	intf := pcapng.Interface{
+		LinkType: reader.LinkType(),
+		SnapLen:  reader.Snaplen(),
+		// Nanosekunden decken Dateien beider Auflösungen ohne Verlust ab
+		TsResol: 9,
+	}
+	// Manche Programme schreiben Pakete über die angegebene SnapLen hinaus
+	if reader.Snaplen() < exportMaxSnapLen {
+		reader.SetSnaplen(exportMaxSnapLen)
+	}
+	return &pcapExportSource{reader: reader, intf: intf}
+}
+
+func (s *pcapExportSource) Next() (*pcapng.Packet, error) {
+	data, ci, err := s.reader.ZeroCopyReadPacketData()
+	if err != nil {
+		return nil, err
+	}
+	s.packet = pcapng.Packet{
+		Timestamp:     ci.Timestamp,
+		CaptureLength: ci.CaptureLength,
+		Length:        ci.Length,
+		Data:          data,
+	}
+	return &s.packet, nil
+}
+
+func (s *pcapExportSource) Section() pcapng.Section          { return pcapng.Section{} }
+func (s *pcapExportSource) SectionNumber() int               { return 0 }
+func (s *pcapExportSource) NumInterfaces() int               { return 1 }
+func (s *pcapExportSource) NameRecords() []pcapng.NameRecord { return nil }
+func (s *pcapExportSource) Interface(index int) (pcapng.Interface, bool) {
+	return s.intf, index == 0
+}
+
+// ExportAnnotatedPcapng liest eine PCAP- oder PCAPNG-Datei, analysiert jedes Paket und schreibt
+// es mit den Analyseergebnissen als Paketkommentar in eine PCAPNG-Datei. Schnittstellen mit
+// Linktyp, Name und Zeitauflösung, vorhandene Kommentare und Namensauflösungen der Quelle
+// bleiben erhalten; Namen aus DNS-Antworten werden als weiterer Namensauflösungsblock
+// ergänzt. Der BPF-Filter der Konfiguration gilt nicht, damit der Export vollständig ist; mit
+// einem Anzeigefilter werden nur passende Pakete geschrieben. Die Analyse sieht dennoch alle
+// Pakete, damit Befunde wie ARP-Spoofing auch ausgeschlossene Vorgänger berücksichtigen.
+func (c *PcapCapturer) ExportAnnotatedPcapng(ctx context.Context, in io.Reader, out io.Writer,
+	filter *displayfilter.Filter) (ExportSummary, error) {
+	summary := ExportSummary{Findings: make(map[string]int)}
+
+	source, err := openExportSource(in)
+	if err != nil {
+		return summary, fmt.Errorf("Fehler beim Lesen der Datei: %w", err)
+	}
+
+	writer, err := pcapng.NewWriter(out, exportSection(source.Section()))
+	if err != nil {
+		return summary, err
+	}
+
+	// Schnittstellen jeder Sektion auf die Schnittstellen der Ausgabe abbilden; sie werden
+	// in ihrer ursprünglichen Reihenfolge übernommen, sobald der Reader sie gelesen hat
+	interfaceMap := make(map[int][]int)
+	syncInterfaces := func() error {
+		section := source.SectionNumber()
+		for i := len(interfaceMap[section]); i < source.NumInterfaces(); i++ {
+			intf, _ := source.Interface(i)
+			index, err := writer.AddInterface(intf)
+			if err != nil {
+				return err
+			}
+			interfaceMap[section] = append(interfaceMap[section], index)
+			summary.Interfaces++
+		}
+		return nil
+	}
+
+	// Der Linktyp wird je Paket nach seiner Schnittstelle gesetzt
+	decoder := newPacketDecoder(c, layers.LinkTypeEthernet)
+	annotator := newPacketAnnotator()
+
+	for {
+		p, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		read := summary.Packets + summary.FilteredPackets
+		if err != nil {
+			return summary, fmt.Errorf("Fehler beim Lesen von Paket %d: %w", read+1, err)
+		}
+		if read%1000 == 0 && ctx.Err() != nil {
+			return summary, ctx.Err()
+		}
+		if err := syncInterfaces(); err != nil {
+			return summary, err
+		}
+
+		intf, _ := source.Interface(p.InterfaceIndex)
+		decoder.linkType = intf.LinkType
+		info, decodeFailed := decoder.decode(p.Data, gopacket.CaptureInfo{
+			Timestamp:     p.Timestamp,
+			CaptureLength: p.CaptureLength,
+			Length:        p.Length,
+		})
+		findings := annotator.annotate(info, decodeFailed)
+		matched := filter.Match(info)
+		ReleasePacketInfo(info)
+		if !matched {
+			summary.FilteredPackets++
+			continue
+		}
+
+		annotated := *p
+		annotated.InterfaceIndex = interfaceMap[source.SectionNumber()][p.InterfaceIndex]
+		if len(findings) > 0 {
+			// Vorhandene Kommentare bleiben vor den Analyseergebnissen erhalten
+			annotated.Comments = append([]string(nil), p.Comments...)
+			for _, finding := range findings {
+				annotated.Comments = append(annotated.Comments, finding.Description)
+			}
+			summary.AnnotatedPackets++
+		}
+		if err := writer.WritePacket(&annotated); err != nil {
+			return summary, err
+		}
+		summary.Packets++
+	}
+
+	// Schnittstellen nach dem letzten Paket übernehmen
+	if err := syncInterfaces(); err != nil {
+		return summary, err
+	}
+
+	// Namensauflösungen der Quelle unverändert, danach die aus DNS-Antworten gewonnenen
+	for _, records := range [][]pcapng.NameRecord{source.NameRecords(), annotator.nameRecords()} {
+		if err := writer.WriteNameRecords(records); err != nil {
+			return summary, err
+		}
+		summary.NameRecords += len(records)
+	}
+
+	for finding, count := range annotator.findings {
+		summary.Findings[finding] = count
+	}
+	return summary, writer.Flush()
+}
+
+// exportSection übernimmt die Angaben der Quellsektion und weist den Analyzer als
+// schreibende Anwendung aus
+func exportSection(source pcapng.Section) pcapng.Section {
+	section := pcapng.Section{
+		Hardware:    source.Hardware,
+		OS:          source.OS,
+		Application: "ki-network-analyzer " + version.Version,
+		Comments:    append([]string(nil), source.Comments...),
+	}
+	if source.Application != "" {
+		section.Comments = append(section.Comments, "Aufgezeichnet mit "+source.Application)
+	}
+	section.Comments = append(section.Comments, "Paketkommentare enthalten Analyseergebnisse von ki-network-analyzer")
+	return section
+}
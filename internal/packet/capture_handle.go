@@ -0,0 +1,44 @@
+package packet
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// captureHandle ist die Paketquelle einer Session: ein libpcap-Handle oder eine Gruppe von
+// AF_PACKET-Sockets. Close darf gleichzeitig zu laufenden Lesern aufgerufen werden.
+type captureHandle interface {
+	LinkType() layers.LinkType
+	SetBPFFilter(filter string) error
+	// readers liefert je Socket einen Leser; wird einmal beim Start der Session aufgerufen
+	readers() []packetReader
+	// kernelStats liefert die bisherigen Verluste im Kernel und auf der Schnittstelle
+	kernelStats() (CaptureStats, error)
+	Close()
+}
+
+// packetReader liest Rohpakete von einem Socket. Die Daten bleiben bis zum nächsten Aufruf
+// gültig; nach dem Schließen des Handles liefert der Leser io.EOF oder einen Fehler.
+type packetReader interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	// release meldet, dass der Leser keine Daten mehr referenziert
+	release()
+}
+
+// linkTypeReader wird von Lesern implementiert, deren Pakete unterschiedliche Linktypen haben
+// können (PCAPNG-Dateien mit mehreren Schnittstellen). Der Wert gilt für das zuletzt gelesene Paket.
+type linkTypeReader interface {
+	packetLinkType() layers.LinkType
+}
+
+// fileOffsetReader wird von Dateilesern implementiert, die ihre Leseposition kennen
+type fileOffsetReader interface {
+	fileOffset() int64
+}
+
+// Größe des Dateikopfs und der Datensatzköpfe einer PCAP-Datei; libpcap meldet keine
+// Leseposition, sie wird daher aus den Paketlängen berechnet
+const (
+	pcapFileHeaderLen   = 24
+	pcapRecordHeaderLen = 16
+)
@@ -0,0 +1,360 @@
+package packet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Höchstzahl ausgepackter Tunnel je Paket; tiefere Verschachtelungen werden als äußeres
+// Paket ausgewertet
+const maxEncapsulations = 8
+
+// Bekannte UDP-Zielports der Tunnelprotokolle
+const (
+	vxlanPort  = 4789
+	genevePort = 6081
+)
+
+// Bezeichnungen der Tunnelarten in models.TunnelInfo
+const (
+	TunnelGRE    = "GRE"
+	TunnelVXLAN  = "VXLAN"
+	TunnelGeneve = "Geneve"
+	TunnelIPinIP = "IP-in-IP"
+)
+
+// Fehler beim Dekodieren von Tunnel-Headern
+var (
+	errTunnelTruncated = errors.New("Tunnel-Header unvollständig")
+	errMPLSTruncated   = errors.New("MPLS-Label-Stapel unvollständig")
+)
+
+// decodeLayers dekodiert ein Paket ab dem ersten Layer und packt dabei Tunnel aus. Erkennt ein
+// Layer eine Kapselung, endet der Durchlauf dort und das innere Paket wird mit denselben Layern
+// erneut dekodiert. Danach beschreiben d.decoded und die Layer das innerste Paket; VLAN-Tags,
+// MPLS-Labels und Tunnel aller Durchläufe stehen in d.info.
+func (d *packetDecoder) decodeLayers(data []byte, first gopacket.LayerType) (decodeFailed bool) {
+	for {
+		d.innerType, d.innerData = gopacket.LayerTypeZero, nil
+		if err := d.parser(first).DecodeLayers(data, &d.decoded); err != nil {
+			return true
+		}
+		if d.innerType == gopacket.LayerTypeZero {
+			return false
+		}
+		first, data = d.innerType, d.innerData
+	}
+}
+
+// encapsulate merkt sich das innere Paket einer Kapselung für den nächsten Durchlauf und
+// vermerkt den Tunnel mit den äußeren Adressen. Innere Protokolle, die der Decoder nicht
+// kennt, werden nicht ausgepackt; das Paket wird dann als äußeres Paket ausgewertet.
+func (d *packetDecoder) encapsulate(tunnelType string, id uint32, src, dst net.IP, inner gopacket.LayerType, payload []byte) bool {
+	switch inner {
+	case layers.LayerTypeEthernet, layers.LayerTypeDot1Q, layers.LayerTypeIPv4, layers.LayerTypeIPv6, layers.LayerTypeMPLS:
+	default:
+		return false
+	}
+	info := d.info
+	if len(info.Tunnels) >= maxEncapsulations {
+		return false
+	}
+	d.innerType, d.innerData = inner, payload
+
+	// Einträge samt Adresspuffern aus früheren Paketen wiederverwenden
+	n := len(info.Tunnels)
+	if n < cap(info.Tunnels) {
+		info.Tunnels = info.Tunnels[:n+1]
+	} else {
+		info.Tunnels = append(info.Tunnels, models.TunnelInfo{})
+	}
+	tunnel := &info.Tunnels[n]
+	tunnel.Type, tunnel.ID = tunnelType, id
+	tunnel.OuterSourceIP = append(tunnel.OuterSourceIP[:0], src...)
+	tunnel.OuterDestinationIP = append(tunnel.OuterDestinationIP[:0], dst...)
+	return true
+}
+
+// outerEndpoints gibt die Adressen der IP-Schicht zurück, über die ein Tunnel-Header im
+// aktuellen Durchlauf transportiert wird
+func (d *packetDecoder) outerEndpoints() (src, dst net.IP) {
+	switch {
+	case d.has(layers.LayerTypeIPv4):
+		return d.ip4.SrcIP, d.ip4.DstIP
+	case d.has(layers.LayerTypeIPv6):
+		return d.ip6.SrcIP, d.ip6.DstIP
+	}
+	return nil, nil
+}
+
+// dot1qLayer dekodiert 802.1Q- und 802.1ad-Tags und vermerkt sie in der Reihenfolge des
+// Pakets, sodass bei QinQ alle Tags erhalten bleiben
+type dot1qLayer struct {
+	layers.Dot1Q
+	decoder *packetDecoder
+}
+
+func (l *dot1qLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.Dot1Q.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	info := l.decoder.info
+	info.VLANs = append(info.VLANs, models.VLANTag{
+		ID:           l.VLANIdentifier,
+		Priority:     l.Priority,
+		DropEligible: l.DropEligible,
+	})
+	return nil
+}
+
+// ipv4Layer dekodiert IPv4 und packt IP-in-IP-Tunnel (Protokoll 4 und 41) aus
+type ipv4Layer struct {
+	layers.IPv4
+	decoder      *packetDecoder
+	encapsulated bool
+}
+
+func (l *ipv4Layer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.IPv4.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	l.encapsulated = false
+	if next := l.IPv4.NextLayerType(); next == layers.LayerTypeIPv4 || next == layers.LayerTypeIPv6 {
+		l.encapsulated = l.decoder.encapsulate(TunnelIPinIP, 0, l.SrcIP, l.DstIP, next, l.Payload)
+	}
+	return nil
+}
+
+func (l *ipv4Layer) NextLayerType() gopacket.LayerType {
+	if l.encapsulated {
+		return gopacket.LayerTypeZero
+	}
+	return l.IPv4.NextLayerType()
+}
+
+// ipv6Layer dekodiert IPv6 und packt IP-in-IP-Tunnel aus
+type ipv6Layer struct {
+	layers.IPv6
+	decoder      *packetDecoder
+	encapsulated bool
+}
+
+func (l *ipv6Layer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if err := l.IPv6.DecodeFromBytes(data, df); err != nil {
+		return err
+	}
+	l.encapsulated = false
+	if next := l.IPv6.NextLayerType(); next == layers.LayerTypeIPv4 || next == layers.LayerTypeIPv6 {
+		l.encapsulated = l.decoder.encapsulate(TunnelIPinIP, 0, l.SrcIP, l.DstIP, next, l.Payload)
+	}
+	return nil
+}
+
+func (l *ipv6Layer) NextLayerType() gopacket.LayerType {
+	if l.encapsulated {
+		return gopacket.LayerTypeZero
+	}
+	return l.IPv6.NextLayerType()
+}
+
+// mplsLayer dekodiert den vollständigen MPLS-Label-Stapel. MPLS enthält keine Angabe zum
+// transportierten Protokoll; IPv4 und IPv6 werden an der Versionsnummer erkannt.
+type mplsLayer struct {
+	layers.BaseLayer
+	decoder *packetDecoder
+	next    gopacket.LayerType
+}
+
+func (l *mplsLayer) CanDecode() gopacket.LayerClass { return layers.LayerTypeMPLS }
+
+func (l *mplsLayer) NextLayerType() gopacket.LayerType { return l.next }
+
+func (l *mplsLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	info := l.decoder.info
+	for offset := 0; ; offset += 4 {
+		if len(data) < offset+4 {
+			df.SetTruncated()
+			return errMPLSTruncated
+		}
+		entry := binary.BigEndian.Uint32(data[offset:])
+		info.MPLSLabels = append(info.MPLSLabels, models.MPLSLabel{
+			Label:        entry >> 12,
+			TrafficClass: uint8(entry>>9) & 0x7,
+			TTL:          uint8(entry),
+		})
+		if entry&0x100 != 0 {
+			l.Contents, l.Payload = data[:offset+4], data[offset+4:]
+			break
+		}
+	}
+
+	l.next = gopacket.LayerTypeZero
+	if len(l.Payload) > 0 {
+		switch l.Payload[0] >> 4 {
+		case 4:
+			l.next = layers.LayerTypeIPv4
+		case 6:
+			l.next = layers.LayerTypeIPv6
+		}
+	}
+	return nil
+}
+
+// greLayer dekodiert GRE-Header (RFC 2784/2890) und packt das transportierte Paket aus.
+// Enhanced GRE (PPTP) und Source Routing werden nicht ausgepackt.
+type greLayer struct {
+	layers.BaseLayer
+	decoder *packetDecoder
+}
+
+func (l *greLayer) CanDecode() gopacket.LayerClass { return layers.LayerTypeGRE }
+
+func (l *greLayer) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *greLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 4 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	flags, version := data[0], data[1]&0x07
+	protocol := layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+
+	headerLen := 4
+	if flags&0x80 != 0 { // Prüfsumme
+		headerLen += 4
+	}
+	var key uint32
+	if flags&0x20 != 0 { // Schlüssel
+		if len(data) >= headerLen+4 {
+			key = binary.BigEndian.Uint32(data[headerLen:])
+		}
+		headerLen += 4
+	}
+	if flags&0x10 != 0 { // Sequenznummer
+		headerLen += 4
+	}
+	if len(data) < headerLen {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	l.Contents, l.Payload = data[:headerLen], data[headerLen:]
+
+	if version == 0 && flags&0x40 == 0 {
+		src, dst := l.decoder.outerEndpoints()
+		l.decoder.encapsulate(TunnelGRE, key, src, dst, protocol.LayerType(), l.Payload)
+	}
+	return nil
+}
+
+// vxlanLayer dekodiert VXLAN-Header (RFC 7348); das innere Paket ist ein Ethernet-Frame
+type vxlanLayer struct {
+	layers.BaseLayer
+	decoder *packetDecoder
+}
+
+func (l *vxlanLayer) CanDecode() gopacket.LayerClass { return layers.LayerTypeVXLAN }
+
+func (l *vxlanLayer) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *vxlanLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	l.Contents, l.Payload = data[:8], data[8:]
+
+	// Nur Pakete an den VXLAN-Port mit gesetztem I-Flag sind VXLAN; ein Client mit zufällig
+	// gleichem Quellport wird nicht ausgepackt
+	if l.decoder.udp.DstPort == vxlanPort && data[0]&0x08 != 0 {
+		src, dst := l.decoder.outerEndpoints()
+		vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+		l.decoder.encapsulate(TunnelVXLAN, vni, src, dst, layers.LayerTypeEthernet, l.Payload)
+	}
+	return nil
+}
+
+// geneveLayer dekodiert Geneve-Header (RFC 8926) samt Optionen
+type geneveLayer struct {
+	layers.BaseLayer
+	decoder *packetDecoder
+}
+
+func (l *geneveLayer) CanDecode() gopacket.LayerClass { return layers.LayerTypeGeneve }
+
+func (l *geneveLayer) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+func (l *geneveLayer) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 8 {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	headerLen := 8 + int(data[0]&0x3f)*4
+	if len(data) < headerLen {
+		df.SetTruncated()
+		return errTunnelTruncated
+	}
+	l.Contents, l.Payload = data[:headerLen], data[headerLen:]
+
+	if l.decoder.udp.DstPort == genevePort && data[0]>>6 == 0 {
+		src, dst := l.decoder.outerEndpoints()
+		protocol := layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+		vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+		l.decoder.encapsulate(TunnelGeneve, vni, src, dst, protocol.LayerType(), l.Payload)
+	}
+	return nil
+}
+
+// vlanScope identifiziert den VLAN-Stapel eines Pakets für die Gateway-Erkennung; 0 steht
+// für Pakete ohne VLAN-Tag. Jede VLAN-ID belegt 12 Bit, sodass bis zu fünf Tags eindeutig
+// abgebildet werden. Tags mit ID 0 tragen nur eine Priorität und zählen nicht.
+type vlanScope uint64
+
+// scopeOf bestimmt den VLAN-Bereich eines Pakets
+func scopeOf(tags []models.VLANTag) vlanScope {
+	var scope vlanScope
+	for _, tag := range tags {
+		if tag.ID != 0 {
+			scope = scope<<12 | vlanScope(tag.ID&0x0fff)
+		}
+	}
+	return scope
+}
+
+// scopedIPKey ist eine Adresse innerhalb eines VLAN-Bereichs
+type scopedIPKey struct {
+	scope vlanScope
+	ip    ipKey
+}
+
+// vlanIDs gibt die IDs der VLAN-Tags eines Pakets zurück; Tags mit ID 0 werden übersprungen
+func vlanIDs(tags []models.VLANTag) []uint16 {
+	var ids []uint16
+	for _, tag := range tags {
+		if tag.ID != 0 {
+			ids = append(ids, tag.ID)
+		}
+	}
+	return ids
+}
+
+// inVLAN beschreibt den VLAN-Bereich eines Pakets für Befunde, z.B. " in VLAN 100/20";
+// ohne VLAN-Tag ist das Ergebnis leer
+func inVLAN(tags []models.VLANTag) string {
+	ids := vlanIDs(tags)
+	if len(ids) == 0 {
+		return ""
+	}
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = strconv.Itoa(int(id))
+	}
+	return " in VLAN " + strings.Join(parts, "/")
+}
@@ -0,0 +1,283 @@
+package packet
+
+import (
+	"sync"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Pools für analysierte Pakete. Die Verarbeitung gibt Pakete mit ReleasePacketInfo zurück;
+// nicht zurückgegebene Pakete werden regulär vom Garbage Collector freigegeben.
+var (
+	packetInfoPool = sync.Pool{New: func() interface{} { return new(models.PacketInfo) }}
+	dnsInfoPool    = sync.Pool{New: func() interface{} { return new(models.DNSInfo) }}
+	arpInfoPool    = sync.Pool{New: func() interface{} { return new(models.ARPInfo) }}
+)
+
+// acquirePacketInfo liefert ein leeres PacketInfo; vorhandene Adresspuffer werden wiederverwendet
+func acquirePacketInfo() *models.PacketInfo {
+	return packetInfoPool.Get().(*models.PacketInfo)
+}
+
+// acquireDNSInfo liefert ein leeres DNSInfo mit wiederverwendeten Listen
+func acquireDNSInfo() *models.DNSInfo {
+	return dnsInfoPool.Get().(*models.DNSInfo)
+}
+
+// acquireARPInfo liefert ein leeres ARPInfo mit wiederverwendeten Adresspuffern
+func acquireARPInfo() *models.ARPInfo {
+	return arpInfoPool.Get().(*models.ARPInfo)
+}
+
+// ReleasePacketInfo gibt ein analysiertes Paket zur Wiederverwendung frei. Danach dürfen weder
+// das Paket noch daraus entnommene Adressen oder DNS-/ARP-Informationen verwendet werden.
+// Pakete, die gespeichert oder weitergereicht werden, werden nicht freigegeben.
+func ReleasePacketInfo(info *models.PacketInfo) {
+	if info == nil {
+		return
+	}
+
+	if dns := info.DNSInfo; dns != nil {
+		*dns = models.DNSInfo{Queries: dns.Queries[:0], Answers: dns.Answers[:0]}
+		dnsInfoPool.Put(dns)
+	}
+	if arp := info.ARPInfo; arp != nil {
+		*arp = models.ARPInfo{SenderIP: arp.SenderIP[:0], TargetIP: arp.TargetIP[:0]}
+		arpInfoPool.Put(arp)
+	}
+
+	// GatewayIP verweist auf eine der Adressen oder auf DHCP-Daten und wird nicht wiederverwendet
+	*info = models.PacketInfo{
+		SourceIP:      info.SourceIP[:0],
+		DestinationIP: info.DestinationIP[:0],
+		VLANs:         info.VLANs[:0],
+		MPLSLabels:    info.MPLSLabels[:0],
+		Tunnels:       info.Tunnels[:0],
+	}
+	packetInfoPool.Put(info)
+}
+
+// packetDecoder dekodiert Rohpakete mit vorab angelegten Layern, ohne pro Paket Layer-Objekte
+// zu erzeugen. Ein Decoder ist nicht threadsicher; jeder Dekodier-Worker besitzt einen eigenen.
+type packetDecoder struct {
+	capturer *PcapCapturer
+	linkType layers.LinkType
+
+	// Parser je erstem Layer, bei Raw-IP abhängig von der IP-Version
+	parsers map[gopacket.LayerType]*gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+
+	// Paket, in das VLAN-Tags, MPLS-Labels und Tunnel während des Dekodierens eingetragen
+	// werden, und das innere Paket einer erkannten Kapselung
+	info      *models.PacketInfo
+	innerType gopacket.LayerType
+	innerData []byte
+
+	eth      layers.Ethernet
+	dot1q    dot1qLayer
+	sll      layers.LinuxSLL
+	loopback layers.Loopback
+	arp      layers.ARP
+	ip4      ipv4Layer
+	ip6      ipv6Layer
+	icmp4    layers.ICMPv4
+	tcp      layers.TCP
+	udp      layers.UDP
+	dns      layers.DNS
+	dhcp     layers.DHCPv4
+	mpls     mplsLayer
+	gre      greLayer
+	vxlan    vxlanLayer
+	geneve   geneveLayer
+}
+
+// newPacketDecoder erstellt einen Decoder für den Linktyp einer Session
+func newPacketDecoder(capturer *PcapCapturer, linkType layers.LinkType) *packetDecoder {
+	d := &packetDecoder{
+		capturer: capturer,
+		linkType: linkType,
+		parsers:  make(map[gopacket.LayerType]*gopacket.DecodingLayerParser),
+		decoded:  make([]gopacket.LayerType, 0, 8),
+	}
+	d.dot1q.decoder = d
+	d.ip4.decoder = d
+	d.ip6.decoder = d
+	d.mpls.decoder = d
+	d.gre.decoder = d
+	d.vxlan.decoder = d
+	d.geneve.decoder = d
+	return d
+}
+
+// parser gibt den Parser zurück, der mit dem angegebenen Layer beginnt
+func (d *packetDecoder) parser(first gopacket.LayerType) *gopacket.DecodingLayerParser {
+	if parser, ok := d.parsers[first]; ok {
+		return parser
+	}
+
+	parser := gopacket.NewDecodingLayerParser(first,
+		&d.eth, &d.dot1q, &d.sll, &d.loopback, &d.arp,
+		&d.ip4, &d.ip6, &d.icmp4, &d.tcp, &d.udp, &d.dns, &d.dhcp,
+		&d.mpls, &d.gre, &d.vxlan, &d.geneve)
+	// Nicht benötigte Layer (z.B. Nutzdaten) beenden das Dekodieren ohne Fehler
+	parser.IgnoreUnsupported = true
+	d.parsers[first] = parser
+	return parser
+}
+
+// firstLayer bestimmt den ersten Layer eines Rohpakets anhand des Linktyps
+func (d *packetDecoder) firstLayer(data []byte) (gopacket.LayerType, bool) {
+	switch d.linkType {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet, true
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL, true
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		return layers.LayerTypeLoopback, true
+	case layers.LinkTypeIPv4:
+		return layers.LayerTypeIPv4, true
+	case layers.LinkTypeIPv6:
+		return layers.LayerTypeIPv6, true
+	case layers.LinkTypeRaw:
+		if len(data) > 0 && data[0]>>4 == 6 {
+			return layers.LayerTypeIPv6, true
+		}
+		return layers.LayerTypeIPv4, true
+	}
+	return 0, false
+}
+
+// decode dekodiert und analysiert ein Rohpaket mit Gateway-Fokus. data wird nach der Rückkehr
+// nicht mehr referenziert. decodeFailed meldet, dass nicht alle Layer dekodiert werden konnten;
+// die bis dahin gewonnenen Informationen werden trotzdem ausgewertet. Getunnelte Pakete werden
+// anhand des innersten Pakets analysiert.
+func (d *packetDecoder) decode(data []byte, ci gopacket.CaptureInfo) (info *models.PacketInfo, decodeFailed bool) {
+	d.decoded = d.decoded[:0]
+
+	info = acquirePacketInfo()
+	info.Timestamp = ci.Timestamp
+	info.Length = uint32(ci.Length)
+	info.Protocol = "Unknown"
+	d.info = info
+
+	if first, ok := d.firstLayer(data); ok {
+		decodeFailed = d.decodeLayers(data, first)
+	} else {
+		// Seltene Linktypen: mit gopacket bis zur Netzwerkschicht dekodieren
+		decodeFailed = d.decodeFallback(data)
+	}
+
+	d.analyze(info)
+	d.info, d.innerData = nil, nil
+
+	// Nicht gesetzte Adressen wie bisher als nil melden
+	if len(info.SourceIP) == 0 {
+		info.SourceIP = nil
+	}
+	if len(info.DestinationIP) == 0 {
+		info.DestinationIP = nil
+	}
+	return info, decodeFailed
+}
+
+// decodeFallback dekodiert Pakete unbekannter Linktypen mit gopacket und übergibt die
+// Netzwerkschicht an den IP-Parser
+func (d *packetDecoder) decodeFallback(data []byte) bool {
+	packet := gopacket.NewPacket(data, d.linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	network := packet.NetworkLayer()
+	if network == nil {
+		return packet.ErrorLayer() != nil
+	}
+
+	var first gopacket.LayerType
+	switch network.LayerType() {
+	case layers.LayerTypeIPv4, layers.LayerTypeIPv6:
+		first = network.LayerType()
+	default:
+		return false
+	}
+
+	networkData := append(append([]byte(nil), network.LayerContents()...), network.LayerPayload()...)
+	return d.decodeLayers(networkData, first)
+}
+
+// has prüft, ob ein Layer im aktuellen Paket dekodiert wurde
+func (d *packetDecoder) has(layerType gopacket.LayerType) bool {
+	for _, decoded := range d.decoded {
+		if decoded == layerType {
+			return true
+		}
+	}
+	return false
+}
+
+// analyze wertet die dekodierten Layer aus
+func (d *packetDecoder) analyze(info *models.PacketInfo) {
+	c := d.capturer
+	scope := scopeOf(info.VLANs)
+
+	// ARP-Analyse
+	if d.has(layers.LayerTypeARP) {
+		c.analyzeARPPacket(&d.arp, info)
+		return
+	}
+
+	// Netzwerk Layer (IPv4/IPv6); Adressen werden in die Puffer des Pakets kopiert
+	switch {
+	case d.has(layers.LayerTypeIPv4):
+		info.SourceIP = append(info.SourceIP, d.ip4.SrcIP...)
+		info.DestinationIP = append(info.DestinationIP, d.ip4.DstIP...)
+		info.TTL = d.ip4.TTL
+
+		// ICMP-Analyse
+		if d.ip4.Protocol == layers.IPProtocolICMPv4 && d.has(layers.LayerTypeICMPv4) {
+			info.Protocol = "ICMP"
+
+			// Prüfen, ob Gateway involviert ist, und das Gateway identifizieren
+			info.IsGatewayTraffic, info.GatewayIP = c.classifyGatewayTraffic(scope, info.SourceIP, info.DestinationIP)
+			return
+		}
+
+	case d.has(layers.LayerTypeIPv6):
+		info.SourceIP = append(info.SourceIP, d.ip6.SrcIP...)
+		info.DestinationIP = append(info.DestinationIP, d.ip6.DstIP...)
+		info.TTL = d.ip6.HopLimit
+
+	default:
+		// Weder IPv4 noch IPv6 - vermutlich anderes Link-Layer-Protokoll
+		return
+	}
+
+	// Transport Layer (TCP/UDP)
+	switch {
+	case d.has(layers.LayerTypeTCP):
+		info.SourcePort = uint16(d.tcp.SrcPort)
+		info.DestinationPort = uint16(d.tcp.DstPort)
+		info.Protocol = "TCP"
+
+	case d.has(layers.LayerTypeUDP):
+		udp := &d.udp
+		info.SourcePort = uint16(udp.SrcPort)
+		info.DestinationPort = uint16(udp.DstPort)
+		info.Protocol = "UDP"
+
+		// DNS-Analyse (Port 53)
+		if (udp.SrcPort == 53 || udp.DstPort == 53) && d.has(layers.LayerTypeDNS) {
+			c.analyzeDNSPacket(&d.dns, info)
+			return
+		}
+
+		// DHCP-Analyse (Port 67/68)
+		if ((udp.SrcPort == 67 && udp.DstPort == 68) || (udp.SrcPort == 68 && udp.DstPort == 67)) &&
+			d.has(layers.LayerTypeDHCPv4) {
+			c.analyzeDHCPPacket(&d.dhcp, info)
+			return
+		}
+	}
+
+	// Gateway-Traffic erkennen und das Gateway identifizieren
+	info.IsGatewayTraffic, info.GatewayIP = c.classifyGatewayTraffic(scope, info.SourceIP, info.DestinationIP)
+}
@@ -0,0 +1,520 @@
+package packet
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// FilterError beschreibt einen BPF-Filter, den libpcap nicht übersetzen kann. Offset und Token
+// zeigen auf die Stelle, an der der Ausdruck ungültig wird.
+type FilterError struct {
+	Filter   string `json:"filter"`
+	LinkType string `json:"link_type"`
+	Message  string `json:"message"`         // Meldung von libpcap
+	Offset   int    `json:"offset"`          // Byte-Position des fehlerhaften Tokens, -1 = unbekannt
+	Token    string `json:"token,omitempty"` // leer, wenn der Ausdruck unvollständig endet
+}
+
+func (e *FilterError) Error() string {
+	switch {
+	case e.Offset < 0:
+		return fmt.Sprintf("Ungültiger BPF-Filter: %s", e.Message)
+	case e.Token == "":
+		return fmt.Sprintf("Ungültiger BPF-Filter: %s (Ausdruck endet unvollständig)", e.Message)
+	}
+	return fmt.Sprintf("Ungültiger BPF-Filter: %s (bei Position %d: '%s')", e.Message, e.Offset, e.Token)
+}
+
+// CompileFilter übersetzt einen Filter für einen Linktyp. Fehler werden als *FilterError mit
+// der Position des fehlerhaften Tokens zurückgegeben. Vorkompilierte Programme im Format von
+// "tcpdump -ddd" (Zeilen oder durch Kommas getrennt) werden direkt übernommen; sie sind ohne
+// libpcap die einzige Möglichkeit, einen Filter zu setzen.
+func CompileFilter(filter string, linkType layers.LinkType, snapLen int) ([]bpf.RawInstruction, error) {
+	if program, ok, err := parseCompiledFilter(filter); ok {
+		if err != nil {
+			return nil, &FilterError{Filter: filter, LinkType: linkType.String(), Message: err.Error(), Offset: -1}
+		}
+		return program, nil
+	}
+	return compileLibpcapFilter(filter, linkType, snapLen)
+}
+
+// ValidateBPFFilter prüft, ob sich ein BPF-Filter für Ethernet-Pakete kompilieren lässt
+func ValidateBPFFilter(filter string, snapLen int) error {
+	if filter == "" {
+		return nil
+	}
+	_, err := CompileFilter(filter, layers.LinkTypeEthernet, snapLen)
+	return err
+}
+
+// Vorkompiliertes BPF-Programm: Anzahl der Anweisungen, danach je Anweisung "code jt jf k"
+var compiledFilterPattern = regexp.MustCompile(`^\s*\d+\s*([,\n]\s*\d+[ \t]+\d+[ \t]+\d+[ \t]+\d+[ \t\r]*)+[,\n]?\s*$`)
+
+// parseCompiledFilter liest ein vorkompiliertes BPF-Programm. ok ist false, wenn der Filter
+// ein Ausdruck der Filtersprache ist.
+func parseCompiledFilter(filter string) (program []bpf.RawInstruction, ok bool, err error) {
+	if !compiledFilterPattern.MatchString(filter) {
+		return nil, false, nil
+	}
+
+	lines := strings.FieldsFunc(filter, func(r rune) bool { return r == ',' || r == '\n' })
+	count, _ := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if count != len(lines)-1 {
+		return nil, true, fmt.Errorf("Vorkompiliertes BPF-Programm nennt %d Anweisungen, enthält aber %d", count, len(lines)-1)
+	}
+
+	for _, line := range lines[1:] {
+		var values [4]uint64
+		for i, field := range strings.Fields(line) {
+			bits := []int{16, 8, 8, 32}[i]
+			if values[i], err = strconv.ParseUint(field, 10, bits); err != nil {
+				return nil, true, fmt.Errorf("Ungültige BPF-Anweisung '%s'", strings.TrimSpace(line))
+			}
+		}
+		program = append(program, bpf.RawInstruction{
+			Op: uint16(values[0]), Jt: uint8(values[1]), Jf: uint8(values[2]), K: uint32(values[3]),
+		})
+	}
+	return program, true, nil
+}
+
+// bpfMatcher prüft einzelne Pakete gegen einen Filter, z.B. beim Lesen von Dateien
+type bpfMatcher interface {
+	Matches(ci gopacket.CaptureInfo, data []byte) bool
+}
+
+// newBPFMatcher übersetzt einen Filter zum Prüfen von Paketen eines Linktyps. Vorkompilierte
+// Programme laufen in der BPF-VM von golang.org/x/net/bpf, die keine Kernel-Erweiterungen
+// (z.B. vlan_avail) kennt. Fehler werden als *FilterError zurückgegeben.
+func newBPFMatcher(linkType layers.LinkType, snapLen int, filter string) (bpfMatcher, error) {
+	program, ok, err := parseCompiledFilter(filter)
+	if !ok {
+		return newLibpcapMatcher(linkType, snapLen, filter)
+	}
+	if err == nil {
+		instructions, _ := bpf.Disassemble(program)
+		var vm *bpf.VM
+		if vm, err = bpf.NewVM(instructions); err == nil {
+			return vmMatcher{vm}, nil
+		}
+	}
+	return nil, &FilterError{Filter: filter, LinkType: linkType.String(), Message: err.Error(), Offset: -1}
+}
+
+// vmMatcher prüft Pakete mit der BPF-VM von golang.org/x/net/bpf
+type vmMatcher struct {
+	vm *bpf.VM
+}
+
+func (m vmMatcher) Matches(ci gopacket.CaptureInfo, data []byte) bool {
+	n, err := m.vm.Run(data)
+	return err == nil && n > 0
+}
+
+// InterfaceLinkType gibt den Linktyp zurück, mit dem Pakete einer Schnittstelle erfasst werden.
+// Die Pseudo-Schnittstelle "any" liefert Linux-Cooked-Capture-Rahmen.
+func InterfaceLinkType(iface string) layers.LinkType {
+	if iface == "any" {
+		return layers.LinkTypeLinuxSLL
+	}
+	return interfaceLinkType(iface)
+}
+
+// Von libpcap in Meldungen zitierte Tokens, z.B. "unknown host 'foo'"
+var quotedFilterToken = regexp.MustCompile(`'([^']+)'`)
+
+// newFilterError bestimmt die fehlerhafte Stelle eines Filters. libpcap nennt keine Position,
+// daher sucht ein eigener Parser die erste Stelle, an der die Syntax nicht mehr passt. Ist
+// die Syntax korrekt (z.B. bei unbekannten Hosts oder Ports), wird das in der Meldung
+// zitierte Token im Ausdruck gesucht.
+func newFilterError(filter string, linkType layers.LinkType, err error) *FilterError {
+	filterErr := &FilterError{
+		Filter:   filter,
+		LinkType: linkType.String(),
+		Message:  err.Error(),
+		Offset:   -1,
+	}
+
+	tokens := lexFilter(filter)
+
+	// Semantische Fehler nennen das Token selbst; der eigene Parser ist nur eine Näherung
+	// der Grammatik und wird für sie nicht gebraucht
+	if !strings.Contains(filterErr.Message, "syntax error") && filterErr.locateQuotedToken(tokens) {
+		return filterErr
+	}
+
+	if index, ok := findFilterSyntaxError(tokens); ok {
+		if index < len(tokens) {
+			filterErr.Offset = tokens[index].offset
+			filterErr.Token = tokens[index].text
+		} else {
+			filterErr.Offset = len(filter)
+		}
+		return filterErr
+	}
+
+	filterErr.locateQuotedToken(tokens)
+	return filterErr
+}
+
+// locateQuotedToken setzt die Position auf das erste in der Meldung zitierte Token, das im
+// Ausdruck vorkommt. libpcap zitiert maskierte Namen ohne den führenden Backslash.
+func (e *FilterError) locateQuotedToken(tokens []filterToken) bool {
+	for _, match := range quotedFilterToken.FindAllStringSubmatch(e.Message, -1) {
+		for _, token := range tokens {
+			if token.text == match[1] || strings.TrimPrefix(token.text, `\`) == match[1] {
+				e.Offset = token.offset
+				e.Token = token.text
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterToken ist ein Token eines Filterausdrucks mit seiner Byte-Position
+type filterToken struct {
+	text   string
+	offset int
+}
+
+// Operatoren aus zwei Zeichen; sie werden vor den einzelnen Zeichen geprüft
+var filterOperators2 = []string{"&&", "||", "<<", ">>", "<=", ">=", "==", "!="}
+
+// lexFilter zerlegt einen Filter wie der Lexer von libpcap in Namen, Zahlen, Adressen und
+// Operatoren. Doppelpunkte gehören außerhalb eckiger Klammern zum Namen (MAC- und
+// IPv6-Adressen), innerhalb trennen sie Offset und Größe. Mit Backslash maskierte Namen
+// wie "\ip" reichen bis zum nächsten Leerzeichen, "!" oder einer Klammer und sind nie
+// Schlüsselwörter.
+func lexFilter(filter string) []filterToken {
+	var tokens []filterToken
+	depth := 0
+	isWordChar := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '_' || c == '.' || c == '-' || (c == ':' && depth == 0)
+	}
+
+	for i := 0; i < len(filter); {
+		c := filter[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\\' && i+1 < len(filter) && !isEscapedNameEnd(filter[i+1]):
+			start := i
+			for i++; i < len(filter) && !isEscapedNameEnd(filter[i]); i++ {
+			}
+			tokens = append(tokens, filterToken{text: filter[start:i], offset: start})
+			continue
+		case isWordChar(c) && c != '-' && c != '.':
+			start := i
+			for i < len(filter) && isWordChar(filter[i]) {
+				i++
+			}
+			tokens = append(tokens, filterToken{text: filter[start:i], offset: start})
+			continue
+		}
+
+		length := 1
+		for _, op := range filterOperators2 {
+			if strings.HasPrefix(filter[i:], op) {
+				length = 2
+				break
+			}
+		}
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			if depth > 0 {
+				depth--
+			}
+		}
+		tokens = append(tokens, filterToken{text: filter[i : i+length], offset: i})
+		i += length
+	}
+	return tokens
+}
+
+// isEscapedNameEnd meldet, ob ein Zeichen einen mit Backslash maskierten Namen beendet
+func isEscapedNameEnd(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '!' || c == '(' || c == ')'
+}
+
+// Schlüsselwörter der Filtersprache, gruppiert nach ihrer Rolle in einem Primitiv
+var (
+	filterProtoKeywords = keywordSet("ether fddi tr wlan ip ip6 arp rarp decnet lat sca moprc mopdl " +
+		"tcp udp sctp icmp icmp6 igmp igrp pim vrrp carp ah esp iso esis es-is isis is-is clnp " +
+		"stp ipx netbeui link ppp slip radio atalk aarp l1 l2 iih lsp snp csnp psnp")
+	filterDirKeywords  = keywordSet("src dst ra ta addr1 addr2 addr3 addr4")
+	filterTypeKeywords = keywordSet("host net port portrange proto protochain gateway")
+
+	// Eigenständige Primitive ohne Argument, mit optionaler Nummer oder mit Pflichtargument
+	filterBareKeywords     = keywordSet("broadcast multicast inbound outbound pppoed")
+	filterOptionalKeywords = keywordSet("vlan mpls pppoes geneve llc")
+	filterArgKeywords      = keywordSet("less greater ifname on rnr rulenum srnr subrulenum " +
+		"reason action rset type subtype")
+
+	filterLogicKeywords = keywordSet("and or not")
+	filterRelOperators  = keywordSet("> < >= <= = == !=")
+	filterArithOps      = keywordSet("+ - * / % & | ^ << >>")
+)
+
+func keywordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range strings.Fields(words) {
+		set[word] = true
+	}
+	return set
+}
+
+// isFilterKeyword meldet, ob ein Token ein Schlüsselwort der Filtersprache ist
+func isFilterKeyword(text string) bool {
+	return filterProtoKeywords[text] || filterDirKeywords[text] || filterTypeKeywords[text] ||
+		filterBareKeywords[text] || filterOptionalKeywords[text] || filterArgKeywords[text] ||
+		filterLogicKeywords[text]
+}
+
+// filterParser prüft die Syntax eines Filters mit Rücksetzen. Er akzeptiert eher zu viel als zu
+// wenig, da libpcap über die Gültigkeit entscheidet; gesucht ist nur die fehlerhafte Stelle.
+type filterParser struct {
+	tokens   []filterToken
+	furthest int // Index des am weitesten entfernten nicht passenden Tokens
+}
+
+// findFilterSyntaxError gibt den Index des ersten nicht passenden Tokens zurück (len(tokens),
+// wenn der Ausdruck unvollständig endet) oder false, wenn die Syntax korrekt ist
+func findFilterSyntaxError(tokens []filterToken) (int, bool) {
+	p := &filterParser{tokens: tokens, furthest: -1}
+	if end, ok := p.expr(0); ok && end == len(tokens) {
+		return 0, false
+	} else if ok {
+		p.fail(end)
+	}
+	return p.furthest, true
+}
+
+func (p *filterParser) tok(i int) string {
+	if i < len(p.tokens) {
+		return p.tokens[i].text
+	}
+	return ""
+}
+
+func (p *filterParser) fail(i int) (int, bool) {
+	if i > p.furthest {
+		p.furthest = i
+	}
+	return i, false
+}
+
+// isValue meldet, ob ein Token als Adresse, Name oder Zahl gelesen werden kann
+func (p *filterParser) isValue(i int) bool {
+	text := p.tok(i)
+	if text == "" || isFilterKeyword(text) {
+		return false
+	}
+	c := text[0]
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == ':' ||
+		(c == '\\' && len(text) > 1)
+}
+
+// expr: term { (and | or) term }
+func (p *filterParser) expr(i int) (int, bool) {
+	j, ok := p.term(i)
+	for ok {
+		switch p.tok(j) {
+		case "and", "or", "&&", "||":
+			j, ok = p.term(j + 1)
+		default:
+			return j, true
+		}
+	}
+	return j, false
+}
+
+// term: not term | ( expr ) | Vergleich | Primitiv
+func (p *filterParser) term(i int) (int, bool) {
+	switch p.tok(i) {
+	case "not", "!":
+		return p.term(i + 1)
+	}
+
+	// Ein Vergleich kann wie ein Klammerausdruck beginnen, z.B. "(len - 14) > 100"
+	if j, ok := p.relation(i); ok {
+		return j, true
+	}
+	if p.tok(i) == "(" {
+		j, ok := p.expr(i + 1)
+		if !ok {
+			return j, false
+		}
+		if p.tok(j) != ")" {
+			return p.fail(j)
+		}
+		return j + 1, true
+	}
+	return p.primitive(i)
+}
+
+// primitive: [proto] [dir] [type] Wert | proto | eigenständiges Schlüsselwort
+func (p *filterParser) primitive(i int) (int, bool) {
+	text := p.tok(i)
+	switch {
+	case filterBareKeywords[text]:
+		return i + 1, true
+	case filterOptionalKeywords[text]:
+		if p.isValue(i + 1) {
+			return i + 2, true
+		}
+		return i + 1, true
+	case filterArgKeywords[text]:
+		if !p.isValue(i + 1) {
+			return p.fail(i + 1)
+		}
+		return i + 2, true
+	}
+
+	j := i
+	proto := false
+	if filterProtoKeywords[p.tok(j)] {
+		proto = true
+		j++
+		// "ether broadcast", "ip multicast"
+		if p.tok(j) == "broadcast" || p.tok(j) == "multicast" {
+			return j + 1, true
+		}
+	}
+	qualified := false
+	if filterDirKeywords[p.tok(j)] {
+		qualified = true
+		j++
+		// "src or dst", "src and dst"
+		if (p.tok(j) == "or" || p.tok(j) == "and") && filterDirKeywords[p.tok(j+1)] {
+			j += 2
+		}
+	}
+	typ := ""
+	if filterTypeKeywords[p.tok(j)] {
+		typ = p.tok(j)
+		qualified = true
+		j++
+	}
+
+	switch {
+	case proto && !qualified:
+		// Protokoll allein, z.B. "tcp"
+		return j, true
+	case !proto && !qualified && !p.isValue(j):
+		return p.fail(j)
+	}
+	return p.value(j, typ)
+}
+
+// value: Wert | ( Wert { (and | or) Wert } )
+func (p *filterParser) value(i int, typ string) (int, bool) {
+	if p.tok(i) == "(" {
+		j, ok := p.value(i+1, typ)
+		for ok && (p.tok(j) == "and" || p.tok(j) == "or" || p.tok(j) == "&&" || p.tok(j) == "||") {
+			j, ok = p.value(j+1, typ)
+		}
+		if !ok {
+			return j, false
+		}
+		if p.tok(j) != ")" {
+			return p.fail(j)
+		}
+		return j + 1, true
+	}
+
+	// Nach "proto" dürfen Protokollnamen stehen, z.B. "ip proto tcp"
+	protoName := (typ == "proto" || typ == "protochain") && filterProtoKeywords[p.tok(i)]
+	if !protoName && !p.isValue(i) {
+		return p.fail(i)
+	}
+	j := i + 1
+
+	// Netze mit Präfixlänge oder Maske
+	if typ == "net" {
+		switch p.tok(j) {
+		case "/":
+			if !p.isValue(j + 1) {
+				return p.fail(j + 1)
+			}
+			j += 2
+		case "mask":
+			if !p.isValue(j + 1) {
+				return p.fail(j + 1)
+			}
+			j += 2
+		}
+	}
+	return j, true
+}
+
+// relation: arith Vergleichsoperator arith
+func (p *filterParser) relation(i int) (int, bool) {
+	j, ok := p.arith(i)
+	if !ok {
+		return j, false
+	}
+	if !filterRelOperators[p.tok(j)] {
+		return p.fail(j)
+	}
+	return p.arith(j + 1)
+}
+
+// arith: aterm { Operator aterm }
+func (p *filterParser) arith(i int) (int, bool) {
+	j, ok := p.aterm(i)
+	for ok && filterArithOps[p.tok(j)] {
+		j, ok = p.aterm(j + 1)
+	}
+	return j, ok
+}
+
+// aterm: - aterm | ( arith ) | proto [ arith [: Größe] ] | Zahl | Name
+func (p *filterParser) aterm(i int) (int, bool) {
+	text := p.tok(i)
+	switch {
+	case text == "-":
+		return p.aterm(i + 1)
+	case text == "(":
+		j, ok := p.arith(i + 1)
+		if !ok {
+			return j, false
+		}
+		if p.tok(j) != ")" {
+			return p.fail(j)
+		}
+		return j + 1, true
+	case filterProtoKeywords[text]:
+		if p.tok(i+1) != "[" {
+			return p.fail(i + 1)
+		}
+		j, ok := p.arith(i + 2)
+		if !ok {
+			return j, false
+		}
+		if p.tok(j) == ":" {
+			if !p.isValue(j + 1) {
+				return p.fail(j + 1)
+			}
+			j += 2
+		}
+		if p.tok(j) != "]" {
+			return p.fail(j)
+		}
+		return j + 1, true
+	case p.isValue(i):
+		return i + 1, true
+	}
+	return p.fail(i)
+}
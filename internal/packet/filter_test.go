@@ -0,0 +1,226 @@
+package packet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Meldung, die libpcap bei Syntaxfehlern liefert
+const pcapSyntaxError = "syntax error in filter expression: syntax error"
+
+func TestFindFilterSyntaxErrorValid(t *testing.T) {
+	testCases := []string{
+		"tcp",
+		"tcp port 80",
+		"port 53 and not tcp",
+		"host 192.168.1.1",
+		"src host 10.0.0.1 and dst port 443",
+		"src or dst host a.example.com",
+		"src net 10.0.0.0/8",
+		"net 10.0.0.0 mask 255.0.0.0",
+		"ether host 00:11:22:33:44:55",
+		"ether broadcast or ip multicast",
+		"ip6 host fe80::1",
+		"portrange 1000-2000",
+		"not arp and not (port 22 or port 53)",
+		"host 10.0.0.1 and (port 80 or 443)",
+		"ip && !udp || icmp",
+		"vlan and udp",
+		"vlan 100 and udp",
+		"less 128",
+		"greater 64 and tcp",
+		"ip proto tcp",
+		"ether proto \\ip",
+		"ether proto \\arp or ip proto \\udp",
+		"ip proto \\tcp and host nosuchhost",
+		"tcp[13] & 2 != 0",
+		"tcp[tcpflags] & (tcp-syn|tcp-fin) != 0",
+		"icmp[icmptype] == icmp-echo",
+		"ip[2:2] > 576",
+		"(len - 14) > 100",
+		"ether[0] & 1 = 0 and ip[16] >= 224",
+	}
+
+	for _, filter := range testCases {
+		t.Run(filter, func(t *testing.T) {
+			tokens := lexFilter(filter)
+			if index, ok := findFilterSyntaxError(tokens); ok {
+				token := "<end>"
+				if index < len(tokens) {
+					token = tokens[index].text
+				}
+				t.Fatalf("syntax error at token %d (%s), want none", index, token)
+			}
+		})
+	}
+}
+
+func TestNewFilterError(t *testing.T) {
+	testCases := []struct {
+		name       string
+		filter     string
+		message    string
+		wantOffset int
+		wantToken  string
+	}{
+		{
+			name:       "keyword instead of host",
+			filter:     "host and port 80",
+			message:    pcapSyntaxError,
+			wantOffset: 5,
+			wantToken:  "and",
+		},
+		{
+			name:       "unbalanced closing parenthesis",
+			filter:     "port 80 )",
+			message:    pcapSyntaxError,
+			wantOffset: 8,
+			wantToken:  ")",
+		},
+		{
+			name:       "missing size after colon",
+			filter:     "ip[2:] > 5",
+			message:    pcapSyntaxError,
+			wantOffset: 5,
+			wantToken:  "]",
+		},
+		{
+			name:       "missing port number",
+			filter:     "tcp port",
+			message:    pcapSyntaxError,
+			wantOffset: 8,
+		},
+		{
+			name:       "direction without value",
+			filter:     "tcp dst port 80 and udp src",
+			message:    pcapSyntaxError,
+			wantOffset: 27,
+		},
+		{
+			name:       "unterminated value list",
+			filter:     "vlan 100 and (host 10.0.0.1 or",
+			message:    pcapSyntaxError,
+			wantOffset: 30,
+		},
+		{
+			name:       "lone backslash",
+			filter:     "ip proto \\",
+			message:    pcapSyntaxError,
+			wantOffset: 9,
+			wantToken:  "\\",
+		},
+		{
+			name:       "unknown host after escaped protocol",
+			filter:     "ether proto \\ip and host nosuchhost",
+			message:    "unknown host 'nosuchhost'",
+			wantOffset: 25,
+			wantToken:  "nosuchhost",
+		},
+		{
+			name:       "unknown escaped protocol",
+			filter:     "ip proto \\nosuchproto",
+			message:    "unknown ip proto 'nosuchproto'",
+			wantOffset: 9,
+			wantToken:  "\\nosuchproto",
+		},
+		{
+			name:       "quoted token takes precedence over approximated grammar",
+			filter:     "tcp port 80 and host nosuchhost",
+			message:    "unknown host 'nosuchhost'",
+			wantOffset: 21,
+			wantToken:  "nosuchhost",
+		},
+		{
+			name:       "message without token",
+			filter:     "port 99999",
+			message:    "illegal port number 99999 > 65535",
+			wantOffset: -1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			filterErr := newFilterError(tc.filter, layers.LinkTypeEthernet, errors.New(tc.message))
+			if filterErr.Offset != tc.wantOffset || filterErr.Token != tc.wantToken {
+				t.Errorf("error at %d %q, want %d %q", filterErr.Offset, filterErr.Token, tc.wantOffset, tc.wantToken)
+			}
+			if filterErr.Message != tc.message {
+				t.Errorf("message %q, want %q", filterErr.Message, tc.message)
+			}
+		})
+	}
+}
+
+// "ip and udp" für Ethernet, wie von tcpdump -ddd ausgegeben
+const compiledUDPFilter = "6\n40 0 0 12\n21 0 3 2048\n48 0 0 23\n21 0 1 17\n6 0 0 262144\n6 0 0 0\n"
+
+func TestParseCompiledFilter(t *testing.T) {
+	testCases := []struct {
+		name    string
+		filter  string
+		wantOK  bool
+		wantLen int
+		wantErr bool
+	}{
+		{name: "tcpdump -ddd output", filter: compiledUDPFilter, wantOK: true, wantLen: 6},
+		{name: "comma separated", filter: "2,40 0 0 12,6 0 0 65535", wantOK: true, wantLen: 2},
+		{name: "CRLF line endings", filter: "1\r\n6 0 0 65535\r\n", wantOK: true, wantLen: 1},
+		{name: "count mismatch", filter: "3,40 0 0 12,6 0 0 65535", wantOK: true, wantErr: true},
+		{name: "jump offset out of range", filter: "1,21 300 0 2048", wantOK: true, wantErr: true},
+		{name: "filter expression", filter: "udp port 53"},
+		{name: "bare number", filter: "80"},
+		{name: "expression starting with number", filter: "1 and tcp"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			program, ok, err := parseCompiledFilter(tc.filter)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tc.wantErr)
+			}
+			if len(program) != tc.wantLen {
+				t.Errorf("%d instructions, want %d", len(program), tc.wantLen)
+			}
+		})
+	}
+}
+
+func TestCompiledFilterMatcher(t *testing.T) {
+	matcher, err := newBPFMatcher(layers.LinkTypeEthernet, 65535, compiledUDPFilter)
+	if err != nil {
+		t.Fatalf("newBPFMatcher: %v", err)
+	}
+
+	// Ethernet-Rahmen mit IPv4-Kopf; nur das Protokollfeld unterscheidet sich
+	frame := func(protocol byte) []byte {
+		data := make([]byte, 34)
+		data[12], data[13] = 0x08, 0x00
+		data[14] = 0x45
+		data[23] = protocol
+		return data
+	}
+
+	testCases := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{name: "udp", data: frame(17), want: true},
+		{name: "tcp", data: frame(6), want: false},
+		{name: "truncated", data: frame(17)[:20], want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ci := gopacket.CaptureInfo{CaptureLength: len(tc.data), Length: len(tc.data)}
+			if got := matcher.Matches(ci, tc.data); got != tc.want {
+				t.Errorf("Matches = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
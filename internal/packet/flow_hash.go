@@ -0,0 +1,139 @@
+package packet
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/google/gopacket/layers"
+)
+
+// FNV-1a-Parameter für den Flow-Hash
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// flowHash berechnet einen richtungsunabhängigen Hash über Protokoll, Adressen und Ports
+// eines Rohpakets, ohne es zu dekodieren. Beide Richtungen einer Verbindung ergeben
+// denselben Wert; Pakete ohne IP-Header ergeben 0.
+func flowHash(data []byte, linkType layers.LinkType) uint32 {
+	offset, ethType, ok := networkOffset(data, linkType)
+	if !ok {
+		return 0
+	}
+
+	var proto byte
+	var srcAddr, dstAddr []byte
+	var transport []byte
+
+	switch ethType {
+	case layers.EthernetTypeIPv4:
+		ip := data[offset:]
+		if len(ip) < 20 || ip[0]>>4 != 4 {
+			return 0
+		}
+		headerLen := int(ip[0]&0x0f) * 4
+		if headerLen < 20 || len(ip) < headerLen {
+			return 0
+		}
+		proto = ip[9]
+		srcAddr, dstAddr = ip[12:16], ip[16:20]
+		// Fragmente enthalten keine bzw. nicht immer Ports; nur über Adressen verteilen
+		if binary.BigEndian.Uint16(ip[6:8])&0x3fff == 0 {
+			transport = ip[headerLen:]
+		}
+
+	case layers.EthernetTypeIPv6:
+		ip := data[offset:]
+		if len(ip) < 40 || ip[0]>>4 != 6 {
+			return 0
+		}
+		// Erweiterungsheader werden nicht verfolgt; solche Pakete werden nur über Adressen verteilt
+		proto = ip[6]
+		srcAddr, dstAddr = ip[8:24], ip[24:40]
+		transport = ip[40:]
+
+	default:
+		return 0
+	}
+
+	var srcPort, dstPort []byte
+	switch layers.IPProtocol(proto) {
+	case layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolSCTP:
+		if len(transport) >= 4 {
+			srcPort, dstPort = transport[0:2], transport[2:4]
+		}
+	}
+
+	// Endpunkte sortieren, damit beide Richtungen denselben Hash ergeben
+	if cmp := bytes.Compare(srcAddr, dstAddr); cmp > 0 || (cmp == 0 && bytes.Compare(srcPort, dstPort) > 0) {
+		srcAddr, dstAddr = dstAddr, srcAddr
+		srcPort, dstPort = dstPort, srcPort
+	}
+
+	hash := uint32(fnvOffset32)
+	hash = (hash ^ uint32(proto)) * fnvPrime32
+	for _, part := range [][]byte{srcAddr, srcPort, dstAddr, dstPort} {
+		for _, b := range part {
+			hash = (hash ^ uint32(b)) * fnvPrime32
+		}
+	}
+	return hash
+}
+
+// networkOffset ermittelt Beginn und Typ des Netzwerk-Headers für die gängigen Linktypen.
+// VLAN-Tags werden übersprungen.
+func networkOffset(data []byte, linkType layers.LinkType) (int, layers.EthernetType, bool) {
+	var offset int
+	var ethType layers.EthernetType
+
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		if len(data) < 14 {
+			return 0, 0, false
+		}
+		offset, ethType = 14, layers.EthernetType(binary.BigEndian.Uint16(data[12:14]))
+		for ethType == layers.EthernetTypeDot1Q || ethType == layers.EthernetTypeQinQ {
+			if len(data) < offset+4 {
+				return 0, 0, false
+			}
+			ethType = layers.EthernetType(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+			offset += 4
+		}
+
+	case layers.LinkTypeLinuxSLL:
+		if len(data) < 16 {
+			return 0, 0, false
+		}
+		offset, ethType = 16, layers.EthernetType(binary.BigEndian.Uint16(data[14:16]))
+
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		if len(data) < 5 {
+			return 0, 0, false
+		}
+		offset = 4
+		ethType = ipVersionType(data[offset])
+
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		if len(data) < 1 {
+			return 0, 0, false
+		}
+		ethType = ipVersionType(data[0])
+
+	default:
+		return 0, 0, false
+	}
+
+	return offset, ethType, true
+}
+
+// ipVersionType leitet den Netzwerktyp aus der Versionsnummer des IP-Headers ab
+func ipVersionType(first byte) layers.EthernetType {
+	switch first >> 4 {
+	case 4:
+		return layers.EthernetTypeIPv4
+	case 6:
+		return layers.EthernetTypeIPv6
+	}
+	return 0
+}
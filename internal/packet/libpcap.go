@@ -0,0 +1,132 @@
+//go:build cgo
+
+package packet
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// errReadTimeout wird von libpcap-Handles ohne Pakete innerhalb des Timeouts geliefert
+var errReadTimeout error = pcap.NextErrorTimeoutExpired
+
+// pcapHandle ist ein libpcap-Handle mit einem einzigen Leser
+type pcapHandle struct {
+	*pcap.Handle
+}
+
+// SetBPFFilter übersetzt den Filter für den Linktyp des Handles und meldet Fehler als *FilterError
+func (h pcapHandle) SetBPFFilter(filter string) error {
+	program, err := CompileFilter(filter, h.LinkType(), h.SnapLen())
+	if err != nil {
+		return err
+	}
+
+	instructions := make([]pcap.BPFInstruction, len(program))
+	for i, ins := range program {
+		instructions[i] = pcap.BPFInstruction{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return h.SetBPFInstructionFilter(instructions)
+}
+
+func (h pcapHandle) readers() []packetReader {
+	return []packetReader{h}
+}
+
+// release ist ohne Wirkung; libpcap gibt den Lesepuffer erst beim Schließen frei
+func (h pcapHandle) release() {}
+
+func (h pcapHandle) kernelStats() (CaptureStats, error) {
+	pcapStats, err := h.Stats()
+	if err != nil {
+		return CaptureStats{}, err
+	}
+	return CaptureStats{
+		KernelDropped:    uint64(pcapStats.PacketsDropped),
+		InterfaceDropped: uint64(pcapStats.PacketsIfDropped),
+	}, nil
+}
+
+// compileLibpcapFilter übersetzt einen Ausdruck mit dem BPF-Compiler von libpcap
+func compileLibpcapFilter(filter string, linkType layers.LinkType, snapLen int) ([]bpf.RawInstruction, error) {
+	instructions, err := pcap.CompileBPFFilter(linkType, snapLen, filter)
+	if err != nil {
+		return nil, newFilterError(filter, linkType, err)
+	}
+
+	program := make([]bpf.RawInstruction, len(instructions))
+	for i, ins := range instructions {
+		program[i] = bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return program, nil
+}
+
+// newLibpcapMatcher übersetzt einen Ausdruck zum Prüfen einzelner Pakete mit libpcap
+func newLibpcapMatcher(linkType layers.LinkType, snapLen int, filter string) (bpfMatcher, error) {
+	matcher, err := pcap.NewBPF(linkType, snapLen, filter)
+	if err != nil {
+		return nil, newFilterError(filter, linkType, err)
+	}
+	return matcher, nil
+}
+
+// openLibpcapFile öffnet eine PCAP-Datei mit libpcap
+func openLibpcapFile(path string) (captureHandle, error) {
+	handle, err := pcap.OpenOffline(path)
+	if err != nil {
+		return nil, err
+	}
+	return pcapHandle{handle}, nil
+}
+
+// openLibpcapLive öffnet eine Schnittstelle mit libpcap. Bridges werden im Immediate-Modus
+// geöffnet, damit Pakete ohne Pufferverzögerung ankommen.
+func openLibpcapLive(interfaceName string, cfg *config.CaptureConfig, isBridge bool) (captureHandle, error) {
+	// Konfigurieren der pcap-Bibliothek für Live-Capture
+	inactive, err := pcap.NewInactiveHandle(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Erstellen des inaktiven Handles: %w", err)
+	}
+	defer inactive.CleanUp()
+
+	// SnapLen setzen (maximale Paketgröße)
+	if err := inactive.SetSnapLen(cfg.SnapLen); err != nil {
+		return nil, fmt.Errorf("Fehler beim Setzen von SnapLen: %w", err)
+	}
+
+	// Promisc-Modus setzen
+	if err := inactive.SetPromisc(cfg.PromiscMode); err != nil {
+		return nil, fmt.Errorf("Fehler beim Setzen des Promisc-Modus: %w", err)
+	}
+
+	// Timeout setzen (BlockForever = -1)
+	if err := inactive.SetTimeout(pcap.BlockForever); err != nil {
+		return nil, fmt.Errorf("Fehler beim Setzen des Timeouts: %w", err)
+	}
+
+	// Buffer-Größe setzen
+	if err := inactive.SetBufferSize(cfg.BufferSize); err != nil {
+		return nil, fmt.Errorf("Fehler beim Setzen der Buffer-Größe: %w", err)
+	}
+
+	// Für Bridge-Interfaces: Immediate-Modus aktivieren (falls verfügbar)
+	if isBridge {
+		// Immediate-Modus ist plattformspezifisch, daher mit Fehlerbehandlung
+		if err := inactive.SetImmediateMode(true); err != nil {
+			// Fehler nur loggen, nicht abbrechen
+			fmt.Printf("Warnung: Immediate-Modus konnte nicht aktiviert werden: %v\n", err)
+		}
+	}
+
+	// Handle aktivieren
+	handle, err := inactive.Activate()
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Aktivieren des Handles: %w", err)
+	}
+	return pcapHandle{handle}, nil
+}
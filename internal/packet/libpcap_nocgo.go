@@ -0,0 +1,41 @@
+//go:build !cgo
+
+package packet
+
+import (
+	"errors"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// Ohne cgo steht libpcap nicht zur Verfügung. Live-Captures laufen dann nur über das
+// AF_PACKET-Backend, Filter müssen vorkompiliert angegeben werden (tcpdump -ddd).
+
+// errReadTimeout wird ohne libpcap-Handles nie geliefert
+var errReadTimeout = errors.New("Zeitüberschreitung beim Lesen")
+
+var errLibpcapUnavailable = errors.New("libpcap ist nicht verfügbar (ohne cgo gebaut)")
+
+// Meldung für Filterausdrücke, die ohne libpcap nicht übersetzt werden können
+const libpcapFilterMessage = "Filterausdrücke erfordern libpcap (ohne cgo gebaut); " +
+	"vorkompiliertes BPF-Programm angeben (tcpdump -ddd)"
+
+func compileLibpcapFilter(filter string, linkType layers.LinkType, snapLen int) ([]bpf.RawInstruction, error) {
+	return nil, &FilterError{Filter: filter, LinkType: linkType.String(), Message: libpcapFilterMessage, Offset: -1}
+}
+
+func newLibpcapMatcher(linkType layers.LinkType, snapLen int, filter string) (bpfMatcher, error) {
+	return nil, &FilterError{Filter: filter, LinkType: linkType.String(), Message: libpcapFilterMessage, Offset: -1}
+}
+
+func openLibpcapFile(path string) (captureHandle, error) {
+	return nil, errLibpcapUnavailable
+}
+
+// openLibpcapLive verweist auf das AF_PACKET-Backend
+func openLibpcapLive(interfaceName string, cfg *config.CaptureConfig, isBridge bool) (captureHandle, error) {
+	return nil, errors.New("libpcap ist nicht verfügbar (ohne cgo gebaut); capture.backend \"afpacket\" verwenden")
+}
@@ -0,0 +1,137 @@
+package packet
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/displayfilter"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Höchstzahl der Pakete, die eine Abfrage zurückgibt
+const MaxQueryLimit = 10000
+
+// PacketQuery beschreibt eine Abfrage analysierter Pakete einer Datei
+type PacketQuery struct {
+	Filter *displayfilter.Filter // nil = alle Pakete
+	Offset int                   // Anzahl passender Pakete, die übersprungen werden
+	Limit  int                   // Höchstzahl zurückgegebener Pakete, höchstens MaxQueryLimit
+}
+
+// QueriedPacket ist ein analysiertes Paket mit seiner Position in der Datei
+type QueriedPacket struct {
+	Number   int      `json:"number"`             // Position in der Datei, beginnend bei 1
+	Findings []string `json:"findings,omitempty"` // Befunde der Analyse zu diesem Paket
+	models.PacketInfo
+}
+
+// PacketQueryResult ist das Ergebnis einer Paketabfrage
+type PacketQueryResult struct {
+	Filter  string          `json:"filter,omitempty"`
+	Scanned int             `json:"scanned"` // gelesene Pakete
+	Matched int             `json:"matched"` // passende Pakete, auch jenseits von Offset und Limit
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Packets []QueriedPacket `json:"packets"`
+}
+
+// QueryPackets liest eine PCAP- oder PCAPNG-Datei vollständig, analysiert jedes Paket und gibt
+// die zum Anzeigefilter passenden Pakete im Bereich von Offset und Limit zurück. Wie beim
+// Export sieht die Analyse alle Pakete, sodass Befunde unabhängig vom Filter sind.
+func (c *PcapCapturer) QueryPackets(ctx context.Context, in io.Reader, query PacketQuery) (*PacketQueryResult, error) {
+	if query.Limit <= 0 || query.Limit > MaxQueryLimit {
+		query.Limit = MaxQueryLimit
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	result := &PacketQueryResult{
+		Filter:  query.Filter.String(),
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+		Packets: []QueriedPacket{},
+	}
+
+	source, err := openExportSource(in)
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Lesen der Datei: %w", err)
+	}
+
+	decoder := newPacketDecoder(c, layers.LinkTypeEthernet)
+	annotator := newPacketAnnotator()
+
+	for {
+		p, err := source.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Fehler beim Lesen von Paket %d: %w", result.Scanned+1, err)
+		}
+		if result.Scanned%1000 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		result.Scanned++
+
+		intf, _ := source.Interface(p.InterfaceIndex)
+		decoder.linkType = intf.LinkType
+		info, decodeFailed := decoder.decode(p.Data, gopacket.CaptureInfo{
+			Timestamp:     p.Timestamp,
+			CaptureLength: p.CaptureLength,
+			Length:        p.Length,
+		})
+		findings := annotator.annotate(info, decodeFailed)
+
+		if query.Filter.Match(info) {
+			result.Matched++
+			if result.Matched > query.Offset && len(result.Packets) < query.Limit {
+				queried := QueriedPacket{Number: result.Scanned, PacketInfo: clonePacketInfo(info)}
+				for _, finding := range findings {
+					queried.Findings = append(queried.Findings, finding.Description)
+				}
+				result.Packets = append(result.Packets, queried)
+			}
+		}
+		ReleasePacketInfo(info)
+	}
+
+	return result, nil
+}
+
+// clonePacketInfo kopiert ein analysiertes Paket samt der wiederverwendeten Puffer, damit es
+// nach ReleasePacketInfo erhalten bleibt. Rohdaten werden nicht übernommen.
+func clonePacketInfo(info *models.PacketInfo) models.PacketInfo {
+	clone := *info
+	clone.RawData = nil
+	clone.SourceIP = copyIP(info.SourceIP)
+	clone.DestinationIP = copyIP(info.DestinationIP)
+	clone.GatewayIP = copyIP(info.GatewayIP)
+	clone.VLANs = append([]models.VLANTag(nil), info.VLANs...)
+	clone.MPLSLabels = append([]models.MPLSLabel(nil), info.MPLSLabels...)
+	clone.Tunnels = nil
+	for _, tunnel := range info.Tunnels {
+		tunnel.OuterSourceIP = copyIP(tunnel.OuterSourceIP)
+		tunnel.OuterDestinationIP = copyIP(tunnel.OuterDestinationIP)
+		clone.Tunnels = append(clone.Tunnels, tunnel)
+	}
+
+	if dns := info.DNSInfo; dns != nil {
+		clone.DNSInfo = &models.DNSInfo{
+			Queries:  append([]models.DNSQuery(nil), dns.Queries...),
+			Answers:  append([]models.DNSAnswer(nil), dns.Answers...),
+			IsQuery:  dns.IsQuery,
+			IsAnswer: dns.IsAnswer,
+		}
+	}
+	if arp := info.ARPInfo; arp != nil {
+		arpCopy := *arp
+		arpCopy.SenderIP = copyIP(arp.SenderIP)
+		arpCopy.TargetIP = copyIP(arp.TargetIP)
+		clone.ARPInfo = &arpCopy
+	}
+	return clone
+}
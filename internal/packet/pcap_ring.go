@@ -0,0 +1,518 @@
+package packet
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// Standardwerte für den PCAP-Ringpuffer
+const (
+	defaultRingFileSize  = 16 * 1024 * 1024  // 16 MB pro Datei
+	defaultRingFileAge   = 5 * time.Minute   // spätestens alle 5 Minuten rotieren
+	defaultRingTotalSize = 512 * 1024 * 1024 // 512 MB Disk-Quota
+	ringFlushInterval    = time.Second
+
+	ringFilePrefix = "ring-"
+	ringFileSuffix = ".pcap"
+)
+
+// PacketRecorder nimmt Rohpakete für die Aufzeichnung entgegen
+type PacketRecorder interface {
+	WritePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) error
+}
+
+// ringFile beschreibt eine abgeschlossene oder aktive Datei im Ringpuffer
+type ringFile struct {
+	path     string
+	start    time.Time
+	end      time.Time
+	size     int64
+	linkType layers.LinkType
+}
+
+// PcapRing schreibt Rohpakete in rotierende PCAP-Dateien mit begrenztem Speicherplatz
+type PcapRing struct {
+	dir          string
+	maxFileSize  int64
+	maxFileAge   time.Duration
+	maxTotalSize int64
+	snapLen      uint32
+
+	mutex     sync.Mutex
+	files     []*ringFile // abgeschlossene Dateien, älteste zuerst
+	current   *ringFile
+	file      *os.File
+	buffered  *bufio.Writer
+	writer    *pcapgo.Writer
+	lastFlush time.Time
+}
+
+// PcapRingSet verwaltet einen eigenen Ringpuffer je Schnittstelle. Getrennte Ringe verhindern,
+// dass Captures mit unterschiedlichen Linktypen sich gegenseitig zur Rotation zwingen, und geben
+// jeder Schnittstelle ihr eigenes Aufbewahrungsfenster. Die Disk-Quota gilt je Schnittstelle.
+type PcapRingSet struct {
+	dir     string
+	cfg     config.RingBufferConfig
+	snapLen int
+
+	mutex sync.Mutex
+	rings map[string]*PcapRing // nach ringDirName der Schnittstelle
+}
+
+// NewPcapRingSet erstellt die Ringpuffer-Verwaltung und übernimmt die Ringe einer früheren Laufzeit
+func NewPcapRingSet(cfg *config.RingBufferConfig, snapLen int) (*PcapRingSet, error) {
+	set := &PcapRingSet{
+		dir:     cfg.Dir,
+		cfg:     *cfg,
+		snapLen: snapLen,
+		rings:   make(map[string]*PcapRing),
+	}
+	if set.dir == "" {
+		set.dir = filepath.Join(os.TempDir(), "ki-network-analyzer", "ring")
+	}
+
+	if err := os.MkdirAll(set.dir, 0755); err != nil {
+		return nil, fmt.Errorf("Fehler beim Erstellen des Ringpuffer-Verzeichnisses: %w", err)
+	}
+
+	entries, err := os.ReadDir(set.dir)
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Lesen des Ringpuffer-Verzeichnisses: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			// Dateien des früheren gemeinsamen Ringpuffers gehören keiner Schnittstelle
+			// an und würden sonst dauerhaft Speicherplatz belegen
+			if strings.HasPrefix(name, ringFilePrefix) && strings.HasSuffix(name, ringFileSuffix) {
+				os.Remove(filepath.Join(set.dir, name))
+			}
+			continue
+		}
+		ring, err := set.newRing(name)
+		if err != nil {
+			return nil, err
+		}
+		set.rings[name] = ring
+	}
+
+	return set, nil
+}
+
+// ringDirName bildet den Verzeichnisnamen einer Schnittstelle. Zeichen außerhalb von
+// [A-Za-z0-9_-] werden ersetzt, damit der Name kein Pfad sein kann.
+func ringDirName(iface string) string {
+	if iface == "" {
+		return "default"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, iface)
+}
+
+// newRing erstellt den Ringpuffer im Unterverzeichnis name
+func (s *PcapRingSet) newRing(name string) (*PcapRing, error) {
+	cfg := s.cfg
+	cfg.Dir = filepath.Join(s.dir, name)
+	return NewPcapRing(&cfg, s.snapLen)
+}
+
+// Ring gibt den Ringpuffer einer Schnittstelle zurück und legt ihn bei Bedarf an
+func (s *PcapRingSet) Ring(iface string) (*PcapRing, error) {
+	name := ringDirName(iface)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if ring, ok := s.rings[name]; ok {
+		return ring, nil
+	}
+	ring, err := s.newRing(name)
+	if err != nil {
+		return nil, err
+	}
+	s.rings[name] = ring
+	return ring, nil
+}
+
+// Interfaces gibt die Namen der vorhandenen Ringe sortiert zurück
+func (s *PcapRingSet) Interfaces() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	names := make([]string, 0, len(s.rings))
+	for name := range s.rings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Extract schreibt einen Zeitausschnitt aus dem Ring einer Schnittstelle nach w. Ohne Angabe
+// einer Schnittstelle wird der einzige vorhandene Ring verwendet.
+func (s *PcapRingSet) Extract(iface string, from, to time.Time, filter string, w io.Writer) (int, error) {
+	s.mutex.Lock()
+	var ring *PcapRing
+	if iface != "" {
+		ring = s.rings[ringDirName(iface)]
+	} else if len(s.rings) == 1 {
+		for _, only := range s.rings {
+			ring = only
+		}
+	} else if len(s.rings) > 1 {
+		s.mutex.Unlock()
+		return 0, fmt.Errorf("Mehrere Ringpuffer vorhanden, Schnittstelle angeben: %s", strings.Join(s.Interfaces(), ", "))
+	}
+	s.mutex.Unlock()
+
+	if ring == nil {
+		// Für Schnittstellen ohne aufgezeichnete Pakete eine leere PCAP-Datei liefern
+		ring = &PcapRing{snapLen: uint32(s.snapLen)}
+		if ring.snapLen == 0 {
+			ring.snapLen = 65535
+		}
+	}
+	return ring.Extract(from, to, filter, w)
+}
+
+// Close schließt die aktuellen Dateien aller Ringe
+func (s *PcapRingSet) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var firstErr error
+	for _, ring := range s.rings {
+		if err := ring.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewPcapRing erstellt einen Ringpuffer und übernimmt vorhandene Dateien aus dem Verzeichnis
+func NewPcapRing(cfg *config.RingBufferConfig, snapLen int) (*PcapRing, error) {
+	ring := &PcapRing{
+		dir:          cfg.Dir,
+		maxFileSize:  cfg.MaxFileSize,
+		maxFileAge:   time.Duration(cfg.MaxFileAge) * time.Second,
+		maxTotalSize: cfg.MaxTotalSize,
+		snapLen:      uint32(snapLen),
+	}
+
+	// Standardwerte setzen
+	if ring.dir == "" {
+		ring.dir = filepath.Join(os.TempDir(), "ki-network-analyzer", "ring")
+	}
+	if ring.maxFileSize <= 0 {
+		ring.maxFileSize = defaultRingFileSize
+	}
+	if ring.maxFileAge <= 0 {
+		ring.maxFileAge = defaultRingFileAge
+	}
+	if ring.maxTotalSize <= 0 {
+		ring.maxTotalSize = defaultRingTotalSize
+	}
+	if ring.snapLen == 0 {
+		ring.snapLen = 65535
+	}
+
+	if err := os.MkdirAll(ring.dir, 0755); err != nil {
+		return nil, fmt.Errorf("Fehler beim Erstellen des Ringpuffer-Verzeichnisses: %w", err)
+	}
+
+	if err := ring.loadExistingFiles(); err != nil {
+		return nil, err
+	}
+	ring.enforceQuota()
+
+	return ring, nil
+}
+
+// loadExistingFiles übernimmt Dateien einer früheren Laufzeit in den Ringpuffer
+func (r *PcapRing) loadExistingFiles() error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Lesen des Ringpuffer-Verzeichnisses: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, ringFilePrefix) || !strings.HasSuffix(name, ringFileSuffix) {
+			continue
+		}
+
+		nanos, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, ringFilePrefix), ringFileSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		r.files = append(r.files, &ringFile{
+			path:  filepath.Join(r.dir, name),
+			start: time.Unix(0, nanos),
+			end:   fileInfo.ModTime(),
+			size:  fileInfo.Size(),
+		})
+	}
+
+	sort.Slice(r.files, func(i, j int) bool {
+		return r.files[i].start.Before(r.files[j].start)
+	})
+
+	return nil
+}
+
+// WritePacket schreibt ein Rohpaket in die aktuelle Datei und rotiert bei Bedarf
+func (r *PcapRing) WritePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Rotation bei Größen- oder Zeitüberschreitung sowie bei geändertem Linktyp
+	if r.current != nil {
+		if r.current.size >= r.maxFileSize ||
+			ci.Timestamp.Sub(r.current.start) >= r.maxFileAge ||
+			r.current.linkType != linkType {
+			if err := r.rotateLocked(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if r.current == nil {
+		if err := r.openFileLocked(ci.Timestamp, linkType); err != nil {
+			return err
+		}
+	}
+
+	if err := r.writer.WritePacket(ci, data); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben in den Ringpuffer: %w", err)
+	}
+
+	// 16 Byte Record-Header plus Nutzdaten
+	r.current.size += int64(16 + len(data))
+	r.current.end = ci.Timestamp
+
+	// Regelmäßig auf die Platte schreiben, damit Abfragen aktuelle Daten sehen
+	if time.Since(r.lastFlush) >= ringFlushInterval {
+		r.buffered.Flush()
+		r.lastFlush = time.Now()
+	}
+
+	return nil
+}
+
+// openFileLocked legt eine neue Ringpuffer-Datei an
+func (r *PcapRing) openFileLocked(start time.Time, linkType layers.LinkType) error {
+	path := filepath.Join(r.dir, fmt.Sprintf("%s%d%s", ringFilePrefix, start.UnixNano(), ringFileSuffix))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Anlegen der Ringpuffer-Datei: %w", err)
+	}
+
+	r.file = file
+	r.buffered = bufio.NewWriterSize(file, 64*1024)
+	r.writer = pcapgo.NewWriterNanos(r.buffered)
+	if err := r.writer.WriteFileHeader(r.snapLen, linkType); err != nil {
+		file.Close()
+		return fmt.Errorf("Fehler beim Schreiben des PCAP-Headers: %w", err)
+	}
+
+	r.current = &ringFile{
+		path:     path,
+		start:    start,
+		end:      start,
+		size:     24, // PCAP-Dateiheader
+		linkType: linkType,
+	}
+	r.lastFlush = time.Now()
+
+	return nil
+}
+
+// rotateLocked schließt die aktuelle Datei und erzwingt die Disk-Quota
+func (r *PcapRing) rotateLocked() error {
+	if r.current == nil {
+		return nil
+	}
+
+	r.buffered.Flush()
+	err := r.file.Close()
+
+	r.files = append(r.files, r.current)
+	r.current = nil
+	r.file = nil
+	r.buffered = nil
+	r.writer = nil
+
+	r.enforceQuota()
+
+	if err != nil {
+		return fmt.Errorf("Fehler beim Schließen der Ringpuffer-Datei: %w", err)
+	}
+	return nil
+}
+
+// enforceQuota löscht die ältesten Dateien, bis die Disk-Quota eingehalten wird
+func (r *PcapRing) enforceQuota() {
+	var total int64
+	for _, f := range r.files {
+		total += f.size
+	}
+	if r.current != nil {
+		total += r.current.size
+	}
+
+	for total > r.maxTotalSize && len(r.files) > 0 {
+		oldest := r.files[0]
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warnung: Ringpuffer-Datei %s konnte nicht gelöscht werden: %v", oldest.path, err)
+		}
+		total -= oldest.size
+		r.files = r.files[1:]
+	}
+}
+
+// Extract schreibt alle Pakete im Zeitraum [from, to], die dem optionalen BPF-Filter
+// entsprechen, als zusammenhängende PCAP-Datei nach w
+func (r *PcapRing) Extract(from, to time.Time, filter string, w io.Writer) (int, error) {
+	// Aktuelle Datei flushen und nur bis zur aktuellen Größe lesen,
+	// damit parallel geschriebene Pakete die Abfrage nicht stören
+	type fileSlice struct {
+		path  string
+		limit int64
+	}
+	var slices []fileSlice
+
+	r.mutex.Lock()
+	if r.current != nil {
+		r.buffered.Flush()
+		r.lastFlush = time.Now()
+	}
+	for _, f := range append(append([]*ringFile{}, r.files...), r.current) {
+		if f == nil {
+			continue
+		}
+		// Dateien außerhalb des Zeitraums überspringen
+		if f.end.Before(from) || (!to.IsZero() && f.start.After(to)) {
+			continue
+		}
+		limit := int64(-1)
+		if f == r.current {
+			limit = f.size
+		}
+		slices = append(slices, fileSlice{path: f.path, limit: limit})
+	}
+	r.mutex.Unlock()
+
+	var out *pcapgo.Writer
+	var outLinkType layers.LinkType
+	var matcher bpfMatcher
+	written := 0
+
+	for _, slice := range slices {
+		file, err := os.Open(slice.path)
+		if err != nil {
+			// Die Datei kann inzwischen durch die Quota gelöscht worden sein
+			continue
+		}
+
+		var source io.Reader = file
+		if slice.limit >= 0 {
+			source = io.LimitReader(file, slice.limit)
+		}
+
+		reader, err := pcapgo.NewReader(bufio.NewReader(source))
+		if err != nil {
+			file.Close()
+			continue
+		}
+
+		// Ausgabedatei mit dem Linktyp der ersten Datei anlegen; der Filter wird vorher
+		// kompiliert, damit bei Fehlern noch nichts geschrieben wurde
+		if out == nil {
+			outLinkType = reader.LinkType()
+			if filter != "" {
+				matcher, err = newBPFMatcher(outLinkType, int(r.snapLen), filter)
+				if err != nil {
+					file.Close()
+					return written, err
+				}
+			}
+			out = pcapgo.NewWriterNanos(w)
+			if err := out.WriteFileHeader(r.snapLen, outLinkType); err != nil {
+				file.Close()
+				return written, fmt.Errorf("Fehler beim Schreiben des PCAP-Headers: %w", err)
+			}
+		} else if reader.LinkType() != outLinkType {
+			log.Printf("Ringpuffer-Datei %s mit abweichendem Linktyp %v übersprungen", slice.path, reader.LinkType())
+			file.Close()
+			continue
+		}
+
+		for {
+			data, ci, err := reader.ReadPacketData()
+			if err != nil {
+				break
+			}
+			if ci.Timestamp.Before(from) || (!to.IsZero() && ci.Timestamp.After(to)) {
+				continue
+			}
+			if matcher != nil && !matcher.Matches(ci, data) {
+				continue
+			}
+			if err := out.WritePacket(ci, data); err != nil {
+				file.Close()
+				return written, fmt.Errorf("Fehler beim Schreiben des Pakets: %w", err)
+			}
+			written++
+		}
+		file.Close()
+	}
+
+	// Auch ohne Treffer eine gültige, leere PCAP-Datei liefern
+	if out == nil {
+		if filter != "" {
+			if _, err := newBPFMatcher(layers.LinkTypeEthernet, int(r.snapLen), filter); err != nil {
+				return 0, err
+			}
+		}
+		out = pcapgo.NewWriterNanos(w)
+		if err := out.WriteFileHeader(r.snapLen, layers.LinkTypeEthernet); err != nil {
+			return 0, fmt.Errorf("Fehler beim Schreiben des PCAP-Headers: %w", err)
+		}
+	}
+
+	return written, nil
+}
+
+// Close schließt die aktuelle Datei des Ringpuffers
+func (r *PcapRing) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.rotateLocked()
+}
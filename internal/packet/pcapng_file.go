@@ -0,0 +1,173 @@
+package packet
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/pcapng"
+)
+
+// isPcapngFile prüft anhand der Kennung am Dateianfang, ob eine Datei im PCAPNG-Format vorliegt
+func isPcapngFile(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, len(pcapng.Magic))
+	if _, err := io.ReadFull(file, magic); err != nil {
+		// Zu kurze Dateien meldet libpcap mit einer aussagekräftigeren Fehlermeldung
+		return false, nil
+	}
+	return bytes.Equal(magic, pcapng.Magic), nil
+}
+
+// pcapngFileHandle liest eine PCAPNG-Datei mit eigenem Reader. Anders als libpcap werden
+// Schnittstellen mit unterschiedlichen Linktypen unterstützt; jedes Paket wird mit dem
+// Linktyp seiner Schnittstelle dekodiert und aufgezeichnet.
+type pcapngFileHandle struct {
+	file    *os.File
+	counter *countingReader
+	reader  *pcapng.Reader
+	snapLen int
+
+	// Linktyp des zuletzt gelesenen Pakets; nur vom Leser verwendet
+	lastLinkType layers.LinkType
+
+	mutex   sync.Mutex // schützt filter und filters gegen SetBPFFilter während des Lesens
+	filter  string
+	filters map[layers.LinkType]bpfMatcher
+}
+
+// openPcapngFile öffnet eine PCAPNG-Datei und liest ihre Schnittstellenbeschreibungen
+func openPcapngFile(path string, snapLen int) (*pcapngFileHandle, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	counter := &countingReader{r: file}
+	reader, err := pcapng.NewReader(counter)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &pcapngFileHandle{
+		file:         file,
+		counter:      counter,
+		reader:       reader,
+		snapLen:      snapLen,
+		lastLinkType: reader.LinkType(),
+	}, nil
+}
+
+// LinkType gibt den Linktyp der ersten Schnittstelle zurück
+func (h *pcapngFileHandle) LinkType() layers.LinkType {
+	return h.reader.LinkType()
+}
+
+// SetBPFFilter setzt einen Filter, der je Linktyp übersetzt wird. Der Ausdruck wird sofort
+// für die erste Schnittstelle geprüft; weitere Linktypen werden beim ersten Paket übersetzt.
+func (h *pcapngFileHandle) SetBPFFilter(filter string) error {
+	filters := make(map[layers.LinkType]bpfMatcher)
+	if filter != "" {
+		matcher, err := newBPFMatcher(h.reader.LinkType(), h.snapLen, filter)
+		if err != nil {
+			return err
+		}
+		filters[h.reader.LinkType()] = matcher
+	}
+
+	h.mutex.Lock()
+	h.filter = filter
+	h.filters = filters
+	h.mutex.Unlock()
+	return nil
+}
+
+// matches prüft ein Paket gegen den Filter seines Linktyps
+func (h *pcapngFileHandle) matches(linkType layers.LinkType, ci gopacket.CaptureInfo, data []byte) (bool, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.filter == "" {
+		return true, nil
+	}
+	matcher, ok := h.filters[linkType]
+	if !ok {
+		var err error
+		if matcher, err = newBPFMatcher(linkType, h.snapLen, h.filter); err != nil {
+			return false, fmt.Errorf("BPF-Filter für Linktyp %v nicht anwendbar: %w", linkType, err)
+		}
+		h.filters[linkType] = matcher
+	}
+	return matcher.Matches(ci, data), nil
+}
+
+func (h *pcapngFileHandle) readers() []packetReader {
+	return []packetReader{h}
+}
+
+// kernelStats ist für Dateien ohne Bedeutung
+func (h *pcapngFileHandle) kernelStats() (CaptureStats, error) {
+	return CaptureStats{}, nil
+}
+
+// Close schließt die Datei; ein laufender Leser erhält danach einen Lesefehler
+func (h *pcapngFileHandle) Close() {
+	h.file.Close()
+}
+
+// ZeroCopyReadPacketData liefert das nächste Paket, das den Filter passiert
+func (h *pcapngFileHandle) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		data, ci, err := h.reader.ZeroCopyReadPacketData()
+		if err != nil {
+			return nil, ci, err
+		}
+
+		intf, _ := h.reader.Interface(ci.InterfaceIndex)
+		h.lastLinkType = intf.LinkType
+
+		ok, err := h.matches(intf.LinkType, ci, data)
+		if err != nil {
+			return nil, ci, err
+		}
+		if ok {
+			return data, ci, nil
+		}
+	}
+}
+
+// packetLinkType gibt den Linktyp des zuletzt gelesenen Pakets zurück
+func (h *pcapngFileHandle) packetLinkType() layers.LinkType {
+	return h.lastLinkType
+}
+
+// release ist ohne Wirkung; der Lesepuffer gehört dem Reader
+func (h *pcapngFileHandle) release() {}
+
+// fileOffset gibt die Anzahl der aus der Datei gelesenen Bytes zurück; der Reader liest
+// gepuffert voraus
+func (h *pcapngFileHandle) fileOffset() int64 {
+	return atomic.LoadInt64(&h.counter.n)
+}
+
+// countingReader zählt die gelesenen Bytes
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
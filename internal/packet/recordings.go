@@ -0,0 +1,265 @@
+package packet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+)
+
+// Standardwerte für ausgelöste Aufzeichnungen
+const (
+	defaultRecordingTotalSize = 256 * 1024 * 1024 // 256 MB Disk-Quota
+
+	recordingFilePrefix = "trigger-"
+	recordingFileSuffix = ".pcap"
+	recordingMetaSuffix = ".json"
+)
+
+// Status einer ausgelösten Aufzeichnung
+const (
+	RecordingStatusRecording = "recording"
+	RecordingStatusCompleted = "completed"
+	RecordingStatusFailed    = "failed"
+)
+
+// TriggerRecording beschreibt eine durch eine Trigger-Regel ausgelöste PCAP-Aufzeichnung
+type TriggerRecording struct {
+	ID          string    `json:"id"`
+	Rule        string    `json:"rule"`
+	Reason      string    `json:"reason"`
+	Severity    string    `json:"severity"`
+	Capture     string    `json:"capture,omitempty"`
+	Interface   string    `json:"interface,omitempty"`
+	TriggeredAt time.Time `json:"triggered_at"`
+	From        time.Time `json:"from"`  // Beginn des Vorlaufs
+	Until       time.Time `json:"until"` // Ende des Nachlaufs
+	Status      string    `json:"status"`
+	Packets     int       `json:"packets"`
+	Size        int64     `json:"size"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// RecordingStore verwaltet die Dateien der ausgelösten Aufzeichnungen eines Knotens.
+// Zu jeder PCAP-Datei wird eine JSON-Datei mit den Metadaten abgelegt.
+type RecordingStore struct {
+	dir          string
+	maxTotalSize int64
+
+	mutex      sync.Mutex
+	recordings []*TriggerRecording // älteste zuerst
+}
+
+// NewRecordingStore legt das Verzeichnis an und übernimmt Aufzeichnungen einer früheren Laufzeit
+func NewRecordingStore(cfg *config.TriggerConfig) (*RecordingStore, error) {
+	store := &RecordingStore{
+		dir:          cfg.Dir,
+		maxTotalSize: cfg.MaxTotalSize,
+	}
+	if store.dir == "" {
+		store.dir = filepath.Join(os.TempDir(), "ki-network-analyzer", "recordings")
+	}
+	if store.maxTotalSize <= 0 {
+		store.maxTotalSize = defaultRecordingTotalSize
+	}
+
+	if err := os.MkdirAll(store.dir, 0755); err != nil {
+		return nil, fmt.Errorf("Fehler beim Erstellen des Aufzeichnungsverzeichnisses: %w", err)
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	store.mutex.Lock()
+	store.enforceQuotaLocked()
+	store.mutex.Unlock()
+
+	return store, nil
+}
+
+// load liest die Metadaten vorhandener Aufzeichnungen
+func (s *RecordingStore) load() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("Fehler beim Lesen des Aufzeichnungsverzeichnisses: %w", err)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, recordingFilePrefix) || !strings.HasSuffix(name, recordingMetaSuffix) {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			continue
+		}
+		var rec TriggerRecording
+		if err := json.Unmarshal(data, &rec); err != nil || rec.ID != strings.TrimSuffix(name, recordingMetaSuffix) {
+			log.Printf("Warnung: Metadaten der Aufzeichnung %s sind ungültig", name)
+			continue
+		}
+
+		fileInfo, err := os.Stat(s.pcapPath(rec.ID))
+		if err != nil {
+			continue
+		}
+		// Beim Beenden unterbrochene Aufzeichnungen sind bis zum letzten Paket lesbar
+		if rec.Status == RecordingStatusRecording {
+			rec.Status = RecordingStatusCompleted
+		}
+		rec.Size = fileInfo.Size()
+		s.recordings = append(s.recordings, &rec)
+	}
+
+	sort.Slice(s.recordings, func(i, j int) bool {
+		return s.recordings[i].TriggeredAt.Before(s.recordings[j].TriggeredAt)
+	})
+	return nil
+}
+
+// pcapPath gibt den Pfad der PCAP-Datei einer Aufzeichnung zurück
+func (s *RecordingStore) pcapPath(id string) string {
+	return filepath.Join(s.dir, id+recordingFileSuffix)
+}
+
+// metaPath gibt den Pfad der Metadaten einer Aufzeichnung zurück
+func (s *RecordingStore) metaPath(id string) string {
+	return filepath.Join(s.dir, id+recordingMetaSuffix)
+}
+
+// create legt eine neue Aufzeichnung an und öffnet ihre PCAP-Datei
+func (s *RecordingStore) create(rec *TriggerRecording) (*os.File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Eindeutige ID aus dem Auslösezeitpunkt
+	nanos := rec.TriggeredAt.UnixNano()
+	for {
+		rec.ID = fmt.Sprintf("%s%d", recordingFilePrefix, nanos)
+		if s.findLocked(rec.ID) == nil {
+			break
+		}
+		nanos++
+	}
+
+	file, err := os.Create(s.pcapPath(rec.ID))
+	if err != nil {
+		return nil, fmt.Errorf("Fehler beim Anlegen der Aufzeichnung: %w", err)
+	}
+
+	// Der Store hält eine eigene Kopie, der Recorder aktualisiert seine über finish
+	rec.Status = RecordingStatusRecording
+	stored := *rec
+	s.recordings = append(s.recordings, &stored)
+	s.saveMetaLocked(rec)
+	return file, nil
+}
+
+// finish speichert den Endstand einer Aufzeichnung und erzwingt die Disk-Quota
+func (s *RecordingStore) finish(rec *TriggerRecording) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if stored := s.findLocked(rec.ID); stored != nil {
+		*stored = *rec
+	}
+	s.saveMetaLocked(rec)
+	s.enforceQuotaLocked()
+}
+
+// saveMetaLocked schreibt die Metadaten einer Aufzeichnung. Der Aufrufer muss mutex halten.
+func (s *RecordingStore) saveMetaLocked(rec *TriggerRecording) {
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(s.metaPath(rec.ID), data, 0644)
+	}
+	if err != nil {
+		log.Printf("Warnung: Metadaten der Aufzeichnung %s konnten nicht gespeichert werden: %v", rec.ID, err)
+	}
+}
+
+// enforceQuotaLocked löscht die ältesten abgeschlossenen Aufzeichnungen, bis die Disk-Quota
+// eingehalten wird. Der Aufrufer muss mutex halten.
+func (s *RecordingStore) enforceQuotaLocked() {
+	var total int64
+	for _, rec := range s.recordings {
+		total += rec.Size
+	}
+
+	kept := s.recordings[:0]
+	for _, rec := range s.recordings {
+		if total > s.maxTotalSize && rec.Status != RecordingStatusRecording {
+			for _, path := range []string{s.pcapPath(rec.ID), s.metaPath(rec.ID)} {
+				if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+					log.Printf("Warnung: Aufzeichnung %s konnte nicht gelöscht werden: %v", path, err)
+				}
+			}
+			total -= rec.Size
+			continue
+		}
+		kept = append(kept, rec)
+	}
+	s.recordings = kept
+}
+
+// findLocked sucht eine Aufzeichnung nach ID. Der Aufrufer muss mutex halten.
+func (s *RecordingStore) findLocked(id string) *TriggerRecording {
+	for _, rec := range s.recordings {
+		if rec.ID == id {
+			return rec
+		}
+	}
+	return nil
+}
+
+// List gibt alle Aufzeichnungen zurück, neueste zuerst
+func (s *RecordingStore) List() []TriggerRecording {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	list := make([]TriggerRecording, 0, len(s.recordings))
+	for i := len(s.recordings) - 1; i >= 0; i-- {
+		list = append(list, *s.recordings[i])
+	}
+	return list
+}
+
+// Get gibt eine Aufzeichnung zurück
+func (s *RecordingStore) Get(id string) (TriggerRecording, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if rec := s.findLocked(id); rec != nil {
+		return *rec, true
+	}
+	return TriggerRecording{}, false
+}
+
+// Open öffnet die PCAP-Datei einer abgeschlossenen Aufzeichnung
+func (s *RecordingStore) Open(id string) (*os.File, TriggerRecording, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	rec := s.findLocked(id)
+	if rec == nil {
+		return nil, TriggerRecording{}, os.ErrNotExist
+	}
+	if rec.Status == RecordingStatusRecording {
+		return nil, *rec, ErrRecordingInProgress
+	}
+
+	file, err := os.Open(s.pcapPath(id))
+	if err != nil {
+		return nil, *rec, err
+	}
+	return file, *rec, nil
+}
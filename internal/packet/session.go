@@ -0,0 +1,402 @@
+package packet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// errSessionClosed wird gemeldet, wenn eine bereits beendete Session gestartet wird
+var errSessionClosed = fmt.Errorf("Capture-Session ist bereits beendet")
+
+// CaptureSession ist eine einzelne Erfassung auf einer Schnittstelle oder aus einer Datei.
+// Sie wird einmal gestartet und mit Stop beendet; für einen Neustart wird eine neue Session
+// geöffnet. Sessions desselben Capturers laufen unabhängig voneinander.
+type CaptureSession struct {
+	capturer *PcapCapturer
+	source   string // Schnittstelle oder Dateipfad
+	live     bool
+	fileSize int64 // Größe der Datei bei Datei-Sessions
+	linkType layers.LinkType
+	recorder PacketRecorder
+
+	// Mehrere Dekodier-Worker auch für Dateien (nur für Durchsatzmessungen)
+	parallelFileDecoding bool
+
+	// Zähler für Empfang, Verluste und Dekodierfehler
+	counters captureCounters
+
+	handleMutex     sync.Mutex // schützt handle gegen gleichzeitiges Schließen und Stats()
+	handle          captureHandle
+	lastKernelStats CaptureStats
+
+	startOnce  sync.Once
+	packetChan <-chan *models.PacketInfo
+	errorChan  <-chan error
+	cancel     context.CancelFunc
+	done       chan struct{}
+}
+
+// newCaptureSession erstellt eine Session für ein geöffnetes Handle
+func newCaptureSession(capturer *PcapCapturer, handle captureHandle, source string, live bool) *CaptureSession {
+	return &CaptureSession{
+		capturer: capturer,
+		source:   source,
+		live:     live,
+		linkType: handle.LinkType(),
+		handle:   handle,
+		done:     make(chan struct{}),
+	}
+}
+
+// Source gibt die Schnittstelle bzw. den Dateipfad der Session zurück
+func (s *CaptureSession) Source() string {
+	return s.source
+}
+
+// Live gibt an, ob die Session von einer Schnittstelle liest
+func (s *CaptureSession) Live() bool {
+	return s.live
+}
+
+// AllowParallelFileDecoding verteilt auch Pakete aus Dateien auf die konfigurierten
+// Dekodier-Worker. Die Gateway-Erkennung hängt dann vom Zeitverhalten der Worker ab und
+// ist nicht mehr reproduzierbar; gedacht nur für Durchsatzmessungen. Muss vor Start
+// aufgerufen werden.
+func (s *CaptureSession) AllowParallelFileDecoding() {
+	s.parallelFileDecoding = true
+}
+
+// SetBPFFilter setzt einen BPF-Filter auf dem Handle bzw. allen Sockets der Session
+func (s *CaptureSession) SetBPFFilter(filter string) error {
+	s.handleMutex.Lock()
+	defer s.handleMutex.Unlock()
+
+	if s.handle == nil {
+		return errSessionClosed
+	}
+	return s.handle.SetBPFFilter(filter)
+}
+
+// SetPacketRecorder setzt einen Recorder, der alle erfassten Rohpakete erhält.
+// Muss vor Start aufgerufen werden.
+func (s *CaptureSession) SetPacketRecorder(recorder PacketRecorder) {
+	s.recorder = recorder
+}
+
+// AddPacketRecorder ergänzt einen weiteren Recorder zu den bereits gesetzten.
+// Muss vor Start aufgerufen werden.
+func (s *CaptureSession) AddPacketRecorder(recorder PacketRecorder) {
+	switch current := s.recorder.(type) {
+	case nil:
+		s.recorder = recorder
+	case MultiRecorder:
+		s.recorder = append(append(MultiRecorder(nil), current...), recorder)
+	default:
+		s.recorder = MultiRecorder{current, recorder}
+	}
+}
+
+// Start beginnt die Erfassung und liefert die Kanäle für analysierte Pakete und Fehler.
+// Beide Kanäle werden geschlossen, wenn die Erfassung endet. Weitere Aufrufe liefern
+// dieselben Kanäle.
+func (s *CaptureSession) Start(ctx context.Context) (<-chan *models.PacketInfo, <-chan error) {
+	s.startOnce.Do(func() {
+		ctx, s.cancel = context.WithCancel(ctx)
+
+		queue := newPacketQueue(s.capturer.config, s.live, &s.counters)
+		errorChan := make(chan error, 10)
+		s.packetChan = queue.ch
+		s.errorChan = errorChan
+
+		s.handleMutex.Lock()
+		handle := s.handle
+		s.handleMutex.Unlock()
+
+		if handle == nil {
+			// Vor dem Start gestoppt
+			errorChan <- errSessionClosed
+			close(queue.ch)
+			close(errorChan)
+			close(s.done)
+			return
+		}
+
+		go s.run(ctx, handle, queue, errorChan)
+	})
+
+	return s.packetChan, s.errorChan
+}
+
+// Größe der Eingangswarteschlange je Dekodier-Worker
+const decodeWorkerQueueSize = 256
+
+// rawPacket ist eine Kopie eines Rohpakets für die Übergabe an einen Dekodier-Worker
+type rawPacket struct {
+	ci       gopacket.CaptureInfo
+	data     []byte
+	linkType layers.LinkType
+}
+
+// rawPacketPool hält die Puffer für rawPacket, damit große Captures keinen Müll erzeugen
+var rawPacketPool = sync.Pool{New: func() interface{} { return new(rawPacket) }}
+
+// run liest Pakete von allen Lesern des Handles und zeichnet sie auf. Analyse und Weitergabe
+// erfolgen direkt im Leser oder, bei mehreren Dekodier-Workern, verteilt nach Flow-Hash,
+// sodass die Pakete einer Verbindung in ihrer Reihenfolge bleiben. Der Lesepuffer wird ohne
+// Kopie dekodiert; für Worker wird das Paket in einen wiederverwendeten Puffer kopiert.
+func (s *CaptureSession) run(ctx context.Context, handle captureHandle, queue *packetQueue, errorChan chan error) {
+	defer close(s.done)
+	defer close(queue.ch)
+	defer close(errorChan)
+
+	// Dateien werden in einem Worker in Dateireihenfolge analysiert: ARP-, DHCP- und
+	// DNS-Pakete aktualisieren den GatewayDetector, bevor die folgenden Pakete klassifiziert
+	// werden, sodass dieselbe Datei immer dieselben Gateways und Ereignisse ergibt
+	workers := s.capturer.config.DecodeWorkers
+	if workers < 1 || (!s.live && !s.parallelFileDecoding) {
+		workers = 1
+	}
+
+	readerList := handle.readers()
+	log.Printf("Starte Paketerfassung auf %s mit Linktyp %v (%d Leser, %d Dekodier-Worker)",
+		s.source, s.linkType, len(readerList), workers)
+
+	// Blockierende Lesezugriffe enden erst mit dem Schließen des Handles
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Stop()
+		case <-s.done:
+		}
+	}()
+
+	// Analyse eines Pakets; gibt false zurück, wenn der Kontext beendet wurde
+	process := func(decoder *packetDecoder, data []byte, ci gopacket.CaptureInfo, linkType layers.LinkType) bool {
+		decoder.linkType = linkType
+		packetInfo, decodeFailed := decoder.decode(data, ci)
+		if decodeFailed {
+			atomic.AddUint64(&s.counters.decodeErrors, 1)
+		}
+
+		// Weitergabe gemäß Backpressure-Richtlinie (blockiert bei PCAP-Dateien)
+		return queue.push(ctx, packetInfo)
+	}
+
+	// Mehrere Worker: jeder dekodiert die Pakete "seiner" Flows. Die Kanäle werden nach dem
+	// Ende der Erfassung geschlossen; die Ausgabekanäle erst, wenn alle Worker fertig sind.
+	var workerChans []chan *rawPacket
+	if workers > 1 {
+		var wg sync.WaitGroup
+		workerChans = make([]chan *rawPacket, workers)
+		for i := range workerChans {
+			workerChans[i] = make(chan *rawPacket, decodeWorkerQueueSize)
+			wg.Add(1)
+			go func(packets <-chan *rawPacket) {
+				defer wg.Done()
+				decoder := newPacketDecoder(s.capturer, s.linkType)
+				running := true
+				for raw := range packets {
+					// Nach dem Ende des Kontexts restliche Pakete nur noch zurückgeben
+					if running {
+						running = process(decoder, raw.data, raw.ci, raw.linkType)
+					}
+					rawPacketPool.Put(raw)
+				}
+			}(workerChans[i])
+		}
+		defer wg.Wait()
+		defer func() {
+			for _, ch := range workerChans {
+				close(ch)
+			}
+		}()
+	}
+	// Jeder Leser (bei AF_PACKET-Fanout ein Socket) läuft in einer eigenen Goroutine
+	var readers sync.WaitGroup
+	for _, reader := range readerList {
+		readers.Add(1)
+		go func(reader packetReader) {
+			defer readers.Done()
+			defer reader.release()
+			s.readPackets(ctx, reader, workerChans, process, errorChan)
+		}(reader)
+	}
+	readers.Wait()
+}
+
+// readPackets liest Pakete von einem Leser, bis die Quelle geschlossen oder der Kontext
+// beendet wird, und analysiert sie direkt oder übergibt sie an den Worker ihres Flows
+func (s *CaptureSession) readPackets(ctx context.Context, reader packetReader, workerChans []chan *rawPacket,
+	process func(*packetDecoder, []byte, gopacket.CaptureInfo, layers.LinkType) bool, errorChan chan error) {
+	var decoder *packetDecoder
+	if workerChans == nil {
+		decoder = newPacketDecoder(s.capturer, s.linkType)
+	}
+
+	// Bei PCAPNG-Dateien mit mehreren Schnittstellen hat jedes Paket den Linktyp seiner Schnittstelle
+	linkType := s.linkType
+	multiLinkReader, _ := reader.(linkTypeReader)
+
+	// Leseposition in Dateien für den Fortschritt; PCAP-Dateien melden keine Position
+	offsetReader, _ := reader.(fileOffsetReader)
+	if !s.live && offsetReader == nil {
+		atomic.AddUint64(&s.counters.fileOffset, pcapFileHeaderLen)
+	}
+
+	for {
+		data, ci, err := reader.ZeroCopyReadPacketData()
+		switch {
+		case err == nil:
+		case err == io.EOF:
+			return
+		case err == errReadTimeout:
+			continue
+		default:
+			if ctx.Err() == nil {
+				select {
+				case errorChan <- fmt.Errorf("Fehler beim Lesen von %s: %w", s.source, err):
+				default:
+				}
+			}
+			return
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		atomic.AddUint64(&s.counters.received, 1)
+
+		if multiLinkReader != nil {
+			linkType = multiLinkReader.packetLinkType()
+		}
+		if !s.live {
+			if offsetReader != nil {
+				atomic.StoreUint64(&s.counters.fileOffset, uint64(offsetReader.fileOffset()))
+			} else {
+				atomic.AddUint64(&s.counters.fileOffset, uint64(pcapRecordHeaderLen+ci.CaptureLength))
+			}
+		}
+
+		// Rohpaket vor der Analyse aufzeichnen, damit auch später verworfene Pakete erhalten bleiben
+		if s.recorder != nil {
+			if err := s.recorder.WritePacket(ci, data, linkType); err != nil {
+				select {
+				case errorChan <- err:
+				default:
+				}
+			}
+		}
+
+		if workerChans == nil {
+			if !process(decoder, data, ci, linkType) {
+				return
+			}
+			continue
+		}
+
+		// Kopie an den Worker des Flows übergeben; wartet, wenn dieser ausgelastet ist
+		raw := rawPacketPool.Get().(*rawPacket)
+		raw.ci = ci
+		raw.data = append(raw.data[:0], data...)
+		raw.linkType = linkType
+		worker := workerChans[flowHash(data, linkType)%uint32(len(workerChans))]
+		select {
+		case worker <- raw:
+		case <-ctx.Done():
+			rawPacketPool.Put(raw)
+			return
+		}
+	}
+}
+
+// Stop beendet die Erfassung und schließt das Handle. Die Zähler bleiben abrufbar.
+// Mehrfache Aufrufe sind erlaubt.
+func (s *CaptureSession) Stop() {
+	// Verhindert einen späteren Start und liefert die Kanäle der laufenden Erfassung
+	s.startOnce.Do(func() {
+		s.cancel = func() {}
+		packetChan := make(chan *models.PacketInfo)
+		errorChan := make(chan error)
+		close(packetChan)
+		close(errorChan)
+		s.packetChan, s.errorChan = packetChan, errorChan
+		close(s.done)
+	})
+	s.cancel()
+
+	s.handleMutex.Lock()
+	defer s.handleMutex.Unlock()
+
+	if s.handle != nil {
+		// Letzte Kernel-Zähler sichern, damit sie nach dem Schließen abrufbar bleiben
+		s.updateKernelStatsLocked()
+		s.handle.Close()
+		s.handle = nil
+	}
+}
+
+// Done wird geschlossen, sobald die Erfassung beendet ist
+func (s *CaptureSession) Done() <-chan struct{} {
+	return s.done
+}
+
+// Progress gibt bei Datei-Sessions den Anteil der bereits gelesenen Datei zurück (0..1).
+// Live-Sessions liefern 0; nach dem Ende der Erfassung ist der Wert 1.
+func (s *CaptureSession) Progress() float64 {
+	select {
+	case <-s.done:
+		return 1
+	default:
+	}
+	if s.live || s.fileSize <= 0 {
+		return 0
+	}
+
+	progress := float64(atomic.LoadUint64(&s.counters.fileOffset)) / float64(s.fileSize)
+	if progress > 1 {
+		progress = 1
+	}
+	return progress
+}
+
+// Stats gibt die aktuellen Zähler der Session zurück. Kernel- und Interface-Verluste
+// stammen aus pcap_stats bzw. den AF_PACKET-Socketstatistiken und sind nur bei
+// Live-Captures verfügbar.
+func (s *CaptureSession) Stats() CaptureStats {
+	stats := CaptureStats{
+		PacketsReceived: atomic.LoadUint64(&s.counters.received),
+		PipelineDropped: atomic.LoadUint64(&s.counters.pipelineDropped),
+		PipelineSampled: atomic.LoadUint64(&s.counters.pipelineSampled),
+		DecodeErrors:    atomic.LoadUint64(&s.counters.decodeErrors),
+	}
+
+	s.handleMutex.Lock()
+	defer s.handleMutex.Unlock()
+
+	s.updateKernelStatsLocked()
+	stats.KernelDropped = s.lastKernelStats.KernelDropped
+	stats.InterfaceDropped = s.lastKernelStats.InterfaceDropped
+
+	return stats
+}
+
+// updateKernelStatsLocked liest die Kernel-Zähler einer Live-Capture.
+// Der Aufrufer muss handleMutex halten.
+func (s *CaptureSession) updateKernelStatsLocked() {
+	if s.handle == nil || !s.live {
+		return
+	}
+	if kernelStats, err := s.handle.kernelStats(); err == nil {
+		s.lastKernelStats = kernelStats
+	}
+}
@@ -0,0 +1,103 @@
+package packet
+
+// Mindestanzahl an Paketen zwischen zwei Messungen, ab der die Verlustrate bewertet wird
+const minDropSamplePackets = 100
+
+// CaptureStats enthält die Zähler einer Paketerfassung
+type CaptureStats struct {
+	PacketsReceived  uint64 `json:"packets_received"`  // an die Anwendung geliefert
+	KernelDropped    uint64 `json:"kernel_dropped"`    // vom Kernel verworfen (Puffer voll)
+	InterfaceDropped uint64 `json:"interface_dropped"` // von der Schnittstelle/dem Treiber verworfen
+	PipelineDropped  uint64 `json:"pipeline_dropped"`  // in der Verarbeitung verworfen (Kanal voll)
+	PipelineSampled  uint64 `json:"pipeline_sampled"`  // durch die Richtlinie "sample" bewusst ausgelassen
+	DecodeErrors     uint64 `json:"decode_errors"`     // Pakete mit Dekodier- oder Analysefehlern
+}
+
+// Dropped gibt die Summe aller verworfenen Pakete zurück
+func (s CaptureStats) Dropped() uint64 {
+	return s.KernelDropped + s.InterfaceDropped + s.PipelineDropped
+}
+
+// DropRate gibt den Anteil der verworfenen an allen gesehenen Paketen zurück (0..1)
+func (s CaptureStats) DropRate() float64 {
+	seen := s.PacketsReceived + s.KernelDropped + s.InterfaceDropped
+	if seen == 0 {
+		return 0
+	}
+	return float64(s.Dropped()) / float64(seen)
+}
+
+// Add addiert die Zähler einer weiteren Erfassung
+func (s CaptureStats) Add(other CaptureStats) CaptureStats {
+	return CaptureStats{
+		PacketsReceived:  s.PacketsReceived + other.PacketsReceived,
+		KernelDropped:    s.KernelDropped + other.KernelDropped,
+		InterfaceDropped: s.InterfaceDropped + other.InterfaceDropped,
+		PipelineDropped:  s.PipelineDropped + other.PipelineDropped,
+		PipelineSampled:  s.PipelineSampled + other.PipelineSampled,
+		DecodeErrors:     s.DecodeErrors + other.DecodeErrors,
+	}
+}
+
+// sub gibt die Differenz zu einer früheren Messung zurück
+func (s CaptureStats) sub(earlier CaptureStats) CaptureStats {
+	diff := func(a, b uint64) uint64 {
+		if a < b {
+			// Zähler wurden zurückgesetzt (neue Session)
+			return a
+		}
+		return a - b
+	}
+	return CaptureStats{
+		PacketsReceived:  diff(s.PacketsReceived, earlier.PacketsReceived),
+		KernelDropped:    diff(s.KernelDropped, earlier.KernelDropped),
+		InterfaceDropped: diff(s.InterfaceDropped, earlier.InterfaceDropped),
+		PipelineDropped:  diff(s.PipelineDropped, earlier.PipelineDropped),
+		PipelineSampled:  diff(s.PipelineSampled, earlier.PipelineSampled),
+		DecodeErrors:     diff(s.DecodeErrors, earlier.DecodeErrors),
+	}
+}
+
+// captureCounters sind die laufend aktualisierten Zähler einer Capture-Session
+type captureCounters struct {
+	received        uint64
+	pipelineDropped uint64
+	pipelineSampled uint64
+	decodeErrors    uint64
+	// Bei Dateien die bisher gelesenen Bytes; Grundlage für CaptureSession.Progress
+	fileOffset uint64
+}
+
+// DropMonitor erkennt, wann die Verlustrate zwischen zwei Messungen einen Schwellwert überschreitet
+type DropMonitor struct {
+	threshold float64
+	last      CaptureStats
+	alerting  bool
+}
+
+// NewDropMonitor erstellt einen DropMonitor; ein Schwellwert <= 0 deaktiviert die Warnungen
+func NewDropMonitor(threshold float64) *DropMonitor {
+	return &DropMonitor{threshold: threshold}
+}
+
+// Check bewertet die Zähler seit der letzten Messung. Gibt die Verlustrate des Intervalls
+// und true zurück, wenn der Schwellwert neu überschritten wurde.
+func (m *DropMonitor) Check(current CaptureStats) (CaptureStats, float64, bool) {
+	interval := current.sub(m.last)
+	m.last = current
+
+	if m.threshold <= 0 || interval.PacketsReceived+interval.KernelDropped+interval.InterfaceDropped < minDropSamplePackets {
+		return interval, interval.DropRate(), false
+	}
+
+	rate := interval.DropRate()
+	if rate < m.threshold {
+		m.alerting = false
+		return interval, rate, false
+	}
+
+	// Nur beim Überschreiten warnen, nicht bei jeder Messung oberhalb des Schwellwerts
+	crossed := !m.alerting
+	m.alerting = true
+	return interval, rate, crossed
+}
@@ -0,0 +1,228 @@
+//go:build linux
+
+package packet
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/google/gopacket"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+)
+
+// errTPacketTimeout meldet, dass während des Poll-Timeouts kein Block fertig wurde
+var errTPacketTimeout = errors.New("Zeitüberschreitung beim Warten auf Pakete")
+
+// Positionen im Blockkopf (struct tpacket_block_desc mit tpacket_hdr_v1)
+const (
+	tpacketBlockStatusOffset   = 8
+	tpacketBlockNumPktsOffset  = 12
+	tpacketBlockFirstPktOffset = 16
+)
+
+// tpacketOptions beschreibt den Ring eines Sockets
+type tpacketOptions struct {
+	ifindex      int
+	frameSize    int
+	blockSize    int
+	numBlocks    int
+	blockTimeout time.Duration
+	pollTimeout  time.Duration
+	// VLAN-Tags, die der Kernel aus dem Paket entfernt, wieder einfügen
+	addVLANHeader bool
+}
+
+// tpacketSocket ist ein AF_PACKET-Socket mit TPACKET_V3-Empfangsring. Die Strukturen des
+// Rings werden direkt im gemappten Speicher gelesen; dafür wird weder cgo noch libpcap
+// benötigt. Ein Socket darf nur von einem Leser gleichzeitig gelesen werden.
+type tpacketSocket struct {
+	fd   int
+	ring []byte
+	opts tpacketOptions
+
+	// Lesezustand; nur vom Leser verwendet
+	block     int    // Index des aktuellen Blocks
+	blockOpen bool   // der aktuelle Block gehört dem Leser und wird beim Weiterlesen freigegeben
+	remaining uint32 // noch nicht gelesene Pakete im aktuellen Block
+	offset    uint32 // Position des nächsten Pakets im aktuellen Block
+	vlanBuf   []byte // Puffer für Pakete mit wieder eingefügtem VLAN-Tag
+
+	statsMutex sync.Mutex // PACKET_STATISTICS setzt die Zähler beim Lesen zurück
+	drops      uint64
+}
+
+// htons wandelt eine Protokollnummer in Netzwerk-Byte-Reihenfolge um
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
+
+// newTPacketSocket öffnet einen Socket auf einer Schnittstelle und richtet den Ring ein
+func newTPacketSocket(opts tpacketOptions) (*tpacketSocket, error) {
+	pageSize := os.Getpagesize()
+	if opts.blockSize%pageSize != 0 {
+		return nil, fmt.Errorf("block_size %d ist kein Vielfaches der Seitengröße %d", opts.blockSize, pageSize)
+	}
+	if opts.blockSize%opts.frameSize != 0 {
+		return nil, fmt.Errorf("block_size %d ist kein Vielfaches der Framegröße %d", opts.blockSize, opts.frameSize)
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW, int(htons(unix.ETH_P_ALL)))
+	if err != nil {
+		return nil, err
+	}
+	s := &tpacketSocket{fd: fd, opts: opts}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		s.close()
+		return nil, fmt.Errorf("TPACKET_V3 wird nicht unterstützt: %w", err)
+	}
+
+	req := unix.TpacketReq3{
+		Block_size:     uint32(opts.blockSize),
+		Block_nr:       uint32(opts.numBlocks),
+		Frame_size:     uint32(opts.frameSize),
+		Frame_nr:       uint32(opts.blockSize / opts.frameSize * opts.numBlocks),
+		Retire_blk_tov: uint32(opts.blockTimeout / time.Millisecond),
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		s.close()
+		return nil, fmt.Errorf("Fehler beim Einrichten des Empfangsrings: %w", err)
+	}
+
+	s.ring, err = unix.Mmap(fd, 0, opts.blockSize*opts.numBlocks, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		s.close()
+		return nil, fmt.Errorf("Fehler beim Einblenden des Empfangsrings: %w", err)
+	}
+
+	addr := unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: opts.ifindex}
+	if err := unix.Bind(fd, &addr); err != nil {
+		s.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// setFanout nimmt den Socket in eine PACKET_FANOUT-Gruppe auf
+func (s *tpacketSocket) setFanout(fanoutType uint16, id uint16) error {
+	return unix.SetsockoptInt(s.fd, unix.SOL_PACKET, unix.PACKET_FANOUT, int(id)|int(fanoutType)<<16)
+}
+
+// setBPF setzt ein BPF-Programm als Socket-Filter
+func (s *tpacketSocket) setBPF(program []bpf.RawInstruction) error {
+	if len(program) == 0 {
+		return errors.New("leeres BPF-Programm")
+	}
+	filters := make([]unix.SockFilter, len(program))
+	for i, ins := range program {
+		filters[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	fprog := unix.SockFprog{Len: uint16(len(filters)), Filter: &filters[0]}
+	return unix.SetsockoptSockFprog(s.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &fprog)
+}
+
+// dropped gibt die seit dem Öffnen vom Kernel verworfenen Pakete zurück
+func (s *tpacketSocket) dropped() (uint64, error) {
+	stats, err := unix.GetsockoptTpacketStatsV3(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return 0, err
+	}
+
+	s.statsMutex.Lock()
+	defer s.statsMutex.Unlock()
+	s.drops += uint64(stats.Drops)
+	return s.drops, nil
+}
+
+// blockStatus gibt einen Zeiger auf den Status eines Blocks zurück; Kernel und Leser tauschen
+// den Block über dieses Feld aus
+func (s *tpacketSocket) blockStatus(block int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&s.ring[block*s.opts.blockSize+tpacketBlockStatusOffset]))
+}
+
+// ZeroCopyReadPacketData liefert das nächste Paket aus dem Ring. Die Daten bleiben bis zum
+// nächsten Aufruf gültig; erst dann wird der gelesene Block an den Kernel zurückgegeben.
+// Ohne Pakete innerhalb des Poll-Timeouts folgt errTPacketTimeout.
+func (s *tpacketSocket) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for {
+		if s.remaining > 0 {
+			return s.nextPacket()
+		}
+
+		if s.blockOpen {
+			atomic.StoreUint32(s.blockStatus(s.block), unix.TP_STATUS_KERNEL)
+			s.block = (s.block + 1) % s.opts.numBlocks
+			s.blockOpen = false
+		}
+
+		if atomic.LoadUint32(s.blockStatus(s.block))&unix.TP_STATUS_USER != 0 {
+			base := s.block * s.opts.blockSize
+			s.blockOpen = true
+			s.remaining = *(*uint32)(unsafe.Pointer(&s.ring[base+tpacketBlockNumPktsOffset]))
+			s.offset = *(*uint32)(unsafe.Pointer(&s.ring[base+tpacketBlockFirstPktOffset]))
+			continue
+		}
+
+		fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN | unix.POLLERR}}
+		n, err := unix.Poll(fds, int(s.opts.pollTimeout/time.Millisecond))
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return nil, gopacket.CaptureInfo{}, err
+		case n == 0:
+			return nil, gopacket.CaptureInfo{}, errTPacketTimeout
+		}
+	}
+}
+
+// nextPacket liest das Paket an der aktuellen Position des Blocks
+func (s *tpacketSocket) nextPacket() ([]byte, gopacket.CaptureInfo, error) {
+	start := s.block*s.opts.blockSize + int(s.offset)
+	hdr := (*unix.Tpacket3Hdr)(unsafe.Pointer(&s.ring[start]))
+	s.remaining--
+	s.offset += hdr.Next_offset
+
+	dataStart := start + int(hdr.Mac)
+	data := s.ring[dataStart : dataStart+int(hdr.Snaplen)]
+	ci := gopacket.CaptureInfo{
+		Timestamp:      time.Unix(int64(hdr.Sec), int64(hdr.Nsec)),
+		CaptureLength:  int(hdr.Snaplen),
+		Length:         int(hdr.Len),
+		InterfaceIndex: s.opts.ifindex,
+	}
+
+	// Der Kernel entfernt VLAN-Tags und meldet sie im Kopf; wie bei libpcap wieder einfügen
+	if s.opts.addVLANHeader && hdr.Status&unix.TP_STATUS_VLAN_VALID != 0 && len(data) >= 12 {
+		tpid := uint16(0x8100)
+		if hdr.Status&unix.TP_STATUS_VLAN_TPID_VALID != 0 {
+			tpid = hdr.Hv1.Vlan_tpid
+		}
+		tci := uint16(hdr.Hv1.Vlan_tci)
+		s.vlanBuf = append(s.vlanBuf[:0], data[:12]...)
+		s.vlanBuf = append(s.vlanBuf, byte(tpid>>8), byte(tpid), byte(tci>>8), byte(tci))
+		s.vlanBuf = append(s.vlanBuf, data[12:]...)
+		data = s.vlanBuf
+		ci.CaptureLength += 4
+		ci.Length += 4
+	}
+	return data, ci, nil
+}
+
+// close gibt den Ring frei und schließt den Socket. Es darf kein Leser mehr aktiv sein.
+func (s *tpacketSocket) close() {
+	if s.ring != nil {
+		unix.Munmap(s.ring)
+		s.ring = nil
+	}
+	if s.fd >= 0 {
+		unix.Close(s.fd)
+		s.fd = -1
+	}
+}
@@ -0,0 +1,459 @@
+package packet
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"github.com/sayedamirkarim/ki-network-analyzer/internal/config"
+	"github.com/sayedamirkarim/ki-network-analyzer/pkg/models"
+)
+
+// Standardwerte für Trigger-Regeln
+const (
+	defaultTriggerPreSeconds      = 10
+	defaultTriggerPostSeconds     = 30
+	defaultTriggerCooldownSeconds = 60
+	defaultTriggerBufferBytes     = 32 * 1024 * 1024 // 32 MB Vorlaufpuffer pro Capture
+
+	// Wartezeit nach dem Nachlauf für Pakete, die noch in der Verarbeitung sind
+	triggerFinishGrace = time.Second
+)
+
+// ErrRecordingInProgress wird zurückgegeben, wenn eine Aufzeichnung noch läuft
+var ErrRecordingInProgress = errors.New("Aufzeichnung läuft noch")
+
+// MultiRecorder gibt Rohpakete an mehrere Recorder weiter
+type MultiRecorder []PacketRecorder
+
+// WritePacket schreibt das Paket in alle Recorder und gibt den ersten Fehler zurück
+func (m MultiRecorder) WritePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) error {
+	var firstErr error
+	for _, recorder := range m {
+		if err := recorder.WritePacket(ci, data, linkType); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// bufferedPacket ist ein Rohpaket im Vorlaufpuffer
+type bufferedPacket struct {
+	ci       gopacket.CaptureInfo
+	data     []byte
+	linkType layers.LinkType
+}
+
+// triggerRuleState ist der Zustand einer Regel innerhalb einer Capture
+type triggerRuleState struct {
+	seen      map[string]string // bekannte DHCP-Server bzw. Gateway-IP zu MAC
+	lastFired time.Time
+}
+
+// activeRecording ist eine laufende Aufzeichnung mit geöffneter PCAP-Datei
+type activeRecording struct {
+	rec      *TriggerRecording
+	file     *os.File
+	buffered *bufio.Writer
+	writer   *pcapgo.Writer
+	linkType layers.LinkType
+	timer    *time.Timer
+}
+
+// TriggerRecorder hält die Rohpakete der letzten Sekunden einer Capture im Speicher und
+// prüft jedes analysierte Paket gegen die Trigger-Regeln. Trifft eine Regel zu, werden der
+// Vorlauf und alle Pakete bis zum Ende des Nachlaufs in eine PCAP-Datei geschrieben.
+type TriggerRecorder struct {
+	rules          []config.TriggerRule
+	store          *RecordingStore
+	snapLen        uint32
+	maxPre         time.Duration
+	maxBufferBytes int64
+
+	mutex       sync.Mutex
+	capture     string
+	iface       string
+	buffer      []bufferedPacket
+	bufferBytes int64
+	active      []*activeRecording
+	states      []*triggerRuleState
+}
+
+// NewTriggerRecorder erstellt einen Trigger-Recorder für eine Capture
+func NewTriggerRecorder(cfg *config.TriggerConfig, store *RecordingStore, snapLen int) *TriggerRecorder {
+	t := &TriggerRecorder{
+		store:          store,
+		snapLen:        uint32(snapLen),
+		maxBufferBytes: cfg.MaxBufferBytes,
+	}
+	if t.snapLen == 0 {
+		t.snapLen = 65535
+	}
+	if t.maxBufferBytes <= 0 {
+		t.maxBufferBytes = defaultTriggerBufferBytes
+	}
+
+	// Standardwerte der Regeln setzen und den längsten Vorlauf bestimmen
+	for _, rule := range cfg.Rules {
+		if rule.PreSeconds == 0 {
+			rule.PreSeconds = defaultTriggerPreSeconds
+		}
+		if rule.PostSeconds == 0 {
+			rule.PostSeconds = defaultTriggerPostSeconds
+		}
+		if rule.CooldownSeconds == 0 {
+			rule.CooldownSeconds = defaultTriggerCooldownSeconds
+		}
+		if rule.Severity == "" {
+			rule.Severity = "warning"
+		}
+		if pre := time.Duration(rule.PreSeconds) * time.Second; pre > t.maxPre {
+			t.maxPre = pre
+		}
+		t.rules = append(t.rules, rule)
+		t.states = append(t.states, &triggerRuleState{seen: make(map[string]string)})
+	}
+
+	return t
+}
+
+// SetSource legt Capture-Name und Schnittstelle fest, die in den Aufzeichnungen vermerkt werden
+func (t *TriggerRecorder) SetSource(capture, iface string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.capture = capture
+	t.iface = iface
+}
+
+// WritePacket übernimmt ein Rohpaket in den Vorlaufpuffer und in laufende Aufzeichnungen
+func (t *TriggerRecorder) WritePacket(ci gopacket.CaptureInfo, data []byte, linkType layers.LinkType) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	// Der Capturer verwendet den Paketpuffer wieder, daher kopieren
+	pkt := bufferedPacket{ci: ci, data: append([]byte(nil), data...), linkType: linkType}
+
+	var firstErr error
+	for _, active := range append([]*activeRecording(nil), t.active...) {
+		if ci.Timestamp.After(active.rec.Until) {
+			t.finishLocked(active, nil)
+			continue
+		}
+		if err := t.writeLocked(active, pkt); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if t.maxPre > 0 {
+		t.buffer = append(t.buffer, pkt)
+		t.bufferBytes += int64(len(pkt.data))
+
+		// Pakete außerhalb des längsten Vorlaufs oder über der Speichergrenze verwerfen
+		cutoff := ci.Timestamp.Add(-t.maxPre)
+		drop := 0
+		for drop < len(t.buffer) &&
+			(t.buffer[drop].ci.Timestamp.Before(cutoff) || t.bufferBytes > t.maxBufferBytes) {
+			t.bufferBytes -= int64(len(t.buffer[drop].data))
+			drop++
+		}
+		if drop > 0 {
+			t.buffer = append(t.buffer[:0], t.buffer[drop:]...)
+		}
+	}
+
+	return firstErr
+}
+
+// Evaluate prüft ein analysiertes Paket gegen alle Regeln und startet für jede zutreffende
+// Regel eine Aufzeichnung. Zurückgegeben werden die gestarteten Aufzeichnungen.
+func (t *TriggerRecorder) Evaluate(info *models.PacketInfo) []TriggerRecording {
+	if info == nil {
+		return nil
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var started []TriggerRecording
+	for i := range t.rules {
+		rule, state := &t.rules[i], t.states[i]
+
+		reason := matchTriggerRule(rule, state, info)
+		if reason == "" {
+			continue
+		}
+
+		now := time.Now()
+		if !state.lastFired.IsZero() && now.Sub(state.lastFired) < time.Duration(rule.CooldownSeconds)*time.Second {
+			continue
+		}
+		state.lastFired = now
+
+		rec, err := t.startLocked(rule, reason, info.Timestamp)
+		if err != nil {
+			log.Printf("Aufzeichnung für Regel '%s' konnte nicht gestartet werden: %v", rule.Name, err)
+			continue
+		}
+		log.Printf("Regel '%s' ausgelöst (%s), Aufzeichnung %s gestartet", rule.Name, reason, rec.ID)
+		started = append(started, *rec)
+	}
+	return started
+}
+
+// startLocked legt eine Aufzeichnung an, schreibt den Vorlauf und plant das Ende.
+// Der Aufrufer muss mutex halten.
+func (t *TriggerRecorder) startLocked(rule *config.TriggerRule, reason string, triggeredAt time.Time) (*TriggerRecording, error) {
+	if triggeredAt.IsZero() {
+		triggeredAt = time.Now()
+	}
+	post := time.Duration(rule.PostSeconds) * time.Second
+
+	rec := &TriggerRecording{
+		Rule:        rule.Name,
+		Reason:      reason,
+		Severity:    rule.Severity,
+		Capture:     t.capture,
+		Interface:   t.iface,
+		TriggeredAt: triggeredAt,
+		From:        triggeredAt.Add(-time.Duration(rule.PreSeconds) * time.Second),
+		Until:       triggeredAt.Add(post),
+	}
+
+	file, err := t.store.create(rec)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewWriterSize(file, 64*1024)
+	active := &activeRecording{
+		rec:      rec,
+		file:     file,
+		buffered: buffered,
+		writer:   pcapgo.NewWriterNanos(buffered),
+	}
+	t.active = append(t.active, active)
+
+	// Vorlauf aus dem Speicherpuffer übernehmen
+	for _, pkt := range t.buffer {
+		if pkt.ci.Timestamp.Before(rec.From) {
+			continue
+		}
+		if err := t.writeLocked(active, pkt); err != nil {
+			t.finishLocked(active, err)
+			return nil, err
+		}
+	}
+
+	// Aufzeichnung auch dann beenden, wenn nach dem Nachlauf keine Pakete mehr eintreffen
+	active.timer = time.AfterFunc(time.Until(rec.Until)+triggerFinishGrace, func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		t.finishLocked(active, nil)
+	})
+
+	snapshot := *rec
+	return &snapshot, nil
+}
+
+// writeLocked schreibt ein Paket in eine Aufzeichnung; der Dateiheader wird mit dem Linktyp des
+// ersten Pakets geschrieben. Der Aufrufer muss mutex halten.
+func (t *TriggerRecorder) writeLocked(active *activeRecording, pkt bufferedPacket) error {
+	if active.rec.Packets == 0 {
+		if err := active.writer.WriteFileHeader(t.snapLen, pkt.linkType); err != nil {
+			return fmt.Errorf("Fehler beim Schreiben des PCAP-Headers: %w", err)
+		}
+		active.linkType = pkt.linkType
+		active.rec.Size = 24 // PCAP-Dateiheader
+	} else if pkt.linkType != active.linkType {
+		return nil
+	}
+
+	if err := active.writer.WritePacket(pkt.ci, pkt.data); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben der Aufzeichnung: %w", err)
+	}
+	active.rec.Packets++
+	active.rec.Size += int64(16 + len(pkt.data))
+	return nil
+}
+
+// finishLocked schließt eine Aufzeichnung ab. Der Aufrufer muss mutex halten.
+func (t *TriggerRecorder) finishLocked(active *activeRecording, cause error) {
+	index := -1
+	for i, a := range t.active {
+		if a == active {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return
+	}
+	t.active = append(t.active[:index], t.active[index+1:]...)
+
+	if active.timer != nil {
+		active.timer.Stop()
+	}
+
+	// Auch ohne Pakete eine gültige, leere PCAP-Datei hinterlassen
+	if active.rec.Packets == 0 {
+		if err := active.writer.WriteFileHeader(t.snapLen, layers.LinkTypeEthernet); err == nil {
+			active.rec.Size = 24
+		}
+	}
+
+	err := active.buffered.Flush()
+	if closeErr := active.file.Close(); err == nil {
+		err = closeErr
+	}
+	if cause != nil {
+		err = cause
+	}
+
+	if err != nil {
+		active.rec.Status = RecordingStatusFailed
+		active.rec.Error = err.Error()
+		log.Printf("Aufzeichnung %s fehlgeschlagen: %v", active.rec.ID, err)
+	} else {
+		active.rec.Status = RecordingStatusCompleted
+		log.Printf("Aufzeichnung %s abgeschlossen: %d Pakete, %d Bytes", active.rec.ID, active.rec.Packets, active.rec.Size)
+	}
+	t.store.finish(active.rec)
+}
+
+// Stop beendet alle laufenden Aufzeichnungen vorzeitig und leert den Vorlaufpuffer.
+// Der Recorder kann danach für eine neue Capture weiterverwendet werden.
+func (t *TriggerRecorder) Stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for len(t.active) > 0 {
+		t.finishLocked(t.active[0], nil)
+	}
+	t.buffer = nil
+	t.bufferBytes = 0
+}
+
+// matchTriggerRule prüft eine Regel und gibt bei einem Treffer eine Beschreibung des Anlasses zurück
+func matchTriggerRule(rule *config.TriggerRule, state *triggerRuleState, info *models.PacketInfo) string {
+	if !matchTriggerConditions(rule, info) {
+		return ""
+	}
+
+	switch rule.Type {
+	case config.TriggerTypeMatch:
+		return fmt.Sprintf("%s-Paket %s -> %s", info.Protocol, formatEndpoint(info.SourceIP, info.SourcePort),
+			formatEndpoint(info.DestinationIP, info.DestinationPort))
+
+	case config.TriggerTypeNewDHCPServer:
+		dhcp := info.DHCPInfo
+		if dhcp == nil || (dhcp.MessageType != "OFFER" && dhcp.MessageType != "ACK") {
+			return ""
+		}
+		// Antworten kommen vom Server selbst; siaddr ist oft nicht gesetzt
+		server := info.SourceIP
+		if server == nil || server.IsUnspecified() {
+			server = dhcp.ServerIP
+		}
+		if server == nil || server.IsUnspecified() {
+			return ""
+		}
+
+		key := server.String()
+		if _, known := state.seen[key]; known {
+			return ""
+		}
+		state.seen[key] = ""
+
+		if len(rule.KnownDHCPServers) == 0 {
+			// Der erste gesehene Server gilt als der legitime
+			if len(state.seen) == 1 {
+				return ""
+			}
+		} else {
+			for _, allowed := range rule.KnownDHCPServers {
+				if net.ParseIP(allowed).Equal(server) {
+					return ""
+				}
+			}
+		}
+		return fmt.Sprintf("Neuer DHCP-Server %s (%s)", key, dhcp.MessageType)
+
+	case config.TriggerTypeGatewayMACChange:
+		arp := info.ARPInfo
+		if arp == nil || arp.SenderIP == nil || arp.SenderIP.IsUnspecified() || arp.SenderMAC == "" {
+			return ""
+		}
+		// Ohne feste Adresse gelten die vom Capturer erkannten Gateways
+		if rule.IP != "" {
+			if !net.ParseIP(rule.IP).Equal(arp.SenderIP) {
+				return ""
+			}
+		} else if info.GatewayIP == nil || !info.GatewayIP.Equal(arp.SenderIP) {
+			return ""
+		}
+
+		key := arp.SenderIP.String()
+		previous, known := state.seen[key]
+		state.seen[key] = arp.SenderMAC
+		if !known || previous == arp.SenderMAC {
+			return ""
+		}
+		return fmt.Sprintf("MAC-Adresse des Gateways %s hat sich von %s auf %s geändert", key, previous, arp.SenderMAC)
+	}
+
+	return ""
+}
+
+// matchTriggerConditions prüft die optionalen Paketbedingungen einer Regel
+func matchTriggerConditions(rule *config.TriggerRule, info *models.PacketInfo) bool {
+	if rule.Protocol != "" && !strings.EqualFold(rule.Protocol, info.Protocol) {
+		return false
+	}
+	if rule.IP != "" {
+		ip := net.ParseIP(rule.IP)
+		if !ip.Equal(info.SourceIP) && !ip.Equal(info.DestinationIP) {
+			return false
+		}
+	}
+	if rule.Port != 0 && rule.Port != info.SourcePort && rule.Port != info.DestinationPort {
+		return false
+	}
+	if rule.DHCPMessageType != "" &&
+		(info.DHCPInfo == nil || !strings.EqualFold(rule.DHCPMessageType, info.DHCPInfo.MessageType)) {
+		return false
+	}
+	if rule.DNSName != "" {
+		if info.DNSInfo == nil {
+			return false
+		}
+		found := false
+		for _, query := range info.DNSInfo.Queries {
+			if strings.Contains(strings.ToLower(query.Name), strings.ToLower(rule.DNSName)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// formatEndpoint formatiert Adresse und Port für Beschreibungen
+func formatEndpoint(ip net.IP, port uint16) string {
+	if port == 0 {
+		return ip.String()
+	}
+	return net.JoinHostPort(ip.String(), fmt.Sprint(port))
+}
@@ -0,0 +1,161 @@
+// Package pcapng liest und schreibt PCAPNG-Dateien einschließlich der Metadaten, die
+// pcapgo verwirft: mehrere Schnittstellen mit eigener Zeitauflösung, Kommentare je Paket
+// und Namensauflösungsblöcke (NRB). Der Writer erzeugt Dateien, die Wireshark ohne
+// Warnungen öffnet.
+package pcapng
+
+import (
+	"errors"
+	"math/bits"
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// Blocktypen
+const (
+	blockTypeInterface      = 0x00000001
+	blockTypePacket         = 0x00000002 // veraltet, nur lesend unterstützt
+	blockTypeSimplePacket   = 0x00000003
+	blockTypeNameResolution = 0x00000004
+	blockTypeInterfaceStats = 0x00000005
+	blockTypeEnhancedPacket = 0x00000006
+	blockTypeSectionHeader  = 0x0A0D0D0A
+)
+
+// Optionscodes; Codes 0 und 1 gelten für alle Blöcke
+const (
+	optEndOfOpt = 0
+	optComment  = 1
+
+	optSHBHardware    = 2
+	optSHBOS          = 3
+	optSHBApplication = 4
+
+	optIfName        = 2
+	optIfDescription = 3
+	optIfTsResol     = 9
+	optIfFilter      = 11
+	optIfOS          = 12
+	optIfTsOffset    = 14
+
+	optEPBFlags = 2
+)
+
+// Einträge eines Namensauflösungsblocks
+const (
+	nrbRecordEnd  = 0
+	nrbRecordIPv4 = 1
+	nrbRecordIPv6 = 2
+)
+
+const (
+	byteOrderMagic = 0x1A2B3C4D
+
+	// Standard-Zeitauflösung ohne if_tsresol: Mikrosekunden
+	defaultTsResol = 6
+
+	// Obergrenze einer Blocklänge; schützt vor riesigen Allokationen bei defekten Dateien
+	maxBlockLength = 64 * 1024 * 1024
+)
+
+// Magic ist die Kennung am Anfang jeder PCAPNG-Datei (Typ des Section Header Blocks)
+var Magic = []byte{0x0A, 0x0D, 0x0D, 0x0A}
+
+var (
+	// ErrNotPcapng wird gemeldet, wenn die Datei nicht mit einem Section Header Block beginnt
+	ErrNotPcapng = errors.New("keine PCAPNG-Datei")
+	// ErrUnknownInterface wird gemeldet, wenn ein Paket auf eine nicht beschriebene Schnittstelle verweist
+	ErrUnknownInterface = errors.New("Paket verweist auf eine unbekannte Schnittstelle")
+)
+
+// Section enthält die Angaben des Section Header Blocks
+type Section struct {
+	Hardware    string   `json:"hardware,omitempty"`
+	OS          string   `json:"os,omitempty"`
+	Application string   `json:"application,omitempty"`
+	Comments    []string `json:"comments,omitempty"`
+}
+
+// Interface beschreibt eine Schnittstelle (Interface Description Block). Pakete verweisen
+// über ihren Index in der Reihenfolge der Blöcke darauf.
+type Interface struct {
+	LinkType    layers.LinkType `json:"link_type"`
+	SnapLen     uint32          `json:"snap_len"`
+	Name        string          `json:"name,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Filter      string          `json:"filter,omitempty"`
+	OS          string          `json:"os,omitempty"`
+	Comments    []string        `json:"comments,omitempty"`
+	// TsResol ist der Rohwert von if_tsresol: ohne gesetztes höchstes Bit 10^-n Sekunden,
+	// sonst 2^-n Sekunden. 0 steht für den Standardwert (Mikrosekunden).
+	TsResol uint8 `json:"ts_resol,omitempty"`
+	// TsOffset wird zu allen Zeitstempeln der Schnittstelle addiert (Sekunden)
+	TsOffset int64 `json:"ts_offset,omitempty"`
+}
+
+// Packet ist ein Paket mit seinen Metadaten
+type Packet struct {
+	InterfaceIndex int
+	Timestamp      time.Time
+	CaptureLength  int
+	Length         int
+	Data           []byte
+	Comments       []string
+	// Flags ist der Wert von epb_flags (Richtung, Empfangsart, Fehler); 0 = nicht gesetzt
+	Flags uint32
+}
+
+// NameRecord ist ein Eintrag eines Namensauflösungsblocks
+type NameRecord struct {
+	IP    net.IP   `json:"ip"`
+	Names []string `json:"names"`
+}
+
+// tsUnits gibt die Zeiteinheiten pro Sekunde für einen if_tsresol-Wert zurück
+func tsUnits(resol uint8) uint64 {
+	if resol == 0 {
+		resol = defaultTsResol
+	}
+	exp := uint64(resol & 0x7f)
+	if resol&0x80 != 0 {
+		if exp > 63 {
+			exp = 63
+		}
+		return 1 << exp
+	}
+	if exp > 19 {
+		exp = 19 // 10^19 ist die größte Zehnerpotenz in uint64
+	}
+	units := uint64(1)
+	for i := uint64(0); i < exp; i++ {
+		units *= 10
+	}
+	return units
+}
+
+// ticksToTime rechnet einen Zeitstempel in Einheiten von units pro Sekunde um
+func ticksToTime(ticks, units uint64, offset int64) time.Time {
+	sec := ticks / units
+	hi, lo := bits.Mul64(ticks%units, uint64(time.Second))
+	nsec, _ := bits.Div64(hi, lo, units)
+	return time.Unix(int64(sec)+offset, int64(nsec))
+}
+
+// timeToTicks rechnet einen Zeitpunkt in Einheiten von units pro Sekunde um; Zeitpunkte
+// vor der Epoche bzw. vor dem Offset werden auf 0 gesetzt
+func timeToTicks(t time.Time, units uint64, offset int64) uint64 {
+	sec := t.Unix() - offset
+	if sec < 0 {
+		return 0
+	}
+	hi, lo := bits.Mul64(uint64(t.Nanosecond()), units)
+	frac, _ := bits.Div64(hi, lo, uint64(time.Second))
+	return uint64(sec)*units + frac
+}
+
+// pad4 rundet eine Länge auf ein Vielfaches von 4 Bytes auf
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
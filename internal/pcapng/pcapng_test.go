@@ -0,0 +1,530 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+// byteOrder ist eine Byte-Reihenfolge, mit der sich auch Werte anhängen lassen
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+// fileBuilder setzt PCAPNG-Dateien in beliebiger Byte-Reihenfolge blockweise zusammen
+type fileBuilder struct {
+	order byteOrder
+	data  []byte
+}
+
+// block hängt einen Block mit Längenfeldern an
+func (b *fileBuilder) block(blockType uint32, body []byte) {
+	length := uint32(12 + len(body))
+	b.data = b.order.AppendUint32(b.data, blockType)
+	b.data = b.order.AppendUint32(b.data, length)
+	b.data = append(b.data, body...)
+	b.data = b.order.AppendUint32(b.data, length)
+}
+
+// option hängt eine Option mit Auffüllung an
+func (b *fileBuilder) option(body []byte, code uint16, value []byte) []byte {
+	body = b.order.AppendUint16(body, code)
+	body = b.order.AppendUint16(body, uint16(len(value)))
+	return appendPadded(body, value)
+}
+
+// endOfOptions schließt eine Optionsliste ab
+func (b *fileBuilder) endOfOptions(body []byte) []byte {
+	return b.order.AppendUint32(body, optEndOfOpt)
+}
+
+func (b *fileBuilder) sectionHeader(comment string) {
+	body := b.order.AppendUint32(nil, byteOrderMagic)
+	body = b.order.AppendUint16(body, 1)
+	body = b.order.AppendUint16(body, 0)
+	body = b.order.AppendUint64(body, ^uint64(0))
+	body = b.option(body, optSHBApplication, []byte("test"))
+	body = b.option(body, optComment, []byte(comment))
+	b.block(blockTypeSectionHeader, b.endOfOptions(body))
+}
+
+func (b *fileBuilder) interfaceDescription(linkType layers.LinkType, name string, tsResol uint8) {
+	body := b.order.AppendUint16(nil, uint16(linkType))
+	body = b.order.AppendUint16(body, 0)
+	body = b.order.AppendUint32(body, 65535)
+	body = b.option(body, optIfName, []byte(name))
+	if tsResol != 0 {
+		body = b.option(body, optIfTsResol, []byte{tsResol})
+	}
+	b.block(blockTypeInterface, b.endOfOptions(body))
+}
+
+func (b *fileBuilder) enhancedPacket(index uint32, ticks uint64, data []byte, length uint32, comment string, flags uint32) {
+	body := b.order.AppendUint32(nil, index)
+	body = b.order.AppendUint32(body, uint32(ticks>>32))
+	body = b.order.AppendUint32(body, uint32(ticks))
+	body = b.order.AppendUint32(body, uint32(len(data)))
+	body = b.order.AppendUint32(body, length)
+	body = appendPadded(body, data)
+	body = b.option(body, optEPBFlags, b.order.AppendUint32(nil, flags))
+	body = b.option(body, optComment, []byte(comment))
+	b.block(blockTypeEnhancedPacket, b.endOfOptions(body))
+}
+
+func (b *fileBuilder) simplePacket(data []byte) {
+	body := b.order.AppendUint32(nil, uint32(len(data)))
+	b.block(blockTypeSimplePacket, appendPadded(body, data))
+}
+
+// readAll liest alle Pakete als Kopien bis zum Dateiende
+func readAll(t *testing.T, r *Reader) []Packet {
+	t.Helper()
+	var packets []Packet
+	for {
+		p, err := r.Next()
+		if err == io.EOF {
+			return packets
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		packet := *p
+		packet.Data = append([]byte(nil), p.Data...)
+		packets = append(packets, packet)
+	}
+}
+
+// roundTripFile schreibt eine Datei mit mehreren Schnittstellen, Zeitauflösungen, Kommentaren
+// und Namensauflösungen
+func roundTripFile(t *testing.T) ([]byte, Section, []Interface, []Packet, []NameRecord) {
+	t.Helper()
+
+	section := Section{
+		Hardware:    "x86_64",
+		OS:          "Linux 6.1",
+		Application: "ki-network-analyzer",
+		Comments:    []string{"erste Sektion", "zweiter Kommentar"},
+	}
+	interfaces := []Interface{
+		{LinkType: layers.LinkTypeEthernet, SnapLen: 65535, Name: "eth0", Description: "Uplink",
+			Filter: "tcp port 80", OS: "Linux", Comments: []string{"Spiegelport"}, TsResol: 9},
+		{LinkType: layers.LinkTypeNull, SnapLen: 262144, Name: "lo"},
+		{LinkType: layers.LinkTypeRaw, SnapLen: 1500, Name: "tun0", TsResol: 3, TsOffset: 1700000000},
+		{LinkType: layers.LinkTypeLinuxSLL, SnapLen: 9000, Name: "any", TsResol: 0x80 | 20},
+	}
+	base := time.Date(2024, 5, 17, 12, 30, 45, 123456789, time.UTC)
+	packets := []Packet{
+		{InterfaceIndex: 0, Timestamp: base, Data: []byte{1, 2, 3, 4, 5, 6, 7}, Length: 1514,
+			Comments: []string{"Grüße", "zweiter"}, Flags: 0x1},
+		{InterfaceIndex: 1, Timestamp: base.Add(time.Second), Data: []byte{2, 0, 0, 0, 0x45}},
+		{InterfaceIndex: 2, Timestamp: base.Add(2 * time.Second), Data: []byte{0x45, 0, 0, 20}},
+		{InterfaceIndex: 3, Timestamp: base.Add(3 * time.Second), Data: bytes.Repeat([]byte{0xab}, 33), Comments: []string{"x"}},
+		{InterfaceIndex: 0, Timestamp: base.Add(4 * time.Second), Data: nil, Flags: 0x2},
+	}
+	names := []NameRecord{
+		{IP: net.ParseIP("192.0.2.1").To4(), Names: []string{"gateway.local", "router"}},
+		{IP: net.ParseIP("2001:db8::1"), Names: []string{"dns.example"}},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, section)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, intf := range interfaces {
+		index, err := w.AddInterface(intf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index != i {
+			t.Fatalf("AddInterface index = %d, want %d", index, i)
+		}
+	}
+	if err := w.WriteNameRecords(names); err != nil {
+		t.Fatal(err)
+	}
+	for i := range packets {
+		if err := w.WritePacket(&packets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), section, interfaces, packets, names
+}
+
+func TestWriterReaderRoundTrip(t *testing.T) {
+	data, section, interfaces, packets, names := roundTripFile(t)
+
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Section(); !reflect.DeepEqual(got, section) {
+		t.Errorf("Section = %+v, want %+v", got, section)
+	}
+	if got := r.Interfaces(); !reflect.DeepEqual(got, interfaces) {
+		t.Errorf("Interfaces = %+v, want %+v", got, interfaces)
+	}
+	if got := r.NameRecords(); !reflect.DeepEqual(got, names) {
+		t.Errorf("NameRecords = %+v, want %+v", got, names)
+	}
+	if r.LinkType() != layers.LinkTypeEthernet {
+		t.Errorf("LinkType = %v, want Ethernet", r.LinkType())
+	}
+
+	got := readAll(t, r)
+	if len(got) != len(packets) {
+		t.Fatalf("read %d packets, want %d", len(got), len(packets))
+	}
+
+	// Größte zulässige Abweichung des Zeitstempels je Schnittstelle durch die Auflösung
+	precision := []time.Duration{time.Nanosecond, time.Microsecond, time.Millisecond, time.Second / (1 << 20)}
+	for i, want := range packets {
+		p := got[i]
+		if p.InterfaceIndex != want.InterfaceIndex {
+			t.Errorf("packet %d: interface %d, want %d", i, p.InterfaceIndex, want.InterfaceIndex)
+		}
+		if diff := want.Timestamp.Sub(p.Timestamp); diff < 0 || diff >= precision[want.InterfaceIndex] {
+			t.Errorf("packet %d: timestamp %v, want %v within %v", i, p.Timestamp, want.Timestamp, precision[want.InterfaceIndex])
+		}
+		if !bytes.Equal(p.Data, want.Data) || p.CaptureLength != len(want.Data) {
+			t.Errorf("packet %d: data %x (%d), want %x", i, p.Data, p.CaptureLength, want.Data)
+		}
+		wantLength := want.Length
+		if wantLength < len(want.Data) {
+			wantLength = len(want.Data)
+		}
+		if p.Length != wantLength {
+			t.Errorf("packet %d: length %d, want %d", i, p.Length, wantLength)
+		}
+		if !reflect.DeepEqual(p.Comments, want.Comments) {
+			t.Errorf("packet %d: comments %q, want %q", i, p.Comments, want.Comments)
+		}
+		if p.Flags != want.Flags {
+			t.Errorf("packet %d: flags %#x, want %#x", i, p.Flags, want.Flags)
+		}
+	}
+}
+
+func TestTimestampResolution(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 987654321, time.UTC)
+	testCases := []struct {
+		name    string
+		tsResol uint8
+		units   uint64
+		want    time.Time
+	}{
+		{name: "default microseconds", tsResol: 0, units: 1e6, want: ts.Truncate(time.Microsecond)},
+		{name: "explicit microseconds", tsResol: 6, units: 1e6, want: ts.Truncate(time.Microsecond)},
+		{name: "nanoseconds", tsResol: 9, units: 1e9, want: ts},
+		{name: "milliseconds", tsResol: 3, units: 1e3, want: ts.Truncate(time.Millisecond)},
+		{name: "whole seconds", tsResol: 0x80, units: 1, want: ts.Truncate(time.Second)},
+		{name: "power of two", tsResol: 0x80 | 10, units: 1024, want: time.Unix(ts.Unix(), 987304687)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			units := tsUnits(tc.tsResol)
+			if units != tc.units {
+				t.Fatalf("tsUnits(%#x) = %d, want %d", tc.tsResol, units, tc.units)
+			}
+			if got := ticksToTime(timeToTicks(ts, units, 0), units, 0); !got.Equal(tc.want) {
+				t.Errorf("round trip = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	// Der Offset verschiebt die Ticks, nicht den gelesenen Zeitpunkt
+	if ticks := timeToTicks(ts, 1e9, ts.Unix()); ticks != 987654321 {
+		t.Errorf("ticks with offset = %d, want 987654321", ticks)
+	}
+	if got := ticksToTime(987654321, 1e9, ts.Unix()); !got.Equal(ts) {
+		t.Errorf("time with offset = %v, want %v", got, ts)
+	}
+}
+
+func TestReaderByteOrder(t *testing.T) {
+	ticks := uint64(1715949045)*1e9 + 123456789
+	for _, order := range []byteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			b := &fileBuilder{order: order}
+			b.sectionHeader("Kommentar")
+			b.interfaceDescription(layers.LinkTypeEthernet, "eth1", 9)
+			b.enhancedPacket(0, ticks, []byte{0xde, 0xad, 0xbe}, 60, "Paketkommentar", 0x1)
+			b.simplePacket([]byte{0xca, 0xfe})
+
+			r, err := NewReader(bytes.NewReader(b.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantSection := Section{Application: "test", Comments: []string{"Kommentar"}}
+			if got := r.Section(); !reflect.DeepEqual(got, wantSection) {
+				t.Errorf("Section = %+v, want %+v", got, wantSection)
+			}
+			wantInterfaces := []Interface{{LinkType: layers.LinkTypeEthernet, SnapLen: 65535, Name: "eth1", TsResol: 9}}
+			if got := r.Interfaces(); !reflect.DeepEqual(got, wantInterfaces) {
+				t.Errorf("Interfaces = %+v, want %+v", got, wantInterfaces)
+			}
+
+			packets := readAll(t, r)
+			if len(packets) != 2 {
+				t.Fatalf("read %d packets, want 2", len(packets))
+			}
+			want := Packet{
+				Timestamp:     time.Unix(1715949045, 123456789),
+				CaptureLength: 3,
+				Length:        60,
+				Data:          []byte{0xde, 0xad, 0xbe},
+				Comments:      []string{"Paketkommentar"},
+				Flags:         0x1,
+			}
+			if !reflect.DeepEqual(packets[0], want) {
+				t.Errorf("enhanced packet = %+v, want %+v", packets[0], want)
+			}
+			if !bytes.Equal(packets[1].Data, []byte{0xca, 0xfe}) || packets[1].Length != 2 {
+				t.Errorf("simple packet = %+v", packets[1])
+			}
+		})
+	}
+}
+
+func TestReaderMultipleSections(t *testing.T) {
+	b := &fileBuilder{order: binary.LittleEndian}
+	b.sectionHeader("eins")
+	b.interfaceDescription(layers.LinkTypeEthernet, "eth0", 0)
+	b.interfaceDescription(layers.LinkTypeNull, "lo", 0)
+	b.enhancedPacket(1, 1e6, []byte{1}, 1, "a", 0)
+
+	// Zweite Sektion in anderer Byte-Reihenfolge mit eigenen Schnittstellen
+	second := &fileBuilder{order: binary.BigEndian}
+	second.sectionHeader("zwei")
+	second.interfaceDescription(layers.LinkTypeRaw, "tun0", 0)
+	second.enhancedPacket(0, 2e6, []byte{2}, 1, "b", 0)
+	second.enhancedPacket(1, 3e6, []byte{3}, 1, "c", 0)
+
+	r, err := NewReader(bytes.NewReader(append(b.data, second.data...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := r.Next()
+	if err != nil || p.InterfaceIndex != 1 || r.SectionNumber() != 0 {
+		t.Fatalf("first packet = %+v, %v in section %d", p, err, r.SectionNumber())
+	}
+	p, err = r.Next()
+	if err != nil || p.Data[0] != 2 || r.SectionNumber() != 1 || r.NumInterfaces() != 1 || r.LinkType() != layers.LinkTypeRaw {
+		t.Fatalf("second packet = %+v, %v in section %d with %d interfaces", p, err, r.SectionNumber(), r.NumInterfaces())
+	}
+	if got := r.Section().Comments; !reflect.DeepEqual(got, []string{"zwei"}) {
+		t.Errorf("section comments = %q", got)
+	}
+	// Schnittstelle 1 gehörte zur ersten Sektion
+	if _, err := r.Next(); !errors.Is(err, ErrUnknownInterface) {
+		t.Errorf("packet of previous section: err = %v, want ErrUnknownInterface", err)
+	}
+}
+
+func TestWriterUnknownInterface(t *testing.T) {
+	w, err := NewWriter(io.Discard, Section{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket(&Packet{InterfaceIndex: 0, Data: []byte{1}}); !errors.Is(err, ErrUnknownInterface) {
+		t.Errorf("err = %v, want ErrUnknownInterface", err)
+	}
+}
+
+func TestReaderNotPcapng(t *testing.T) {
+	pcapHeader := []byte{0xd4, 0xc3, 0xb2, 0xa1, 2, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 0, 0, 1, 0, 0, 0}
+	for name, data := range map[string][]byte{
+		"empty":       nil,
+		"pcap":        pcapHeader,
+		"short magic": Magic[:2],
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(data)); !errors.Is(err, ErrNotPcapng) {
+				t.Errorf("err = %v, want ErrNotPcapng", err)
+			}
+		})
+	}
+}
+
+// blockBoundaries gibt die Offsets zurück, an denen in einer Little-Endian-Datei Blöcke beginnen
+func blockBoundaries(t *testing.T, data []byte) map[int]bool {
+	t.Helper()
+	boundaries := map[int]bool{}
+	for offset := 0; offset < len(data); {
+		boundaries[offset] = true
+		offset += int(binary.LittleEndian.Uint32(data[offset+4:]))
+	}
+	boundaries[len(data)] = true
+	return boundaries
+}
+
+func TestReaderTruncated(t *testing.T) {
+	data, _, _, packets, _ := roundTripFile(t)
+	boundaries := blockBoundaries(t, data)
+
+	for n := 0; n < len(data); n++ {
+		r, err := NewReader(bytes.NewReader(data[:n]))
+		if err != nil {
+			continue
+		}
+
+		count := 0
+		for {
+			_, err = r.Next()
+			if err != nil {
+				break
+			}
+			count++
+		}
+		if count >= len(packets) {
+			t.Errorf("cut at %d: read all %d packets", n, count)
+		}
+		// Nur ein Schnitt zwischen zwei Blöcken ist ein reguläres Dateiende
+		if err == io.EOF && !boundaries[n] {
+			t.Errorf("cut at %d inside a block: got io.EOF, want an error", n)
+		}
+	}
+}
+
+func TestReaderMalformed(t *testing.T) {
+	valid := func() *fileBuilder {
+		b := &fileBuilder{order: binary.LittleEndian}
+		b.sectionHeader("")
+		b.interfaceDescription(layers.LinkTypeEthernet, "eth0", 0)
+		return b
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(b *fileBuilder)
+	}{
+		{
+			name: "block length below minimum",
+			modify: func(b *fileBuilder) {
+				b.data = b.order.AppendUint32(b.data, blockTypeEnhancedPacket)
+				b.data = b.order.AppendUint32(b.data, 8)
+				b.data = b.order.AppendUint32(b.data, 8)
+			},
+		},
+		{
+			name: "block length not aligned",
+			modify: func(b *fileBuilder) {
+				b.data = b.order.AppendUint32(b.data, blockTypeEnhancedPacket)
+				b.data = b.order.AppendUint32(b.data, 13)
+				b.data = append(b.data, make([]byte, 5)...)
+			},
+		},
+		{
+			name: "block length above maximum",
+			modify: func(b *fileBuilder) {
+				b.data = b.order.AppendUint32(b.data, blockTypeEnhancedPacket)
+				b.data = b.order.AppendUint32(b.data, maxBlockLength+4)
+			},
+		},
+		{
+			name: "trailing length mismatch",
+			modify: func(b *fileBuilder) {
+				b.enhancedPacket(0, 0, []byte{1, 2, 3, 4}, 4, "", 0)
+				b.order.PutUint32(b.data[len(b.data)-4:], 0)
+			},
+		},
+		{
+			name: "enhanced packet too short",
+			modify: func(b *fileBuilder) {
+				b.block(blockTypeEnhancedPacket, make([]byte, 16))
+			},
+		},
+		{
+			name: "capture length beyond block",
+			modify: func(b *fileBuilder) {
+				body := b.order.AppendUint32(nil, 0)
+				body = b.order.AppendUint64(body, 0)
+				body = b.order.AppendUint32(body, 100)
+				body = b.order.AppendUint32(body, 100)
+				b.block(blockTypeEnhancedPacket, append(body, 1, 2, 3, 4))
+			},
+		},
+		{
+			name: "option beyond block",
+			modify: func(b *fileBuilder) {
+				body := b.order.AppendUint32(nil, 0)
+				body = b.order.AppendUint64(body, 0)
+				body = b.order.AppendUint32(body, 4)
+				body = b.order.AppendUint32(body, 4)
+				body = append(body, 1, 2, 3, 4)
+				body = b.order.AppendUint16(body, optComment)
+				body = b.order.AppendUint16(body, 200)
+				b.block(blockTypeEnhancedPacket, append(body, 'x', 0, 0, 0))
+			},
+		},
+		{
+			name: "unknown interface",
+			modify: func(b *fileBuilder) {
+				b.enhancedPacket(5, 0, []byte{1}, 1, "", 0)
+			},
+		},
+		{
+			name: "interface description too short",
+			modify: func(b *fileBuilder) {
+				b.block(blockTypeInterface, make([]byte, 4))
+			},
+		},
+		{
+			name: "name record beyond block",
+			modify: func(b *fileBuilder) {
+				body := b.order.AppendUint16(nil, nrbRecordIPv4)
+				body = b.order.AppendUint16(body, 64)
+				b.block(blockTypeNameResolution, append(body, 192, 0, 2, 1))
+			},
+		},
+		{
+			name: "invalid byte order magic in second section",
+			modify: func(b *fileBuilder) {
+				b.block(blockTypeSectionHeader, make([]byte, 16))
+			},
+		},
+		{
+			name: "unsupported major version",
+			modify: func(b *fileBuilder) {
+				body := b.order.AppendUint32(nil, byteOrderMagic)
+				body = b.order.AppendUint16(body, 2)
+				body = b.order.AppendUint16(body, 0)
+				b.block(blockTypeSectionHeader, b.order.AppendUint64(body, 0))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b := valid()
+			tc.modify(b)
+
+			r, err := NewReader(bytes.NewReader(b.data))
+			if err != nil {
+				// Fehler in den führenden Blöcken werden bereits beim Öffnen gemeldet
+				return
+			}
+			for {
+				_, err = r.Next()
+				if err != nil {
+					break
+				}
+			}
+			if err == io.EOF {
+				t.Errorf("got io.EOF, want an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,481 @@
+package pcapng
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Reader liest eine PCAPNG-Datei Block für Block. Schnittstellen und Namensauflösungen
+// werden beim Lesen übernommen; ein neuer Section Header Block beginnt eine neue Sektion
+// mit eigenen Schnittstellen. Ein Reader ist nicht threadsicher.
+type Reader struct {
+	r     *bufio.Reader
+	order binary.ByteOrder
+
+	sectionNumber int
+	section       Section
+	interfaces    []Interface
+	units         []uint64
+	names         []NameRecord
+
+	header [12]byte
+	buf    []byte
+	packet Packet
+}
+
+// NewReader prüft den ersten Section Header Block und liefert einen Reader
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReaderSize(r, 64*1024), sectionNumber: -1}
+
+	blockType, body, err := reader.readBlock()
+	if err == io.EOF || err == io.ErrUnexpectedEOF || err == ErrNotPcapng {
+		return nil, ErrNotPcapng
+	}
+	if err != nil {
+		return nil, err
+	}
+	if blockType != blockTypeSectionHeader {
+		return nil, ErrNotPcapng
+	}
+	if err := reader.readSectionHeader(body); err != nil {
+		return nil, err
+	}
+	if err := reader.readLeadingBlocks(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+// readLeadingBlocks liest die Schnittstellen und Namensauflösungen vor dem ersten Paket,
+// damit Linktyp und Schnittstellen schon nach dem Öffnen bekannt sind
+func (r *Reader) readLeadingBlocks() error {
+	for {
+		header, err := r.r.Peek(4)
+		if err != nil {
+			// Dateiende oder Lesefehler meldet der nächste Aufruf von Next
+			return nil
+		}
+
+		var handle func([]byte) error
+		switch r.order.Uint32(header) {
+		case blockTypeInterface:
+			handle = r.readInterface
+		case blockTypeNameResolution:
+			handle = r.readNameResolution
+		default:
+			return nil
+		}
+
+		_, body, err := r.readBlock()
+		if err != nil {
+			return err
+		}
+		if err := handle(body); err != nil {
+			return err
+		}
+	}
+}
+
+// Section gibt die Angaben der aktuellen Sektion zurück
+func (r *Reader) Section() Section {
+	return r.section
+}
+
+// SectionNumber gibt die laufende Nummer der aktuellen Sektion zurück (beginnend bei 0).
+// Schnittstellenindizes gelten nur innerhalb einer Sektion.
+func (r *Reader) SectionNumber() int {
+	return r.sectionNumber
+}
+
+// Interfaces gibt die bisher gelesenen Schnittstellen der aktuellen Sektion zurück
+func (r *Reader) Interfaces() []Interface {
+	return append([]Interface(nil), r.interfaces...)
+}
+
+// NumInterfaces gibt die Anzahl der bisher gelesenen Schnittstellen der aktuellen Sektion zurück
+func (r *Reader) NumInterfaces() int {
+	return len(r.interfaces)
+}
+
+// Interface gibt eine Schnittstelle der aktuellen Sektion zurück
+func (r *Reader) Interface(index int) (Interface, bool) {
+	if index < 0 || index >= len(r.interfaces) {
+		return Interface{}, false
+	}
+	return r.interfaces[index], true
+}
+
+// LinkType gibt den Linktyp der ersten Schnittstelle zurück; ohne Schnittstelle wird
+// Ethernet angenommen
+func (r *Reader) LinkType() layers.LinkType {
+	if len(r.interfaces) == 0 {
+		return layers.LinkTypeEthernet
+	}
+	return r.interfaces[0].LinkType
+}
+
+// NameRecords gibt alle bisher gelesenen Namensauflösungen zurück
+func (r *Reader) NameRecords() []NameRecord {
+	return append([]NameRecord(nil), r.names...)
+}
+
+// Next liest das nächste Paket. Das Paket und seine Daten bleiben bis zum nächsten Aufruf
+// gültig. Am Dateiende wird io.EOF geliefert.
+func (r *Reader) Next() (*Packet, error) {
+	for {
+		blockType, body, err := r.readBlock()
+		if err != nil {
+			return nil, err
+		}
+
+		switch blockType {
+		case blockTypeSectionHeader:
+			if err := r.readSectionHeader(body); err != nil {
+				return nil, err
+			}
+		case blockTypeInterface:
+			if err := r.readInterface(body); err != nil {
+				return nil, err
+			}
+		case blockTypeNameResolution:
+			if err := r.readNameResolution(body); err != nil {
+				return nil, err
+			}
+		case blockTypeEnhancedPacket:
+			return r.readEnhancedPacket(body)
+		case blockTypePacket:
+			return r.readObsoletePacket(body)
+		case blockTypeSimplePacket:
+			return r.readSimplePacket(body)
+		default:
+			// Statistiken, Entschlüsselungsgeheimnisse und unbekannte Blöcke überspringen
+		}
+	}
+}
+
+// ZeroCopyReadPacketData liefert das nächste Paket für gopacket; CaptureInfo.InterfaceIndex
+// ist der Index der Schnittstelle innerhalb der Sektion
+func (r *Reader) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	packet, err := r.Next()
+	if err != nil {
+		return nil, gopacket.CaptureInfo{}, err
+	}
+	return packet.Data, gopacket.CaptureInfo{
+		Timestamp:      packet.Timestamp,
+		CaptureLength:  packet.CaptureLength,
+		Length:         packet.Length,
+		InterfaceIndex: packet.InterfaceIndex,
+	}, nil
+}
+
+// ReadPacketData liefert wie ZeroCopyReadPacketData das nächste Paket, jedoch als Kopie
+func (r *Reader) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	data, ci, err := r.ZeroCopyReadPacketData()
+	if err != nil {
+		return nil, ci, err
+	}
+	return append([]byte(nil), data...), ci, nil
+}
+
+// readBlock liest den nächsten Block und gibt Typ und Inhalt zwischen den Längenfeldern
+// zurück. Der Inhalt bleibt bis zum nächsten Aufruf gültig.
+func (r *Reader) readBlock() (uint32, []byte, error) {
+	header := r.header[:8]
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return 0, nil, err
+	}
+
+	// Der Typ des Section Header Blocks ist symmetrisch und in jeder Byte-Reihenfolge lesbar;
+	// die Reihenfolge der Sektion ergibt sich aus dem folgenden Byte-Order-Magic
+	isSection := bytes.Equal(header[:4], Magic)
+	if r.order == nil && !isSection {
+		return 0, nil, ErrNotPcapng
+	}
+	var magic []byte
+	if isSection {
+		magic = r.header[8:12]
+		if _, err := io.ReadFull(r.r, magic); err != nil {
+			return 0, nil, noEOF(err)
+		}
+		switch {
+		case binary.LittleEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.LittleEndian
+		case binary.BigEndian.Uint32(magic) == byteOrderMagic:
+			r.order = binary.BigEndian
+		default:
+			return 0, nil, fmt.Errorf("ungültiges Byte-Order-Magic im Section Header Block")
+		}
+	}
+
+	blockType := r.order.Uint32(header[:4])
+	length := r.order.Uint32(header[4:8])
+	if length < 12 || length%4 != 0 || length > maxBlockLength {
+		return 0, nil, fmt.Errorf("ungültige Blocklänge %d (Typ 0x%08x)", length, blockType)
+	}
+
+	bodyLength := int(length) - 12
+	if cap(r.buf) < bodyLength+4 {
+		r.buf = make([]byte, bodyLength+4)
+	}
+	body := r.buf[:bodyLength]
+	if isSection {
+		if bodyLength < 4 {
+			return 0, nil, fmt.Errorf("Section Header Block ist zu kurz")
+		}
+		copy(body, magic)
+		body = body[4:]
+	}
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		return 0, nil, noEOF(err)
+	}
+
+	trailer := r.buf[bodyLength : bodyLength+4]
+	if _, err := io.ReadFull(r.r, trailer); err != nil {
+		return 0, nil, noEOF(err)
+	}
+	if r.order.Uint32(trailer) != length {
+		return 0, nil, fmt.Errorf("Blocklängen stimmen nicht überein (Typ 0x%08x)", blockType)
+	}
+	return blockType, r.buf[:bodyLength], nil
+}
+
+// noEOF meldet ein Dateiende innerhalb eines Blocks als abgeschnittene Datei
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readOptions ruft fn für jede Option bis opt_endofopt auf. Die Werte verweisen in den Block.
+func (r *Reader) readOptions(data []byte, fn func(code uint16, value []byte)) error {
+	for len(data) >= 4 {
+		code := r.order.Uint16(data[0:2])
+		length := int(r.order.Uint16(data[2:4]))
+		if code == optEndOfOpt {
+			return nil
+		}
+		if 4+length > len(data) {
+			return fmt.Errorf("Option %d überschreitet das Blockende", code)
+		}
+		fn(code, data[4:4+length])
+
+		next := 4 + pad4(length)
+		if next > len(data) {
+			return nil
+		}
+		data = data[next:]
+	}
+	return nil
+}
+
+// readSectionHeader beginnt eine neue Sektion; Schnittstellen gelten nur innerhalb einer Sektion
+func (r *Reader) readSectionHeader(body []byte) error {
+	// Byte-Order-Magic, Version und Sektionslänge
+	if len(body) < 16 {
+		return fmt.Errorf("Section Header Block ist zu kurz")
+	}
+	if major := r.order.Uint16(body[4:6]); major != 1 {
+		return fmt.Errorf("nicht unterstützte PCAPNG-Version %d", major)
+	}
+
+	section := Section{}
+	err := r.readOptions(body[16:], func(code uint16, value []byte) {
+		switch code {
+		case optComment:
+			section.Comments = append(section.Comments, string(value))
+		case optSHBHardware:
+			section.Hardware = string(value)
+		case optSHBOS:
+			section.OS = string(value)
+		case optSHBApplication:
+			section.Application = string(value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	r.sectionNumber++
+	r.section = section
+	r.interfaces = r.interfaces[:0]
+	r.units = r.units[:0]
+	return nil
+}
+
+// readInterface übernimmt einen Interface Description Block
+func (r *Reader) readInterface(body []byte) error {
+	if len(body) < 8 {
+		return fmt.Errorf("Interface Description Block ist zu kurz")
+	}
+
+	intf := Interface{
+		LinkType: layers.LinkType(r.order.Uint16(body[0:2])),
+		SnapLen:  r.order.Uint32(body[4:8]),
+	}
+	err := r.readOptions(body[8:], func(code uint16, value []byte) {
+		switch code {
+		case optComment:
+			intf.Comments = append(intf.Comments, string(value))
+		case optIfName:
+			intf.Name = string(value)
+		case optIfDescription:
+			intf.Description = string(value)
+		case optIfFilter:
+			// Erstes Byte ist die Art des Filters; 0 = libpcap-Filterausdruck
+			if len(value) > 1 && value[0] == 0 {
+				intf.Filter = string(value[1:])
+			}
+		case optIfOS:
+			intf.OS = string(value)
+		case optIfTsResol:
+			if len(value) == 1 {
+				intf.TsResol = value[0]
+			}
+		case optIfTsOffset:
+			if len(value) == 8 {
+				intf.TsOffset = int64(r.order.Uint64(value))
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	r.interfaces = append(r.interfaces, intf)
+	r.units = append(r.units, tsUnits(intf.TsResol))
+	return nil
+}
+
+// readNameResolution übernimmt die Einträge eines Namensauflösungsblocks
+func (r *Reader) readNameResolution(body []byte) error {
+	for len(body) >= 4 {
+		recordType := r.order.Uint16(body[0:2])
+		length := int(r.order.Uint16(body[2:4]))
+		if recordType == nrbRecordEnd {
+			return nil
+		}
+		if 4+length > len(body) {
+			return fmt.Errorf("Namenseintrag überschreitet das Blockende")
+		}
+		value := body[4 : 4+length]
+
+		ipLength := 0
+		switch recordType {
+		case nrbRecordIPv4:
+			ipLength = net.IPv4len
+		case nrbRecordIPv6:
+			ipLength = net.IPv6len
+		}
+		if ipLength > 0 && len(value) > ipLength {
+			record := NameRecord{IP: append(net.IP(nil), value[:ipLength]...)}
+			for _, name := range bytes.Split(value[ipLength:], []byte{0}) {
+				if len(name) > 0 {
+					record.Names = append(record.Names, string(name))
+				}
+			}
+			if len(record.Names) > 0 {
+				r.names = append(r.names, record)
+			}
+		}
+
+		next := 4 + pad4(length)
+		if next > len(body) {
+			return nil
+		}
+		body = body[next:]
+	}
+	return nil
+}
+
+// readEnhancedPacket liest einen Enhanced Packet Block
+func (r *Reader) readEnhancedPacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("Enhanced Packet Block ist zu kurz")
+	}
+	index := int(r.order.Uint32(body[0:4]))
+	ticks := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	return r.packetFromBlock(index, ticks, r.order.Uint32(body[12:16]), r.order.Uint32(body[16:20]), body[20:])
+}
+
+// readObsoletePacket liest einen Packet Block älterer Schreibprogramme
+func (r *Reader) readObsoletePacket(body []byte) (*Packet, error) {
+	if len(body) < 20 {
+		return nil, fmt.Errorf("Packet Block ist zu kurz")
+	}
+	index := int(r.order.Uint16(body[0:2]))
+	ticks := uint64(r.order.Uint32(body[4:8]))<<32 | uint64(r.order.Uint32(body[8:12]))
+	return r.packetFromBlock(index, ticks, r.order.Uint32(body[12:16]), r.order.Uint32(body[16:20]), body[20:])
+}
+
+// packetFromBlock füllt das Paket aus den gemeinsamen Feldern der Paketblöcke
+func (r *Reader) packetFromBlock(index int, ticks uint64, captureLength, length uint32, rest []byte) (*Packet, error) {
+	if index >= len(r.interfaces) {
+		return nil, ErrUnknownInterface
+	}
+	if int(captureLength) > len(rest) {
+		return nil, fmt.Errorf("Paketdaten überschreiten das Blockende")
+	}
+
+	p := &r.packet
+	*p = Packet{
+		InterfaceIndex: index,
+		Timestamp:      ticksToTime(ticks, r.units[index], r.interfaces[index].TsOffset),
+		CaptureLength:  int(captureLength),
+		Length:         int(length),
+		Data:           rest[:captureLength],
+	}
+
+	options := rest[pad4(int(captureLength)):]
+	if pad4(int(captureLength)) > len(rest) {
+		options = nil
+	}
+	err := r.readOptions(options, func(code uint16, value []byte) {
+		switch code {
+		case optComment:
+			p.Comments = append(p.Comments, string(value))
+		case optEPBFlags:
+			if len(value) == 4 {
+				p.Flags = r.order.Uint32(value)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// readSimplePacket liest einen Simple Packet Block; er gehört immer zur ersten Schnittstelle
+// und hat keinen Zeitstempel
+func (r *Reader) readSimplePacket(body []byte) (*Packet, error) {
+	if len(r.interfaces) == 0 {
+		return nil, ErrUnknownInterface
+	}
+	if len(body) < 4 {
+		return nil, fmt.Errorf("Simple Packet Block ist zu kurz")
+	}
+
+	length := int(r.order.Uint32(body[0:4]))
+	captureLength := length
+	if snapLen := int(r.interfaces[0].SnapLen); snapLen > 0 && captureLength > snapLen {
+		captureLength = snapLen
+	}
+	if captureLength > len(body)-4 {
+		captureLength = len(body) - 4
+	}
+
+	r.packet = Packet{
+		CaptureLength: captureLength,
+		Length:        length,
+		Data:          body[4 : 4+captureLength],
+	}
+	return &r.packet, nil
+}
@@ -0,0 +1,193 @@
+package pcapng
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Größter Wert einer Option bzw. eines Namenseintrags (16-Bit-Längenfeld)
+const maxOptionLength = 0xffff
+
+// Writer schreibt eine PCAPNG-Datei mit einer Sektion in Little-Endian. Schnittstellen
+// können jederzeit ergänzt werden, müssen aber vor ihren Paketen angelegt sein. Ein Writer
+// ist nicht threadsicher; Flush schreibt die gepufferten Blöcke.
+type Writer struct {
+	w          *bufio.Writer
+	interfaces []Interface
+	units      []uint64
+	buf        []byte
+}
+
+// NewWriter schreibt den Section Header Block und liefert einen Writer
+func NewWriter(w io.Writer, section Section) (*Writer, error) {
+	writer := &Writer{w: bufio.NewWriterSize(w, 64*1024)}
+
+	body := writer.begin(blockTypeSectionHeader)
+	body = binary.LittleEndian.AppendUint32(body, byteOrderMagic)
+	body = binary.LittleEndian.AppendUint16(body, 1) // Version 1.0
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint64(body, ^uint64(0)) // Sektionslänge unbekannt
+	body = appendStringOption(body, optSHBHardware, section.Hardware)
+	body = appendStringOption(body, optSHBOS, section.OS)
+	body = appendStringOption(body, optSHBApplication, section.Application)
+	for _, comment := range section.Comments {
+		body = appendStringOption(body, optComment, comment)
+	}
+	if err := writer.end(body, true); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// AddInterface schreibt einen Interface Description Block und gibt den Index zurück, über
+// den Pakete auf die Schnittstelle verweisen
+func (w *Writer) AddInterface(intf Interface) (int, error) {
+	body := w.begin(blockTypeInterface)
+	body = binary.LittleEndian.AppendUint16(body, uint16(intf.LinkType))
+	body = binary.LittleEndian.AppendUint16(body, 0)
+	body = binary.LittleEndian.AppendUint32(body, intf.SnapLen)
+	body = appendStringOption(body, optIfName, intf.Name)
+	body = appendStringOption(body, optIfDescription, intf.Description)
+	if intf.Filter != "" {
+		body = appendOption(body, optIfFilter, append([]byte{0}, intf.Filter...))
+	}
+	body = appendStringOption(body, optIfOS, intf.OS)
+	if intf.TsResol != 0 && intf.TsResol != defaultTsResol {
+		body = appendOption(body, optIfTsResol, []byte{intf.TsResol})
+	}
+	if intf.TsOffset != 0 {
+		body = appendOption(body, optIfTsOffset, binary.LittleEndian.AppendUint64(nil, uint64(intf.TsOffset)))
+	}
+	for _, comment := range intf.Comments {
+		body = appendStringOption(body, optComment, comment)
+	}
+	if err := w.end(body, true); err != nil {
+		return 0, err
+	}
+
+	w.interfaces = append(w.interfaces, intf)
+	w.units = append(w.units, tsUnits(intf.TsResol))
+	return len(w.interfaces) - 1, nil
+}
+
+// WritePacket schreibt ein Paket als Enhanced Packet Block mit seinen Kommentaren.
+// CaptureLength wird aus den Daten bestimmt; Length ist mindestens so groß.
+func (w *Writer) WritePacket(p *Packet) error {
+	if p.InterfaceIndex < 0 || p.InterfaceIndex >= len(w.interfaces) {
+		return ErrUnknownInterface
+	}
+	index := p.InterfaceIndex
+	length := p.Length
+	if length < len(p.Data) {
+		length = len(p.Data)
+	}
+	ticks := timeToTicks(p.Timestamp, w.units[index], w.interfaces[index].TsOffset)
+
+	body := w.begin(blockTypeEnhancedPacket)
+	body = binary.LittleEndian.AppendUint32(body, uint32(index))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ticks>>32))
+	body = binary.LittleEndian.AppendUint32(body, uint32(ticks))
+	body = binary.LittleEndian.AppendUint32(body, uint32(len(p.Data)))
+	body = binary.LittleEndian.AppendUint32(body, uint32(length))
+	body = appendPadded(body, p.Data)
+	if p.Flags != 0 {
+		body = appendOption(body, optEPBFlags, binary.LittleEndian.AppendUint32(nil, p.Flags))
+	}
+	for _, comment := range p.Comments {
+		body = appendStringOption(body, optComment, comment)
+	}
+	return w.end(body, len(p.Comments) > 0 || p.Flags != 0)
+}
+
+// WriteNameRecords schreibt die Einträge als Namensauflösungsblock. Einträge ohne Namen
+// oder mit ungültiger Adresse werden übergangen.
+func (w *Writer) WriteNameRecords(records []NameRecord) error {
+	body := w.begin(blockTypeNameResolution)
+	written := 0
+	for _, record := range records {
+		recordType, ip := uint16(nrbRecordIPv6), record.IP.To16()
+		if ip4 := record.IP.To4(); ip4 != nil {
+			recordType, ip = nrbRecordIPv4, ip4
+		}
+		if ip == nil {
+			continue
+		}
+
+		value := append([]byte(nil), ip...)
+		for _, name := range record.Names {
+			if name == "" || len(value)+len(name)+1 > maxOptionLength {
+				continue
+			}
+			value = append(append(value, name...), 0)
+		}
+		if len(value) == len(ip) {
+			continue
+		}
+
+		body = binary.LittleEndian.AppendUint16(body, recordType)
+		body = binary.LittleEndian.AppendUint16(body, uint16(len(value)))
+		body = appendPadded(body, value)
+		written++
+	}
+	if written == 0 {
+		return nil
+	}
+	body = binary.LittleEndian.AppendUint32(body, nrbRecordEnd) // nrb_record_end mit Länge 0
+	return w.end(body, false)
+}
+
+// Flush schreibt alle gepufferten Blöcke
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// begin beginnt einen Block im internen Puffer; die Länge wird in end eingetragen
+func (w *Writer) begin(blockType uint32) []byte {
+	body := binary.LittleEndian.AppendUint32(w.buf[:0], blockType)
+	return binary.LittleEndian.AppendUint32(body, 0)
+}
+
+// end schließt die Optionen ab, trägt die Länge ein und schreibt den Block
+func (w *Writer) end(block []byte, hasOptions bool) error {
+	if hasOptions {
+		block = binary.LittleEndian.AppendUint32(block, optEndOfOpt)
+	}
+	length := uint32(len(block) + 4)
+	binary.LittleEndian.PutUint32(block[4:8], length)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	w.buf = block
+
+	if _, err := w.w.Write(block); err != nil {
+		return fmt.Errorf("Fehler beim Schreiben des Blocks: %w", err)
+	}
+	return nil
+}
+
+// appendOption hängt eine Option an; zu lange Werte werden gekürzt
+func appendOption(body []byte, code uint16, value []byte) []byte {
+	if len(value) > maxOptionLength {
+		value = value[:maxOptionLength]
+	}
+	body = binary.LittleEndian.AppendUint16(body, code)
+	body = binary.LittleEndian.AppendUint16(body, uint16(len(value)))
+	return appendPadded(body, value)
+}
+
+// appendStringOption hängt eine Textoption an, sofern sie nicht leer ist
+func appendStringOption(body []byte, code uint16, value string) []byte {
+	if value == "" {
+		return body
+	}
+	return appendOption(body, code, []byte(value))
+}
+
+// appendPadded hängt Daten an und füllt auf ein Vielfaches von 4 Bytes auf
+func appendPadded(body, data []byte) []byte {
+	body = append(body, data...)
+	for i := len(data); i%4 != 0; i++ {
+		body = append(body, 0)
+	}
+	return body
+}
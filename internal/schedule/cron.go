@@ -0,0 +1,226 @@
+// Package schedule berechnet Ausführungszeitpunkte für wiederkehrende Aufgaben
+// anhand von Cron-Ausdrücken.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Maximale Suchdauer für den nächsten Zeitpunkt; Ausdrücke wie "0 0 30 2 *" treffen nie zu
+	maxSearchYears = 5
+
+	// Stundenfeld, das auf jede Stunde zutrifft
+	allHours = 1<<24 - 1
+)
+
+// Cron ist ein geparster Cron-Ausdruck mit den fünf Standardfeldern
+// Minute, Stunde, Tag des Monats, Monat und Wochentag
+type Cron struct {
+	expr    string
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// Kurzformen für häufige Zeitpläne
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Namen für Monate und Wochentage
+var (
+	monthNames = map[string]int{"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12}
+	dowNames = map[string]int{"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6}
+)
+
+// ParseCron parst einen Cron-Ausdruck ("*/15 8-18 * * mon-fri") oder eine Kurzform wie "@daily".
+// Unterstützt werden Listen, Bereiche, Schrittweiten sowie Monats- und Wochentagsnamen.
+func ParseCron(expr string) (*Cron, error) {
+	normalized := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(normalized)]; ok {
+		normalized = macro
+	}
+
+	fields := strings.Fields(normalized)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Cron-Ausdruck '%s' muss 5 Felder haben (Minute Stunde Tag Monat Wochentag)", expr)
+	}
+
+	c := &Cron{expr: expr}
+	var err error
+	if c.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("Minute: %w", err)
+	}
+	if c.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("Stunde: %w", err)
+	}
+	if c.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("Tag des Monats: %w", err)
+	}
+	if c.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("Monat: %w", err)
+	}
+	// Sonntag darf als 0 oder 7 angegeben werden
+	if c.dow, err = parseField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("Wochentag: %w", err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar = fields[2] == "*" || fields[2] == "?"
+	c.dowStar = fields[4] == "*" || fields[4] == "?"
+
+	return c, nil
+}
+
+// String gibt den ursprünglichen Ausdruck zurück
+func (c *Cron) String() string {
+	return c.expr
+}
+
+// Next gibt den ersten passenden Zeitpunkt nach t zurück (minutengenau, in der Zeitzone von t).
+// Trifft der Ausdruck in den nächsten Jahren nie zu, wird der Nullwert zurückgegeben.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			if c.missedInGap(t, next) {
+				return next
+			}
+			t = next
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			next := t.Add(time.Minute)
+			if c.missedInGap(t, next) {
+				return next
+			}
+			t = next
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// missedInGap meldet, ob zwischen t und next Stunden liegen, die durch die Umstellung auf
+// Sommerzeit ausfallen und zu denen der Ausdruck ausgeführt würde. Wie bei cron werden
+// solche Zeitpunkte am Ende der Lücke nachgeholt; Ausdrücke für jede Stunde laufen
+// einfach in der nächsten vorhandenen Stunde weiter.
+func (c *Cron) missedInGap(t, next time.Time) bool {
+	if c.hour == allHours || next.Day() != t.Day() {
+		return false
+	}
+	for hour := t.Hour() + 1; hour < next.Hour(); hour++ {
+		if c.hour&(1<<uint(hour)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// dayMatches prüft Tag des Monats und Wochentag. Wie bei cron genügt ein Treffer in
+// einem der beiden Felder, wenn beide eingeschränkt sind.
+func (c *Cron) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.domStar && c.dowStar:
+		return true
+	case c.domStar:
+		return dowMatch
+	case c.dowStar:
+		return domMatch
+	default:
+		return domMatch || dowMatch
+	}
+}
+
+// parseField parst ein Feld mit Listen ("1,5"), Bereichen ("1-5") und Schrittweiten ("*/10", "0-30/5")
+func parseField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("ungültige Schrittweite in '%s'", part)
+			}
+			rangePart = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*" || rangePart == "?":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = parseValue(bounds[0], names); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(bounds[1], names); err != nil {
+				return 0, err
+			}
+		default:
+			value, err := parseValue(rangePart, names)
+			if err != nil {
+				return 0, err
+			}
+			low = value
+			// "5/15" bedeutet ab 5 in Schritten von 15
+			if step == 1 {
+				high = value
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("'%s' liegt außerhalb von %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// parseValue parst eine Zahl oder einen Namen (z.B. "mon", "jan")
+func parseValue(value string, names map[string]int) (int, error) {
+	if n, ok := names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("ungültiger Wert '%s'", value)
+	}
+	return n, nil
+}
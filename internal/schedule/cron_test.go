@@ -0,0 +1,267 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// bitsOf bildet die Bitmaske eines Felds aus einzelnen Werten
+func bitsOf(values ...int) uint64 {
+	var bits uint64
+	for _, v := range values {
+		bits |= 1 << uint(v)
+	}
+	return bits
+}
+
+// rangeOf liefert alle Werte von low bis high
+func rangeOf(low, high int) []int {
+	var values []int
+	for v := low; v <= high; v++ {
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestParseField(t *testing.T) {
+	testCases := []struct {
+		name     string
+		field    string
+		min, max int
+		names    map[string]int
+		want     uint64
+		wantErr  bool
+	}{
+		{name: "wildcard", field: "*", min: 0, max: 59, want: bitsOf(rangeOf(0, 59)...)},
+		{name: "question mark", field: "?", min: 1, max: 31, want: bitsOf(rangeOf(1, 31)...)},
+		{name: "single value", field: "7", min: 0, max: 23, want: bitsOf(7)},
+		{name: "range", field: "1-5", min: 0, max: 59, want: bitsOf(1, 2, 3, 4, 5)},
+		{name: "step over wildcard", field: "*/15", min: 0, max: 59, want: bitsOf(0, 15, 30, 45)},
+		{name: "step over range", field: "10-30/10", min: 0, max: 59, want: bitsOf(10, 20, 30)},
+		{name: "step from value", field: "5/20", min: 0, max: 59, want: bitsOf(5, 25, 45)},
+		{name: "step starts at field minimum", field: "*/10", min: 1, max: 31, want: bitsOf(1, 11, 21, 31)},
+		{name: "list", field: "1,3,5-7", min: 0, max: 59, want: bitsOf(1, 3, 5, 6, 7)},
+		{name: "list with steps", field: "0-10/5,*/20", min: 0, max: 59, want: bitsOf(0, 5, 10, 20, 40)},
+		{name: "weekday names", field: "mon-fri", min: 0, max: 7, names: dowNames, want: bitsOf(1, 2, 3, 4, 5)},
+		{name: "month names ignore case", field: "jan,JUL", min: 1, max: 12, names: monthNames, want: bitsOf(1, 7)},
+		{name: "mixed names and numbers", field: "3-may", min: 1, max: 12, names: monthNames, want: bitsOf(3, 4, 5)},
+		{name: "above maximum", field: "60", min: 0, max: 59, wantErr: true},
+		{name: "below minimum", field: "0", min: 1, max: 31, wantErr: true},
+		{name: "range above maximum", field: "20-24", min: 0, max: 23, wantErr: true},
+		{name: "reversed range", field: "5-1", min: 0, max: 59, wantErr: true},
+		{name: "zero step", field: "*/0", min: 0, max: 59, wantErr: true},
+		{name: "invalid step", field: "*/x", min: 0, max: 59, wantErr: true},
+		{name: "invalid value", field: "abc", min: 0, max: 59, wantErr: true},
+		{name: "empty list element", field: "1,,2", min: 0, max: 59, wantErr: true},
+		{name: "name in numeric field", field: "mon", min: 0, max: 59, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseField(tc.field, tc.min, tc.max, tc.names)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("parseField(%q) = %b, want error", tc.field, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseField(%q): %v", tc.field, err)
+			}
+			if got != tc.want {
+				t.Errorf("parseField(%q) = %b, want %b", tc.field, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	testCases := []struct {
+		name    string
+		expr    string
+		wantErr bool
+	}{
+		{name: "standard", expr: "*/15 8-18 * * mon-fri"},
+		{name: "surrounding whitespace", expr: "  0 0 1 1 *  "},
+		{name: "macro", expr: "@daily"},
+		{name: "macro ignores case", expr: "@Hourly"},
+		{name: "sunday as seven", expr: "0 12 * * 7"},
+		{name: "too few fields", expr: "* * * *", wantErr: true},
+		{name: "too many fields", expr: "0 * * * * *", wantErr: true},
+		{name: "unknown macro", expr: "@sometimes", wantErr: true},
+		{name: "invalid minute", expr: "60 * * * *", wantErr: true},
+		{name: "invalid hour", expr: "* 24 * * *", wantErr: true},
+		{name: "invalid day of month", expr: "* * 0 * *", wantErr: true},
+		{name: "invalid month", expr: "* * * 13 *", wantErr: true},
+		{name: "invalid weekday", expr: "* * * * 8", wantErr: true},
+		{name: "weekday name in month field", expr: "* * * mon *", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := ParseCron(tc.expr)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ParseCron(%q) succeeded, want error", tc.expr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+			}
+			if c.String() != tc.expr {
+				t.Errorf("String() = %q, want %q", c.String(), tc.expr)
+			}
+		})
+	}
+}
+
+func TestParseCronSundayAsSeven(t *testing.T) {
+	seven, err := ParseCron("0 0 * * 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero, err := ParseCron("0 0 * * 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seven.dow&1 == 0 || zero.dow&1 == 0 {
+		t.Errorf("Sunday not set: 7 -> %b, 0 -> %b", seven.dow, zero.dow)
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{name: "next step", expr: "*/15 * * * *", from: utc(2024, 6, 10, 10, 7), want: utc(2024, 6, 10, 10, 15)},
+		{name: "strictly after a match", expr: "*/15 * * * *", from: utc(2024, 6, 10, 10, 15), want: utc(2024, 6, 10, 10, 30)},
+		{name: "seconds are ignored", expr: "*/15 * * * *", from: utc(2024, 6, 10, 10, 14).Add(59 * time.Second), want: utc(2024, 6, 10, 10, 15)},
+		{name: "next hour", expr: "0 * * * *", from: utc(2024, 6, 10, 23, 30), want: utc(2024, 6, 11, 0, 0)},
+		{name: "hour range", expr: "0 8-18 * * *", from: utc(2024, 6, 10, 18, 1), want: utc(2024, 6, 11, 8, 0)},
+		{name: "weekdays across month end", expr: "0 9 * * mon-fri", from: utc(2024, 5, 31, 10, 0), want: utc(2024, 6, 3, 9, 0)},
+		{name: "day 31 skips short months", expr: "0 0 31 * *", from: utc(2024, 4, 15, 0, 0), want: utc(2024, 5, 31, 0, 0)},
+		{name: "leap day", expr: "0 0 29 2 *", from: utc(2023, 3, 1, 0, 0), want: utc(2024, 2, 29, 0, 0)},
+		{name: "year boundary", expr: "@yearly", from: utc(2024, 12, 31, 12, 0), want: utc(2025, 1, 1, 0, 0)},
+		{name: "month list", expr: "0 0 1 jan,jul *", from: utc(2024, 2, 1, 0, 0), want: utc(2024, 7, 1, 0, 0)},
+		{name: "impossible date", expr: "0 0 30 2 *", from: utc(2024, 1, 1, 0, 0), want: time.Time{}},
+
+		// Sind Tag des Monats und Wochentag eingeschränkt, genügt einer der beiden
+		{name: "day of month before weekday", expr: "0 0 15 * mon", from: utc(2024, 6, 11, 0, 0), want: utc(2024, 6, 15, 0, 0)},
+		{name: "weekday before day of month", expr: "0 0 15 * mon", from: utc(2024, 6, 15, 1, 0), want: utc(2024, 6, 17, 0, 0)},
+		{name: "only day of month restricted", expr: "0 0 15 * *", from: utc(2024, 6, 15, 1, 0), want: utc(2024, 7, 15, 0, 0)},
+		{name: "only weekday restricted", expr: "0 0 * * sun", from: utc(2024, 6, 11, 0, 0), want: utc(2024, 6, 16, 0, 0)},
+		{name: "question mark is unrestricted", expr: "0 0 ? * sun", from: utc(2024, 6, 11, 0, 0), want: utc(2024, 6, 16, 0, 0)},
+		{name: "sunday as seven", expr: "0 12 * * 7", from: utc(2024, 6, 11, 0, 0), want: utc(2024, 6, 16, 12, 0)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := ParseCron(tc.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+			}
+			if got := c.Next(tc.from); !got.Equal(tc.want) {
+				t.Errorf("Next(%v) = %v, want %v", tc.from, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skipf("Zeitzonendaten nicht verfügbar: %v", err)
+	}
+	local := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, berlin)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	// Am 31.03.2024 springt die Uhr in Berlin von 02:00 auf 03:00, am 27.10.2024 von 03:00
+	// zurück auf 02:00
+	testCases := []struct {
+		name string
+		expr string
+		from time.Time
+		want []time.Time
+	}{
+		{
+			name: "daily job in the spring gap runs at the end of the gap",
+			expr: "30 2 * * *",
+			from: local(2024, 3, 30, 12, 0),
+			want: []time.Time{utc(2024, 3, 31, 1, 0), local(2024, 4, 1, 2, 30)},
+		},
+		{
+			name: "gap is caught up from within the previous hour",
+			expr: "30 1,2 * * *",
+			from: local(2024, 3, 31, 1, 30),
+			want: []time.Time{utc(2024, 3, 31, 1, 0), local(2024, 4, 1, 1, 30)},
+		},
+		{
+			name: "hourly job is not caught up",
+			expr: "15 * * * *",
+			from: local(2024, 3, 31, 1, 15),
+			want: []time.Time{utc(2024, 3, 31, 1, 15), utc(2024, 3, 31, 2, 15)},
+		},
+		{
+			name: "steps continue after the spring gap",
+			expr: "*/30 * * * *",
+			from: local(2024, 3, 31, 1, 0),
+			want: []time.Time{utc(2024, 3, 31, 0, 30), utc(2024, 3, 31, 1, 0), utc(2024, 3, 31, 1, 30)},
+		},
+		{
+			name: "daily job in the repeated hour runs once",
+			expr: "30 2 * * *",
+			from: local(2024, 10, 26, 12, 0),
+			want: []time.Time{local(2024, 10, 27, 2, 30), local(2024, 10, 28, 2, 30)},
+		},
+		{
+			name: "daily job after the repeated hour keeps its wall clock time",
+			expr: "0 3 * * *",
+			from: local(2024, 10, 26, 12, 0),
+			want: []time.Time{utc(2024, 10, 27, 2, 0), utc(2024, 10, 28, 2, 0)},
+		},
+		{
+			name: "steps run through the repeated hour in real time",
+			expr: "*/30 * * * *",
+			from: utc(2024, 10, 27, 0, 0).In(berlin),
+			want: []time.Time{utc(2024, 10, 27, 0, 30), utc(2024, 10, 27, 1, 0), utc(2024, 10, 27, 1, 30), utc(2024, 10, 27, 2, 0)},
+		},
+		{
+			name: "month boundary in local time",
+			expr: "0 0 1 * *",
+			from: local(2024, 10, 15, 0, 0),
+			want: []time.Time{utc(2024, 10, 31, 23, 0), utc(2024, 11, 30, 23, 0)},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := ParseCron(tc.expr)
+			if err != nil {
+				t.Fatalf("ParseCron(%q): %v", tc.expr, err)
+			}
+			from := tc.from
+			for i, want := range tc.want {
+				got := c.Next(from)
+				if !got.Equal(want) {
+					t.Fatalf("run %d: Next(%v) = %v, want %v", i+1, from, got, want.In(berlin))
+				}
+				if got.Location() != berlin {
+					t.Errorf("run %d: location %v, want %v", i+1, got.Location(), berlin)
+				}
+				from = got
+			}
+		})
+	}
+}
@@ -24,6 +24,11 @@ type PacketInfo struct {
 	DHCPInfo         *DHCPInfo `json:"dhcp_info,omitempty"`
 	ARPInfo          *ARPInfo  `json:"arp_info,omitempty"`
 
+	// Kapselungen; Adressen, Ports und Protokolle oben beschreiben das innerste Paket
+	VLANs      []VLANTag    `json:"vlans,omitempty"`       // äußeres Tag zuerst (QinQ)
+	MPLSLabels []MPLSLabel  `json:"mpls_labels,omitempty"` // oberstes Label zuerst
+	Tunnels    []TunnelInfo `json:"tunnels,omitempty"`     // äußerster Tunnel zuerst
+
 	// Rohpaketdaten für detaillierte Analyse
 	RawData []byte `json:"-"`
 }
@@ -84,6 +89,28 @@ type ARPInfo struct {
 	IsGratuitous bool   `json:"is_gratuitous,omitempty"`
 }
 
+// VLANTag ist ein 802.1Q-Tag; bei QinQ (802.1ad) trägt ein Paket mehrere
+type VLANTag struct {
+	ID           uint16 `json:"id"`
+	Priority     uint8  `json:"pcp"`
+	DropEligible bool   `json:"dei,omitempty"`
+}
+
+// MPLSLabel ist ein Eintrag des MPLS-Label-Stapels
+type MPLSLabel struct {
+	Label        uint32 `json:"label"`
+	TrafficClass uint8  `json:"tc"`
+	TTL          uint8  `json:"ttl"`
+}
+
+// TunnelInfo beschreibt eine Tunnel-Kapselung mit den Adressen des äußeren Pakets
+type TunnelInfo struct {
+	Type               string `json:"type"` // "GRE", "VXLAN", "Geneve", "IP-in-IP"
+	OuterSourceIP      net.IP `json:"outer_source_ip"`
+	OuterDestinationIP net.IP `json:"outer_destination_ip"`
+	ID                 uint32 `json:"id,omitempty"` // VNI bei VXLAN und Geneve, Schlüssel bei GRE
+}
+
 // PacketSummary enthält eine kompakte Zusammenfassung des Pakets
 type PacketSummary struct {
 	Timestamp        time.Time `json:"timestamp"`
@@ -92,6 +119,7 @@ type PacketSummary struct {
 	Protocol         string    `json:"protocol"`
 	Length           uint32    `json:"length"`
 	IsGatewayTraffic bool      `json:"is_gateway_traffic"`
+	VLANID           uint16    `json:"vlan_id,omitempty"` // innerstes VLAN-Tag
 	Summary          string    `json:"summary"`
 	EventType        string    `json:"event_type,omitempty"` // Normal, Warning, Error
 }
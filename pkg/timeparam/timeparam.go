@@ -0,0 +1,25 @@
+package timeparam
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Parse akzeptiert RFC3339-Zeitstempel oder Unix-Sekunden (auch mit Nachkommastellen).
+// Ein leerer Wert ergibt die Nullzeit.
+func Parse(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return t, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("ungültige Zeitangabe %q", value)
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)), nil
+}
@@ -0,0 +1,46 @@
+package version
+
+// Protokollversion zwischen Server und Agent. Sie wird bei jeder inkompatiblen
+// Änderung der Agent-API (Registrierung, Heartbeat, Steuer-Endpunkte) erhöht.
+const (
+	// Vom aktuellen Build gesprochene Protokollversion
+	ProtocolVersion = 2
+	// Älteste Protokollversion, die der Server noch akzeptiert. Agents vor der
+	// Einführung der Aushandlung senden keine Version und gelten als Version 1.
+	MinProtocolVersion = 1
+)
+
+// Fähigkeiten, die ein Agent bei der Registrierung meldet. Der Server nutzt
+// eine Funktion nur, wenn der Agent sie ankündigt.
+const (
+	// Mehrere gleichzeitige, benannte Captures auf verschiedenen Schnittstellen
+	CapabilityMultiInterface = "multi-interface"
+	// Ringpuffer der letzten Pakete mit PCAP-Export über /pcap
+	CapabilityPcapRing = "pcap-ring"
+	// Live-Paketstrom über WebSocket mit Abonnements je Client
+	CapabilityStreaming = "streaming"
+	// Vom Server verteilte Konfiguration (Heartbeat-Feld "config")
+	CapabilityRemoteConfig = "remote-config"
+	// Signierte Selbstaktualisierung (Heartbeat-Feld "update")
+	CapabilitySelfUpdate = "self-update"
+	// Nach Dauer, Paketen oder Bytes begrenzte Captures
+	CapabilityBoundedCapture = "bounded-capture"
+	// Durch Trigger-Regeln ausgelöste Aufzeichnungen mit Download über /recordings
+	CapabilityTriggerRecording = "trigger-recording"
+	// Fortlaufende Aufzeichnung in pcap_dir mit Liste und Download über /pcap-files
+	CapabilityPcapArchive = "pcap-archive"
+)
+
+// AgentCapabilities listet die Fähigkeiten des aktuellen Agent-Builds
+func AgentCapabilities() []string {
+	return []string{
+		CapabilityMultiInterface,
+		CapabilityPcapRing,
+		CapabilityStreaming,
+		CapabilityRemoteConfig,
+		CapabilitySelfUpdate,
+		CapabilityBoundedCapture,
+		CapabilityTriggerRecording,
+		CapabilityPcapArchive,
+	}
+}
@@ -0,0 +1,15 @@
+package version
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ReleaseSignatureMessage gibt die Nachricht zurück, über die ein Agent-Release mit Ed25519
+// signiert wird. Sie enthält neben der Prüfsumme Version und Plattform, damit ein Server
+// weder ältere signierte Builds erneut ausliefern noch Binärdateien einer anderen Plattform
+// unterschieben kann.
+func ReleaseSignatureMessage(version, goos, arch, sha256Hex string) []byte {
+	return []byte(fmt.Sprintf("ki-network-analyzer-agent\nversion=%s\nos=%s\narch=%s\nsha256=%s\n",
+		version, goos, arch, strings.ToLower(sha256Hex)))
+}
@@ -1,16 +1,52 @@
 package version
 
+import (
+	"strconv"
+	"strings"
+)
+
 // Version-Informationen
 const (
 	// Semantische Versionskomponenten
 	Major = 0
 	Minor = 1
 	Patch = 0
+)
 
+// Build-Informationen, werden vom Build-System über
+// -ldflags "-X github.com/sayedamirkarim/ki-network-analyzer/pkg/version.Version=..." gesetzt
+var (
 	// Vollständige Version als String
 	Version = "0.1.0"
 
-	// Build-Informationen (werden vom Build-System gesetzt)
 	BuildDate  = ""
 	CommitHash = ""
 )
+
+// Compare vergleicht zwei semantische Versionen ("1.2.3", optional mit "v"-Präfix
+// und Suffix wie "-rc1") und liefert -1, 0 oder 1. Nicht lesbare Komponenten zählen als 0.
+func Compare(a, b string) int {
+	pa, pb := parse(a), parse(b)
+	for i := range pa {
+		if pa[i] < pb[i] {
+			return -1
+		}
+		if pa[i] > pb[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// parse zerlegt eine Version in Major, Minor und Patch
+func parse(v string) [3]int {
+	var parts [3]int
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	for i, field := range strings.SplitN(v, ".", 3) {
+		parts[i], _ = strconv.Atoi(field)
+	}
+	return parts
+}
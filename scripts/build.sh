@@ -37,6 +37,14 @@ go build -o "$BUILD_DIR/analyzer" \
 
 echo "Build erfolgreich erstellt: $BUILD_DIR/analyzer"
 
+# Agent mit eingebetteter Version bauen (Grundlage für signierte Agent-Releases)
+echo "Erstelle Agent-Build..."
+go build -o "$BUILD_DIR/agent" \
+  -ldflags "-X github.com/sayedamirkarim/ki-network-analyzer/pkg/version.Version=$VERSION" \
+  ./cmd/agent
+
+echo "Agent-Build erfolgreich erstellt: $BUILD_DIR/agent"
+
 # Web-Dateien kopieren
 echo "Kopiere Web-Dateien..."
 mkdir -p "$BUILD_DIR/web"
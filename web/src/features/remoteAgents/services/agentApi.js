@@ -28,6 +28,29 @@ export const fetchAgents = async () => {
   }
 };
 
+/**
+ * Ruft die per mDNS gefundenen, noch nicht registrierten Agenten ab
+ * @returns {Promise<Array>} Liste der gefundenen Agenten
+ */
+export const fetchDiscoveredAgents = async () => {
+  try {
+    const response = await fetch('/api/agents/discovered?unregistered=true');
+    if (!response.ok) {
+      throw new Error(`Fehler beim Laden der gefundenen Agenten: ${response.statusText}`);
+    }
+    
+    const data = await response.json();
+    if (data.success) {
+      return data.data || [];
+    } else {
+      throw new Error(data.error || 'Fehler beim Laden der gefundenen Agenten');
+    }
+  } catch (err) {
+    console.error('Fehler beim Laden der gefundenen Agenten:', err);
+    throw err;
+  }
+};
+
 /**
  * Startet die Paketerfassung auf einem Agenten
  * @param {Object} agent - Agent-Objekt